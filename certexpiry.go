@@ -0,0 +1,146 @@
+package main
+
+import (
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/message"
+	"jwireguard/pki"
+	"time"
+)
+
+// CertExpiryMonitor 定期扫描 pki/issued 下的证书，记录到期时间，发送到期提醒并按配置自动续签
+func CertExpiryMonitor() {
+	global.Log.Infof("[CertExpiryMonitor] start")
+	for {
+		ScanCertExpiry()
+
+		interval := global.GlobalJWireGuardini.CertScanInterval
+		if interval <= 0 {
+			interval = 24
+		}
+		time.Sleep(time.Duration(interval) * time.Hour)
+	}
+}
+
+// ScanCertExpiry 执行一次证书到期扫描
+func ScanCertExpiry() {
+	ca, err := global.GetCA()
+	if err != nil {
+		global.Log.Errorf("[CertExpiryMonitor] 无法加载CA, err:%v", err)
+		return
+	}
+
+	certs, errs := ca.ScanIssued()
+	for _, err := range errs {
+		global.Log.Errorf("[CertExpiryMonitor] 解析证书失败, err:%v", err)
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[CertExpiryMonitor] 数据库连接失败, err:%v", err)
+		return
+	}
+
+	clientConfig := database.CliConfig{}
+	clientConfig.CreateCliConfig(global.GlobalDB)
+	clientConfigs, err := clientConfig.GetAllCliConfig(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[CertExpiryMonitor] 获取客户端列表失败, err:%v", err)
+		return
+	}
+
+	configMap := make(map[string]database.CliConfig, len(clientConfigs))
+	for _, config := range clientConfigs {
+		configMap[config.CliID.String] = config
+	}
+
+	remindDays := global.GlobalJWireGuardini.CertRemindDays
+	renewDays := global.GlobalJWireGuardini.CertRenewDays
+
+	for _, cert := range certs {
+		config, ok := configMap[cert.Name]
+		if !ok {
+			// 服务端证书或已删除的客户端
+			continue
+		}
+		changed := config.CertExpire.Int64 != cert.NotAfter.Unix()
+
+		// 自动续签
+		if renewDays > 0 && cert.ExpiresWithin(time.Duration(renewDays)*24*time.Hour) {
+			renewed, err := renewClientCert(ca, cert.Name)
+			if err != nil {
+				global.Log.Errorf("[CertExpiryMonitor] 客户端ID: [%s] 证书续签失败, err:%v", cert.Name, err)
+			} else {
+				global.Log.Infof("[CertExpiryMonitor] 客户端ID: [%s] 证书已续签, 序列号:[%s] 到期时间:[%s]",
+					cert.Name, renewed.Serial, renewed.NotAfter.Format("2006-01-02 15:04:05"))
+				cert = renewed
+				config.CertRemind.Int64 = 0
+				changed = true
+				sendCertExpiryMail(config, cert, true)
+			}
+		}
+
+		// 到期提醒, 每张证书只提醒一次
+		if remindDays > 0 && cert.ExpiresWithin(time.Duration(remindDays)*24*time.Hour) &&
+			config.CertRemind.Int64 < cert.NotAfter.AddDate(0, 0, -remindDays).Unix() {
+			if sendCertExpiryMail(config, cert, false) {
+				config.CertRemind.Int64 = time.Now().Unix()
+				changed = true
+			}
+		}
+
+		if !changed {
+			continue
+		}
+		config.CertExpire.Int64 = cert.NotAfter.Unix()
+		if err := config.UpdateCertStatus(global.GlobalDB); err != nil {
+			global.Log.Errorf("[CertExpiryMonitor] 无法更新客户端ID: [%s]的证书到期时间, err:%v", cert.Name, err)
+		}
+	}
+}
+
+// renewClientCert 通过 ShellRenewClient 签发新证书并生成客户端配置，旧证书不吊销，到期后自然失效
+func renewClientCert(ca *pki.CA, cliId string) (pki.IssuedCert, error) {
+	if err := global.ShellRenewClient(cliId); err != nil {
+		return pki.IssuedCert{}, err
+	}
+
+	_, _, crtFile := ca.ClientFiles(cliId)
+	cert, err := pki.ParseCertificateFile(crtFile)
+	if err != nil {
+		return pki.IssuedCert{}, err
+	}
+	return pki.NewIssuedCert(cliId, cert), nil
+}
+
+// sendCertExpiryMail 发送证书到期通知，邮件发送给子网所属用户，没有用户邮箱时发送到默认收件人
+func sendCertExpiryMail(config database.CliConfig, cert pki.IssuedCert, renewed bool) bool {
+	user := database.User{}
+	user.SerID.String = config.SerID.String
+	to, err := user.GetUserEmailsBySerID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[CertExpiryMonitor] 获取子网[%s]用户邮箱失败, err:%v", config.SerID.String, err)
+	}
+	if len(to) == 0 {
+		to = []string{global.GlobalJWireGuardini.To}
+	}
+
+	event := message.EVENTCERTEXPIRY
+	if renewed {
+		event = message.EVENTCERTRENEWED
+	}
+
+	if !dispatchTemplate("CertExpiryMonitor", event, config.SerID.String, config.CliID.String, to, map[string]interface{}{
+		"CliID":    config.CliID.String,
+		"CliName":  config.CliName.String,
+		"SerName":  config.SerName.String,
+		"Serial":   cert.Serial,
+		"NotAfter": cert.NotAfter.Local().Format("2006-01-02 15:04:05"),
+		"DaysLeft": int(time.Until(cert.NotAfter).Hours() / 24),
+	}) {
+		return false
+	}
+	global.Log.Debugf("[CertExpiryMonitor] 客户端ID: [%s] 证书到期通知已写入发件箱！", config.CliID.String)
+	return true
+}
@@ -21,6 +21,9 @@ type CliConfig struct {
 	Timestamp    sql.NullInt64  `json:"ts"`
 	EditStatus   sql.NullInt32  `json:"edit_stauts"`
 	OnlineStatus sql.NullString `json:"online_status"`
+	CertExpire   sql.NullInt64  `json:"cert_expire"`  // 证书到期时间
+	CertRemind   sql.NullInt64  `json:"cert_remind"`  // 到期提醒发送时间
+	CliAddress6  sql.NullString `json:"cli_address6"` // IPv6 地址，未启用 IPv6 时为空
 }
 
 type ExportedCliConfig struct {
@@ -36,6 +39,9 @@ type ExportedCliConfig struct {
 	Timestamp    int64  `json:"ts"`
 	EditStatus   int32  `json:"edit_stauts"`
 	OnlineStatus string `json:"online_status"`
+	CertExpire   int64  `json:"cert_expire"`
+	CertRemind   int64  `json:"cert_remind"`
+	CliAddress6  string `json:"cli_address6"`
 }
 
 // ConvertToCliConfig converts ExportedCliConfig to CliConfig
@@ -53,6 +59,9 @@ func (exported *ExportedCliConfig) ConvertToCliConfig() CliConfig {
 		Timestamp:    sql.NullInt64{Int64: exported.Timestamp, Valid: exported.Timestamp != -1},
 		EditStatus:   sql.NullInt32{Int32: exported.EditStatus, Valid: exported.EditStatus != -1},
 		OnlineStatus: sql.NullString{String: exported.OnlineStatus, Valid: exported.OnlineStatus != ""},
+		CertExpire:   sql.NullInt64{Int64: exported.CertExpire, Valid: exported.CertExpire != 0},
+		CertRemind:   sql.NullInt64{Int64: exported.CertRemind, Valid: exported.CertRemind != 0},
+		CliAddress6:  sql.NullString{String: exported.CliAddress6, Valid: exported.CliAddress6 != ""},
 	}
 }
 
@@ -71,7 +80,10 @@ func (c *CliConfig) CreateCliConfig(db *sql.DB) {
             cli_status VARCHAR(255),
             ts BIGINT,
             edit_stauts INT,
-            online_status VARCHAR(255)
+            online_status VARCHAR(255),
+            cert_expire BIGINT,
+            cert_remind BIGINT,
+            cli_address6 VARCHAR(64)
         ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
 		_, err := db.Exec(createTableSQL)
 		if err != nil {
@@ -80,6 +92,65 @@ func (c *CliConfig) CreateCliConfig(db *sql.DB) {
 		}
 	} else {
 		global.Log.Debugln("[CreateCliConfig] Table 'cli_config' already exists.")
+		c.addMissingColumns(db)
+	}
+}
+
+// addMissingColumns 为旧版本创建的 cli_config 表补充新增字段
+func (c *CliConfig) addMissingColumns(db *sql.DB) {
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"cert_expire", "BIGINT"},
+		{"cert_remind", "BIGINT"},
+		{"cli_address6", "VARCHAR(64)"},
+	}
+
+	for _, column := range columns {
+		if c.ColumnExists(db, column.name) {
+			continue
+		}
+		alterSQL := fmt.Sprintf("ALTER TABLE cli_config ADD COLUMN %s %s", column.name, column.definition)
+		if _, err := db.Exec(alterSQL); err != nil {
+			global.Log.Errorf("[CreateCliConfig] Error adding column %s: %v", column.name, err)
+		}
+	}
+
+	c.fillAddress6(db)
+}
+
+// fillAddress6 启用 IPv6 后为还没有 IPv6 地址的客户端按 IPv4 地址计算 cli_address6
+func (c *CliConfig) fillAddress6(db *sql.DB) {
+	if !global.IPv6Enabled() {
+		return
+	}
+	rows, err := db.Query("SELECT cli_id, cli_address FROM cli_config WHERE (cli_address6 IS NULL OR cli_address6 = '') AND cli_address <> ''")
+	if err != nil {
+		global.Log.Errorln("[CreateCliConfig] Error querying clients without IPv6 address:", err)
+		return
+	}
+	addresses := make(map[string]string)
+	for rows.Next() {
+		var cliId string
+		var cliAddress sql.NullString
+		if err := rows.Scan(&cliId, &cliAddress); err != nil {
+			global.Log.Errorln("[CreateCliConfig] Error scanning clients without IPv6 address:", err)
+			break
+		}
+		addresses[cliId] = cliAddress.String
+	}
+	rows.Close()
+
+	for cliId, cliAddress := range addresses {
+		cliAddress6, err := global.ClientAddress6(cliAddress)
+		if err != nil {
+			global.Log.Errorf("[CreateCliConfig] Error computing IPv6 address of %s: %v", cliId, err)
+			continue
+		}
+		if _, err := db.Exec("UPDATE cli_config SET cli_address6 = ? WHERE cli_id = ?", cliAddress6, cliId); err != nil {
+			global.Log.Errorf("[CreateCliConfig] Error updating IPv6 address of %s: %v", cliId, err)
+		}
 	}
 }
 
@@ -98,13 +169,16 @@ func (c *CliConfig) ToExported() ExportedCliConfig {
 		Timestamp:    nullInt64ToInt64(c.Timestamp),
 		EditStatus:   nullInt32ToInt32(c.EditStatus),
 		OnlineStatus: nullStringToString(c.OnlineStatus),
+		CertExpire:   nullInt64ToInt64(c.CertExpire),
+		CertRemind:   nullInt64ToInt64(c.CertRemind),
+		CliAddress6:  nullStringToString(c.CliAddress6),
 	}
 }
 
 // InsertCliConfig inserts a new record into cli_config
 func (c *CliConfig) InsertCliConfig(db *sql.DB) error {
 	// 包含 cli_mac 字段
-	stmt, err := db.Prepare("INSERT INTO cli_config (cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := db.Prepare("INSERT INTO cli_config (cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status, cert_expire, cert_remind, cli_address6) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
@@ -123,7 +197,10 @@ func (c *CliConfig) InsertCliConfig(db *sql.DB) error {
 		c.CliStatus.String,
 		c.Timestamp.Int64,
 		c.EditStatus.Int32,
-		c.OnlineStatus.String)
+		c.OnlineStatus.String,
+		c.CertExpire.Int64,
+		c.CertRemind.Int64,
+		c.CliAddress6.String)
 	if err != nil {
 		return err
 	}
@@ -133,7 +210,7 @@ func (c *CliConfig) InsertCliConfig(db *sql.DB) error {
 
 // GetCliConfigByCliID retrieves a record by cli_id
 func (c *CliConfig) GetCliConfigByCliID(db *sql.DB) error {
-	query := "SELECT cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status FROM cli_config WHERE cli_id = ?"
+	query := "SELECT cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status, cert_expire, cert_remind, cli_address6 FROM cli_config WHERE cli_id = ?"
 	row := db.QueryRow(query, c.CliID.String)
 
 	// 添加 cli_mac 字段扫描
@@ -149,7 +226,10 @@ func (c *CliConfig) GetCliConfigByCliID(db *sql.DB) error {
 		&c.CliStatus,
 		&c.Timestamp,
 		&c.EditStatus,
-		&c.OnlineStatus)
+		&c.OnlineStatus,
+		&c.CertExpire,
+		&c.CertRemind,
+		&c.CliAddress6)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return fmt.Errorf("CliConfig with CliID %s not found", c.CliID.String)
@@ -162,7 +242,7 @@ func (c *CliConfig) GetCliConfigByCliID(db *sql.DB) error {
 
 // GetCliConfigBySerID retrieves records by ser_id
 func (c *CliConfig) GetCliConfigBySerID(db *sql.DB) ([]CliConfig, error) {
-	query := "SELECT cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status FROM cli_config WHERE ser_id = ? ORDER BY INET_ATON(cli_address)"
+	query := "SELECT cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status, cert_expire, cert_remind, cli_address6 FROM cli_config WHERE ser_id = ? ORDER BY INET_ATON(cli_address)"
 	rows, err := db.Query(query, c.SerID.String)
 	if err != nil {
 		return nil, err
@@ -186,6 +266,9 @@ func (c *CliConfig) GetCliConfigBySerID(db *sql.DB) ([]CliConfig, error) {
 			&config.Timestamp,
 			&config.EditStatus,
 			&config.OnlineStatus,
+			&config.CertExpire,
+			&config.CertRemind,
+			&config.CliAddress6,
 		)
 		if err != nil {
 			return nil, err
@@ -202,7 +285,7 @@ func (c *CliConfig) GetCliConfigBySerID(db *sql.DB) ([]CliConfig, error) {
 
 // GetAllCliConfig retrieves all records from cli_config
 func (c *CliConfig) GetAllCliConfig(db *sql.DB) ([]CliConfig, error) {
-	query := "SELECT cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status FROM cli_config"
+	query := "SELECT cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status, cert_expire, cert_remind, cli_address6 FROM cli_config"
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
@@ -226,6 +309,9 @@ func (c *CliConfig) GetAllCliConfig(db *sql.DB) ([]CliConfig, error) {
 			&config.Timestamp,
 			&config.EditStatus,
 			&config.OnlineStatus,
+			&config.CertExpire,
+			&config.CertRemind,
+			&config.CliAddress6,
 		)
 		if err != nil {
 			return nil, err
@@ -273,6 +359,10 @@ func (c *CliConfig) UpdateCliConfig(db *sql.DB) error {
 		setClauses = append(setClauses, "cli_address = ?")
 		args = append(args, c.CliAddress.String)
 	}
+	if c.CliAddress6.String != "" {
+		setClauses = append(setClauses, "cli_address6 = ?")
+		args = append(args, c.CliAddress6.String)
+	}
 	if c.CliMapping.String != "" {
 		setClauses = append(setClauses, "cli_mapping = ?")
 		args = append(args, c.CliMapping.String)
@@ -293,6 +383,14 @@ func (c *CliConfig) UpdateCliConfig(db *sql.DB) error {
 		setClauses = append(setClauses, "online_status = ?")
 		args = append(args, c.OnlineStatus.String)
 	}
+	if c.CertExpire.Int64 != 0 {
+		setClauses = append(setClauses, "cert_expire = ?")
+		args = append(args, c.CertExpire.Int64)
+	}
+	if c.CertRemind.Int64 != 0 {
+		setClauses = append(setClauses, "cert_remind = ?")
+		args = append(args, c.CertRemind.Int64)
+	}
 
 	if len(setClauses) == 0 {
 		return errors.New("no fields to update")
@@ -315,20 +413,130 @@ func (c *CliConfig) UpdateCliConfig(db *sql.DB) error {
 	return nil
 }
 
-// DeleteCliConfig deletes a record from cli_config
+// GetCliConfigCertExpiring retrieves records whose certificate expires before the given time
+func (c *CliConfig) GetCliConfigCertExpiring(db *sql.DB, before int64) ([]CliConfig, error) {
+	query := "SELECT cli_id, ser_id, cli_sn, cli_mac, cli_name, ser_name, cli_address, cli_mapping, cli_status, ts, edit_stauts, online_status, cert_expire, cert_remind, cli_address6 FROM cli_config WHERE cert_expire > 0 AND cert_expire <= ?"
+	args := []interface{}{before}
+	if c.SerID.String != "" {
+		query += " AND ser_id = ?"
+		args = append(args, c.SerID.String)
+	}
+	query += " ORDER BY cert_expire"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configs []CliConfig
+	for rows.Next() {
+		var config CliConfig
+		err := rows.Scan(
+			&config.CliID,
+			&config.SerID,
+			&config.CliSN,
+			&config.CliMac,
+			&config.CliName,
+			&config.SerName,
+			&config.CliAddress,
+			&config.CliMapping,
+			&config.CliStatus,
+			&config.Timestamp,
+			&config.EditStatus,
+			&config.OnlineStatus,
+			&config.CertExpire,
+			&config.CertRemind,
+			&config.CliAddress6,
+		)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+// UpdateCertStatus updates cert_expire and cert_remind only
+func (c *CliConfig) UpdateCertStatus(db *sql.DB) error {
+	if c.CliID.String == "" {
+		return errors.New("cli_id cannot be empty")
+	}
+
+	_, err := db.Exec("UPDATE cli_config SET cert_expire = ?, cert_remind = ? WHERE cli_id = ?",
+		c.CertExpire.Int64, c.CertRemind.Int64, c.CliID.String)
+	return err
+}
+
+// UpdateCliTimestamps 批量更新心跳时间戳，每条语句最多更新 500 个客户端
+func UpdateCliTimestamps(db *sql.DB, timestamps map[string]int64) error {
+	const batchSize = 500
+
+	cliIds := make([]string, 0, len(timestamps))
+	for cliId := range timestamps {
+		cliIds = append(cliIds, cliId)
+	}
+
+	for start := 0; start < len(cliIds); start += batchSize {
+		end := start + batchSize
+		if end > len(cliIds) {
+			end = len(cliIds)
+		}
+		batch := cliIds[start:end]
+
+		var cases strings.Builder
+		args := make([]interface{}, 0, len(batch)*3)
+		for _, cliId := range batch {
+			cases.WriteString(" WHEN ? THEN ?")
+			args = append(args, cliId, timestamps[cliId])
+		}
+		for _, cliId := range batch {
+			args = append(args, cliId)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+
+		query := fmt.Sprintf("UPDATE cli_config SET ts = CASE cli_id%s END WHERE cli_id IN (%s)", cases.String(), placeholders)
+		if _, err := db.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteCliConfig deletes a record from cli_config and releases its address
 func (c *CliConfig) DeleteCliConfig(db *sql.DB) error {
-	stmt, err := db.Prepare("DELETE FROM cli_config WHERE cli_id = ?")
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	_, err = stmt.Exec(c.CliID.String)
+	_, err = tx.Exec("DELETE FROM cli_config WHERE cli_id = ?", c.CliID.String)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if tableExists(db, "ip_address") {
+		_, err = tx.Exec("DELETE FROM ip_address WHERE cli_id = ?", c.CliID.String)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ColumnExists checks if a column exists in the cli_config table
+func (c *CliConfig) ColumnExists(db *sql.DB, columnName string) bool {
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'cli_config' AND column_name = ?"
+	var name string
+	err := db.QueryRow(query, columnName).Scan(&name)
+	return err == nil
 }
 
 // TableExists checks if the table exists in MySQL
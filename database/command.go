@@ -0,0 +1,417 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwireguard/global"
+	"strings"
+)
+
+const (
+	COMMANDPENDING   = "pending"   // 等待下发
+	COMMANDDELIVERED = "delivered" // 已在心跳回复中下发，等待回执
+	COMMANDDONE      = "done"      // 设备执行成功
+	COMMANDFAILED    = "failed"    // 设备执行失败
+	COMMANDEXPIRED   = "expired"   // 超时未收到回执
+	COMMANDCANCELED  = "canceled"  // 客户端被删除
+)
+
+// CliCommand 管理端下发给设备的命令
+type CliCommand struct {
+	ID          sql.NullInt64  `json:"id"`
+	CliID       sql.NullString `json:"cli_id"`
+	Type        sql.NullString `json:"type"`
+	Args        sql.NullString `json:"args"` // JSON 格式的参数
+	Status      sql.NullString `json:"status"`
+	Result      sql.NullString `json:"result"`
+	CreatedBy   sql.NullString `json:"created_by"`
+	CreatedAt   sql.NullInt64  `json:"created_at"`
+	DeliveredAt sql.NullInt64  `json:"delivered_at"`
+	AckedAt     sql.NullInt64  `json:"acked_at"`
+	ExpireAt    sql.NullInt64  `json:"expire_at"`
+}
+
+type ExportedCliCommand struct {
+	ID          int64             `json:"id"`
+	CliID       string            `json:"cli_id"`
+	Type        string            `json:"type"`
+	Args        map[string]string `json:"args"`
+	Status      string            `json:"status"`
+	Result      string            `json:"result"`
+	CreatedBy   string            `json:"created_by"`
+	CreatedAt   int64             `json:"created_at"`
+	DeliveredAt int64             `json:"delivered_at"`
+	AckedAt     int64             `json:"acked_at"`
+	ExpireAt    int64             `json:"expire_at"`
+}
+
+// CliCommandLog 命令状态变化记录
+type CliCommandLog struct {
+	ID        sql.NullInt64  `json:"id"`
+	CommandID sql.NullInt64  `json:"command_id"`
+	CliID     sql.NullString `json:"cli_id"`
+	Event     sql.NullString `json:"event"`
+	Operator  sql.NullString `json:"operator"` // 用户 ID，设备回执时为 cli_id，系统操作时为 system
+	Detail    sql.NullString `json:"detail"`
+	CreatedAt sql.NullInt64  `json:"created_at"`
+}
+
+type ExportedCliCommandLog struct {
+	ID        int64  `json:"id"`
+	CommandID int64  `json:"command_id"`
+	CliID     string `json:"cli_id"`
+	Event     string `json:"event"`
+	Operator  string `json:"operator"`
+	Detail    string `json:"detail"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+const cliCommandColumns = "id, cli_id, type, args, status, result, created_by, created_at, delivered_at, acked_at, expire_at"
+
+// CreateCliCommand creates the cli_command and cli_command_log tables in MySQL
+func (c *CliCommand) CreateCliCommand(db *sql.DB) {
+	if !tableExists(db, "cli_command") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS cli_command (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            cli_id VARCHAR(255) NOT NULL,
+            type VARCHAR(32) NOT NULL,
+            args TEXT,
+            status VARCHAR(16) NOT NULL,
+            result TEXT,
+            created_by VARCHAR(255),
+            created_at BIGINT NOT NULL,
+            delivered_at BIGINT,
+            acked_at BIGINT,
+            expire_at BIGINT NOT NULL,
+            INDEX idx_cli_status (cli_id, status),
+            INDEX idx_status_expire (status, expire_at)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateCliCommand] Error creating table:", err)
+			return
+		}
+	}
+
+	if !tableExists(db, "cli_command_log") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS cli_command_log (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            command_id BIGINT NOT NULL,
+            cli_id VARCHAR(255) NOT NULL,
+            event VARCHAR(16) NOT NULL,
+            operator VARCHAR(255),
+            detail TEXT,
+            created_at BIGINT NOT NULL,
+            INDEX idx_command_id (command_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateCliCommand] Error creating log table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts CliCommand to ExportedCliCommand
+func (c *CliCommand) ToExported() ExportedCliCommand {
+	exported := ExportedCliCommand{
+		ID:          nullInt64ToInt64(c.ID),
+		CliID:       nullStringToString(c.CliID),
+		Type:        nullStringToString(c.Type),
+		Status:      nullStringToString(c.Status),
+		Result:      nullStringToString(c.Result),
+		CreatedBy:   nullStringToString(c.CreatedBy),
+		CreatedAt:   nullInt64ToInt64(c.CreatedAt),
+		DeliveredAt: nullInt64ToInt64(c.DeliveredAt),
+		AckedAt:     nullInt64ToInt64(c.AckedAt),
+		ExpireAt:    nullInt64ToInt64(c.ExpireAt),
+	}
+	if c.Args.String != "" {
+		json.Unmarshal([]byte(c.Args.String), &exported.Args)
+	}
+	return exported
+}
+
+// ToExported converts CliCommandLog to ExportedCliCommandLog
+func (l *CliCommandLog) ToExported() ExportedCliCommandLog {
+	return ExportedCliCommandLog{
+		ID:        nullInt64ToInt64(l.ID),
+		CommandID: nullInt64ToInt64(l.CommandID),
+		CliID:     nullStringToString(l.CliID),
+		Event:     nullStringToString(l.Event),
+		Operator:  nullStringToString(l.Operator),
+		Detail:    nullStringToString(l.Detail),
+		CreatedAt: nullInt64ToInt64(l.CreatedAt),
+	}
+}
+
+func scanCliCommand(scanner interface{ Scan(...interface{}) error }, c *CliCommand) error {
+	return scanner.Scan(&c.ID, &c.CliID, &c.Type, &c.Args, &c.Status, &c.Result, &c.CreatedBy,
+		&c.CreatedAt, &c.DeliveredAt, &c.AckedAt, &c.ExpireAt)
+}
+
+// insertCliCommandLog 在事务中记录命令状态变化
+func insertCliCommandLog(tx *sql.Tx, commandId int64, cliId string, event string, operator string, detail string, now int64) error {
+	_, err := tx.Exec("INSERT INTO cli_command_log (command_id, cli_id, event, operator, detail, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		commandId, cliId, event, operator, detail, now)
+	return err
+}
+
+// InsertCliCommand 添加待下发的命令并记录操作人
+func (c *CliCommand) InsertCliCommand(db *sql.DB) error {
+	if c.CliID.String == "" || c.Type.String == "" {
+		return errors.New("cli_id and type cannot be empty")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c.Status.String = COMMANDPENDING
+	result, err := tx.Exec("INSERT INTO cli_command (cli_id, type, args, status, created_by, created_at, expire_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		c.CliID.String, c.Type.String, c.Args.String, c.Status.String, c.CreatedBy.String, c.CreatedAt.Int64, c.ExpireAt.Int64)
+	if err != nil {
+		return err
+	}
+	if c.ID.Int64, err = result.LastInsertId(); err != nil {
+		return err
+	}
+	if err := insertCliCommandLog(tx, c.ID.Int64, c.CliID.String, COMMANDPENDING, c.CreatedBy.String, c.Args.String, c.CreatedAt.Int64); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetCliCommandByID retrieves a command by its ID
+func (c *CliCommand) GetCliCommandByID(db *sql.DB) error {
+	row := db.QueryRow("SELECT "+cliCommandColumns+" FROM cli_command WHERE id = ?", c.ID.Int64)
+	if err := scanCliCommand(row, c); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("CliCommand with ID %d not found", c.ID.Int64)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetCliCommandsByCliID retrieves the commands of a client, newest first
+// status 为空时不限制
+func (c *CliCommand) GetCliCommandsByCliID(db *sql.DB, status string, page int) ([]CliCommand, int, error) {
+	if c.CliID.String == "" {
+		return nil, 0, errors.New("cli_id cannot be empty")
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * PAGINNATIONLIMIT
+
+	where := "WHERE cli_id = ?"
+	args := []interface{}{c.CliID.String}
+	if status != "" {
+		where += " AND status = ?"
+		args = append(args, status)
+	}
+
+	rows, err := db.Query("SELECT "+cliCommandColumns+" FROM cli_command "+where+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, PAGINNATIONLIMIT, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var commands []CliCommand
+	for rows.Next() {
+		var command CliCommand
+		if err := scanCliCommand(rows, &command); err != nil {
+			return nil, 0, err
+		}
+		commands = append(commands, command)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM cli_command "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	return commands, total, nil
+}
+
+// GetOpenCliCommands 获取设备未完成且未过期的命令，按添加顺序
+func GetOpenCliCommands(db *sql.DB, cliId string, now int64, limit int) ([]CliCommand, error) {
+	rows, err := db.Query("SELECT "+cliCommandColumns+" FROM cli_command WHERE cli_id = ? AND status IN (?, ?) AND expire_at > ? ORDER BY id LIMIT ?",
+		cliId, COMMANDPENDING, COMMANDDELIVERED, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commands []CliCommand
+	for rows.Next() {
+		var command CliCommand
+		if err := scanCliCommand(rows, &command); err != nil {
+			return nil, err
+		}
+		commands = append(commands, command)
+	}
+	return commands, rows.Err()
+}
+
+// GetOpenCommandCliIDs 获取有未完成命令的设备
+func GetOpenCommandCliIDs(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT cli_id FROM cli_command WHERE status IN (?, ?)", COMMANDPENDING, COMMANDDELIVERED)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cliIds []string
+	for rows.Next() {
+		var cliId string
+		if err := rows.Scan(&cliId); err != nil {
+			return nil, err
+		}
+		cliIds = append(cliIds, cliId)
+	}
+	return cliIds, rows.Err()
+}
+
+// MarkCliCommandsDelivered 记录首次下发，重复下发不再记录
+func MarkCliCommandsDelivered(db *sql.DB, commands []CliCommand, now int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, command := range commands {
+		result, err := tx.Exec("UPDATE cli_command SET status = ?, delivered_at = ? WHERE id = ? AND status = ?",
+			COMMANDDELIVERED, now, command.ID.Int64, COMMANDPENDING)
+		if err != nil {
+			return err
+		}
+		if count, _ := result.RowsAffected(); count == 0 {
+			continue
+		}
+		if err := insertCliCommandLog(tx, command.ID.Int64, command.CliID.String, COMMANDDELIVERED, "system", "", now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AckCliCommand 记录设备的回执，命令已完成或不属于该设备时返回 false
+func AckCliCommand(db *sql.DB, cliId string, id int64, status string, result string, now int64) (bool, error) {
+	if status != COMMANDDONE && status != COMMANDFAILED {
+		return false, fmt.Errorf("invalid ack status: %s", status)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE cli_command SET status = ?, result = ?, acked_at = ? WHERE id = ? AND cli_id = ? AND status IN (?, ?)",
+		status, result, now, id, cliId, COMMANDPENDING, COMMANDDELIVERED)
+	if err != nil {
+		return false, err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return false, nil
+	}
+	if err := insertCliCommandLog(tx, id, cliId, status, cliId, result, now); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ExpireCliCommands 将过期未完成的命令标记为 expired，返回涉及的设备
+func ExpireCliCommands(db *sql.DB, now int64) ([]string, error) {
+	return closeCliCommands(db, "expire_at <= ?", []interface{}{now}, COMMANDEXPIRED, "system", now)
+}
+
+// CancelCliCommands 删除客户端时取消其未完成的命令，命令和记录保留
+func CancelCliCommands(db *sql.DB, cliId string, operator string, now int64) error {
+	if !tableExists(db, "cli_command") {
+		return nil
+	}
+	_, err := closeCliCommands(db, "cli_id = ?", []interface{}{cliId}, COMMANDCANCELED, operator, now)
+	return err
+}
+
+func closeCliCommands(db *sql.DB, where string, args []interface{}, status string, operator string, now int64) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, cli_id FROM cli_command WHERE status IN (?, ?) AND "+where+" FOR UPDATE",
+		append([]interface{}{COMMANDPENDING, COMMANDDELIVERED}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []interface{}
+	var cliIds []string
+	seen := make(map[string]bool)
+	logs := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var cliId string
+		if err := rows.Scan(&id, &cliId); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		logs[id] = cliId
+		if !seen[cliId] {
+			seen[cliId] = true
+			cliIds = append(cliIds, cliId)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	if _, err := tx.Exec("UPDATE cli_command SET status = ? WHERE id IN ("+placeholders+")",
+		append([]interface{}{status}, ids...)...); err != nil {
+		return nil, err
+	}
+	for id, cliId := range logs {
+		if err := insertCliCommandLog(tx, id, cliId, status, operator, "", now); err != nil {
+			return nil, err
+		}
+	}
+	return cliIds, tx.Commit()
+}
+
+// GetCliCommandLogs 获取命令的状态变化记录
+func (c *CliCommand) GetCliCommandLogs(db *sql.DB) ([]CliCommandLog, error) {
+	rows, err := db.Query("SELECT id, command_id, cli_id, event, operator, detail, created_at FROM cli_command_log WHERE command_id = ? ORDER BY id",
+		c.ID.Int64)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []CliCommandLog
+	for rows.Next() {
+		var log CliCommandLog
+		if err := rows.Scan(&log.ID, &log.CommandID, &log.CliID, &log.Event, &log.Operator, &log.Detail, &log.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
@@ -97,3 +97,32 @@ func ConvertUsers(users []User) []ExportedUser {
 	}
 	return exported
 }
+
+// tableExists checks if the table exists in MySQL
+func tableExists(db *sql.DB, table string) bool {
+	query := "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	var name string
+	err := db.QueryRow(query, table).Scan(&name)
+	return err == nil
+}
+
+// indexExists checks if an index exists in the table
+func indexExists(db *sql.DB, table string, index string) bool {
+	query := "SELECT index_name FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ? LIMIT 1"
+	var name string
+	err := db.QueryRow(query, table, index).Scan(&name)
+	return err == nil
+}
+
+// preparer 兼容 *sql.DB 和 *sql.Tx
+type preparer interface {
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// columnExists checks if a column exists in the table
+func columnExists(db *sql.DB, table string, column string) bool {
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?"
+	var name string
+	err := db.QueryRow(query, table, column).Scan(&name)
+	return err == nil
+}
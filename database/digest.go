@@ -0,0 +1,222 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"jwireguard/global"
+	"strings"
+)
+
+// 摘要频率
+const (
+	DIGESTOFF    = "off"
+	DIGESTDAILY  = "daily"
+	DIGESTWEEKLY = "weekly"
+)
+
+// 客户端变更
+const (
+	CLICHANGEADD    = "add"
+	CLICHANGEDELETE = "delete"
+)
+
+// DigestSchedule 用户的摘要设置，空值和 -1 表示使用 [DIGEST SETTING] 的默认值
+type DigestSchedule struct {
+	UserID    sql.NullString `json:"user_id"`
+	Frequency sql.NullString `json:"frequency"`  // off/daily/weekly
+	SendTime  sql.NullString `json:"send_time"`  // HH:MM
+	Weekday   sql.NullInt64  `json:"weekday"`    // 0 为周日
+	ChannelID sql.NullInt64  `json:"channel_id"` // 通知渠道，0 表示发送到用户邮箱
+	LastSent  sql.NullInt64  `json:"last_sent"`  // 最近一次发送对应的计划时间
+	UpdatedBy sql.NullString `json:"updated_by"`
+	UpdatedAt sql.NullInt64  `json:"updated_at"`
+}
+
+type ExportedDigestSchedule struct {
+	UserID    string `json:"user_id"`
+	Frequency string `json:"frequency"`
+	SendTime  string `json:"send_time"`
+	Weekday   int64  `json:"weekday"`
+	ChannelID int64  `json:"channel_id"`
+	LastSent  int64  `json:"last_sent"`
+	UpdatedBy string `json:"updated_by"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// CliChange 客户端的添加和删除记录，客户端删除后保留
+type CliChange struct {
+	ID       sql.NullInt64  `json:"id"`
+	CliID    sql.NullString `json:"cli_id"`
+	SerID    sql.NullString `json:"ser_id"`
+	CliName  sql.NullString `json:"cli_name"`
+	Action   sql.NullString `json:"action"` // add/delete
+	Operator sql.NullString `json:"operator"`
+	Ts       sql.NullInt64  `json:"ts"`
+}
+
+// CreateDigestSchedule creates the digest_schedule table in MySQL
+func (d *DigestSchedule) CreateDigestSchedule(db *sql.DB) {
+	if !tableExists(db, "digest_schedule") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS digest_schedule (
+            user_id VARCHAR(255) NOT NULL PRIMARY KEY,
+            frequency VARCHAR(8) NOT NULL DEFAULT '',
+            send_time VARCHAR(5) NOT NULL DEFAULT '',
+            weekday INT NOT NULL DEFAULT -1,
+            channel_id BIGINT NOT NULL DEFAULT 0,
+            last_sent BIGINT NOT NULL DEFAULT 0,
+            updated_by VARCHAR(255),
+            updated_at BIGINT
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateDigestSchedule] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// CreateCliChange creates the cli_change table in MySQL
+func (c *CliChange) CreateCliChange(db *sql.DB) {
+	if !tableExists(db, "cli_change") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS cli_change (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            cli_id VARCHAR(255) NOT NULL,
+            ser_id VARCHAR(255),
+            cli_name VARCHAR(255),
+            action VARCHAR(16) NOT NULL,
+            operator VARCHAR(255),
+            ts BIGINT NOT NULL,
+            INDEX idx_ser_ts (ser_id, ts)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateCliChange] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts DigestSchedule to ExportedDigestSchedule
+func (d *DigestSchedule) ToExported() ExportedDigestSchedule {
+	weekday := int64(-1)
+	if d.Weekday.Valid {
+		weekday = d.Weekday.Int64
+	}
+	return ExportedDigestSchedule{
+		UserID:    nullStringToString(d.UserID),
+		Frequency: nullStringToString(d.Frequency),
+		SendTime:  nullStringToString(d.SendTime),
+		Weekday:   weekday,
+		ChannelID: nullInt64ToInt64(d.ChannelID),
+		LastSent:  nullInt64ToInt64(d.LastSent),
+		UpdatedBy: nullStringToString(d.UpdatedBy),
+		UpdatedAt: nullInt64ToInt64(d.UpdatedAt),
+	}
+}
+
+// ConvertToDigestSchedule converts ExportedDigestSchedule to DigestSchedule
+func (exported *ExportedDigestSchedule) ConvertToDigestSchedule() DigestSchedule {
+	return DigestSchedule{
+		UserID:    sql.NullString{String: exported.UserID, Valid: exported.UserID != ""},
+		Frequency: sql.NullString{String: exported.Frequency, Valid: true},
+		SendTime:  sql.NullString{String: exported.SendTime, Valid: true},
+		Weekday:   sql.NullInt64{Int64: exported.Weekday, Valid: true},
+		ChannelID: sql.NullInt64{Int64: exported.ChannelID, Valid: true},
+		LastSent:  sql.NullInt64{Int64: exported.LastSent, Valid: exported.LastSent != 0},
+		UpdatedBy: sql.NullString{String: exported.UpdatedBy, Valid: exported.UpdatedBy != ""},
+		UpdatedAt: sql.NullInt64{Int64: exported.UpdatedAt, Valid: exported.UpdatedAt != 0},
+	}
+}
+
+// SaveDigestSchedule inserts or updates the schedule, last_sent is kept
+func (d *DigestSchedule) SaveDigestSchedule(db *sql.DB) error {
+	if d.UserID.String == "" {
+		return errors.New("user_id cannot be empty")
+	}
+	_, err := db.Exec(`INSERT INTO digest_schedule (user_id, frequency, send_time, weekday, channel_id, updated_by, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE frequency = VALUES(frequency), send_time = VALUES(send_time), weekday = VALUES(weekday),
+        channel_id = VALUES(channel_id), updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`,
+		d.UserID.String, d.Frequency.String, d.SendTime.String, d.Weekday.Int64, d.ChannelID.Int64, d.UpdatedBy.String, d.UpdatedAt.Int64)
+	return err
+}
+
+// GetAllDigestSchedule retrieves all schedules keyed by user_id
+func (d *DigestSchedule) GetAllDigestSchedule(db *sql.DB) (map[string]DigestSchedule, error) {
+	rows, err := db.Query("SELECT user_id, frequency, send_time, weekday, channel_id, last_sent, updated_by, updated_at FROM digest_schedule")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := make(map[string]DigestSchedule)
+	for rows.Next() {
+		var schedule DigestSchedule
+		if err := rows.Scan(&schedule.UserID, &schedule.Frequency, &schedule.SendTime, &schedule.Weekday, &schedule.ChannelID,
+			&schedule.LastSent, &schedule.UpdatedBy, &schedule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		schedules[schedule.UserID.String] = schedule
+	}
+	return schedules, rows.Err()
+}
+
+// GetDigestScheduleByUserID retrieves the schedule of a user, sql.ErrNoRows when not set
+func (d *DigestSchedule) GetDigestScheduleByUserID(db *sql.DB) error {
+	return db.QueryRow("SELECT user_id, frequency, send_time, weekday, channel_id, last_sent, updated_by, updated_at FROM digest_schedule WHERE user_id = ?",
+		d.UserID.String).Scan(&d.UserID, &d.Frequency, &d.SendTime, &d.Weekday, &d.ChannelID, &d.LastSent, &d.UpdatedBy, &d.UpdatedAt)
+}
+
+// MarkDigestSent records the scheduled time of the last digest, the row is created with defaults when missing
+func MarkDigestSent(db *sql.DB, userId string, scheduled int64) error {
+	_, err := db.Exec("INSERT INTO digest_schedule (user_id, last_sent) VALUES (?, ?) ON DUPLICATE KEY UPDATE last_sent = VALUES(last_sent)",
+		userId, scheduled)
+	return err
+}
+
+// DeleteDigestSchedule deletes the schedule of a user
+func (d *DigestSchedule) DeleteDigestSchedule(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM digest_schedule WHERE user_id = ?", d.UserID.String)
+	return err
+}
+
+// InsertCliChange records that a client was added or deleted
+func (c *CliChange) InsertCliChange(db *sql.DB) error {
+	if c.CliID.String == "" || c.Action.String == "" {
+		return errors.New("cli_id and action cannot be empty")
+	}
+	_, err := db.Exec("INSERT INTO cli_change (cli_id, ser_id, cli_name, action, operator, ts) VALUES (?, ?, ?, ?, ?, ?)",
+		c.CliID.String, c.SerID.String, c.CliName.String, c.Action.String, c.Operator.String, c.Ts.Int64)
+	return err
+}
+
+// GetCliChanges retrieves changes of clients in the subnets within [start, end), oldest first
+func GetCliChanges(db *sql.DB, serIds []string, start int64, end int64) ([]CliChange, error) {
+	if len(serIds) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(serIds)), ",")
+	args := make([]interface{}, 0, len(serIds)+2)
+	for _, serId := range serIds {
+		args = append(args, serId)
+	}
+	args = append(args, start, end)
+
+	rows, err := db.Query(fmt.Sprintf("SELECT id, cli_id, ser_id, cli_name, action, operator, ts FROM cli_change WHERE ser_id IN (%s) AND ts >= ? AND ts < ? ORDER BY ts, id",
+		placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []CliChange
+	for rows.Next() {
+		var change CliChange
+		if err := rows.Scan(&change.ID, &change.CliID, &change.SerID, &change.CliName, &change.Action, &change.Operator, &change.Ts); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,305 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"jwireguard/global"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	EVENTONLINE  = "online"
+	EVENTOFFLINE = "offline"
+
+	EVENTCAUSEHEARTBEAT = "heartbeat" // 收到心跳
+	EVENTCAUSETIMEOUT   = "timeout"   // 连续丢失心跳
+	EVENTCAUSEINITIAL   = "initial"   // 建表时设备的状态
+)
+
+// CliEvent 设备在线状态的变化
+type CliEvent struct {
+	ID     sql.NullInt64  `json:"id"`
+	CliID  sql.NullString `json:"cli_id"`
+	SerID  sql.NullString `json:"ser_id"`
+	Status sql.NullString `json:"status"` // online/offline
+	Cause  sql.NullString `json:"cause"`
+	Detail sql.NullString `json:"detail"`
+	Ts     sql.NullInt64  `json:"ts"`
+}
+
+type ExportedCliEvent struct {
+	ID     int64  `json:"id"`
+	CliID  string `json:"cli_id"`
+	SerID  string `json:"ser_id"`
+	Status string `json:"status"`
+	Cause  string `json:"cause"`
+	Detail string `json:"detail"`
+	Ts     int64  `json:"ts"`
+}
+
+// Outage 一次离线，End 为 0 表示统计结束时仍离线
+type Outage struct {
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Duration int64  `json:"duration"` // 在统计范围内的秒数
+	Cause    string `json:"cause"`
+}
+
+// Uptime 设备在统计范围内的在线情况，没有记录的时间不参与计算
+type Uptime struct {
+	CliID   string   `json:"cli_id"`
+	Start   int64    `json:"start"`
+	End     int64    `json:"end"`
+	Online  int64    `json:"online"`  // 在线秒数
+	Offline int64    `json:"offline"` // 离线秒数
+	Unknown int64    `json:"unknown"` // 没有记录的秒数
+	Percent float64  `json:"percent"` // 在线百分比，没有记录时为 -1
+	Outages []Outage `json:"outages"`
+	Count   int      `json:"count"`   // 离线次数
+	Longest int64    `json:"longest"` // 最长离线秒数
+	Status  string   `json:"status"`  // 统计结束时的状态，没有记录时为空
+}
+
+const cliEventColumns = "id, cli_id, ser_id, status, cause, detail, ts"
+
+// CreateCliEvent creates the cli_event table in MySQL
+// 新建表时记录所有设备当前的状态，作为统计的起点
+func (e *CliEvent) CreateCliEvent(db *sql.DB) {
+	if !tableExists(db, "cli_event") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS cli_event (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            cli_id VARCHAR(255) NOT NULL,
+            ser_id VARCHAR(255),
+            status VARCHAR(16) NOT NULL,
+            cause VARCHAR(32),
+            detail VARCHAR(255),
+            ts BIGINT NOT NULL,
+            INDEX idx_cli_ts (cli_id, ts)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateCliEvent] Error creating table:", err)
+			return
+		}
+
+		if tableExists(db, "cli_config") {
+			_, err := db.Exec(`INSERT INTO cli_event (cli_id, ser_id, status, cause, detail, ts)
+                SELECT cli_id, ser_id, IF(cli_status = 'true', ?, ?), ?, '', ? FROM cli_config`,
+				EVENTONLINE, EVENTOFFLINE, EVENTCAUSEINITIAL, time.Now().Unix())
+			if err != nil {
+				global.Log.Errorln("[CreateCliEvent] Error importing cli_config:", err)
+			}
+		}
+	}
+}
+
+// ToExported converts CliEvent to ExportedCliEvent
+func (e *CliEvent) ToExported() ExportedCliEvent {
+	return ExportedCliEvent{
+		ID:     nullInt64ToInt64(e.ID),
+		CliID:  nullStringToString(e.CliID),
+		SerID:  nullStringToString(e.SerID),
+		Status: nullStringToString(e.Status),
+		Cause:  nullStringToString(e.Cause),
+		Detail: nullStringToString(e.Detail),
+		Ts:     nullInt64ToInt64(e.Ts),
+	}
+}
+
+func scanCliEvents(rows *sql.Rows) ([]CliEvent, error) {
+	defer rows.Close()
+
+	var events []CliEvent
+	for rows.Next() {
+		var event CliEvent
+		if err := rows.Scan(&event.ID, &event.CliID, &event.SerID, &event.Status, &event.Cause, &event.Detail, &event.Ts); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// InsertCliEvent records a status change
+func (e *CliEvent) InsertCliEvent(db *sql.DB) error {
+	if e.CliID.String == "" || (e.Status.String != EVENTONLINE && e.Status.String != EVENTOFFLINE) {
+		return errors.New("invalid cli event")
+	}
+	if len(e.Detail.String) > 255 {
+		e.Detail.String = e.Detail.String[:255]
+	}
+	result, err := db.Exec("INSERT INTO cli_event (cli_id, ser_id, status, cause, detail, ts) VALUES (?, ?, ?, ?, ?, ?)",
+		e.CliID.String, e.SerID.String, e.Status.String, e.Cause.String, e.Detail.String, e.Ts.Int64)
+	if err != nil {
+		return err
+	}
+	e.ID.Int64, err = result.LastInsertId()
+	e.ID.Valid = err == nil
+	return nil
+}
+
+// GetCliEventsByCliID retrieves the events of a client in [start, end), newest first
+func (e *CliEvent) GetCliEventsByCliID(db *sql.DB, start int64, end int64, page int) ([]CliEvent, int, error) {
+	if e.CliID.String == "" {
+		return nil, 0, errors.New("cli_id cannot be empty")
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * PAGINNATIONLIMIT
+
+	rows, err := db.Query("SELECT "+cliEventColumns+" FROM cli_event WHERE cli_id = ? AND ts >= ? AND ts < ? ORDER BY ts DESC, id DESC LIMIT ? OFFSET ?",
+		e.CliID.String, start, end, PAGINNATIONLIMIT, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	events, err := scanCliEvents(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM cli_event WHERE cli_id = ? AND ts >= ? AND ts < ?",
+		e.CliID.String, start, end).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// GetUptimes 计算多个设备在 [start, end) 内的在线情况
+// 以 start 之前的最后一条记录作为起始状态，start 之前没有记录的设备从第一条记录开始计算
+// ----------------------------------------------------------------------------------------------------------
+func GetUptimes(db *sql.DB, cliIds []string, start int64, end int64) ([]Uptime, error) {
+	if len(cliIds) == 0 {
+		return []Uptime{}, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cliIds)), ", ")
+	ids := make([]interface{}, 0, len(cliIds))
+	for _, cliId := range cliIds {
+		ids = append(ids, cliId)
+	}
+
+	// start 之前每个设备的最后一条记录
+	rows, err := db.Query(`SELECT `+cliEventColumns+` FROM cli_event e
+        WHERE e.id IN (SELECT MAX(id) FROM cli_event WHERE ts < ? AND cli_id IN (`+placeholders+`) GROUP BY cli_id)`,
+		append([]interface{}{start}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	before, err := scanCliEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT "+cliEventColumns+" FROM cli_event WHERE ts >= ? AND ts < ? AND cli_id IN ("+placeholders+") ORDER BY ts, id",
+		append([]interface{}{start, end}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	events, err := scanCliEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	initial := make(map[string]*CliEvent)
+	for i := range before {
+		initial[before[i].CliID.String] = &before[i]
+	}
+	grouped := make(map[string][]CliEvent)
+	for _, event := range events {
+		grouped[event.CliID.String] = append(grouped[event.CliID.String], event)
+	}
+
+	uptimes := make([]Uptime, 0, len(cliIds))
+	for _, cliId := range cliIds {
+		uptimes = append(uptimes, ComputeUptime(cliId, initial[cliId], grouped[cliId], start, end))
+	}
+	sort.Slice(uptimes, func(i, j int) bool { return uptimes[i].CliID < uptimes[j].CliID })
+	return uptimes, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ComputeUptime 按时间顺序的记录计算在线时间和离线列表，initial 为 start 之前的最后一条记录
+// ----------------------------------------------------------------------------------------------------------
+func ComputeUptime(cliId string, initial *CliEvent, events []CliEvent, start int64, end int64) Uptime {
+	uptime := Uptime{CliID: cliId, Start: start, End: end, Outages: []Outage{}}
+
+	status, cause := "", ""
+	if initial != nil {
+		status, cause = initial.Status.String, initial.Cause.String
+	}
+	var outage *Outage
+	if status == EVENTOFFLINE {
+		outage = &Outage{Start: initial.Ts.Int64, Cause: cause}
+	}
+
+	last := start
+	for _, event := range events {
+		ts := event.Ts.Int64
+		uptime.add(status, ts-last)
+		if event.Status.String == status {
+			last = ts
+			continue
+		}
+
+		if event.Status.String == EVENTOFFLINE {
+			outage = &Outage{Start: ts, Cause: event.Cause.String}
+		} else if outage != nil {
+			outage.End = ts
+			uptime.closeOutage(outage, start, end)
+			outage = nil
+		}
+		status = event.Status.String
+		last = ts
+	}
+	uptime.add(status, end-last)
+	if outage != nil {
+		uptime.closeOutage(outage, start, end)
+	}
+
+	uptime.Status = status
+	uptime.Count = len(uptime.Outages)
+	if known := uptime.Online + uptime.Offline; known > 0 {
+		uptime.Percent = float64(uptime.Online) * 100 / float64(known)
+	} else {
+		uptime.Percent = -1
+	}
+	return uptime
+}
+
+func (u *Uptime) add(status string, seconds int64) {
+	if seconds <= 0 {
+		return
+	}
+	switch status {
+	case EVENTONLINE:
+		u.Online += seconds
+	case EVENTOFFLINE:
+		u.Offline += seconds
+	default:
+		u.Unknown += seconds
+	}
+}
+
+// closeOutage 记录离线，End 为 0 时计算到 end
+func (u *Uptime) closeOutage(outage *Outage, start int64, end int64) {
+	until := outage.End
+	if until == 0 {
+		until = end
+	}
+	outage.Duration = until - max64(outage.Start, start)
+	u.Outages = append(u.Outages, *outage)
+	if outage.Duration > u.Longest {
+		u.Longest = outage.Duration
+	}
+}
+
+func max64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"jwireguard/global"
+	"time"
+)
+
+// CliSecret 设备的心跳密钥，添加客户端时签发
+type CliSecret struct {
+	CliID      sql.NullString `json:"cli_id"`
+	Secret     sql.NullString `json:"secret"`
+	CreatedAt  sql.NullInt64  `json:"created_at"`
+	VerifiedAt sql.NullInt64  `json:"verified_at"` // 第一次收到签名正确的 v2 心跳的时间，之后不再接受 v1 心跳
+}
+
+// CreateCliSecret creates the cli_secret table in MySQL
+func (s *CliSecret) CreateCliSecret(db *sql.DB) {
+	if !tableExists(db, "cli_secret") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS cli_secret (
+            cli_id VARCHAR(255) NOT NULL PRIMARY KEY,
+            secret VARCHAR(128) NOT NULL,
+            created_at BIGINT NOT NULL,
+            verified_at BIGINT NOT NULL DEFAULT 0
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateCliSecret] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// SaveCliSecret 保存设备密钥，已存在时替换并清除 v2 校验记录
+func (s *CliSecret) SaveCliSecret(db *sql.DB) error {
+	s.CreatedAt.Int64 = time.Now().Unix()
+	_, err := db.Exec(`INSERT INTO cli_secret (cli_id, secret, created_at, verified_at) VALUES(?, ?, ?, 0)
+        ON DUPLICATE KEY UPDATE secret = VALUES(secret), created_at = VALUES(created_at), verified_at = 0`,
+		s.CliID.String, s.Secret.String, s.CreatedAt.Int64)
+	return err
+}
+
+// GetCliSecretByCliID retrieves the secret by cli_id
+func (s *CliSecret) GetCliSecretByCliID(db *sql.DB) error {
+	row := db.QueryRow("SELECT cli_id, secret, created_at, verified_at FROM cli_secret WHERE cli_id = ?", s.CliID.String)
+	err := row.Scan(&s.CliID, &s.Secret, &s.CreatedAt, &s.VerifiedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("CliSecret with CliID %s not found: %w", s.CliID.String, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// MarkVerified 记录第一次收到 v2 心跳的时间
+func (s *CliSecret) MarkVerified(db *sql.DB) error {
+	s.VerifiedAt.Int64 = time.Now().Unix()
+	_, err := db.Exec("UPDATE cli_secret SET verified_at = ? WHERE cli_id = ? AND verified_at = 0",
+		s.VerifiedAt.Int64, s.CliID.String)
+	return err
+}
+
+// DeleteCliSecret deletes the secret by cli_id
+func (s *CliSecret) DeleteCliSecret(db *sql.DB) error {
+	if !tableExists(db, "cli_secret") {
+		return nil
+	}
+	_, err := db.Exec("DELETE FROM cli_secret WHERE cli_id = ?", s.CliID.String)
+	return err
+}
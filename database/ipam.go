@@ -0,0 +1,450 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"jwireguard/global"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrNoFreeAddress 网段中没有可用的地址
+var ErrNoFreeAddress = errors.New("all IP addresses in the range are used")
+
+// ErrAddressExists 客户端已分配了地址
+var ErrAddressExists = errors.New("the client already has an IP address")
+
+// IPAddress 已分配给客户端的地址，address 和 cli_id 都唯一
+type IPAddress struct {
+	Address   sql.NullString `json:"address"`
+	SerID     sql.NullString `json:"ser_id"`
+	CliID     sql.NullString `json:"cli_id"`
+	CreatedAt sql.NullInt64  `json:"created_at"`
+}
+
+// IPReserved 保留的地址范围，分配时跳过
+type IPReserved struct {
+	ID           sql.NullInt64  `json:"id"`
+	StartAddress sql.NullString `json:"start_address"`
+	EndAddress   sql.NullString `json:"end_address"`
+	Note         sql.NullString `json:"note"`
+}
+
+type ExportedIPReserved struct {
+	ID           int64  `json:"id"`
+	StartAddress string `json:"start_address"`
+	EndAddress   string `json:"end_address"`
+	Note         string `json:"note"`
+}
+
+// CreateIPAddress creates the ip_address table in MySQL
+// 新建表时从 cli_config 导入已分配的地址
+func (a *IPAddress) CreateIPAddress(db *sql.DB) {
+	if !tableExists(db, "ip_address") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS ip_address (
+            address VARCHAR(64) NOT NULL PRIMARY KEY,
+            ser_id VARCHAR(255),
+            cli_id VARCHAR(255) NOT NULL,
+            created_at BIGINT NOT NULL,
+            UNIQUE KEY uk_cli_id (cli_id),
+            INDEX idx_ser_id (ser_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateIPAddress] Error creating table:", err)
+			return
+		}
+
+		if tableExists(db, "cli_config") {
+			// 用户没有子网，不参与地址分配
+			result, err := db.Exec(`INSERT IGNORE INTO ip_address (address, ser_id, cli_id, created_at)
+                SELECT cli_address, ser_id, cli_id, ? FROM cli_config
+                WHERE cli_address IS NOT NULL AND cli_address <> '' AND ser_id IS NOT NULL AND ser_id <> ''`,
+				time.Now().Unix())
+			if err != nil {
+				global.Log.Errorln("[CreateIPAddress] Error importing cli_config:", err)
+				return
+			}
+			count, _ := result.RowsAffected()
+			global.Log.Infof("[CreateIPAddress] 已从 cli_config 导入 %d 个地址", count)
+		}
+	}
+}
+
+// CreateIPReserved creates the ip_reserved table in MySQL
+func (r *IPReserved) CreateIPReserved(db *sql.DB) {
+	if !tableExists(db, "ip_reserved") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS ip_reserved (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            start_address VARCHAR(64) NOT NULL,
+            end_address VARCHAR(64) NOT NULL,
+            note VARCHAR(255),
+            UNIQUE KEY uk_range (start_address, end_address)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateIPReserved] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts IPReserved to ExportedIPReserved
+func (r *IPReserved) ToExported() ExportedIPReserved {
+	return ExportedIPReserved{
+		ID:           nullInt64ToInt64(r.ID),
+		StartAddress: nullStringToString(r.StartAddress),
+		EndAddress:   nullStringToString(r.EndAddress),
+		Note:         nullStringToString(r.Note),
+	}
+}
+
+// ConvertToIPReserved converts ExportedIPReserved to IPReserved
+func (exported *ExportedIPReserved) ConvertToIPReserved() IPReserved {
+	return IPReserved{
+		ID:           sql.NullInt64{Int64: exported.ID, Valid: exported.ID != 0},
+		StartAddress: sql.NullString{String: exported.StartAddress, Valid: exported.StartAddress != ""},
+		EndAddress:   sql.NullString{String: exported.EndAddress, Valid: exported.EndAddress != ""},
+		Note:         sql.NullString{String: exported.Note, Valid: exported.Note != ""},
+	}
+}
+
+// InsertIPReserved inserts a new reserved range
+func (r *IPReserved) InsertIPReserved(db *sql.DB) error {
+	start, errStart := ipToUint32(r.StartAddress.String)
+	end, errEnd := ipToUint32(r.EndAddress.String)
+	if errStart != nil || errEnd != nil || start > end {
+		return fmt.Errorf("invalid reserved range: %s - %s", r.StartAddress.String, r.EndAddress.String)
+	}
+
+	result, err := db.Exec("INSERT INTO ip_reserved (start_address, end_address, note) VALUES(?, ?, ?)",
+		r.StartAddress.String, r.EndAddress.String, r.Note.String)
+	if err != nil {
+		return err
+	}
+	r.ID.Int64, err = result.LastInsertId()
+	r.ID.Valid = err == nil
+	return nil
+}
+
+// DeleteIPReserved deletes a reserved range by id
+func (r *IPReserved) DeleteIPReserved(db *sql.DB) error {
+	result, err := db.Exec("DELETE FROM ip_reserved WHERE id = ?", r.ID.Int64)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("IPReserved with ID %d not found", r.ID.Int64)
+	}
+	return nil
+}
+
+// GetAllIPReserved retrieves all reserved ranges
+func (r *IPReserved) GetAllIPReserved(db *sql.DB) ([]IPReserved, error) {
+	return queryIPReserved(db)
+}
+
+// GetAllIPAddress retrieves all allocated addresses
+func (a *IPAddress) GetAllIPAddress(db *sql.DB) ([]IPAddress, error) {
+	rows, err := db.Query("SELECT address, ser_id, cli_id, created_at FROM ip_address ORDER BY INET_ATON(address)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []IPAddress
+	for rows.Next() {
+		var address IPAddress
+		err := rows.Scan(&address.Address, &address.SerID, &address.CliID, &address.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// AllocateIPAddress 在 network 中为新客户端分配未使用的地址，客户端已有地址时返回 ErrAddressExists，
+// 没有空闲地址时返回 ErrNoFreeAddress，cli_config 中已存在的客户端同时更新 cli_address 和 cli_address6
+// ----------------------------------------------------------------------------------------------------------
+func AllocateIPAddress(db *sql.DB, serId string, cliId string, network *net.IPNet) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var address string
+	err = tx.QueryRow("SELECT address FROM ip_address WHERE cli_id = ? FOR UPDATE", cliId).Scan(&address)
+	if err == nil {
+		return "", ErrAddressExists
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	address, err = allocateIPAddressTx(db, tx, serId, cliId, network)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ReallocateIPAddress 在 network 中为客户端分配新地址，原有地址在同一事务中释放且不会被再次分配，
+// 返回新地址和释放的原有地址(没有时 Address 为空)，后续步骤失败时通过 RestoreIPAddress 恢复
+// ----------------------------------------------------------------------------------------------------------
+func ReallocateIPAddress(db *sql.DB, serId string, cliId string, network *net.IPNet) (string, IPAddress, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", IPAddress{}, err
+	}
+	defer tx.Rollback()
+
+	previous := IPAddress{}
+	err = tx.QueryRow("SELECT address, ser_id, cli_id, created_at FROM ip_address WHERE cli_id = ? FOR UPDATE", cliId).
+		Scan(&previous.Address, &previous.SerID, &previous.CliID, &previous.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return "", IPAddress{}, err
+	}
+	if err == nil {
+		// 删除原有地址后才能插入新地址(uk_cli_id)，原有地址通过 exclude 跳过
+		if _, err := tx.Exec("DELETE FROM ip_address WHERE cli_id = ? AND address = ?", cliId, previous.Address.String); err != nil {
+			return "", IPAddress{}, err
+		}
+	}
+
+	address, err := allocateIPAddressTx(db, tx, serId, cliId, network, previous.Address.String)
+	if err != nil {
+		return "", IPAddress{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", IPAddress{}, err
+	}
+	return address, previous, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// RestoreIPAddress 撤销 ReallocateIPAddress，释放新分配的 address 并恢复原有地址
+// 原有地址已被其他客户端占用时返回错误
+// ----------------------------------------------------------------------------------------------------------
+func RestoreIPAddress(db *sql.DB, cliId string, address string, previous IPAddress) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM ip_address WHERE cli_id = ? AND address = ?", cliId, address); err != nil {
+		return err
+	}
+	if previous.Address.String != "" {
+		_, err := tx.Exec("INSERT INTO ip_address (address, ser_id, cli_id, created_at) VALUES(?, ?, ?, ?)",
+			previous.Address.String, previous.SerID.String, cliId, previous.CreatedAt.Int64)
+		if err != nil {
+			return err
+		}
+		if tableExists(db, "cli_config") {
+			address6, err := global.ClientAddress6(previous.Address.String)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec("UPDATE cli_config SET cli_address = ?, cli_address6 = ? WHERE cli_id = ?",
+				previous.Address.String, address6, cliId); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// allocateIPAddressTx 在事务中分配地址并更新 cli_config，exclude 中的地址不分配
+func allocateIPAddressTx(db *sql.DB, tx *sql.Tx, serId string, cliId string, network *net.IPNet, exclude ...string) (string, error) {
+	first, last, err := hostRange(network)
+	if err != nil {
+		return "", err
+	}
+
+	reserved, err := queryIPReserved(tx)
+	if err != nil {
+		return "", err
+	}
+
+	usedIPs := make(map[uint32]bool)
+	rows, err := tx.Query("SELECT address FROM ip_address")
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			rows.Close()
+			return "", err
+		}
+		if n, err := ipToUint32(address); err == nil {
+			usedIPs[n] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	// 用户和服务端使用的地址不分配给客户端
+	for _, ip := range global.ReservedAddresses() {
+		if n, err := ipToUint32(ip.String()); err == nil {
+			usedIPs[n] = true
+		}
+	}
+	for _, address := range exclude {
+		if n, err := ipToUint32(address); err == nil {
+			usedIPs[n] = true
+		}
+	}
+
+	now := time.Now().Unix()
+	for n := first; n <= last; n++ {
+		if usedIPs[n] || isReserved(reserved, n) {
+			continue
+		}
+
+		address := uint32ToIP(n)
+		_, err := tx.Exec("INSERT INTO ip_address (address, ser_id, cli_id, created_at) VALUES(?, ?, ?, ?)",
+			address, serId, cliId, now)
+		if isDuplicateKey(err, "uk_cli_id") {
+			// 同一客户端的并发请求已分配了地址
+			return "", ErrAddressExists
+		}
+		if isDuplicateEntry(err) {
+			// 并发分配时地址已被其他事务占用，继续尝试下一个
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if tableExists(db, "cli_config") {
+			address6, err := global.ClientAddress6(address)
+			if err != nil {
+				return "", err
+			}
+			if _, err := tx.Exec("UPDATE cli_config SET cli_address = ?, cli_address6 = ? WHERE cli_id = ?", address, address6, cliId); err != nil {
+				return "", err
+			}
+		}
+		return address, nil
+	}
+
+	return "", ErrNoFreeAddress
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ReleaseIPAddress 释放客户端的地址，只删除 address 仍属于该客户端的记录
+// ----------------------------------------------------------------------------------------------------------
+func ReleaseIPAddress(db *sql.DB, cliId string, address string) error {
+	if !tableExists(db, "ip_address") {
+		return nil
+	}
+	_, err := db.Exec("DELETE FROM ip_address WHERE cli_id = ? AND address = ?", cliId, address)
+	return err
+}
+
+// queryer 兼容 *sql.DB 和 *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryIPReserved(q queryer) ([]IPReserved, error) {
+	rows, err := q.Query("SELECT id, start_address, end_address, note FROM ip_reserved ORDER BY INET_ATON(start_address)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranges []IPReserved
+	for rows.Next() {
+		var reserved IPReserved
+		err := rows.Scan(&reserved.ID, &reserved.StartAddress, &reserved.EndAddress, &reserved.Note)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, reserved)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// isReserved 判断地址是否在保留范围内
+func isReserved(ranges []IPReserved, n uint32) bool {
+	for _, reserved := range ranges {
+		start, errStart := ipToUint32(reserved.StartAddress.String)
+		end, errEnd := ipToUint32(reserved.EndAddress.String)
+		if errStart == nil && errEnd == nil && n >= start && n <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// hostRange 网段中可分配的第一个和最后一个地址，不含网络地址和广播地址
+func hostRange(network *net.IPNet) (uint32, uint32, error) {
+	ip := network.IP.To4()
+	ones, bits := network.Mask.Size()
+	if ip == nil || bits != 32 {
+		return 0, 0, fmt.Errorf("invalid IPv4 network: %s", network)
+	}
+	if ones > 30 {
+		return 0, 0, fmt.Errorf("network %s is too small", network)
+	}
+
+	base := binary.BigEndian.Uint32(ip) & binary.BigEndian.Uint32(net.IP(network.Mask).To4())
+	size := uint32(1) << uint(32-ones)
+	return base + 1, base + size - 2, nil
+}
+
+func ipToUint32(address string) (uint32, error) {
+	ip := net.ParseIP(address).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("invalid IP address: %s", address)
+	}
+	return binary.BigEndian.Uint32(ip), nil
+}
+
+func uint32ToIP(n uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip.String()
+}
+
+// isDuplicateEntry 判断是否违反唯一约束
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// isDuplicateKey 判断是否违反指定的唯一约束，MySQL 8 的错误信息中索引名带有表名前缀
+func isDuplicateKey(err error, key string) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 &&
+		(strings.HasSuffix(mysqlErr.Message, "'"+key+"'") || strings.HasSuffix(mysqlErr.Message, "."+key+"'"))
+}
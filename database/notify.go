@@ -0,0 +1,307 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"jwireguard/global"
+	"strings"
+)
+
+// NotifyChannel 通知渠道
+type NotifyChannel struct {
+	ID         sql.NullInt64  `json:"id"`
+	Name       sql.NullString `json:"name"`
+	Type       sql.NullString `json:"type"` // email/webhook/dingtalk/wecom/feishu
+	URL        sql.NullString `json:"url"`
+	Secret     sql.NullString `json:"secret"`
+	Recipients sql.NullString `json:"recipients"` // 邮件收件人，逗号分隔
+	Enabled    sql.NullBool   `json:"enabled"`
+	UpdatedBy  sql.NullString `json:"updated_by"`
+	UpdatedAt  sql.NullInt64  `json:"updated_at"`
+}
+
+type ExportedNotifyChannel struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	Recipients []string `json:"recipients"`
+	Enabled    bool     `json:"enabled"`
+	UpdatedBy  string   `json:"updated_by"`
+	UpdatedAt  int64    `json:"updated_at"`
+}
+
+// NotifyRule 通知路由规则，event 为 * 时匹配所有事件，ser_id 为空时匹配所有子网
+type NotifyRule struct {
+	ID        sql.NullInt64  `json:"id"`
+	Event     sql.NullString `json:"event"`
+	SerID     sql.NullString `json:"ser_id"`
+	ChannelID sql.NullInt64  `json:"channel_id"`
+	UpdatedBy sql.NullString `json:"updated_by"`
+	UpdatedAt sql.NullInt64  `json:"updated_at"`
+}
+
+type ExportedNotifyRule struct {
+	ID        int64  `json:"id"`
+	Event     string `json:"event"`
+	SerID     string `json:"ser_id"`
+	ChannelID int64  `json:"channel_id"`
+	UpdatedBy string `json:"updated_by"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+const notifyChannelColumns = "id, name, type, url, secret, recipients, enabled, updated_by, updated_at"
+
+// CreateNotifyChannel creates the notify_channel and notify_rule tables in MySQL
+func (c *NotifyChannel) CreateNotifyChannel(db *sql.DB) {
+	if !tableExists(db, "notify_channel") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS notify_channel (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            name VARCHAR(64) NOT NULL UNIQUE,
+            type VARCHAR(16) NOT NULL,
+            url VARCHAR(1024),
+            secret VARCHAR(255),
+            recipients TEXT,
+            enabled BOOLEAN NOT NULL DEFAULT TRUE,
+            updated_by VARCHAR(255),
+            updated_at BIGINT
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateNotifyChannel] Error creating table:", err)
+			return
+		}
+	}
+
+	if !tableExists(db, "notify_rule") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS notify_rule (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            event VARCHAR(32) NOT NULL,
+            ser_id VARCHAR(255) NOT NULL DEFAULT '',
+            channel_id BIGINT NOT NULL,
+            updated_by VARCHAR(255),
+            updated_at BIGINT,
+            UNIQUE KEY uk_rule (event, ser_id, channel_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateNotifyChannel] Error creating notify_rule table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts NotifyChannel to ExportedNotifyChannel
+func (c *NotifyChannel) ToExported() ExportedNotifyChannel {
+	return ExportedNotifyChannel{
+		ID:         nullInt64ToInt64(c.ID),
+		Name:       nullStringToString(c.Name),
+		Type:       nullStringToString(c.Type),
+		URL:        nullStringToString(c.URL),
+		Secret:     nullStringToString(c.Secret),
+		Recipients: c.RecipientList(),
+		Enabled:    NullBoolToBool(c.Enabled),
+		UpdatedBy:  nullStringToString(c.UpdatedBy),
+		UpdatedAt:  nullInt64ToInt64(c.UpdatedAt),
+	}
+}
+
+// ConvertToNotifyChannel converts ExportedNotifyChannel to NotifyChannel
+func (exported *ExportedNotifyChannel) ConvertToNotifyChannel() NotifyChannel {
+	recipients := strings.Join(exported.Recipients, ",")
+	return NotifyChannel{
+		ID:         sql.NullInt64{Int64: exported.ID, Valid: exported.ID != 0},
+		Name:       sql.NullString{String: exported.Name, Valid: exported.Name != ""},
+		Type:       sql.NullString{String: exported.Type, Valid: exported.Type != ""},
+		URL:        sql.NullString{String: exported.URL, Valid: exported.URL != ""},
+		Secret:     sql.NullString{String: exported.Secret, Valid: exported.Secret != ""},
+		Recipients: sql.NullString{String: recipients, Valid: recipients != ""},
+		Enabled:    sql.NullBool{Bool: exported.Enabled, Valid: true},
+		UpdatedBy:  sql.NullString{String: exported.UpdatedBy, Valid: exported.UpdatedBy != ""},
+		UpdatedAt:  sql.NullInt64{Int64: exported.UpdatedAt, Valid: exported.UpdatedAt != 0},
+	}
+}
+
+// RecipientList 邮件收件人列表
+func (c *NotifyChannel) RecipientList() []string {
+	recipients := []string{}
+	for _, recipient := range strings.Split(c.Recipients.String, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+func scanNotifyChannels(rows *sql.Rows) ([]NotifyChannel, error) {
+	defer rows.Close()
+
+	var channels []NotifyChannel
+	for rows.Next() {
+		var channel NotifyChannel
+		if err := rows.Scan(&channel.ID, &channel.Name, &channel.Type, &channel.URL, &channel.Secret,
+			&channel.Recipients, &channel.Enabled, &channel.UpdatedBy, &channel.UpdatedAt); err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// SaveNotifyChannel inserts the channel when ID is 0, otherwise updates it
+func (c *NotifyChannel) SaveNotifyChannel(db *sql.DB) error {
+	if c.Name.String == "" || c.Type.String == "" {
+		return errors.New("name and type cannot be empty")
+	}
+
+	if c.ID.Int64 == 0 {
+		result, err := db.Exec("INSERT INTO notify_channel (name, type, url, secret, recipients, enabled, updated_by, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			c.Name.String, c.Type.String, c.URL.String, c.Secret.String, c.Recipients.String, c.Enabled.Bool, c.UpdatedBy.String, c.UpdatedAt.Int64)
+		if err != nil {
+			return err
+		}
+		c.ID.Int64, err = result.LastInsertId()
+		c.ID.Valid = err == nil
+		return err
+	}
+
+	result, err := db.Exec("UPDATE notify_channel SET name = ?, type = ?, url = ?, secret = ?, recipients = ?, enabled = ?, updated_by = ?, updated_at = ? WHERE id = ?",
+		c.Name.String, c.Type.String, c.URL.String, c.Secret.String, c.Recipients.String, c.Enabled.Bool, c.UpdatedBy.String, c.UpdatedAt.Int64, c.ID.Int64)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		// 内容没有变化时也返回 0，确认记录是否存在
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(*) FROM notify_channel WHERE id = ?", c.ID.Int64).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("NotifyChannel %d not found", c.ID.Int64)
+		}
+	}
+	return nil
+}
+
+// GetNotifyChannelByID retrieves a channel by ID
+func (c *NotifyChannel) GetNotifyChannelByID(db *sql.DB) error {
+	rows, err := db.Query("SELECT "+notifyChannelColumns+" FROM notify_channel WHERE id = ?", c.ID.Int64)
+	if err != nil {
+		return err
+	}
+	channels, err := scanNotifyChannels(rows)
+	if err != nil {
+		return err
+	}
+	if len(channels) == 0 {
+		return fmt.Errorf("NotifyChannel %d not found", c.ID.Int64)
+	}
+	*c = channels[0]
+	return nil
+}
+
+// GetAllNotifyChannel retrieves all channels
+func (c *NotifyChannel) GetAllNotifyChannel(db *sql.DB) ([]NotifyChannel, error) {
+	rows, err := db.Query("SELECT " + notifyChannelColumns + " FROM notify_channel ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanNotifyChannels(rows)
+}
+
+// DeleteNotifyChannel deletes the channel and its rules
+func (c *NotifyChannel) DeleteNotifyChannel(db *sql.DB) error {
+	result, err := db.Exec("DELETE FROM notify_channel WHERE id = ?", c.ID.Int64)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("NotifyChannel %d not found", c.ID.Int64)
+	}
+	_, err = db.Exec("DELETE FROM notify_rule WHERE channel_id = ?", c.ID.Int64)
+	return err
+}
+
+// ToExported converts NotifyRule to ExportedNotifyRule
+func (r *NotifyRule) ToExported() ExportedNotifyRule {
+	return ExportedNotifyRule{
+		ID:        nullInt64ToInt64(r.ID),
+		Event:     nullStringToString(r.Event),
+		SerID:     nullStringToString(r.SerID),
+		ChannelID: nullInt64ToInt64(r.ChannelID),
+		UpdatedBy: nullStringToString(r.UpdatedBy),
+		UpdatedAt: nullInt64ToInt64(r.UpdatedAt),
+	}
+}
+
+// ConvertToNotifyRule converts ExportedNotifyRule to NotifyRule
+func (exported *ExportedNotifyRule) ConvertToNotifyRule() NotifyRule {
+	return NotifyRule{
+		ID:        sql.NullInt64{Int64: exported.ID, Valid: exported.ID != 0},
+		Event:     sql.NullString{String: exported.Event, Valid: exported.Event != ""},
+		SerID:     sql.NullString{String: exported.SerID, Valid: true},
+		ChannelID: sql.NullInt64{Int64: exported.ChannelID, Valid: exported.ChannelID != 0},
+		UpdatedBy: sql.NullString{String: exported.UpdatedBy, Valid: exported.UpdatedBy != ""},
+		UpdatedAt: sql.NullInt64{Int64: exported.UpdatedAt, Valid: exported.UpdatedAt != 0},
+	}
+}
+
+// InsertNotifyRule inserts a rule, the same event, subnet and channel can only be added once
+func (r *NotifyRule) InsertNotifyRule(db *sql.DB) error {
+	if r.Event.String == "" || r.ChannelID.Int64 == 0 {
+		return errors.New("event and channel_id cannot be empty")
+	}
+	result, err := db.Exec("INSERT INTO notify_rule (event, ser_id, channel_id, updated_by, updated_at) VALUES (?, ?, ?, ?, ?)",
+		r.Event.String, r.SerID.String, r.ChannelID.Int64, r.UpdatedBy.String, r.UpdatedAt.Int64)
+	if err != nil {
+		return err
+	}
+	r.ID.Int64, err = result.LastInsertId()
+	r.ID.Valid = err == nil
+	return err
+}
+
+// GetAllNotifyRule retrieves all rules
+func (r *NotifyRule) GetAllNotifyRule(db *sql.DB) ([]NotifyRule, error) {
+	rows, err := db.Query("SELECT id, event, ser_id, channel_id, updated_by, updated_at FROM notify_rule ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []NotifyRule
+	for rows.Next() {
+		var rule NotifyRule
+		if err := rows.Scan(&rule.ID, &rule.Event, &rule.SerID, &rule.ChannelID, &rule.UpdatedBy, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteNotifyRule deletes a rule by ID
+func (r *NotifyRule) DeleteNotifyRule(db *sql.DB) error {
+	result, err := db.Exec("DELETE FROM notify_rule WHERE id = ?", r.ID.Int64)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("NotifyRule %d not found", r.ID.Int64)
+	}
+	return nil
+}
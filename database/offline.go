@@ -0,0 +1,126 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"jwireguard/global"
+)
+
+const (
+	OFFLINESCOPESUBNET = "subnet" // 子网内所有设备
+	OFFLINESCOPEDEVICE = "device" // 单个设备，优先于子网
+)
+
+// OfflinePolicy 子网或设备的离线判断参数
+// 超过 interval * missed 秒没有心跳时判断为离线
+type OfflinePolicy struct {
+	Scope     sql.NullString `json:"scope"`
+	TargetID  sql.NullString `json:"target_id"` // scope 为 subnet 时是 ser_id，为 device 时是 cli_id
+	Interval  sql.NullInt64  `json:"interval"`  // 心跳间隔(秒)
+	Missed    sql.NullInt64  `json:"missed"`    // 连续丢失的心跳次数
+	UpdatedBy sql.NullString `json:"updated_by"`
+	UpdatedAt sql.NullInt64  `json:"updated_at"`
+}
+
+type ExportedOfflinePolicy struct {
+	Scope     string `json:"scope"`
+	TargetID  string `json:"target_id"`
+	Interval  int64  `json:"interval"`
+	Missed    int64  `json:"missed"`
+	UpdatedBy string `json:"updated_by"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// CreateOfflinePolicy creates the offline_policy table in MySQL
+func (p *OfflinePolicy) CreateOfflinePolicy(db *sql.DB) {
+	if !tableExists(db, "offline_policy") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS offline_policy (
+            scope VARCHAR(16) NOT NULL,
+            target_id VARCHAR(255) NOT NULL,
+            interval_sec INT NOT NULL,
+            missed INT NOT NULL,
+            updated_by VARCHAR(255),
+            updated_at BIGINT,
+            PRIMARY KEY (scope, target_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateOfflinePolicy] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts OfflinePolicy to ExportedOfflinePolicy
+func (p *OfflinePolicy) ToExported() ExportedOfflinePolicy {
+	return ExportedOfflinePolicy{
+		Scope:     nullStringToString(p.Scope),
+		TargetID:  nullStringToString(p.TargetID),
+		Interval:  nullInt64ToInt64(p.Interval),
+		Missed:    nullInt64ToInt64(p.Missed),
+		UpdatedBy: nullStringToString(p.UpdatedBy),
+		UpdatedAt: nullInt64ToInt64(p.UpdatedAt),
+	}
+}
+
+// ConvertToOfflinePolicy converts ExportedOfflinePolicy to OfflinePolicy
+func (exported *ExportedOfflinePolicy) ConvertToOfflinePolicy() OfflinePolicy {
+	return OfflinePolicy{
+		Scope:     sql.NullString{String: exported.Scope, Valid: exported.Scope != ""},
+		TargetID:  sql.NullString{String: exported.TargetID, Valid: exported.TargetID != ""},
+		Interval:  sql.NullInt64{Int64: exported.Interval, Valid: exported.Interval != 0},
+		Missed:    sql.NullInt64{Int64: exported.Missed, Valid: exported.Missed != 0},
+		UpdatedBy: sql.NullString{String: exported.UpdatedBy, Valid: exported.UpdatedBy != ""},
+		UpdatedAt: sql.NullInt64{Int64: exported.UpdatedAt, Valid: exported.UpdatedAt != 0},
+	}
+}
+
+// SaveOfflinePolicy inserts or replaces the policy of a subnet or device
+func (p *OfflinePolicy) SaveOfflinePolicy(db *sql.DB) error {
+	if p.Scope.String != OFFLINESCOPESUBNET && p.Scope.String != OFFLINESCOPEDEVICE {
+		return fmt.Errorf("invalid scope: %s", p.Scope.String)
+	}
+	if p.TargetID.String == "" || p.Interval.Int64 <= 0 || p.Missed.Int64 <= 0 {
+		return fmt.Errorf("invalid offline policy: target_id %q interval %d missed %d", p.TargetID.String, p.Interval.Int64, p.Missed.Int64)
+	}
+
+	_, err := db.Exec(`INSERT INTO offline_policy (scope, target_id, interval_sec, missed, updated_by, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE interval_sec = VALUES(interval_sec), missed = VALUES(missed), updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`,
+		p.Scope.String, p.TargetID.String, p.Interval.Int64, p.Missed.Int64, p.UpdatedBy.String, p.UpdatedAt.Int64)
+	return err
+}
+
+// GetAllOfflinePolicy retrieves all policies
+func (p *OfflinePolicy) GetAllOfflinePolicy(db *sql.DB) ([]OfflinePolicy, error) {
+	rows, err := db.Query("SELECT scope, target_id, interval_sec, missed, updated_by, updated_at FROM offline_policy ORDER BY scope, target_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []OfflinePolicy
+	for rows.Next() {
+		var policy OfflinePolicy
+		if err := rows.Scan(&policy.Scope, &policy.TargetID, &policy.Interval, &policy.Missed, &policy.UpdatedBy, &policy.UpdatedAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// DeleteOfflinePolicy deletes the policy of a subnet or device
+func (p *OfflinePolicy) DeleteOfflinePolicy(db *sql.DB) error {
+	result, err := db.Exec("DELETE FROM offline_policy WHERE scope = ? AND target_id = ?", p.Scope.String, p.TargetID.String)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("OfflinePolicy %s %s not found", p.Scope.String, p.TargetID.String)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"jwireguard/global"
+	"strings"
+)
+
+const (
+	OUTBOXPENDING = "pending" // 等待发送或等待重试
+	OUTBOXSENT    = "sent"    // 发送成功
+	OUTBOXDEAD    = "dead"    // 重试次数用完，需要手动重试
+)
+
+// NotifyOutbox 等待发送的通知，每个渠道一条
+type NotifyOutbox struct {
+	ID         sql.NullInt64  `json:"id"`
+	Event      sql.NullString `json:"event"`
+	SerID      sql.NullString `json:"ser_id"`
+	CliID      sql.NullString `json:"cli_id"`
+	Channel    sql.NullString `json:"channel"` // 渠道名称，为空时使用默认邮件
+	Title      sql.NullString `json:"title"`
+	Text       sql.NullString `json:"text"`
+	HTML       sql.NullString `json:"html"`
+	Recipients sql.NullString `json:"recipients"` // 逗号分隔
+	Status     sql.NullString `json:"status"`
+	Attempts   sql.NullInt64  `json:"attempts"`
+	LastError  sql.NullString `json:"last_error"`
+	NextAt     sql.NullInt64  `json:"next_at"`
+	CreatedAt  sql.NullInt64  `json:"created_at"`
+	SentAt     sql.NullInt64  `json:"sent_at"`
+}
+
+type ExportedNotifyOutbox struct {
+	ID         int64    `json:"id"`
+	Event      string   `json:"event"`
+	SerID      string   `json:"ser_id"`
+	CliID      string   `json:"cli_id"`
+	Channel    string   `json:"channel"`
+	Title      string   `json:"title"`
+	Text       string   `json:"text"`
+	Recipients []string `json:"recipients"`
+	Status     string   `json:"status"`
+	Attempts   int64    `json:"attempts"`
+	LastError  string   `json:"last_error"`
+	NextAt     int64    `json:"next_at"`
+	CreatedAt  int64    `json:"created_at"`
+	SentAt     int64    `json:"sent_at"`
+}
+
+const notifyOutboxColumns = "id, event, ser_id, cli_id, channel, title, text, html, recipients, status, attempts, last_error, next_at, created_at, sent_at"
+
+// CreateNotifyOutbox creates the notify_outbox table in MySQL
+func (o *NotifyOutbox) CreateNotifyOutbox(db *sql.DB) {
+	if !tableExists(db, "notify_outbox") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS notify_outbox (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            event VARCHAR(32) NOT NULL,
+            ser_id VARCHAR(255),
+            cli_id VARCHAR(255),
+            channel VARCHAR(64) NOT NULL DEFAULT '',
+            title VARCHAR(255),
+            text TEXT,
+            html MEDIUMTEXT,
+            recipients TEXT,
+            status VARCHAR(16) NOT NULL,
+            attempts INT NOT NULL DEFAULT 0,
+            last_error VARCHAR(1024),
+            next_at BIGINT NOT NULL,
+            created_at BIGINT NOT NULL,
+            sent_at BIGINT,
+            INDEX idx_status_next (status, next_at)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateNotifyOutbox] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts NotifyOutbox to ExportedNotifyOutbox, HTML is omitted
+func (o *NotifyOutbox) ToExported() ExportedNotifyOutbox {
+	return ExportedNotifyOutbox{
+		ID:         nullInt64ToInt64(o.ID),
+		Event:      nullStringToString(o.Event),
+		SerID:      nullStringToString(o.SerID),
+		CliID:      nullStringToString(o.CliID),
+		Channel:    nullStringToString(o.Channel),
+		Title:      nullStringToString(o.Title),
+		Text:       nullStringToString(o.Text),
+		Recipients: o.RecipientList(),
+		Status:     nullStringToString(o.Status),
+		Attempts:   nullInt64ToInt64(o.Attempts),
+		LastError:  nullStringToString(o.LastError),
+		NextAt:     nullInt64ToInt64(o.NextAt),
+		CreatedAt:  nullInt64ToInt64(o.CreatedAt),
+		SentAt:     nullInt64ToInt64(o.SentAt),
+	}
+}
+
+// RecipientList 邮件收件人列表
+func (o *NotifyOutbox) RecipientList() []string {
+	recipients := []string{}
+	for _, recipient := range strings.Split(o.Recipients.String, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+func scanNotifyOutbox(rows *sql.Rows) ([]NotifyOutbox, error) {
+	defer rows.Close()
+
+	var items []NotifyOutbox
+	for rows.Next() {
+		var item NotifyOutbox
+		if err := rows.Scan(&item.ID, &item.Event, &item.SerID, &item.CliID, &item.Channel, &item.Title, &item.Text, &item.HTML,
+			&item.Recipients, &item.Status, &item.Attempts, &item.LastError, &item.NextAt, &item.CreatedAt, &item.SentAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// InsertNotifyOutbox adds a pending notification, due immediately
+func (o *NotifyOutbox) InsertNotifyOutbox(db *sql.DB) error {
+	if o.Event.String == "" {
+		return errors.New("event cannot be empty")
+	}
+	result, err := db.Exec(`INSERT INTO notify_outbox (event, ser_id, cli_id, channel, title, text, html, recipients, status, attempts, last_error, next_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, '', ?, ?)`,
+		o.Event.String, o.SerID.String, o.CliID.String, o.Channel.String, o.Title.String, o.Text.String, o.HTML.String,
+		o.Recipients.String, OUTBOXPENDING, o.CreatedAt.Int64, o.CreatedAt.Int64)
+	if err != nil {
+		return err
+	}
+	o.ID.Int64, err = result.LastInsertId()
+	o.ID.Valid = err == nil
+	return err
+}
+
+// GetDueNotifyOutbox retrieves pending notifications whose next_at has passed, oldest first
+func GetDueNotifyOutbox(db *sql.DB, now int64, limit int) ([]NotifyOutbox, error) {
+	rows, err := db.Query("SELECT "+notifyOutboxColumns+" FROM notify_outbox WHERE status = ? AND next_at <= ? ORDER BY next_at, id LIMIT ?",
+		OUTBOXPENDING, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotifyOutbox(rows)
+}
+
+// GetNotifyOutboxByStatus retrieves notifications by status (all when empty), newest first
+func GetNotifyOutboxByStatus(db *sql.DB, status string, page int) ([]NotifyOutbox, int, error) {
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * PAGINNATIONLIMIT
+
+	where, args := "", []interface{}{}
+	if status != "" {
+		where, args = " WHERE status = ?", append(args, status)
+	}
+	rows, err := db.Query("SELECT "+notifyOutboxColumns+" FROM notify_outbox"+where+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, PAGINNATIONLIMIT, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, err := scanNotifyOutbox(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM notify_outbox"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+// MarkNotifyOutboxSent marks a notification as delivered
+func (o *NotifyOutbox) MarkNotifyOutboxSent(db *sql.DB, now int64) error {
+	_, err := db.Exec("UPDATE notify_outbox SET status = ?, attempts = attempts + 1, last_error = '', sent_at = ? WHERE id = ?",
+		OUTBOXSENT, now, o.ID.Int64)
+	return err
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// MarkNotifyOutboxFailed 记录一次发送失败，dead 为 true 时不再重试，否则在 nextAt 重试
+// ----------------------------------------------------------------------------------------------------------
+func (o *NotifyOutbox) MarkNotifyOutboxFailed(db *sql.DB, lastError string, nextAt int64, dead bool) error {
+	if len(lastError) > 1024 {
+		lastError = lastError[:1024]
+	}
+	status := OUTBOXPENDING
+	if dead {
+		status = OUTBOXDEAD
+	}
+	_, err := db.Exec("UPDATE notify_outbox SET status = ?, attempts = attempts + 1, last_error = ?, next_at = ? WHERE id = ?",
+		status, lastError, nextAt, o.ID.Int64)
+	return err
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// RetryNotifyOutbox 将失败的通知重新放入发件箱，id 为 0 时重试全部失败的通知，返回重试的条数
+// ----------------------------------------------------------------------------------------------------------
+func RetryNotifyOutbox(db *sql.DB, id int64, now int64) (int64, error) {
+	query, args := "UPDATE notify_outbox SET status = ?, attempts = 0, next_at = ? WHERE status = ?", []interface{}{OUTBOXPENDING, now, OUTBOXDEAD}
+	if id != 0 {
+		query, args = query+" AND id = ?", append(args, id)
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if id != 0 && count == 0 {
+		return 0, fmt.Errorf("NotifyOutbox %d not found or not failed", id)
+	}
+	return count, nil
+}
+
+// PurgeNotifyOutbox deletes delivered notifications sent before the given time
+func PurgeNotifyOutbox(db *sql.DB, before int64) (int64, error) {
+	result, err := db.Exec("DELETE FROM notify_outbox WHERE status = ? AND sent_at < ?", OUTBOXSENT, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"jwireguard/global"
+)
+
+const (
+	PROFILEMAILSENT           = "sent"
+	PROFILEMAILFAILED         = "failed"          // 配置没有发出
+	PROFILEMAILPASSWORDFAILED = "password_failed" // 配置已发出，密码没有发出
+)
+
+// ProfileMail 通过邮件发送客户端配置的审计记录，不保存配置内容和密码
+type ProfileMail struct {
+	ID              sql.NullInt64  `json:"id"`
+	CliID           sql.NullString `json:"cli_id"`
+	SerID           sql.NullString `json:"ser_id"`
+	Recipient       sql.NullString `json:"recipient"`
+	Filename        sql.NullString `json:"filename"`
+	Encrypted       sql.NullBool   `json:"encrypted"`        // 是否为加密的 zip
+	PasswordChannel sql.NullString `json:"password_channel"` // 发送密码的通知渠道，为空时密码只返回给操作人
+	Status          sql.NullString `json:"status"`
+	Error           sql.NullString `json:"error"`
+	SentBy          sql.NullString `json:"sent_by"`
+	ClientIP        sql.NullString `json:"client_ip"`
+	CreatedAt       sql.NullInt64  `json:"created_at"`
+}
+
+type ExportedProfileMail struct {
+	ID              int64  `json:"id"`
+	CliID           string `json:"cli_id"`
+	SerID           string `json:"ser_id"`
+	Recipient       string `json:"recipient"`
+	Filename        string `json:"filename"`
+	Encrypted       bool   `json:"encrypted"`
+	PasswordChannel string `json:"password_channel"`
+	Status          string `json:"status"`
+	Error           string `json:"error"`
+	SentBy          string `json:"sent_by"`
+	ClientIP        string `json:"client_ip"`
+	CreatedAt       int64  `json:"created_at"`
+}
+
+// CreateProfileMail creates the cli_profile_mail table in MySQL
+func (p *ProfileMail) CreateProfileMail(db *sql.DB) {
+	if !tableExists(db, "cli_profile_mail") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS cli_profile_mail (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            cli_id VARCHAR(255) NOT NULL,
+            ser_id VARCHAR(255),
+            recipient VARCHAR(255) NOT NULL,
+            filename VARCHAR(255),
+            encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+            password_channel VARCHAR(64),
+            status VARCHAR(16) NOT NULL,
+            error VARCHAR(1024),
+            sent_by VARCHAR(255),
+            client_ip VARCHAR(64),
+            created_at BIGINT NOT NULL,
+            INDEX idx_cli_id (cli_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateProfileMail] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts ProfileMail to ExportedProfileMail
+func (p *ProfileMail) ToExported() ExportedProfileMail {
+	return ExportedProfileMail{
+		ID:              nullInt64ToInt64(p.ID),
+		CliID:           nullStringToString(p.CliID),
+		SerID:           nullStringToString(p.SerID),
+		Recipient:       nullStringToString(p.Recipient),
+		Filename:        nullStringToString(p.Filename),
+		Encrypted:       p.Encrypted.Bool,
+		PasswordChannel: nullStringToString(p.PasswordChannel),
+		Status:          nullStringToString(p.Status),
+		Error:           nullStringToString(p.Error),
+		SentBy:          nullStringToString(p.SentBy),
+		ClientIP:        nullStringToString(p.ClientIP),
+		CreatedAt:       nullInt64ToInt64(p.CreatedAt),
+	}
+}
+
+// InsertProfileMail adds an audit record
+func (p *ProfileMail) InsertProfileMail(db *sql.DB) error {
+	if p.CliID.String == "" || p.Recipient.String == "" {
+		return errors.New("cli_id and recipient cannot be empty")
+	}
+	if len(p.Error.String) > 1024 {
+		p.Error.String = p.Error.String[:1024]
+	}
+	result, err := db.Exec(`INSERT INTO cli_profile_mail (cli_id, ser_id, recipient, filename, encrypted, password_channel, status, error, sent_by, client_ip, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.CliID.String, p.SerID.String, p.Recipient.String, p.Filename.String, p.Encrypted.Bool, p.PasswordChannel.String,
+		p.Status.String, p.Error.String, p.SentBy.String, p.ClientIP.String, p.CreatedAt.Int64)
+	if err != nil {
+		return err
+	}
+	p.ID.Int64, err = result.LastInsertId()
+	p.ID.Valid = err == nil
+	return err
+}
+
+// GetProfileMail retrieves audit records of a client (all clients when empty), newest first
+func GetProfileMail(db *sql.DB, cliId string, page int) ([]ProfileMail, int, error) {
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * PAGINNATIONLIMIT
+
+	where, args := "", []interface{}{}
+	if cliId != "" {
+		where, args = " WHERE cli_id = ?", append(args, cliId)
+	}
+	rows, err := db.Query(`SELECT id, cli_id, ser_id, recipient, filename, encrypted, password_channel, status, error, sent_by, client_ip, created_at
+        FROM cli_profile_mail`+where+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, PAGINNATIONLIMIT, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var records []ProfileMail
+	for rows.Next() {
+		var p ProfileMail
+		if err := rows.Scan(&p.ID, &p.CliID, &p.SerID, &p.Recipient, &p.Filename, &p.Encrypted, &p.PasswordChannel,
+			&p.Status, &p.Error, &p.SentBy, &p.ClientIP, &p.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		records = append(records, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM cli_profile_mail"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"jwireguard/global"
 	"log"
+	"net"
 	"strings"
 )
 
@@ -13,13 +15,18 @@ type Subnet struct {
 	SerName sql.NullString `json:"ser_name"`
 	SerNum  sql.NullInt32  `json:"ser_num"`
 	CliNum  sql.NullInt32  `json:"cli_num"`
+	Backend sql.NullString `json:"backend"` // 隧道后端 openvpn/wireguard
+	CIDR    sql.NullString `json:"cidr"`    // 子网网段，如 10.100.5.0/24
 }
 
 type ExportedSubnet struct {
-	SerID   string `json:"ser_id"`
-	SerName string `json:"ser_name"`
-	SerNum  int32  `json:"ser_num"`
-	CliNum  int32  `json:"cli_num"`
+	SerID     string `json:"ser_id"`
+	SerName   string `json:"ser_name"`
+	SerNum    int32  `json:"ser_num"`
+	CliNum    int32  `json:"cli_num"`
+	Backend   string `json:"backend"`
+	CIDR      string `json:"cidr"`
+	PrefixLen int    `json:"prefix_len"` // 未指定 cidr 时按该前缀长度分配网段
 }
 
 // CreateSubnet creates the subnet table in MySQL
@@ -30,23 +37,92 @@ func (s *Subnet) CreateSubnet(db *sql.DB) {
             ser_name VARCHAR(255),
             ser_num INT,
             cli_num INT,
-            INDEX idx_ser_num (ser_num)
+            backend VARCHAR(32),
+            cidr VARCHAR(64),
+            INDEX idx_ser_num (ser_num),
+            UNIQUE KEY uk_cidr (cidr)
         ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
 		_, err := db.Exec(createTableSQL)
 		if err != nil {
 			log.Println("[CreateSubnet] Error creating table:", err)
 			return
 		}
+	} else {
+		s.addMissingColumns(db)
+	}
+}
+
+// addMissingColumns 为旧版本创建的 subnet 表补充新增字段
+func (s *Subnet) addMissingColumns(db *sql.DB) {
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"backend", "VARCHAR(32)"},
+		{"cidr", "VARCHAR(64)"},
+	}
+
+	for _, column := range columns {
+		if columnExists(db, "subnet", column.name) {
+			continue
+		}
+		alterSQL := fmt.Sprintf("ALTER TABLE subnet ADD COLUMN %s %s", column.name, column.definition)
+		if _, err := db.Exec(alterSQL); err != nil {
+			log.Printf("[CreateSubnet] Error adding column %s: %v", column.name, err)
+		}
+	}
+
+	s.fillLegacyCIDR(db)
+
+	// 旧版本的表通过 ADD COLUMN 补充 cidr，需要单独添加唯一索引
+	if !indexExists(db, "subnet", "uk_cidr") {
+		if _, err := db.Exec("ALTER TABLE subnet ADD UNIQUE KEY uk_cidr (cidr)"); err != nil {
+			log.Println("[CreateSubnet] Error adding unique key uk_cidr:", err)
+		}
+	}
+}
+
+// fillLegacyCIDR 旧版本的子网按 IP_PREFIX.ser_num.0 计算网段
+func (s *Subnet) fillLegacyCIDR(db *sql.DB) {
+	rows, err := db.Query("SELECT ser_id, ser_num FROM subnet WHERE cidr IS NULL OR cidr = ''")
+	if err != nil {
+		log.Println("[CreateSubnet] Error querying legacy subnets:", err)
+		return
+	}
+	legacy := make(map[string]int32)
+	for rows.Next() {
+		var serId string
+		var serNum sql.NullInt32
+		if err := rows.Scan(&serId, &serNum); err != nil {
+			log.Println("[CreateSubnet] Error scanning legacy subnets:", err)
+			break
+		}
+		legacy[serId] = serNum.Int32
+	}
+	rows.Close()
+
+	for serId, serNum := range legacy {
+		cidr, err := global.LegacySubnetCIDR(serNum)
+		if err != nil {
+			log.Printf("[CreateSubnet] Error computing cidr of %s: %v", serId, err)
+			continue
+		}
+		if _, err := db.Exec("UPDATE subnet SET cidr = ? WHERE ser_id = ?", cidr, serId); err != nil {
+			log.Printf("[CreateSubnet] Error updating cidr of %s: %v", serId, err)
+		}
 	}
 }
 
 // ToExported converts Subnet to ExportedSubnet
 func (s *Subnet) ToExported() ExportedSubnet {
 	return ExportedSubnet{
-		SerID:   nullStringToString(s.SerID),
-		SerName: nullStringToString(s.SerName),
-		SerNum:  nullInt32ToInt32(s.SerNum),
-		CliNum:  nullInt32ToInt32(s.CliNum),
+		SerID:     nullStringToString(s.SerID),
+		SerName:   nullStringToString(s.SerName),
+		SerNum:    nullInt32ToInt32(s.SerNum),
+		CliNum:    nullInt32ToInt32(s.CliNum),
+		Backend:   nullStringToString(s.Backend),
+		CIDR:      nullStringToString(s.CIDR),
+		PrefixLen: s.PrefixLen(),
 	}
 }
 
@@ -57,18 +133,24 @@ func (exported *ExportedSubnet) ConvertToSubnet() Subnet {
 		SerName: sql.NullString{String: exported.SerName, Valid: exported.SerName != ""},
 		SerNum:  sql.NullInt32{Int32: exported.SerNum, Valid: exported.SerNum != -1},
 		CliNum:  sql.NullInt32{Int32: exported.CliNum, Valid: exported.CliNum != -1},
+		Backend: sql.NullString{String: exported.Backend, Valid: exported.Backend != ""},
+		CIDR:    sql.NullString{String: exported.CIDR, Valid: exported.CIDR != ""},
 	}
 }
 
 // InsertSubnet inserts a new subnet record
 func (s *Subnet) InsertSubnet(db *sql.DB) error {
-	stmt, err := db.Prepare("INSERT INTO subnet (ser_id, ser_name, ser_num, cli_num) VALUES(?, ?, ?, ?)")
+	return s.insertSubnet(db)
+}
+
+func (s *Subnet) insertSubnet(db preparer) error {
+	stmt, err := db.Prepare("INSERT INTO subnet (ser_id, ser_name, ser_num, cli_num, backend, cidr) VALUES(?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(s.SerID.String, s.SerName.String, s.SerNum.Int32, s.CliNum.Int32)
+	_, err = stmt.Exec(s.SerID.String, s.SerName.String, s.SerNum.Int32, s.CliNum.Int32, s.Backend.String, s.CIDR.String)
 	if err != nil {
 		return err
 	}
@@ -78,10 +160,10 @@ func (s *Subnet) InsertSubnet(db *sql.DB) error {
 
 // GetSubnetBySerId retrieves a subnet by ser_id
 func (s *Subnet) GetSubnetBySerId(db *sql.DB) error {
-	query := "SELECT ser_id, ser_name, ser_num, cli_num FROM subnet WHERE ser_id = ?"
+	query := "SELECT ser_id, ser_name, ser_num, cli_num, backend, cidr FROM subnet WHERE ser_id = ?"
 	row := db.QueryRow(query, s.SerID.String)
 
-	err := row.Scan(&s.SerID, &s.SerName, &s.SerNum, &s.CliNum)
+	err := row.Scan(&s.SerID, &s.SerName, &s.SerNum, &s.CliNum, &s.Backend, &s.CIDR)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return fmt.Errorf("Subnet with SerID %s not found", s.SerID.String)
@@ -100,7 +182,7 @@ func (s *Subnet) GetSubnetBySerIDs(db *sql.DB, serids []string) ([]Subnet, error
 
 	placeholders := strings.Repeat("?,", len(serids))
 	placeholders = placeholders[:len(placeholders)-1]
-	query := fmt.Sprintf("SELECT ser_id, ser_name, ser_num, cli_num FROM subnet WHERE ser_id IN (%s) ORDER BY ser_name", placeholders)
+	query := fmt.Sprintf("SELECT ser_id, ser_name, ser_num, cli_num, backend, cidr FROM subnet WHERE ser_id IN (%s) ORDER BY ser_name", placeholders)
 
 	args := make([]interface{}, len(serids))
 	for i, id := range serids {
@@ -116,7 +198,7 @@ func (s *Subnet) GetSubnetBySerIDs(db *sql.DB, serids []string) ([]Subnet, error
 	var subnets []Subnet
 	for rows.Next() {
 		var subnet Subnet
-		err := rows.Scan(&subnet.SerID, &subnet.SerName, &subnet.SerNum, &subnet.CliNum)
+		err := rows.Scan(&subnet.SerID, &subnet.SerName, &subnet.SerNum, &subnet.CliNum, &subnet.Backend, &subnet.CIDR)
 		if err != nil {
 			return nil, err
 		}
@@ -132,7 +214,7 @@ func (s *Subnet) GetSubnetBySerIDs(db *sql.DB, serids []string) ([]Subnet, error
 
 // GetAllSubnet retrieves all subnet records
 func (s *Subnet) GetAllSubnet(db *sql.DB) ([]Subnet, error) {
-	query := "SELECT ser_id, ser_name, ser_num, cli_num FROM subnet"
+	query := "SELECT ser_id, ser_name, ser_num, cli_num, backend, cidr FROM subnet"
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
@@ -142,7 +224,7 @@ func (s *Subnet) GetAllSubnet(db *sql.DB) ([]Subnet, error) {
 	var subnets []Subnet
 	for rows.Next() {
 		var subnet Subnet
-		err := rows.Scan(&subnet.SerID, &subnet.SerName, &subnet.SerNum, &subnet.CliNum)
+		err := rows.Scan(&subnet.SerID, &subnet.SerName, &subnet.SerNum, &subnet.CliNum, &subnet.Backend, &subnet.CIDR)
 		if err != nil {
 			return nil, err
 		}
@@ -158,6 +240,10 @@ func (s *Subnet) GetAllSubnet(db *sql.DB) ([]Subnet, error) {
 
 // UpdateSubnet updates a subnet record
 func (s *Subnet) UpdateSubnet(db *sql.DB) error {
+	return s.updateSubnet(db)
+}
+
+func (s *Subnet) updateSubnet(db preparer) error {
 	if s.SerID.String == "" {
 		return errors.New("ser_id cannot be empty")
 	}
@@ -177,6 +263,14 @@ func (s *Subnet) UpdateSubnet(db *sql.DB) error {
 		setClauses = append(setClauses, "cli_num = ?")
 		args = append(args, s.CliNum.Int32)
 	}
+	if s.Backend.String != "" {
+		setClauses = append(setClauses, "backend = ?")
+		args = append(args, s.Backend.String)
+	}
+	if s.CIDR.String != "" {
+		setClauses = append(setClauses, "cidr = ?")
+		args = append(args, s.CIDR.String)
+	}
 
 	if len(setClauses) == 0 {
 		return errors.New("no fields to update")
@@ -223,47 +317,95 @@ func (s *Subnet) TableExists(db *sql.DB) bool {
 	return err == nil
 }
 
-// GetNewSubnetNumber finds an available subnet number
-func (s *Subnet) GetNewSubnetNumber(db *sql.DB) (int32, error) {
-	// More efficient query to find gaps in the sequence
-	query := `SELECT MIN(t1.ser_num) + 1 
-              FROM subnet t1
-              WHERE NOT EXISTS (
-                  SELECT 1 FROM subnet t2 
-                  WHERE t2.ser_num = t1.ser_num + 1
-              ) AND t1.ser_num < 254`
-
-	var availableNum sql.NullInt32
-	err := db.QueryRow(query).Scan(&availableNum)
-	if err != nil && err != sql.ErrNoRows {
-		return -1, err
+// Network 解析子网网段
+func (s *Subnet) Network() (*net.IPNet, error) {
+	if s.CIDR.String == "" {
+		return nil, fmt.Errorf("Subnet %s has no cidr", s.SerID.String)
 	}
-
-	// If we found a gap, return it
-	if availableNum.Valid && availableNum.Int32 <= 254 {
-		return availableNum.Int32, nil
+	_, network, err := net.ParseCIDR(s.CIDR.String)
+	if err != nil {
+		return nil, err
 	}
+	return network, nil
+}
 
-	// If no gaps found, check if 0 is available
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM subnet WHERE ser_num = 0").Scan(&count)
+// PrefixLen 子网网段的前缀长度
+func (s *Subnet) PrefixLen() int {
+	network, err := s.Network()
 	if err != nil {
-		return -1, err
+		return 0
 	}
-	if count == 0 {
-		return 0, nil
+	ones, _ := network.Mask.Size()
+	return ones
+}
+
+// NewSubnetNetwork finds the first free network of prefixLen in the supernet
+func NewSubnetNetwork(prefixLen int, used []*net.IPNet) (*net.IPNet, error) {
+	supernet, err := global.Supernet()
+	if err != nil {
+		return nil, err
 	}
+	return global.NextFreeSubnet(supernet, prefixLen, used, global.ReservedAddresses())
+}
 
-	// Otherwise find the max number + 1
-	var maxNum sql.NullInt32
-	err = db.QueryRow("SELECT MAX(ser_num) FROM subnet").Scan(&maxNum)
+// ----------------------------------------------------------------------------------------------------------
+// InsertSubnetWithNetwork 在锁定 subnet 表的事务中确定网段并插入子网，并发添加的子网不会重叠
+// resolve 根据其他子网已占用的网段返回本子网的网段，cidr 和 ser_num 由返回的网段填写
+// ----------------------------------------------------------------------------------------------------------
+func (s *Subnet) InsertSubnetWithNetwork(db *sql.DB, resolve func(used []*net.IPNet) (*net.IPNet, error)) (*net.IPNet, error) {
+	return s.withNetwork(db, resolve, s.insertSubnet)
+}
+
+// UpdateSubnetWithNetwork 与 InsertSubnetWithNetwork 相同，用于修改子网网段
+func (s *Subnet) UpdateSubnetWithNetwork(db *sql.DB, resolve func(used []*net.IPNet) (*net.IPNet, error)) (*net.IPNet, error) {
+	return s.withNetwork(db, resolve, s.updateSubnet)
+}
+
+func (s *Subnet) withNetwork(db *sql.DB, resolve func(used []*net.IPNet) (*net.IPNet, error), write func(preparer) error) (*net.IPNet, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	if maxNum.Valid && maxNum.Int32 < 254 {
-		return maxNum.Int32 + 1, nil
+	// FOR UPDATE 锁定全表，其他添加或修改网段的事务在提交前等待
+	rows, err := tx.Query("SELECT ser_id, cidr FROM subnet FOR UPDATE")
+	if err != nil {
+		return nil, err
+	}
+	var used []*net.IPNet
+	for rows.Next() {
+		var serId, cidr sql.NullString
+		if err := rows.Scan(&serId, &cidr); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if serId.String == s.SerID.String {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr.String)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("Subnet %s has invalid cidr %q", serId.String, cidr.String)
+		}
+		used = append(used, network)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return -1, errors.New("no available subnet numbers")
+	network, err := resolve(used)
+	if err != nil {
+		return nil, err
+	}
+	s.CIDR.String = network.String()
+	s.SerNum.Int32 = global.SubnetNumber(network)
+	if err := write(tx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return network, nil
 }
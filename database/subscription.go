@@ -0,0 +1,361 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"jwireguard/global"
+	"strings"
+)
+
+// NotifySubscription 用户订阅的事件，event 为 * 时匹配所有事件，ser_id 为空时匹配用户可见的所有子网
+type NotifySubscription struct {
+	ID          sql.NullInt64  `json:"id"`
+	UserID      sql.NullString `json:"user_id"`
+	Event       sql.NullString `json:"event"`
+	SerID       sql.NullString `json:"ser_id"`
+	ChannelID   sql.NullInt64  `json:"channel_id"`   // 通知渠道，0 表示发送到用户邮箱
+	MinSeverity sql.NullString `json:"min_severity"` // info/warning/critical，为空时不过滤
+	UpdatedBy   sql.NullString `json:"updated_by"`
+	UpdatedAt   sql.NullInt64  `json:"updated_at"`
+}
+
+type ExportedNotifySubscription struct {
+	ID          int64  `json:"id"`
+	UserID      string `json:"user_id"`
+	Event       string `json:"event"`
+	SerID       string `json:"ser_id"`
+	ChannelID   int64  `json:"channel_id"`
+	MinSeverity string `json:"min_severity"`
+	UpdatedBy   string `json:"updated_by"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// QuietHours 用户的免打扰时段，start 和 end 为 timezone 的本地时间，start 大于 end 时跨越零点
+type QuietHours struct {
+	UserID    sql.NullString `json:"user_id"`
+	Enabled   sql.NullBool   `json:"enabled"`
+	Start     sql.NullString `json:"start"` // HH:MM
+	End       sql.NullString `json:"end"`   // HH:MM
+	Timezone  sql.NullString `json:"timezone"`
+	UpdatedBy sql.NullString `json:"updated_by"`
+	UpdatedAt sql.NullInt64  `json:"updated_at"`
+}
+
+type ExportedQuietHours struct {
+	UserID    string `json:"user_id"`
+	Enabled   bool   `json:"enabled"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Timezone  string `json:"timezone"`
+	UpdatedBy string `json:"updated_by"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// NotifyHeld 免打扰期间暂存的通知，结束后合并为一条摘要发送
+type NotifyHeld struct {
+	ID         sql.NullInt64  `json:"id"`
+	UserID     sql.NullString `json:"user_id"`
+	Channel    sql.NullString `json:"channel"` // 渠道名称，为空时使用默认邮件
+	Recipients sql.NullString `json:"recipients"`
+	Event      sql.NullString `json:"event"`
+	Severity   sql.NullString `json:"severity"`
+	SerID      sql.NullString `json:"ser_id"`
+	CliID      sql.NullString `json:"cli_id"`
+	Title      sql.NullString `json:"title"`
+	Text       sql.NullString `json:"text"`
+	CreatedAt  sql.NullInt64  `json:"created_at"`
+}
+
+// CreateNotifySubscription creates the notify_subscription, notify_quiet_hours and notify_held tables in MySQL
+func (s *NotifySubscription) CreateNotifySubscription(db *sql.DB) {
+	if !tableExists(db, "notify_subscription") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS notify_subscription (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            user_id VARCHAR(255) NOT NULL,
+            event VARCHAR(32) NOT NULL,
+            ser_id VARCHAR(255) NOT NULL DEFAULT '',
+            channel_id BIGINT NOT NULL DEFAULT 0,
+            min_severity VARCHAR(16) NOT NULL DEFAULT '',
+            updated_by VARCHAR(255),
+            updated_at BIGINT,
+            UNIQUE KEY uk_subscription (user_id, event, ser_id, channel_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateNotifySubscription] Error creating table:", err)
+			return
+		}
+	}
+
+	if !tableExists(db, "notify_quiet_hours") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS notify_quiet_hours (
+            user_id VARCHAR(255) NOT NULL PRIMARY KEY,
+            enabled BOOLEAN NOT NULL DEFAULT FALSE,
+            quiet_start VARCHAR(5) NOT NULL DEFAULT '',
+            quiet_end VARCHAR(5) NOT NULL DEFAULT '',
+            timezone VARCHAR(64) NOT NULL DEFAULT '',
+            updated_by VARCHAR(255),
+            updated_at BIGINT
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateNotifySubscription] Error creating notify_quiet_hours table:", err)
+			return
+		}
+	}
+
+	if !tableExists(db, "notify_held") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS notify_held (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            user_id VARCHAR(255) NOT NULL,
+            channel VARCHAR(64) NOT NULL DEFAULT '',
+            recipients TEXT,
+            event VARCHAR(32) NOT NULL,
+            severity VARCHAR(16),
+            ser_id VARCHAR(255),
+            cli_id VARCHAR(255),
+            title VARCHAR(255),
+            text TEXT,
+            created_at BIGINT NOT NULL,
+            INDEX idx_user_id (user_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateNotifySubscription] Error creating notify_held table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts NotifySubscription to ExportedNotifySubscription
+func (s *NotifySubscription) ToExported() ExportedNotifySubscription {
+	return ExportedNotifySubscription{
+		ID:          nullInt64ToInt64(s.ID),
+		UserID:      nullStringToString(s.UserID),
+		Event:       nullStringToString(s.Event),
+		SerID:       nullStringToString(s.SerID),
+		ChannelID:   nullInt64ToInt64(s.ChannelID),
+		MinSeverity: nullStringToString(s.MinSeverity),
+		UpdatedBy:   nullStringToString(s.UpdatedBy),
+		UpdatedAt:   nullInt64ToInt64(s.UpdatedAt),
+	}
+}
+
+// ConvertToNotifySubscription converts ExportedNotifySubscription to NotifySubscription
+func (exported *ExportedNotifySubscription) ConvertToNotifySubscription() NotifySubscription {
+	return NotifySubscription{
+		ID:          sql.NullInt64{Int64: exported.ID, Valid: exported.ID != 0},
+		UserID:      sql.NullString{String: exported.UserID, Valid: exported.UserID != ""},
+		Event:       sql.NullString{String: exported.Event, Valid: exported.Event != ""},
+		SerID:       sql.NullString{String: exported.SerID, Valid: true},
+		ChannelID:   sql.NullInt64{Int64: exported.ChannelID, Valid: true},
+		MinSeverity: sql.NullString{String: exported.MinSeverity, Valid: true},
+		UpdatedBy:   sql.NullString{String: exported.UpdatedBy, Valid: exported.UpdatedBy != ""},
+		UpdatedAt:   sql.NullInt64{Int64: exported.UpdatedAt, Valid: exported.UpdatedAt != 0},
+	}
+}
+
+func scanNotifySubscriptions(rows *sql.Rows) ([]NotifySubscription, error) {
+	defer rows.Close()
+
+	var subscriptions []NotifySubscription
+	for rows.Next() {
+		var subscription NotifySubscription
+		if err := rows.Scan(&subscription.ID, &subscription.UserID, &subscription.Event, &subscription.SerID, &subscription.ChannelID,
+			&subscription.MinSeverity, &subscription.UpdatedBy, &subscription.UpdatedAt); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// GetAllNotifySubscription retrieves the subscriptions of all users
+func (s *NotifySubscription) GetAllNotifySubscription(db *sql.DB) ([]NotifySubscription, error) {
+	rows, err := db.Query("SELECT id, user_id, event, ser_id, channel_id, min_severity, updated_by, updated_at FROM notify_subscription ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanNotifySubscriptions(rows)
+}
+
+// GetNotifySubscriptionByUserID retrieves the subscriptions of a user
+func (s *NotifySubscription) GetNotifySubscriptionByUserID(db *sql.DB) ([]NotifySubscription, error) {
+	rows, err := db.Query("SELECT id, user_id, event, ser_id, channel_id, min_severity, updated_by, updated_at FROM notify_subscription WHERE user_id = ? ORDER BY id",
+		s.UserID.String)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotifySubscriptions(rows)
+}
+
+// ReplaceNotifySubscription replaces all subscriptions of a user
+func ReplaceNotifySubscription(db *sql.DB, userId string, subscriptions []NotifySubscription) error {
+	if userId == "" {
+		return errors.New("user_id cannot be empty")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM notify_subscription WHERE user_id = ?", userId); err != nil {
+		return err
+	}
+	for _, s := range subscriptions {
+		if s.Event.String == "" {
+			return errors.New("event cannot be empty")
+		}
+		_, err := tx.Exec("INSERT INTO notify_subscription (user_id, event, ser_id, channel_id, min_severity, updated_by, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			userId, s.Event.String, s.SerID.String, s.ChannelID.Int64, s.MinSeverity.String, s.UpdatedBy.String, s.UpdatedAt.Int64)
+		if err != nil {
+			return fmt.Errorf("subscription %s/%s/%d: %v", s.Event.String, s.SerID.String, s.ChannelID.Int64, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteNotifySubscription deletes the subscriptions, quiet hours and held notifications of a user
+func (s *NotifySubscription) DeleteNotifySubscription(db *sql.DB) error {
+	for _, table := range []string{"notify_subscription", "notify_quiet_hours", "notify_held"} {
+		if _, err := db.Exec("DELETE FROM "+table+" WHERE user_id = ?", s.UserID.String); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToExported converts QuietHours to ExportedQuietHours
+func (q *QuietHours) ToExported() ExportedQuietHours {
+	return ExportedQuietHours{
+		UserID:    nullStringToString(q.UserID),
+		Enabled:   NullBoolToBool(q.Enabled),
+		Start:     nullStringToString(q.Start),
+		End:       nullStringToString(q.End),
+		Timezone:  nullStringToString(q.Timezone),
+		UpdatedBy: nullStringToString(q.UpdatedBy),
+		UpdatedAt: nullInt64ToInt64(q.UpdatedAt),
+	}
+}
+
+// ConvertToQuietHours converts ExportedQuietHours to QuietHours
+func (exported *ExportedQuietHours) ConvertToQuietHours() QuietHours {
+	return QuietHours{
+		UserID:    sql.NullString{String: exported.UserID, Valid: exported.UserID != ""},
+		Enabled:   sql.NullBool{Bool: exported.Enabled, Valid: true},
+		Start:     sql.NullString{String: exported.Start, Valid: true},
+		End:       sql.NullString{String: exported.End, Valid: true},
+		Timezone:  sql.NullString{String: exported.Timezone, Valid: true},
+		UpdatedBy: sql.NullString{String: exported.UpdatedBy, Valid: exported.UpdatedBy != ""},
+		UpdatedAt: sql.NullInt64{Int64: exported.UpdatedAt, Valid: exported.UpdatedAt != 0},
+	}
+}
+
+// SaveQuietHours inserts or updates the quiet hours of a user
+func (q *QuietHours) SaveQuietHours(db *sql.DB) error {
+	if q.UserID.String == "" {
+		return errors.New("user_id cannot be empty")
+	}
+	_, err := db.Exec(`INSERT INTO notify_quiet_hours (user_id, enabled, quiet_start, quiet_end, timezone, updated_by, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE enabled = VALUES(enabled), quiet_start = VALUES(quiet_start), quiet_end = VALUES(quiet_end), timezone = VALUES(timezone),
+        updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`,
+		q.UserID.String, q.Enabled.Bool, q.Start.String, q.End.String, q.Timezone.String, q.UpdatedBy.String, q.UpdatedAt.Int64)
+	return err
+}
+
+// GetQuietHoursByUserID retrieves the quiet hours of a user, sql.ErrNoRows when not set
+func (q *QuietHours) GetQuietHoursByUserID(db *sql.DB) error {
+	return db.QueryRow("SELECT user_id, enabled, quiet_start, quiet_end, timezone, updated_by, updated_at FROM notify_quiet_hours WHERE user_id = ?",
+		q.UserID.String).Scan(&q.UserID, &q.Enabled, &q.Start, &q.End, &q.Timezone, &q.UpdatedBy, &q.UpdatedAt)
+}
+
+// GetAllQuietHours retrieves the quiet hours of all users keyed by user_id
+func (q *QuietHours) GetAllQuietHours(db *sql.DB) (map[string]QuietHours, error) {
+	rows, err := db.Query("SELECT user_id, enabled, quiet_start, quiet_end, timezone, updated_by, updated_at FROM notify_quiet_hours")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quietHours := make(map[string]QuietHours)
+	for rows.Next() {
+		var item QuietHours
+		if err := rows.Scan(&item.UserID, &item.Enabled, &item.Start, &item.End, &item.Timezone, &item.UpdatedBy, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		quietHours[item.UserID.String] = item
+	}
+	return quietHours, rows.Err()
+}
+
+// RecipientList 邮件收件人列表
+func (h *NotifyHeld) RecipientList() []string {
+	recipients := []string{}
+	for _, recipient := range strings.Split(h.Recipients.String, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// InsertNotifyHeld adds a held notification
+func (h *NotifyHeld) InsertNotifyHeld(db *sql.DB) error {
+	if h.UserID.String == "" || h.Event.String == "" {
+		return errors.New("user_id and event cannot be empty")
+	}
+	result, err := db.Exec(`INSERT INTO notify_held (user_id, channel, recipients, event, severity, ser_id, cli_id, title, text, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		h.UserID.String, h.Channel.String, h.Recipients.String, h.Event.String, h.Severity.String, h.SerID.String, h.CliID.String,
+		h.Title.String, h.Text.String, h.CreatedAt.Int64)
+	if err != nil {
+		return err
+	}
+	h.ID.Int64, err = result.LastInsertId()
+	h.ID.Valid = err == nil
+	return err
+}
+
+// GetAllNotifyHeld retrieves all held notifications, oldest first
+func (h *NotifyHeld) GetAllNotifyHeld(db *sql.DB) ([]NotifyHeld, error) {
+	rows, err := db.Query("SELECT id, user_id, channel, recipients, event, severity, ser_id, cli_id, title, text, created_at FROM notify_held ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []NotifyHeld
+	for rows.Next() {
+		var item NotifyHeld
+		if err := rows.Scan(&item.ID, &item.UserID, &item.Channel, &item.Recipients, &item.Event, &item.Severity, &item.SerID, &item.CliID,
+			&item.Title, &item.Text, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// CountNotifyHeld counts the held notifications of a user
+func (h *NotifyHeld) CountNotifyHeld(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM notify_held WHERE user_id = ?", h.UserID.String).Scan(&count)
+	return count, err
+}
+
+// DeleteNotifyHeld deletes held notifications by ID
+func DeleteNotifyHeld(db *sql.DB, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := db.Exec(fmt.Sprintf("DELETE FROM notify_held WHERE id IN (%s)", placeholders), args...)
+	return err
+}
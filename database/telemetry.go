@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwireguard/global"
+	"strings"
+)
+
+// CliTelemetry 设备心跳上报的运行状态，按时间保存
+type CliTelemetry struct {
+	ID       sql.NullInt64   `json:"id"`
+	CliID    sql.NullString  `json:"cli_id"`
+	Ts       sql.NullInt64   `json:"ts"`
+	Firmware sql.NullString  `json:"firmware"`
+	Uptime   sql.NullInt64   `json:"uptime"`
+	CPU      sql.NullFloat64 `json:"cpu"`
+	Memory   sql.NullFloat64 `json:"memory"`
+	WanIP    sql.NullString  `json:"wan_ip"`
+	Signal   sql.NullInt64   `json:"signal"`
+	Custom   sql.NullString  `json:"custom"` // JSON 格式的自定义字段
+}
+
+type ExportedCliTelemetry struct {
+	ID       int64             `json:"id"`
+	CliID    string            `json:"cli_id"`
+	Ts       int64             `json:"ts"`
+	Firmware *string           `json:"firmware"` // 未上报的字段为 null
+	Uptime   *int64            `json:"uptime"`
+	CPU      *float64          `json:"cpu"`
+	Memory   *float64          `json:"memory"`
+	WanIP    *string           `json:"wan_ip"`
+	Signal   *int64            `json:"signal"`
+	Custom   map[string]string `json:"custom"`
+}
+
+// CreateCliTelemetry creates the cli_telemetry table in MySQL
+func (t *CliTelemetry) CreateCliTelemetry(db *sql.DB) {
+	if !tableExists(db, "cli_telemetry") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS cli_telemetry (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            cli_id VARCHAR(255) NOT NULL,
+            ts BIGINT NOT NULL,
+            firmware VARCHAR(128),
+            uptime BIGINT,
+            cpu DOUBLE,
+            memory DOUBLE,
+            wan_ip VARCHAR(64),
+            ` + "`signal`" + ` INT,
+            custom TEXT,
+            INDEX idx_cli_ts (cli_id, ts),
+            INDEX idx_ts (ts)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateCliTelemetry] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts CliTelemetry to ExportedCliTelemetry
+func (t *CliTelemetry) ToExported() ExportedCliTelemetry {
+	exported := ExportedCliTelemetry{
+		ID:    nullInt64ToInt64(t.ID),
+		CliID: nullStringToString(t.CliID),
+		Ts:    nullInt64ToInt64(t.Ts),
+	}
+	if t.Firmware.Valid {
+		exported.Firmware = &t.Firmware.String
+	}
+	if t.Uptime.Valid {
+		exported.Uptime = &t.Uptime.Int64
+	}
+	if t.CPU.Valid {
+		exported.CPU = &t.CPU.Float64
+	}
+	if t.Memory.Valid {
+		exported.Memory = &t.Memory.Float64
+	}
+	if t.WanIP.Valid {
+		exported.WanIP = &t.WanIP.String
+	}
+	if t.Signal.Valid {
+		exported.Signal = &t.Signal.Int64
+	}
+	if t.Custom.String != "" {
+		json.Unmarshal([]byte(t.Custom.String), &exported.Custom)
+	}
+	return exported
+}
+
+// InsertCliTelemetries 批量写入设备运行状态
+func InsertCliTelemetries(db *sql.DB, telemetries []CliTelemetry) error {
+	const batchSize = 200
+
+	for start := 0; start < len(telemetries); start += batchSize {
+		end := start + batchSize
+		if end > len(telemetries) {
+			end = len(telemetries)
+		}
+		batch := telemetries[start:end]
+
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*9)
+		for _, t := range batch {
+			values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+			// 可选字段直接传 Null 类型，Valid=false 时写入 NULL
+			args = append(args, t.CliID.String, t.Ts.Int64, t.Firmware, t.Uptime,
+				t.CPU, t.Memory, t.WanIP, t.Signal, t.Custom)
+		}
+		query := "INSERT INTO cli_telemetry (cli_id, ts, firmware, uptime, cpu, memory, wan_ip, `signal`, custom) VALUES " +
+			strings.Join(values, ", ")
+		if _, err := db.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLatestCliTelemetry retrieves the newest record of a client
+func (t *CliTelemetry) GetLatestCliTelemetry(db *sql.DB) error {
+	row := db.QueryRow("SELECT id, cli_id, ts, firmware, uptime, cpu, memory, wan_ip, `signal`, custom FROM cli_telemetry WHERE cli_id = ? ORDER BY ts DESC LIMIT 1",
+		t.CliID.String)
+	err := row.Scan(&t.ID, &t.CliID, &t.Ts, &t.Firmware, &t.Uptime, &t.CPU, &t.Memory, &t.WanIP, &t.Signal, &t.Custom)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("CliTelemetry with CliID %s not found", t.CliID.String)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetCliTelemetryByCliID retrieves the history of a client in [start, end], newest first
+// start/end 为 0 时不限制
+func (t *CliTelemetry) GetCliTelemetryByCliID(db *sql.DB, start int64, end int64, page int) ([]CliTelemetry, int, error) {
+	if t.CliID.String == "" {
+		return nil, 0, errors.New("cli_id cannot be empty")
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * PAGINNATIONLIMIT
+
+	where := "WHERE cli_id = ?"
+	args := []interface{}{t.CliID.String}
+	if start > 0 {
+		where += " AND ts >= ?"
+		args = append(args, start)
+	}
+	if end > 0 {
+		where += " AND ts <= ?"
+		args = append(args, end)
+	}
+
+	rows, err := db.Query("SELECT id, cli_id, ts, firmware, uptime, cpu, memory, wan_ip, `signal`, custom FROM cli_telemetry "+
+		where+" ORDER BY ts DESC LIMIT ? OFFSET ?", append(args, PAGINNATIONLIMIT, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var telemetries []CliTelemetry
+	for rows.Next() {
+		var telemetry CliTelemetry
+		err := rows.Scan(&telemetry.ID, &telemetry.CliID, &telemetry.Ts, &telemetry.Firmware, &telemetry.Uptime,
+			&telemetry.CPU, &telemetry.Memory, &telemetry.WanIP, &telemetry.Signal, &telemetry.Custom)
+		if err != nil {
+			return nil, 0, err
+		}
+		telemetries = append(telemetries, telemetry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM cli_telemetry "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	return telemetries, total, nil
+}
+
+// PurgeCliTelemetry 删除 before 之前的记录
+func PurgeCliTelemetry(db *sql.DB, before int64) (int64, error) {
+	result, err := db.Exec("DELETE FROM cli_telemetry WHERE ts < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteCliTelemetry deletes all records of a client
+func (t *CliTelemetry) DeleteCliTelemetry(db *sql.DB) error {
+	if !tableExists(db, "cli_telemetry") {
+		return nil
+	}
+	_, err := db.Exec("DELETE FROM cli_telemetry WHERE cli_id = ?", t.CliID.String)
+	return err
+}
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"jwireguard/global"
+	"strings"
+	"time"
+)
+
+const TRAFFICDAYLAYOUT = "2006-01-02" // traffic_daily.day 格式
+
+// TrafficSession 一次 OpenVPN 连接的流量记录
+type TrafficSession struct {
+	ID             sql.NullInt64  `json:"id"`
+	CliID          sql.NullString `json:"cli_id"`
+	SerID          sql.NullString `json:"ser_id"`
+	RealAddress    sql.NullString `json:"real_address"`
+	VirtualAddress sql.NullString `json:"virtual_address"`
+	ConnectedSince sql.NullInt64  `json:"connected_since"`
+	LastSeen       sql.NullInt64  `json:"last_seen"`
+	BytesReceived  sql.NullInt64  `json:"bytes_received"`
+	BytesSent      sql.NullInt64  `json:"bytes_sent"`
+	Closed         sql.NullBool   `json:"closed"`
+}
+
+type ExportedTrafficSession struct {
+	ID             int64  `json:"id"`
+	CliID          string `json:"cli_id"`
+	SerID          string `json:"ser_id"`
+	RealAddress    string `json:"real_address"`
+	VirtualAddress string `json:"virtual_address"`
+	ConnectedSince int64  `json:"connected_since"`
+	LastSeen       int64  `json:"last_seen"`
+	BytesReceived  int64  `json:"bytes_received"`
+	BytesSent      int64  `json:"bytes_sent"`
+	Closed         bool   `json:"closed"`
+}
+
+// TrafficTotal 按天或按月汇总的流量
+type TrafficTotal struct {
+	Date          string `json:"date"`
+	BytesReceived int64  `json:"bytes_received"`
+	BytesSent     int64  `json:"bytes_sent"`
+}
+
+// TrafficRank 客户端在统计范围内的流量合计
+type TrafficRank struct {
+	CliID         string `json:"cli_id"`
+	SerID         string `json:"ser_id"`
+	BytesReceived int64  `json:"bytes_received"`
+	BytesSent     int64  `json:"bytes_sent"`
+}
+
+// TrafficDaily 每个客户端每天的流量
+type TrafficDaily struct {
+	CliID         sql.NullString `json:"cli_id"`
+	SerID         sql.NullString `json:"ser_id"`
+	Day           sql.NullString `json:"day"`
+	BytesReceived sql.NullInt64  `json:"bytes_received"`
+	BytesSent     sql.NullInt64  `json:"bytes_sent"`
+}
+
+// CreateTrafficSession creates the traffic_session table in MySQL
+func (t *TrafficSession) CreateTrafficSession(db *sql.DB) {
+	if !tableExists(db, "traffic_session") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS traffic_session (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            cli_id VARCHAR(255) NOT NULL,
+            ser_id VARCHAR(255),
+            real_address VARCHAR(255) NOT NULL,
+            virtual_address VARCHAR(255),
+            connected_since BIGINT NOT NULL,
+            last_seen BIGINT NOT NULL,
+            bytes_received BIGINT NOT NULL DEFAULT 0,
+            bytes_sent BIGINT NOT NULL DEFAULT 0,
+            closed TINYINT(1) NOT NULL DEFAULT 0,
+            UNIQUE KEY uk_session (cli_id, connected_since, real_address),
+            INDEX idx_closed (closed),
+            INDEX idx_ser_id (ser_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateTrafficSession] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// CreateTrafficDaily creates the traffic_daily table in MySQL
+func (t *TrafficDaily) CreateTrafficDaily(db *sql.DB) {
+	if !tableExists(db, "traffic_daily") {
+		createTableSQL := `CREATE TABLE IF NOT EXISTS traffic_daily (
+            cli_id VARCHAR(255) NOT NULL,
+            ser_id VARCHAR(255),
+            day CHAR(10) NOT NULL,
+            bytes_received BIGINT NOT NULL DEFAULT 0,
+            bytes_sent BIGINT NOT NULL DEFAULT 0,
+            PRIMARY KEY (cli_id, day),
+            INDEX idx_ser_day (ser_id, day)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+		_, err := db.Exec(createTableSQL)
+		if err != nil {
+			global.Log.Errorln("[CreateTrafficDaily] Error creating table:", err)
+			return
+		}
+	}
+}
+
+// ToExported converts TrafficSession to ExportedTrafficSession
+func (t *TrafficSession) ToExported() ExportedTrafficSession {
+	return ExportedTrafficSession{
+		ID:             nullInt64ToInt64(t.ID),
+		CliID:          nullStringToString(t.CliID),
+		SerID:          nullStringToString(t.SerID),
+		RealAddress:    nullStringToString(t.RealAddress),
+		VirtualAddress: nullStringToString(t.VirtualAddress),
+		ConnectedSince: nullInt64ToInt64(t.ConnectedSince),
+		LastSeen:       nullInt64ToInt64(t.LastSeen),
+		BytesReceived:  nullInt64ToInt64(t.BytesReceived),
+		BytesSent:      nullInt64ToInt64(t.BytesSent),
+		Closed:         NullBoolToBool(t.Closed),
+	}
+}
+
+// GetTrafficSessionsByCliID retrieves the session history of a client, newest first
+func (t *TrafficSession) GetTrafficSessionsByCliID(db *sql.DB, page int) ([]TrafficSession, int, error) {
+	if t.CliID.String == "" {
+		return nil, 0, errors.New("cli_id cannot be empty")
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * PAGINNATIONLIMIT
+
+	rows, err := db.Query(`SELECT id, cli_id, ser_id, real_address, virtual_address, connected_since, last_seen, bytes_received, bytes_sent, closed
+            FROM traffic_session
+            WHERE cli_id = ?
+            ORDER BY connected_since DESC
+            LIMIT ? OFFSET ?`, t.CliID.String, PAGINNATIONLIMIT, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	sessions, err := scanTrafficSessions(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM traffic_session WHERE cli_id = ?", t.CliID.String).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	return sessions, total, nil
+}
+
+func scanTrafficSessions(rows *sql.Rows) ([]TrafficSession, error) {
+	var sessions []TrafficSession
+	for rows.Next() {
+		var session TrafficSession
+		err := rows.Scan(
+			&session.ID,
+			&session.CliID,
+			&session.SerID,
+			&session.RealAddress,
+			&session.VirtualAddress,
+			&session.ConnectedSince,
+			&session.LastSeen,
+			&session.BytesReceived,
+			&session.BytesSent,
+			&session.Closed,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SaveTrafficSample 在一个事务中保存一次 status 采样
+// 已存在的连接按计数器差值累加到当天流量，不在本次采样中的连接标记为已关闭
+// ----------------------------------------------------------------------------------------------------------
+func SaveTrafficSample(db *sql.DB, samples []TrafficSession, now time.Time) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("开始事务失败: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	day := now.Format(TRAFFICDAYLAYOUT)
+	var seen []interface{}
+	for _, sample := range samples {
+		var id int64
+		var prevReceived, prevSent int64
+		err = tx.QueryRow(`SELECT id, bytes_received, bytes_sent FROM traffic_session
+                WHERE cli_id = ? AND connected_since = ? AND real_address = ? FOR UPDATE`,
+			sample.CliID.String, sample.ConnectedSince.Int64, sample.RealAddress.String).Scan(&id, &prevReceived, &prevSent)
+		if err == sql.ErrNoRows {
+			var result sql.Result
+			result, err = tx.Exec(`INSERT INTO traffic_session (cli_id, ser_id, real_address, virtual_address, connected_since, last_seen, bytes_received, bytes_sent, closed)
+                    VALUES(?, ?, ?, ?, ?, ?, ?, ?, 0)`,
+				sample.CliID.String, sample.SerID.String, sample.RealAddress.String, sample.VirtualAddress.String,
+				sample.ConnectedSince.Int64, now.Unix(), sample.BytesReceived.Int64, sample.BytesSent.Int64)
+			if err != nil {
+				return fmt.Errorf("插入连接记录失败: %w", err)
+			}
+			if id, err = result.LastInsertId(); err != nil {
+				return fmt.Errorf("获取连接记录ID失败: %w", err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("查询连接记录失败: %w", err)
+		} else {
+			_, err = tx.Exec(`UPDATE traffic_session SET virtual_address = ?, last_seen = ?, bytes_received = ?, bytes_sent = ?, closed = 0 WHERE id = ?`,
+				sample.VirtualAddress.String, now.Unix(), sample.BytesReceived.Int64, sample.BytesSent.Int64, id)
+			if err != nil {
+				return fmt.Errorf("更新连接记录失败: %w", err)
+			}
+		}
+		seen = append(seen, id)
+
+		deltaReceived := trafficDelta(sample.BytesReceived.Int64, prevReceived)
+		deltaSent := trafficDelta(sample.BytesSent.Int64, prevSent)
+		if deltaReceived == 0 && deltaSent == 0 {
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO traffic_daily (cli_id, ser_id, day, bytes_received, bytes_sent) VALUES(?, ?, ?, ?, ?)
+                ON DUPLICATE KEY UPDATE ser_id = VALUES(ser_id), bytes_received = bytes_received + VALUES(bytes_received), bytes_sent = bytes_sent + VALUES(bytes_sent)`,
+			sample.CliID.String, sample.SerID.String, day, deltaReceived, deltaSent)
+		if err != nil {
+			return fmt.Errorf("更新每日流量失败: %w", err)
+		}
+	}
+
+	// 关闭已断开的连接
+	closeSQL := "UPDATE traffic_session SET closed = 1 WHERE closed = 0"
+	if len(seen) > 0 {
+		placeholders := strings.Repeat("?,", len(seen))
+		closeSQL += fmt.Sprintf(" AND id NOT IN (%s)", placeholders[:len(placeholders)-1])
+	}
+	if _, err = tx.Exec(closeSQL, seen...); err != nil {
+		return fmt.Errorf("关闭连接记录失败: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// trafficDelta 计算计数器增量，计数器变小说明连接已重置
+func trafficDelta(current, previous int64) int64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// GetTrafficTotals 按天或按月汇总流量，cliId 与 serId 二选一
+// monthly 为 true 时按月汇总，start/end 为 YYYY-MM-DD，为空时不限制
+func GetTrafficTotals(db *sql.DB, cliId string, serId string, monthly bool, start string, end string) ([]TrafficTotal, error) {
+	dateExpr := "day"
+	if monthly {
+		dateExpr = "LEFT(day, 7)"
+	}
+
+	var where []string
+	var args []interface{}
+	if cliId != "" {
+		where = append(where, "cli_id = ?")
+		args = append(args, cliId)
+	}
+	if serId != "" {
+		where = append(where, "ser_id = ?")
+		args = append(args, serId)
+	}
+	if len(where) == 0 {
+		return nil, errors.New("cli_id and ser_id cannot both be empty")
+	}
+	if start != "" {
+		where = append(where, "day >= ?")
+		args = append(args, start)
+	}
+	if end != "" {
+		where = append(where, "day <= ?")
+		args = append(args, end)
+	}
+
+	query := fmt.Sprintf("SELECT %s AS d, SUM(bytes_received), SUM(bytes_sent) FROM traffic_daily WHERE %s GROUP BY d ORDER BY d",
+		dateExpr, strings.Join(where, " AND "))
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []TrafficTotal{}
+	for rows.Next() {
+		var total TrafficTotal
+		if err := rows.Scan(&total.Date, &total.BytesReceived, &total.BytesSent); err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// GetTopTraffic 统计子网内客户端在 [start, end] 的流量，按收发合计从多到少返回前 limit 个
+func GetTopTraffic(db *sql.DB, serIds []string, start string, end string, limit int) ([]TrafficRank, error) {
+	if len(serIds) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(serIds)), ",")
+	args := make([]interface{}, 0, len(serIds)+3)
+	for _, serId := range serIds {
+		args = append(args, serId)
+	}
+	args = append(args, start, end, limit)
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT cli_id, MAX(ser_id), SUM(bytes_received) AS received, SUM(bytes_sent) AS sent FROM traffic_daily
+        WHERE ser_id IN (%s) AND day >= ? AND day <= ? GROUP BY cli_id ORDER BY received + sent DESC, cli_id LIMIT ?`, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranks []TrafficRank
+	for rows.Next() {
+		var rank TrafficRank
+		if err := rows.Scan(&rank.CliID, &rank.SerID, &rank.BytesReceived, &rank.BytesSent); err != nil {
+			return nil, err
+		}
+		ranks = append(ranks, rank)
+	}
+	return ranks, rows.Err()
+}
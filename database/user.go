@@ -327,6 +327,34 @@ func (u *User) GetSubnetIdsByUserIds(db *sql.DB, userIds []string) ([]string, er
 	return result, nil
 }
 
+// GetUserEmailsBySerID retrieves distinct non-empty emails of users bound to ser_id
+func (u *User) GetUserEmailsBySerID(db *sql.DB) ([]string, error) {
+	if u.SerID.String == "" {
+		return nil, errors.New("ser_id cannot be empty")
+	}
+
+	rows, err := db.Query("SELECT DISTINCT user_email FROM user WHERE ser_id = ? AND user_email IS NOT NULL AND user_email <> ''", u.SerID.String)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
 // GetAllUsers retrieves all users
 func (u *User) GetAllUsers(db *sql.DB) ([]User, error) {
 	// 更新查询语句包含user_mac字段
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/message"
+	"time"
+)
+
+// 摘要的检查间隔
+const digestCheckInterval = time.Minute
+
+// 错过发送时间超过该时长(如服务停止)时不补发，等下一次发送时间
+const digestGrace = time.Hour
+
+// digestPlan 用户实际使用的摘要设置
+type digestPlan struct {
+	Frequency string
+	Hour      int
+	Minute    int
+	Weekday   time.Weekday
+	ChannelID int64
+}
+
+// DigestScheduler 按用户的摘要设置定期发送每日/每周摘要
+func DigestScheduler() {
+	global.Log.Infof("[DigestScheduler] start")
+	for {
+		sendDueDigests(time.Now())
+		time.Sleep(digestCheckInterval)
+	}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// sendDueDigests 为到达发送时间的用户生成摘要并写入发件箱
+// 没有单独设置的用户使用 [DIGEST SETTING] 的默认值
+// ----------------------------------------------------------------------------------------------------------
+func sendDueDigests(now time.Time) {
+	var err error
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[DigestScheduler] 数据库连接失败, err:%v", err)
+		return
+	}
+
+	digestSchedule := database.DigestSchedule{}
+	digestSchedule.CreateDigestSchedule(global.GlobalDB)
+	schedules, err := digestSchedule.GetAllDigestSchedule(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[DigestScheduler] 读取摘要设置失败, err:%v", err)
+		return
+	}
+	user := database.User{}
+	users, err := user.GetAllUsers(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[DigestScheduler] 获取用户列表失败, err:%v", err)
+		return
+	}
+
+	for _, user := range users {
+		schedule := schedules[user.UserID.String]
+		plan := resolveDigestPlan(schedule)
+		scheduled, due := digestDue(plan, schedule.LastSent.Int64, now)
+		if !due {
+			continue
+		}
+
+		if sendDigest(user, plan, scheduled) {
+			if err := database.MarkDigestSent(global.GlobalDB, user.UserID.String, scheduled.Unix()); err != nil {
+				global.Log.Errorf("[DigestScheduler] 无法记录用户 %s 的摘要发送时间, err:%v", user.UserName.String, err)
+			}
+		}
+	}
+}
+
+// resolveDigestPlan 用默认值补全用户的摘要设置
+func resolveDigestPlan(schedule database.DigestSchedule) digestPlan {
+	plan := digestPlan{
+		Frequency: global.GlobalJWireGuardini.DigestFrequency,
+		Weekday:   time.Weekday(global.GlobalJWireGuardini.DigestWeekday),
+		ChannelID: schedule.ChannelID.Int64,
+	}
+	if schedule.Frequency.String != "" {
+		plan.Frequency = schedule.Frequency.String
+	}
+	sendTime := global.GlobalJWireGuardini.DigestTime
+	if schedule.SendTime.String != "" {
+		sendTime = schedule.SendTime.String
+	}
+	if t, err := time.Parse("15:04", sendTime); err == nil {
+		plan.Hour, plan.Minute = t.Hour(), t.Minute()
+	}
+	if schedule.Weekday.Valid && schedule.Weekday.Int64 >= 0 && schedule.Weekday.Int64 <= 6 {
+		plan.Weekday = time.Weekday(schedule.Weekday.Int64)
+	}
+	return plan
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// digestDue 返回最近一次计划发送时间，该时间在 lastSent 之后且没有超过 digestGrace 时需要发送
+// ----------------------------------------------------------------------------------------------------------
+func digestDue(plan digestPlan, lastSent int64, now time.Time) (time.Time, bool) {
+	if plan.Frequency != database.DIGESTDAILY && plan.Frequency != database.DIGESTWEEKLY {
+		return time.Time{}, false
+	}
+
+	scheduled := time.Date(now.Year(), now.Month(), now.Day(), plan.Hour, plan.Minute, 0, 0, now.Location())
+	if plan.Frequency == database.DIGESTWEEKLY {
+		scheduled = scheduled.AddDate(0, 0, -((int(now.Weekday()) - int(plan.Weekday) + 7) % 7))
+	}
+	if scheduled.After(now) {
+		if plan.Frequency == database.DIGESTWEEKLY {
+			scheduled = scheduled.AddDate(0, 0, -7)
+		} else {
+			scheduled = scheduled.AddDate(0, 0, -1)
+		}
+	}
+	return scheduled, scheduled.Unix() > lastSent && now.Sub(scheduled) <= digestGrace
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// sendDigest 生成用户的摘要并写入发件箱，指定了通知渠道时通过该渠道发送，否则发送到用户邮箱
+// 用户没有可管理的子网或没有收件地址时不发送，也不再重试
+// ----------------------------------------------------------------------------------------------------------
+func sendDigest(user database.User, plan digestPlan, scheduled time.Time) bool {
+	userName := user.UserName.String
+	channel := ""
+	var to []string
+	if plan.ChannelID != 0 {
+		notifyChannel := database.NotifyChannel{}
+		notifyChannel.CreateNotifyChannel(global.GlobalDB)
+		notifyChannel.ID.Int64 = plan.ChannelID
+		if err := notifyChannel.GetNotifyChannelByID(global.GlobalDB); err != nil || !notifyChannel.Enabled.Bool {
+			global.Log.Warnf("[DigestScheduler] 用户 %s 的摘要渠道 %d 不存在或已停用, 改为发送到用户邮箱", userName, plan.ChannelID)
+		} else {
+			channel = notifyChannel.Name.String
+		}
+	}
+	if channel == "" {
+		if !global.IsValidEmail(user.UserEmail.String) {
+			global.Log.Debugf("[DigestScheduler] 用户 %s 没有邮箱, 不发送摘要", userName)
+			return true
+		}
+		to = []string{user.UserEmail.String}
+	}
+
+	period := 24 * time.Hour
+	if plan.Frequency == database.DIGESTWEEKLY {
+		period = 7 * 24 * time.Hour
+	}
+	data, ok, err := buildDigest(user, scheduled.Add(-period), scheduled)
+	if err != nil {
+		global.Log.Errorf("[DigestScheduler] 生成用户 %s 的摘要失败, err:%v", userName, err)
+		return false
+	}
+	if !ok {
+		global.Log.Debugf("[DigestScheduler] 用户 %s 没有可管理的子网, 不发送摘要", userName)
+		return true
+	}
+	data["Frequency"] = plan.Frequency
+
+	rendered, err := notifyTemplates().Render(message.TEMPLATEDIGEST, "", data)
+	if err != nil {
+		global.Log.Errorf("[DigestScheduler] 渲染摘要模板失败, err:%v", err)
+		return false
+	}
+	global.Log.Infof("[DigestScheduler] 发送用户 %s 的%s摘要, channel:[%s]", userName, plan.Frequency, channel)
+	return queueNotification("DigestScheduler", channel, message.Notification{
+		Event: message.TEMPLATEDIGEST,
+		Title: rendered.Subject,
+		Text:  rendered.Text,
+		HTML:  rendered.HTML,
+		To:    to,
+	})
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// buildDigest 统计用户可管理的子网在 [start, end) 的情况，用户没有子网时返回 false
+// 包括当前离线的设备、新增和删除的客户端、即将到期的证书和流量最多的客户端
+// ----------------------------------------------------------------------------------------------------------
+func buildDigest(user database.User, start time.Time, end time.Time) (map[string]interface{}, bool, error) {
+	userIds, err := user.QueryUserIds(global.GlobalDB, user.UserID.String)
+	if err != nil {
+		return nil, false, err
+	}
+	serIds, err := user.GetSubnetIdsByUserIds(global.GlobalDB, userIds)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(serIds) == 0 {
+		return nil, false, nil
+	}
+
+	subnet := database.Subnet{}
+	subnets, err := subnet.GetSubnetBySerIDs(global.GlobalDB, serIds)
+	if err != nil {
+		return nil, false, err
+	}
+	serNames := make(map[string]string, len(subnets))
+	for _, subnet := range subnets {
+		serNames[subnet.SerID.String] = subnet.SerName.String
+	}
+
+	remindDays := global.GlobalJWireGuardini.CertRemindDays
+	expireBefore := end.AddDate(0, 0, remindDays).Unix()
+	cliNames := make(map[string]string)
+	clientCount := 0
+	offline := []map[string]interface{}{}
+	certExpiring := []map[string]interface{}{}
+	for _, serId := range serIds {
+		cliConfig := database.CliConfig{}
+		cliConfig.SerID.String = serId
+		clients, err := cliConfig.GetCliConfigBySerID(global.GlobalDB)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, client := range clients {
+			clientCount++
+			cliNames[client.CliID.String] = client.CliName.String
+			if client.CliStatus.String != "true" {
+				offline = append(offline, map[string]interface{}{
+					"CliID":      client.CliID.String,
+					"CliName":    client.CliName.String,
+					"SerName":    serNames[serId],
+					"CliAddress": client.CliAddress.String,
+					"LastSeen":   formatDigestTime(client.Timestamp.Int64),
+				})
+			}
+			if expire := client.CertExpire.Int64; expire > 0 && expire <= expireBefore {
+				certExpiring = append(certExpiring, map[string]interface{}{
+					"CliID":    client.CliID.String,
+					"CliName":  client.CliName.String,
+					"SerName":  serNames[serId],
+					"NotAfter": formatDigestTime(expire),
+					"DaysLeft": int(time.Until(time.Unix(expire, 0)).Hours() / 24),
+				})
+			}
+		}
+	}
+
+	cliChange := database.CliChange{}
+	cliChange.CreateCliChange(global.GlobalDB)
+	changes, err := database.GetCliChanges(global.GlobalDB, serIds, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, false, err
+	}
+	added := []map[string]interface{}{}
+	deleted := []map[string]interface{}{}
+	for _, change := range changes {
+		item := map[string]interface{}{
+			"CliID":    change.CliID.String,
+			"CliName":  change.CliName.String,
+			"SerName":  serNames[change.SerID.String],
+			"Time":     formatDigestTime(change.Ts.Int64),
+			"Operator": change.Operator.String,
+		}
+		if change.Action.String == database.CLICHANGEADD {
+			added = append(added, item)
+		} else {
+			deleted = append(deleted, item)
+		}
+	}
+
+	// traffic_daily 按天统计，包含 end 前一秒所在的那一天
+	trafficDaily := database.TrafficDaily{}
+	trafficDaily.CreateTrafficDaily(global.GlobalDB)
+	ranks, err := database.GetTopTraffic(global.GlobalDB, serIds, start.Format(database.TRAFFICDAYLAYOUT),
+		end.Add(-time.Second).Format(database.TRAFFICDAYLAYOUT), global.GlobalJWireGuardini.DigestTopTraffic)
+	if err != nil {
+		return nil, false, err
+	}
+	topTraffic := []map[string]interface{}{}
+	for _, rank := range ranks {
+		name, ok := cliNames[rank.CliID]
+		if !ok {
+			name = rank.CliID
+		}
+		topTraffic = append(topTraffic, map[string]interface{}{
+			"CliID":    rank.CliID,
+			"CliName":  name,
+			"SerName":  serNames[rank.SerID],
+			"Received": formatBytes(rank.BytesReceived),
+			"Sent":     formatBytes(rank.BytesSent),
+			"Total":    formatBytes(rank.BytesReceived + rank.BytesSent),
+		})
+	}
+
+	return map[string]interface{}{
+		"UserName":     user.UserName.String,
+		"Start":        start.Format("2006-01-02 15:04"),
+		"End":          end.Format("2006-01-02 15:04"),
+		"SubnetCount":  len(serIds),
+		"ClientCount":  clientCount,
+		"RemindDays":   remindDays,
+		"Offline":      offline,
+		"Added":        added,
+		"Deleted":      deleted,
+		"CertExpiring": certExpiring,
+		"TopTraffic":   topTraffic,
+	}, true, nil
+}
+
+func formatDigestTime(ts int64) string {
+	if ts <= 0 {
+		return "-"
+	}
+	return time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+}
+
+// formatBytes 按 1024 换算为 KB/MB/GB/TB
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
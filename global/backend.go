@@ -0,0 +1,584 @@
+// myapp/global/backend.go
+package global
+
+import (
+	"errors"
+	"fmt"
+	"jwireguard/wireguard"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 隧道后端名称，保存在 subnet.backend 中
+const (
+	BACKENDOPENVPN   = "openvpn"
+	BACKENDWIREGUARD = "wireguard"
+)
+
+// TunnelBackend 隧道后端，每个子网选择一种后端生成客户端配置
+type TunnelBackend interface {
+	// Name 后端名称
+	Name() string
+	// AddClient 生成客户端凭据并分配地址，network 为客户端所在子网
+	AddClient(cliId string, cliAddr string, network *net.IPNet) error
+	// UpdateClient 重新生成客户端凭据，地址不变
+	UpdateClient(cliId string) error
+	// DelClient 删除客户端凭据和配置
+	DelClient(cliId string) error
+	// SetClientAddress 修改客户端地址，network 为客户端所在子网
+	SetClientAddress(cliId string, cliAddr string, network *net.IPNet) error
+	// ClientConfigFile 生成客户端配置文件并返回路径
+	ClientConfigFile(cliId string) (string, error)
+}
+
+// RouteBackend 支持网络映射的后端，将客户端后面的网络路由到该客户端
+// OpenVPN 的网络映射写入 ccd 文件，不需要实现该接口
+type RouteBackend interface {
+	// SetClientRoutes 设置客户端后面的网络，routes 为 CIDR 列表，会替换原有的映射
+	SetClientRoutes(cliId string, routes []string) error
+}
+
+var backends = map[string]TunnelBackend{
+	BACKENDOPENVPN:   openVPNBackend{},
+	BACKENDWIREGUARD: wireGuardBackend{},
+}
+
+// 服务端私钥，首次使用时加载或生成
+var globalWireGuardKey *wireguard.Key
+var globalWireGuardMutex sync.Mutex
+
+// ----------------------------------------------------------------------------------------------------------
+// GetBackend 按名称获取隧道后端，名称为空时使用 OpenVPN，兼容旧版本的子网
+// ----------------------------------------------------------------------------------------------------------
+func GetBackend(name string) (TunnelBackend, error) {
+	if name == "" {
+		name = BACKENDOPENVPN
+	}
+	backend, ok := backends[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("不支持的隧道后端: %s", name)
+	}
+	return backend, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// WriteClientCcd 写入 OpenVPN 客户端的 ccd 文件
+// ----------------------------------------------------------------------------------------------------------
+func WriteClientCcd(cliId string, cliAddr string, network *net.IPNet) error {
+	ccdClient := fmt.Sprintf("%s/%s", GlobalOpenVPNPath.CcdPath, cliId)
+	changClientAddr, err := ClientCcd(cliAddr, network)
+	if err != nil {
+		return err
+	}
+
+	err = WriteToFile(ccdClient, changClientAddr)
+	if err != nil {
+		return fmt.Errorf("无法对ccd文件写入: %s file:%s ", err, ccdClient)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// openVPNBackend 基于 pki 证书和 ccd 文件的 OpenVPN 后端
+// ----------------------------------------------------------------------------------------------------------
+type openVPNBackend struct{}
+
+func (openVPNBackend) Name() string {
+	return BACKENDOPENVPN
+}
+
+func (openVPNBackend) AddClient(cliId string, cliAddr string, network *net.IPNet) error {
+	return ShellAddClient(cliId, cliAddr, network)
+}
+
+func (openVPNBackend) UpdateClient(cliId string) error {
+	return ShellUpdateClient(cliId)
+}
+
+func (openVPNBackend) DelClient(cliId string) error {
+	return ShellDelClient(cliId)
+}
+
+func (openVPNBackend) SetClientAddress(cliId string, cliAddr string, network *net.IPNet) error {
+	return WriteClientCcd(cliId, cliAddr, network)
+}
+
+func (openVPNBackend) ClientConfigFile(cliId string) (string, error) {
+	headClient := fmt.Sprintf("%s/openvpn.txt", GlobalOpenVPNPath.ConfigPath)
+	caClient := fmt.Sprintf("%s/ca.crt", GlobalOpenVPNPath.PkiPath)
+	taClient := fmt.Sprintf("%s/ta.key", GlobalOpenVPNPath.PkiPath)
+	ovpnClient := fmt.Sprintf("%s/%s.ovpn", GlobalOpenVPNPath.ConfigPath, cliId)
+	privateClient := fmt.Sprintf("%s/%s.key", GlobalOpenVPNPath.PrivatePath, cliId)
+	issuedClient := fmt.Sprintf("%s/%s.crt", GlobalOpenVPNPath.IssuedPath, cliId)
+
+	// Define file paths
+	files := map[string]string{
+		"key":      privateClient,
+		"cert":     issuedClient,
+		"ca":       caClient,
+		"tls-auth": taClient,
+	}
+
+	for _, file := range files {
+		if !CheckFileExists(file) {
+			return "", fmt.Errorf("该%s文件不存在", file)
+		}
+	}
+
+	// Create the .ovpn file
+	err := CreateOVPNFile(headClient, ovpnClient, files)
+	if err != nil {
+		return "", fmt.Errorf("无法合成%s.ovpn, err:%v", cliId, err)
+	}
+	return ovpnClient, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// wireGuardBackend 基于 Curve25519 密钥和 wg-quick 配置的 WireGuard 后端
+// ----------------------------------------------------------------------------------------------------------
+type wireGuardBackend struct{}
+
+func (wireGuardBackend) Name() string {
+	return BACKENDWIREGUARD
+}
+
+// AddClient WireGuard 客户端地址使用 /32，路由由 SUPERNET 决定，不需要子网掩码
+func (b wireGuardBackend) AddClient(cliId string, cliAddr string, network *net.IPNet) error {
+	// 同名客户端的遗留配置一并删除
+	if err := b.DelClient(cliId); err != nil {
+		return err
+	}
+
+	key, err := wireguard.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+	if err := b.writeKey(cliId, key); err != nil {
+		return err
+	}
+	return b.writeClient(cliId, key, cliAddr, nil)
+}
+
+func (b wireGuardBackend) UpdateClient(cliId string) error {
+	peer, err := b.readPeer(cliId)
+	if err != nil {
+		return err
+	}
+	cliAddr, err := peerAddress(peer)
+	if err != nil {
+		return err
+	}
+
+	key, err := wireguard.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+	if err := b.writeKey(cliId, key); err != nil {
+		return err
+	}
+
+	// 移除旧公钥，避免旧的配置文件继续可用
+	removeWireGuardPeer(peer)
+	return b.writeClient(cliId, key, cliAddr, peerRoutes(peer))
+}
+
+func (b wireGuardBackend) DelClient(cliId string) error {
+	peer, peerErr := b.readPeer(cliId)
+
+	for _, file := range []string{b.peerFile(cliId), b.keyFile(cliId), b.confFile(cliId)} {
+		if err := DeleteFileIfExists(file); err != nil {
+			return fmt.Errorf("无法删除%s文件, err:%v", file, err)
+		}
+	}
+
+	if err := SyncWireGuardServer(); err != nil {
+		return err
+	}
+	if peerErr == nil {
+		removeWireGuardPeer(peer)
+	}
+	return nil
+}
+
+func (b wireGuardBackend) SetClientAddress(cliId string, cliAddr string, network *net.IPNet) error {
+	key, err := b.readKey(cliId)
+	if err != nil {
+		return err
+	}
+	var routes []string
+	if peer, err := b.readPeer(cliId); err == nil {
+		routes = peerRoutes(peer)
+		removeWireGuardPeer(peer)
+	}
+	return b.writeClient(cliId, key, cliAddr, routes)
+}
+
+// SetClientRoutes 映射的网络加入服务端 Peer 的 AllowedIPs，客户端配置不变
+func (b wireGuardBackend) SetClientRoutes(cliId string, routes []string) error {
+	key, err := b.readKey(cliId)
+	if err != nil {
+		return err
+	}
+	peer, err := b.readPeer(cliId)
+	if err != nil {
+		return err
+	}
+	cliAddr, err := peerAddress(peer)
+	if err != nil {
+		return err
+	}
+
+	// 移除旧的 AllowedIPs 和路由，再按新的映射添加
+	removeWireGuardPeer(peer)
+	return b.writeClient(cliId, key, cliAddr, routes)
+}
+
+func (b wireGuardBackend) ClientConfigFile(cliId string) (string, error) {
+	key, err := b.readKey(cliId)
+	if err != nil {
+		return "", err
+	}
+	peer, err := b.readPeer(cliId)
+	if err != nil {
+		return "", err
+	}
+	cliAddr, err := peerAddress(peer)
+	if err != nil {
+		return "", err
+	}
+
+	// 每次重新生成，服务端地址或端口修改后客户端可以直接获取新配置
+	if err := b.writeConf(cliId, key, cliAddr); err != nil {
+		return "", err
+	}
+	return b.confFile(cliId), nil
+}
+
+func (wireGuardBackend) keyFile(cliId string) string {
+	return filepath.Join(GlobalWireGuardPath.ClientPath, cliId+".key")
+}
+
+func (wireGuardBackend) confFile(cliId string) string {
+	return filepath.Join(GlobalWireGuardPath.ClientPath, cliId+".conf")
+}
+
+func (wireGuardBackend) peerFile(cliId string) string {
+	return filepath.Join(GlobalWireGuardPath.PeerPath, cliId+".conf")
+}
+
+func (b wireGuardBackend) readKey(cliId string) (wireguard.Key, error) {
+	data, err := os.ReadFile(b.keyFile(cliId))
+	if err != nil {
+		return wireguard.Key{}, fmt.Errorf("无法读取客户端私钥: %w", err)
+	}
+	return wireguard.ParseKey(string(data))
+}
+
+func (b wireGuardBackend) writeKey(cliId string, key wireguard.Key) error {
+	if err := os.MkdirAll(GlobalWireGuardPath.ClientPath, 0700); err != nil {
+		return fmt.Errorf("无法创建目录 %s: %v", GlobalWireGuardPath.ClientPath, err)
+	}
+	if err := os.WriteFile(b.keyFile(cliId), []byte(key.String()+"\n"), 0600); err != nil {
+		return fmt.Errorf("无法写入客户端私钥: %v", err)
+	}
+	return nil
+}
+
+func (b wireGuardBackend) readPeer(cliId string) (wireguard.Peer, error) {
+	data, err := os.ReadFile(b.peerFile(cliId))
+	if err != nil {
+		return wireguard.Peer{}, fmt.Errorf("无法读取客户端 %s 的 Peer 配置: %w", cliId, err)
+	}
+	peers, err := wireguard.ParsePeers(data)
+	if err != nil {
+		return wireguard.Peer{}, err
+	}
+	if len(peers) != 1 {
+		return wireguard.Peer{}, fmt.Errorf("客户端 %s 的 Peer 配置错误", cliId)
+	}
+	return peers[0], nil
+}
+
+// writeClient 写入服务端 Peer 段和客户端配置，并同步到服务端，routes 为映射到该客户端的网络
+func (b wireGuardBackend) writeClient(cliId string, key wireguard.Key, cliAddr string, routes []string) error {
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		return err
+	}
+	peer := wireguard.Peer{
+		Name:       cliId,
+		PublicKey:  publicKey,
+		AllowedIPs: []string{cliAddr + "/32"},
+	}
+	// 第一个 AllowedIPs 固定为 IPv4 地址，见 peerAddress
+	cliAddr6, err := ClientAddress6(cliAddr)
+	if err != nil {
+		return err
+	}
+	if cliAddr6 != "" {
+		peer.AllowedIPs = append(peer.AllowedIPs, cliAddr6+"/128")
+	}
+	peer.AllowedIPs = append(peer.AllowedIPs, routes...)
+
+	if err := os.MkdirAll(GlobalWireGuardPath.PeerPath, 0700); err != nil {
+		return fmt.Errorf("无法创建目录 %s: %v", GlobalWireGuardPath.PeerPath, err)
+	}
+	if err := WriteToFile(b.peerFile(cliId), peer.String()); err != nil {
+		return fmt.Errorf("无法写入 Peer 配置: %v", err)
+	}
+	if err := b.writeConf(cliId, key, cliAddr); err != nil {
+		return err
+	}
+	if err := SyncWireGuardServer(); err != nil {
+		return err
+	}
+
+	applyWireGuardPeer(peer)
+	return nil
+}
+
+// writeConf 生成 wg-quick 客户端配置
+func (b wireGuardBackend) writeConf(cliId string, key wireguard.Key, cliAddr string) error {
+	if GlobalJWireGuardini.WireGuardEndpoint == "" {
+		return errors.New("未配置 WireGuard ENDPOINT")
+	}
+	serverKey, err := GetWireGuardServerKey()
+	if err != nil {
+		return err
+	}
+	serverPublicKey, err := serverKey.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	supernet, err := Supernet()
+	if err != nil {
+		return err
+	}
+	supernetBits, _ := supernet.Mask.Size()
+
+	var dns []string
+	for _, server := range strings.Split(GlobalJWireGuardini.WireGuardDNS, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			dns = append(dns, server)
+		}
+	}
+
+	config := wireguard.Config{
+		Interface: wireguard.Interface{
+			PrivateKey: key,
+			Address:    []string{fmt.Sprintf("%s/%d", cliAddr, supernetBits)},
+			DNS:        dns,
+		},
+		Peers: []wireguard.Peer{{
+			PublicKey:           serverPublicKey,
+			Endpoint:            GlobalJWireGuardini.WireGuardEndpoint,
+			AllowedIPs:          []string{supernet.String()},
+			PersistentKeepalive: GlobalJWireGuardini.WireGuardKeepalive,
+		}},
+	}
+
+	// 启用 IPv6 时同时分配 IPv6 地址并路由 IPV6_PREFIX
+	if IPv6Enabled() {
+		cliAddr6, err := ClientAddress6(cliAddr)
+		if err != nil {
+			return err
+		}
+		prefix6, err := IPv6Prefix()
+		if err != nil {
+			return err
+		}
+		prefixBits, _ := prefix6.Mask.Size()
+		config.Interface.Address = append(config.Interface.Address, fmt.Sprintf("%s/%d", cliAddr6, prefixBits))
+		config.Peers[0].AllowedIPs = append(config.Peers[0].AllowedIPs, prefix6.String())
+	}
+
+	if err := os.MkdirAll(GlobalWireGuardPath.ClientPath, 0700); err != nil {
+		return fmt.Errorf("无法创建目录 %s: %v", GlobalWireGuardPath.ClientPath, err)
+	}
+	if err := os.WriteFile(b.confFile(cliId), []byte(config.String()), 0600); err != nil {
+		return fmt.Errorf("无法写入客户端配置: %v", err)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// GetWireGuardServerKey 获取服务端私钥，不存在时生成
+// ----------------------------------------------------------------------------------------------------------
+func GetWireGuardServerKey() (wireguard.Key, error) {
+	globalWireGuardMutex.Lock()
+	defer globalWireGuardMutex.Unlock()
+
+	if globalWireGuardKey != nil {
+		return *globalWireGuardKey, nil
+	}
+
+	data, err := os.ReadFile(GlobalWireGuardPath.KeyFile)
+	if err == nil {
+		key, err := wireguard.ParseKey(string(data))
+		if err != nil {
+			return wireguard.Key{}, fmt.Errorf("服务端私钥 %s 格式错误: %v", GlobalWireGuardPath.KeyFile, err)
+		}
+		globalWireGuardKey = &key
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return wireguard.Key{}, fmt.Errorf("无法读取服务端私钥: %v", err)
+	}
+
+	key, err := wireguard.GeneratePrivateKey()
+	if err != nil {
+		return wireguard.Key{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(GlobalWireGuardPath.KeyFile), 0700); err != nil {
+		return wireguard.Key{}, err
+	}
+	if err := os.WriteFile(GlobalWireGuardPath.KeyFile, []byte(key.String()+"\n"), 0600); err != nil {
+		return wireguard.Key{}, fmt.Errorf("无法写入服务端私钥: %v", err)
+	}
+	Log.Infof("[GetWireGuardServerKey] 已生成服务端私钥 %s", GlobalWireGuardPath.KeyFile)
+	globalWireGuardKey = &key
+	return key, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SyncWireGuardServer 由模板和所有 Peer 段重新生成服务端配置
+// ----------------------------------------------------------------------------------------------------------
+func SyncWireGuardServer() error {
+	serverKey, err := GetWireGuardServerKey()
+	if err != nil {
+		return err
+	}
+
+	// 存在模板时使用模板中的 [Interface]，便于配置 PostUp 等参数
+	var head string
+	if CheckFileExists(GlobalWireGuardPath.HeadFile) {
+		content, err := ReadFile(GlobalWireGuardPath.HeadFile)
+		if err != nil {
+			return fmt.Errorf("无法读取服务端模板: %v", err)
+		}
+		head = string(content)
+	} else {
+		serverInterface := wireguard.Interface{
+			PrivateKey: serverKey,
+			Address:    []string{GlobalJWireGuardini.WireGuardAddress + "/32"},
+			ListenPort: GlobalJWireGuardini.WireGuardPort,
+		}
+		serverAddr6, err := ClientAddress6(GlobalJWireGuardini.WireGuardAddress)
+		if err != nil {
+			return err
+		}
+		if serverAddr6 != "" {
+			serverInterface.Address = append(serverInterface.Address, serverAddr6+"/128")
+		}
+		head = serverInterface.String()
+	}
+
+	globalWireGuardMutex.Lock()
+	defer globalWireGuardMutex.Unlock()
+
+	peerFiles, err := filepath.Glob(filepath.Join(GlobalWireGuardPath.PeerPath, "*.conf"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(peerFiles)
+
+	var builder strings.Builder
+	builder.WriteString(head)
+	for _, file := range peerFiles {
+		content, err := ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("无法读取 Peer 配置 %s: %v", file, err)
+		}
+		builder.WriteString("\n")
+		builder.Write(content)
+	}
+
+	if err := os.WriteFile(GlobalWireGuardPath.ServerFile, []byte(builder.String()), 0600); err != nil {
+		return fmt.Errorf("无法写入服务端配置 %s: %v", GlobalWireGuardPath.ServerFile, err)
+	}
+	return nil
+}
+
+// applyWireGuardPeer 将 Peer 添加到运行中的接口，接口未启动时由 wg-quick 启动时加载
+func applyWireGuardPeer(peer wireguard.Peer) {
+	iface := GlobalJWireGuardini.WireGuardInterface
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return
+	}
+
+	args := []string{"set", iface, "peer", peer.PublicKey.String(), "allowed-ips", strings.Join(peer.AllowedIPs, ",")}
+	if output, err := exec.Command("wg", args...).CombinedOutput(); err != nil {
+		Log.Errorf("[applyWireGuardPeer] 无法添加 Peer %s, err:%v, output:%s", peer.Name, err, output)
+		return
+	}
+	for _, allowedIP := range peer.AllowedIPs {
+		if output, err := exec.Command("ip", "route", "replace", allowedIP, "dev", iface).CombinedOutput(); err != nil {
+			Log.Errorf("[applyWireGuardPeer] 无法添加路由 %s, err:%v, output:%s", allowedIP, err, output)
+		}
+	}
+}
+
+// removeWireGuardPeer 从运行中的接口移除 Peer
+func removeWireGuardPeer(peer wireguard.Peer) {
+	iface := GlobalJWireGuardini.WireGuardInterface
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return
+	}
+
+	for _, allowedIP := range peer.AllowedIPs {
+		exec.Command("ip", "route", "del", allowedIP, "dev", iface).Run()
+	}
+	args := []string{"set", iface, "peer", peer.PublicKey.String(), "remove"}
+	if output, err := exec.Command("wg", args...).CombinedOutput(); err != nil {
+		Log.Errorf("[removeWireGuardPeer] 无法移除 Peer %s, err:%v, output:%s", peer.Name, err, output)
+	}
+}
+
+// peerAddress 从 Peer 的 AllowedIPs 中取出客户端地址
+func peerAddress(peer wireguard.Peer) (string, error) {
+	if len(peer.AllowedIPs) == 0 {
+		return "", fmt.Errorf("客户端 %s 没有分配地址", peer.Name)
+	}
+	ip, _, err := net.ParseCIDR(peer.AllowedIPs[0])
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// peerRoutes 返回 Peer 的 AllowedIPs 中客户端地址以外的网络，即映射到该客户端的网络
+func peerRoutes(peer wireguard.Peer) []string {
+	cliAddr, err := peerAddress(peer)
+	if err != nil {
+		return nil
+	}
+	cliAddr6, _ := ClientAddress6(cliAddr)
+
+	var routes []string
+	for _, allowedIP := range peer.AllowedIPs {
+		if allowedIP == cliAddr+"/32" || (cliAddr6 != "" && allowedIP == cliAddr6+"/128") {
+			continue
+		}
+		routes = append(routes, allowedIP)
+	}
+	return routes
+}
+
+// maskBits 将 255.255.255.0 形式的掩码转为前缀长度
+func maskBits(mask string) (int, error) {
+	ip := net.ParseIP(mask).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("invalid subnet mask: %s", mask)
+	}
+	ones, bits := net.IPMask(ip).Size()
+	if bits == 0 {
+		return 0, fmt.Errorf("invalid subnet mask: %s", mask)
+	}
+	return ones, nil
+}
@@ -9,24 +9,27 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
 	"database/sql"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"jwireguard/management"
+	"jwireguard/pki"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/net/html"
-
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/sha3"
 	"gopkg.in/ini.v1"
@@ -52,6 +55,75 @@ type JWireGuardIni struct {
 	FormEmail     string // 发送邮箱地址
 	FormName      string // 发送邮箱名称
 	To            string // 收件人地址
+
+	CertScanInterval int // 证书到期扫描间隔(小时)
+	CertRemindDays   int // 证书到期前提醒天数
+	CertRenewDays    int // 证书到期前自动续签天数(0 表示不自动续签)
+
+	ManagementAddr string // OpenVPN 管理接口地址
+	ManagementPass string // OpenVPN 管理接口密码
+	ManagementAuth bool   // OpenVPN 开启 management-client-auth 时放行客户端连接
+
+	TrafficStatusFile string // OpenVPN status 文件
+	TrafficInterval   int    // 流量采集间隔(秒)
+
+	TunnelBackend string // 新建子网默认使用的隧道后端 openvpn/wireguard
+
+	Supernet        string // 所有子网所在的网段(CIDR)
+	SubnetPrefixLen int    // 新建子网默认的前缀长度
+	IPv6Prefix      string // 客户端 IPv6 地址所在的 ULA 网段(CIDR)，为空时不分配 IPv6 地址
+
+	WireGuardPath      string // WireGuard 配置目录
+	WireGuardInterface string // WireGuard 接口名称
+	WireGuardAddress   string // WireGuard 服务端地址
+	WireGuardPort      int    // WireGuard 监听端口
+	WireGuardEndpoint  string // 客户端连接的服务端地址 host:port
+	WireGuardDNS       string // 下发给客户端的 DNS，多个用逗号分隔
+	WireGuardKeepalive int    // 客户端 PersistentKeepalive(秒)
+
+	HeartbeatMaxSkew int       // v2 心跳允许的时钟偏差(秒)
+	HeartbeatV1Until time.Time // 接受 v1 心跳的截止时间，零值表示不接受
+
+	HeartbeatWorkers       int     // 处理心跳的工作协程数
+	HeartbeatQueueSize     int     // 心跳队列长度，队列满时丢弃
+	HeartbeatRate          float64 // 每个设备每秒允许的心跳数
+	HeartbeatBurst         int     // 每个设备允许的突发心跳数
+	HeartbeatSourceRate    float64 // 校验签名前每个来源地址每秒允许的心跳数
+	HeartbeatSourceBurst   int     // 校验签名前每个来源地址允许的突发心跳数
+	HeartbeatFlushInterval int     // 心跳时间戳批量写入间隔(秒)
+
+	HeartbeatTelemetryInterval  int // 同一设备运行状态的采样间隔(秒)
+	HeartbeatTelemetryRetention int // 运行状态保留天数，0 表示不清理
+
+	HeartbeatCommandTTL int // 命令默认有效期(秒)
+
+	ProxyUpstream    string // TLS 端口 UDP 代理的转发目标，为空时转发到本机 SERVER_PORT
+	ProxyIdleTimeout int    // 代理会话空闲超时(秒)
+	ProxyMaxSessions int    // 代理最大会话数
+
+	OfflineCheckInterval int // 离线检查间隔(秒)
+	OfflineInterval      int // 默认心跳间隔(秒)，可按子网或设备修改
+	OfflineMissed        int // 默认连续丢失多少次心跳后判断为离线
+	FlapWindow           int // 统计状态变化的时间窗口(秒)
+	FlapCount            int // 窗口内状态变化达到该次数时判断为不稳定，0 表示不检测
+	FlapStablePeriod     int // 不稳定的设备持续该时间(秒)没有状态变化后恢复
+
+	NotifyInterval      int // 通知发件箱的检查间隔(秒)
+	NotifyRetryBase     int // 第一次重试的等待时间(秒)，之后每次加倍
+	NotifyRetryMax      int // 重试等待时间的上限(秒)
+	NotifyMaxAttempts   int // 发送失败达到该次数后不再重试
+	NotifyRetentionDays int // 已发送的通知保留天数
+
+	TemplateDir    string // 自定义通知模板目录，<locale>/<name>.tmpl 优先于内置模板
+	TemplateLocale string // 通知使用的语言 zh/en
+	ServerName     string // 通知模板中的服务器名称
+
+	EmailSecurity string // 邮箱服务器连接方式 tls/starttls/plain，为空时按端口判断
+
+	DigestFrequency  string // 没有单独设置的用户使用的摘要频率 off/daily/weekly
+	DigestTime       string // 摘要的发送时间 HH:MM
+	DigestWeekday    int    // 每周摘要的发送日，0 为周日
+	DigestTopTraffic int    // 摘要中列出流量最多的客户端数
 }
 
 type OpenVPNPath struct {
@@ -67,6 +139,14 @@ type OpenVPNPath struct {
 	ReqsPath    string
 }
 
+type WireGuardPath struct {
+	ServerFile string // 服务端配置 wg0.conf
+	HeadFile   string // 服务端 [Interface] 模板
+	KeyFile    string // 服务端私钥
+	PeerPath   string // 服务端 [Peer] 段，每个客户端一个文件
+	ClientPath string // 客户端私钥和配置文件
+}
+
 // ----------------------------------------------------------------------------------------------------------
 // 全局变量
 // ----------------------------------------------------------------------------------------------------------
@@ -76,8 +156,20 @@ var GlobalJWireGuardini *JWireGuardIni
 var GlobalEncryptKey string
 var GlobalJWireGuardDBFile string
 var GlobalOpenVPNPath OpenVPNPath
+var GlobalWireGuardPath WireGuardPath
 var Log *logrus.Logger
 
+// 证书签发机构，首次使用时加载
+var globalCA *pki.CA
+var globalCAMutex sync.Mutex
+
+// OpenVPN 管理接口连接，同一时间只能有一个连接
+var globalManagement *management.Client
+var globalManagementMutex sync.Mutex
+
+// ManagementEventHandler 管理接口实时通知的处理函数，需在首次连接前设置
+var ManagementEventHandler func(management.Event)
+
 // 定义多个时间服务器
 var timeServers = []string{
 	"http://worldtimeapi.org/api/timezone/Etc/UTC",
@@ -199,13 +291,63 @@ func LoadOrCreateJWireGuardIni(filePath string) (*JWireGuardIni, error) {
 		cfg.Section("GENERAL SETTING").Key("UDP_PORT").SetValue("1092")
 		cfg.Section("SSL SETTING").Key("CERT_FILE").SetValue("")
 		cfg.Section("SSL SETTING").Key("KEY_FILE").SetValue("")
-		cfg.Section("EMAIL SETTING").Key("HOST").String()
+		cfg.Section("EMAIL SETTING").Key("HOST").SetValue("")
 		cfg.Section("EMAIL SETTING").Key("PORT").MustInt(465)
-		cfg.Section("EMAIL SETTING").Key("USERNAME").String()
-		cfg.Section("EMAIL SETTING").Key("PASSWORD").String()
-		cfg.Section("EMAIL SETTING").Key("FROMEMAIL").String()
-		cfg.Section("EMAIL SETTING").Key("FROMNAME").String()
-		cfg.Section("EMAIL SETTING").Key("TO").String()
+		cfg.Section("EMAIL SETTING").Key("USERNAME").SetValue("")
+		cfg.Section("EMAIL SETTING").Key("PASSWORD").SetValue("")
+		cfg.Section("EMAIL SETTING").Key("FROMEMAIL").SetValue("")
+		cfg.Section("EMAIL SETTING").Key("FROMNAME").SetValue("")
+		cfg.Section("EMAIL SETTING").Key("TO").SetValue("")
+		cfg.Section("EMAIL SETTING").Key("SECURITY").SetValue("")
+		cfg.Section("CERT SETTING").Key("SCAN_INTERVAL").SetValue("24")
+		cfg.Section("CERT SETTING").Key("REMIND_DAYS").SetValue("30")
+		cfg.Section("CERT SETTING").Key("RENEW_DAYS").SetValue("0")
+		cfg.Section("MANAGEMENT SETTING").Key("ADDR").SetValue("127.0.0.1:7505")
+		cfg.Section("MANAGEMENT SETTING").Key("PASSWORD").SetValue("")
+		cfg.Section("TRAFFIC SETTING").Key("STATUS_FILE").SetValue("/tmp/openvpn-status.log")
+		cfg.Section("TRAFFIC SETTING").Key("INTERVAL").SetValue("60")
+		cfg.Section("GENERAL SETTING").Key("TUNNEL_BACKEND").SetValue(BACKENDOPENVPN)
+		cfg.Section("GENERAL SETTING").Key("SUPERNET").SetValue("")
+		cfg.Section("GENERAL SETTING").Key("SUBNET_PREFIX_LEN").SetValue("")
+		cfg.Section("GENERAL SETTING").Key("IPV6_PREFIX").SetValue("")
+		cfg.Section("WIREGUARD SETTING").Key("PATH").SetValue("/etc/wireguard")
+		cfg.Section("WIREGUARD SETTING").Key("INTERFACE").SetValue("wg0")
+		cfg.Section("WIREGUARD SETTING").Key("ADDRESS").SetValue("")
+		cfg.Section("WIREGUARD SETTING").Key("LISTEN_PORT").SetValue("51820")
+		cfg.Section("WIREGUARD SETTING").Key("ENDPOINT").SetValue("")
+		cfg.Section("WIREGUARD SETTING").Key("DNS").SetValue("")
+		cfg.Section("WIREGUARD SETTING").Key("KEEPALIVE").SetValue("25")
+		cfg.Section("HEARTBEAT SETTING").Key("MAX_SKEW").SetValue("60")
+		cfg.Section("HEARTBEAT SETTING").Key("V1_UNTIL").SetValue("")
+		cfg.Section("HEARTBEAT SETTING").Key("WORKERS").SetValue("4")
+		cfg.Section("HEARTBEAT SETTING").Key("QUEUE_SIZE").SetValue("4096")
+		cfg.Section("HEARTBEAT SETTING").Key("RATE").SetValue("1")
+		cfg.Section("HEARTBEAT SETTING").Key("BURST").SetValue("5")
+		cfg.Section("HEARTBEAT SETTING").Key("FLUSH_INTERVAL").SetValue("5")
+		cfg.Section("HEARTBEAT SETTING").Key("TELEMETRY_INTERVAL").SetValue("60")
+		cfg.Section("HEARTBEAT SETTING").Key("TELEMETRY_RETENTION_DAYS").SetValue("30")
+		cfg.Section("HEARTBEAT SETTING").Key("COMMAND_TTL").SetValue("86400")
+		cfg.Section("PROXY SETTING").Key("UPSTREAM").SetValue("")
+		cfg.Section("PROXY SETTING").Key("IDLE_TIMEOUT").SetValue("60")
+		cfg.Section("PROXY SETTING").Key("MAX_SESSIONS").SetValue("1024")
+		cfg.Section("OFFLINE SETTING").Key("CHECK_INTERVAL").SetValue("60")
+		cfg.Section("OFFLINE SETTING").Key("INTERVAL").SetValue("60")
+		cfg.Section("OFFLINE SETTING").Key("MISSED").SetValue("1")
+		cfg.Section("OFFLINE SETTING").Key("FLAP_WINDOW").SetValue("600")
+		cfg.Section("OFFLINE SETTING").Key("FLAP_COUNT").SetValue("4")
+		cfg.Section("OFFLINE SETTING").Key("STABLE_PERIOD").SetValue("900")
+		cfg.Section("NOTIFY SETTING").Key("INTERVAL").SetValue("5")
+		cfg.Section("NOTIFY SETTING").Key("RETRY_BASE").SetValue("30")
+		cfg.Section("NOTIFY SETTING").Key("RETRY_MAX").SetValue("3600")
+		cfg.Section("NOTIFY SETTING").Key("MAX_ATTEMPTS").SetValue("8")
+		cfg.Section("NOTIFY SETTING").Key("RETENTION_DAYS").SetValue("7")
+		cfg.Section("TEMPLATE SETTING").Key("DIR").SetValue("")
+		cfg.Section("TEMPLATE SETTING").Key("LOCALE").SetValue("zh")
+		cfg.Section("TEMPLATE SETTING").Key("SERVER_NAME").SetValue("VPN-上海服务器")
+		cfg.Section("DIGEST SETTING").Key("FREQUENCY").SetValue("off")
+		cfg.Section("DIGEST SETTING").Key("TIME").SetValue("08:00")
+		cfg.Section("DIGEST SETTING").Key("WEEKDAY").SetValue("1")
+		cfg.Section("DIGEST SETTING").Key("TOP_TRAFFIC").SetValue("10")
 
 		// 保存到文件
 		if err = cfg.SaveTo(filePath); err != nil {
@@ -239,6 +381,203 @@ func LoadOrCreateJWireGuardIni(filePath string) (*JWireGuardIni, error) {
 		FormEmail:     cfg.Section("EMAIL SETTING").Key("FROMEMAIL").String(),
 		FormName:      cfg.Section("EMAIL SETTING").Key("FROMNAME").String(),
 		To:            cfg.Section("EMAIL SETTING").Key("TO").String(),
+
+		CertScanInterval: cfg.Section("CERT SETTING").Key("SCAN_INTERVAL").MustInt(24),
+		CertRemindDays:   cfg.Section("CERT SETTING").Key("REMIND_DAYS").MustInt(30),
+		CertRenewDays:    cfg.Section("CERT SETTING").Key("RENEW_DAYS").MustInt(0),
+
+		ManagementAddr: cfg.Section("MANAGEMENT SETTING").Key("ADDR").MustString("127.0.0.1:7505"),
+		ManagementPass: cfg.Section("MANAGEMENT SETTING").Key("PASSWORD").String(),
+		ManagementAuth: cfg.Section("MANAGEMENT SETTING").Key("CLIENT_AUTH").MustBool(false),
+
+		TrafficStatusFile: cfg.Section("TRAFFIC SETTING").Key("STATUS_FILE").MustString("/tmp/openvpn-status.log"),
+		TrafficInterval:   cfg.Section("TRAFFIC SETTING").Key("INTERVAL").MustInt(60),
+
+		TunnelBackend: cfg.Section("GENERAL SETTING").Key("TUNNEL_BACKEND").MustString(BACKENDOPENVPN),
+
+		Supernet:        cfg.Section("GENERAL SETTING").Key("SUPERNET").String(),
+		SubnetPrefixLen: cfg.Section("GENERAL SETTING").Key("SUBNET_PREFIX_LEN").MustInt(0),
+		IPv6Prefix:      cfg.Section("GENERAL SETTING").Key("IPV6_PREFIX").String(),
+
+		WireGuardPath:      cfg.Section("WIREGUARD SETTING").Key("PATH").MustString("/etc/wireguard"),
+		WireGuardInterface: cfg.Section("WIREGUARD SETTING").Key("INTERFACE").MustString("wg0"),
+		WireGuardAddress:   cfg.Section("WIREGUARD SETTING").Key("ADDRESS").String(),
+		WireGuardPort:      cfg.Section("WIREGUARD SETTING").Key("LISTEN_PORT").MustInt(51820),
+		WireGuardEndpoint:  cfg.Section("WIREGUARD SETTING").Key("ENDPOINT").String(),
+		WireGuardDNS:       cfg.Section("WIREGUARD SETTING").Key("DNS").String(),
+		WireGuardKeepalive: cfg.Section("WIREGUARD SETTING").Key("KEEPALIVE").MustInt(25),
+
+		HeartbeatMaxSkew: cfg.Section("HEARTBEAT SETTING").Key("MAX_SKEW").MustInt(60),
+
+		HeartbeatWorkers:       cfg.Section("HEARTBEAT SETTING").Key("WORKERS").MustInt(4),
+		HeartbeatQueueSize:     cfg.Section("HEARTBEAT SETTING").Key("QUEUE_SIZE").MustInt(4096),
+		HeartbeatRate:          cfg.Section("HEARTBEAT SETTING").Key("RATE").MustFloat64(1),
+		HeartbeatBurst:         cfg.Section("HEARTBEAT SETTING").Key("BURST").MustInt(5),
+		HeartbeatSourceRate:    cfg.Section("HEARTBEAT SETTING").Key("SOURCE_RATE").MustFloat64(50),
+		HeartbeatSourceBurst:   cfg.Section("HEARTBEAT SETTING").Key("SOURCE_BURST").MustInt(100),
+		HeartbeatFlushInterval: cfg.Section("HEARTBEAT SETTING").Key("FLUSH_INTERVAL").MustInt(5),
+
+		HeartbeatTelemetryInterval:  cfg.Section("HEARTBEAT SETTING").Key("TELEMETRY_INTERVAL").MustInt(60),
+		HeartbeatTelemetryRetention: cfg.Section("HEARTBEAT SETTING").Key("TELEMETRY_RETENTION_DAYS").MustInt(30),
+
+		HeartbeatCommandTTL: cfg.Section("HEARTBEAT SETTING").Key("COMMAND_TTL").MustInt(86400),
+
+		ProxyUpstream:    cfg.Section("PROXY SETTING").Key("UPSTREAM").String(),
+		ProxyIdleTimeout: cfg.Section("PROXY SETTING").Key("IDLE_TIMEOUT").MustInt(60),
+		ProxyMaxSessions: cfg.Section("PROXY SETTING").Key("MAX_SESSIONS").MustInt(1024),
+
+		OfflineCheckInterval: cfg.Section("OFFLINE SETTING").Key("CHECK_INTERVAL").MustInt(60),
+		OfflineInterval:      cfg.Section("OFFLINE SETTING").Key("INTERVAL").MustInt(60),
+		OfflineMissed:        cfg.Section("OFFLINE SETTING").Key("MISSED").MustInt(1),
+		FlapWindow:           cfg.Section("OFFLINE SETTING").Key("FLAP_WINDOW").MustInt(600),
+		FlapCount:            cfg.Section("OFFLINE SETTING").Key("FLAP_COUNT").MustInt(4),
+		FlapStablePeriod:     cfg.Section("OFFLINE SETTING").Key("STABLE_PERIOD").MustInt(900),
+		NotifyInterval:       cfg.Section("NOTIFY SETTING").Key("INTERVAL").MustInt(5),
+		NotifyRetryBase:      cfg.Section("NOTIFY SETTING").Key("RETRY_BASE").MustInt(30),
+		NotifyRetryMax:       cfg.Section("NOTIFY SETTING").Key("RETRY_MAX").MustInt(3600),
+		NotifyMaxAttempts:    cfg.Section("NOTIFY SETTING").Key("MAX_ATTEMPTS").MustInt(8),
+		NotifyRetentionDays:  cfg.Section("NOTIFY SETTING").Key("RETENTION_DAYS").MustInt(7),
+		TemplateDir:          cfg.Section("TEMPLATE SETTING").Key("DIR").String(),
+		TemplateLocale:       cfg.Section("TEMPLATE SETTING").Key("LOCALE").MustString("zh"),
+		ServerName:           cfg.Section("TEMPLATE SETTING").Key("SERVER_NAME").MustString("VPN-上海服务器"),
+		EmailSecurity:        strings.ToLower(cfg.Section("EMAIL SETTING").Key("SECURITY").String()),
+		DigestFrequency:      strings.ToLower(cfg.Section("DIGEST SETTING").Key("FREQUENCY").MustString("off")),
+		DigestTime:           cfg.Section("DIGEST SETTING").Key("TIME").MustString("08:00"),
+		DigestWeekday:        cfg.Section("DIGEST SETTING").Key("WEEKDAY").MustInt(1),
+		DigestTopTraffic:     cfg.Section("DIGEST SETTING").Key("TOP_TRAFFIC").MustInt(10),
+	}
+
+	// V1_UNTIL 为 YYYY-MM-DD，当天结束前仍接受 v1 心跳
+	if v1Until := cfg.Section("HEARTBEAT SETTING").Key("V1_UNTIL").String(); v1Until != "" {
+		day, err := time.ParseInLocation("2006-01-02", v1Until, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid V1_UNTIL: %s", v1Until)
+		}
+		jwg.HeartbeatV1Until = day.AddDate(0, 0, 1)
+	}
+	if jwg.HeartbeatMaxSkew <= 0 {
+		jwg.HeartbeatMaxSkew = 60
+	}
+	if jwg.HeartbeatWorkers <= 0 {
+		jwg.HeartbeatWorkers = 4
+	}
+	if jwg.HeartbeatQueueSize <= 0 {
+		jwg.HeartbeatQueueSize = 4096
+	}
+	if jwg.HeartbeatFlushInterval <= 0 {
+		jwg.HeartbeatFlushInterval = 5
+	}
+	if jwg.HeartbeatTelemetryInterval < 0 {
+		jwg.HeartbeatTelemetryInterval = 60
+	}
+	if jwg.HeartbeatCommandTTL <= 0 {
+		jwg.HeartbeatCommandTTL = 86400
+	}
+	if jwg.ProxyUpstream == "" {
+		jwg.ProxyUpstream = fmt.Sprintf("127.0.0.1:%d", jwg.ServerPort)
+	}
+	if jwg.ProxyIdleTimeout <= 0 {
+		jwg.ProxyIdleTimeout = 60
+	}
+	if jwg.ProxyMaxSessions <= 0 {
+		jwg.ProxyMaxSessions = 1024
+	}
+	if jwg.OfflineCheckInterval <= 0 {
+		jwg.OfflineCheckInterval = 60
+	}
+	if jwg.OfflineInterval <= 0 {
+		jwg.OfflineInterval = 60
+	}
+	if jwg.OfflineMissed <= 0 {
+		jwg.OfflineMissed = 1
+	}
+	if jwg.FlapWindow <= 0 {
+		jwg.FlapWindow = 600
+	}
+	if jwg.FlapStablePeriod <= 0 {
+		jwg.FlapStablePeriod = 900
+	}
+	if jwg.NotifyInterval <= 0 {
+		jwg.NotifyInterval = 5
+	}
+	if jwg.NotifyRetryBase <= 0 {
+		jwg.NotifyRetryBase = 30
+	}
+	if jwg.NotifyRetryMax < jwg.NotifyRetryBase {
+		jwg.NotifyRetryMax = jwg.NotifyRetryBase
+	}
+	if jwg.NotifyMaxAttempts <= 0 {
+		jwg.NotifyMaxAttempts = 8
+	}
+	if jwg.NotifyRetentionDays <= 0 {
+		jwg.NotifyRetentionDays = 7
+	}
+
+	switch jwg.EmailSecurity {
+	case "", "tls", "starttls", "plain":
+	default:
+		return nil, fmt.Errorf("invalid SECURITY: %s", jwg.EmailSecurity)
+	}
+
+	switch jwg.DigestFrequency {
+	case "off", "daily", "weekly":
+	default:
+		return nil, fmt.Errorf("invalid FREQUENCY: %s", jwg.DigestFrequency)
+	}
+	if _, err := time.Parse("15:04", jwg.DigestTime); err != nil {
+		return nil, fmt.Errorf("invalid TIME: %s", jwg.DigestTime)
+	}
+	if jwg.DigestWeekday < 0 || jwg.DigestWeekday > 6 {
+		return nil, fmt.Errorf("invalid WEEKDAY: %d", jwg.DigestWeekday)
+	}
+	if jwg.DigestTopTraffic <= 0 {
+		jwg.DigestTopTraffic = 10
+	}
+
+	// 未配置 SUPERNET 时沿用 IP_PREFIX.0.0 和 NETWORK_MASK
+	if jwg.Supernet == "" {
+		bits, err := maskBits(jwg.NetworkMask)
+		if err != nil {
+			return nil, err
+		}
+		jwg.Supernet = fmt.Sprintf("%s.0.0/%d", jwg.IPPrefix, bits)
+	}
+	_, supernet, err := net.ParseCIDR(jwg.Supernet)
+	if err != nil || supernet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid SUPERNET: %s", jwg.Supernet)
+	}
+	jwg.Supernet = supernet.String()
+
+	// 未配置子网前缀长度时沿用 SUBNET_MAKE
+	if jwg.SubnetPrefixLen == 0 {
+		jwg.SubnetPrefixLen, err = maskBits(jwg.SubnetMask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// IPV6_PREFIX 必须是 ULA 网段，并且能按偏移容纳整个 SUPERNET
+	if jwg.IPv6Prefix != "" {
+		_, prefix6, err := net.ParseCIDR(jwg.IPv6Prefix)
+		if err != nil || prefix6.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid IPV6_PREFIX: %s", jwg.IPv6Prefix)
+		}
+		_, ula, _ := net.ParseCIDR("fc00::/7")
+		if !ula.Contains(prefix6.IP) {
+			return nil, fmt.Errorf("IPV6_PREFIX %s is not a ULA prefix", jwg.IPv6Prefix)
+		}
+		superOnes, _ := supernet.Mask.Size()
+		prefixOnes, _ := prefix6.Mask.Size()
+		if prefixOnes > 128-(32-superOnes) {
+			return nil, fmt.Errorf("IPV6_PREFIX %s is too small for SUPERNET %s", jwg.IPv6Prefix, jwg.Supernet)
+		}
+		jwg.IPv6Prefix = prefix6.String()
+	}
+
+	// 服务端地址默认使用 SUPERNET 的最后一个地址，新建子网时会跳过该地址
+	if jwg.WireGuardAddress == "" {
+		hostmask := ^ipToUint32(net.IP(supernet.Mask))
+		jwg.WireGuardAddress = uint32ToIP(ipToUint32(supernet.IP) | hostmask - 1).String()
 	}
 
 	return jwg, nil
@@ -322,9 +661,13 @@ func ConvertToNetworkAddress(ipStr, maskStr string) (string, error) {
 		return "", fmt.Errorf("invalid subnet mask: %s", maskStr)
 	}
 
-	// Convert IP and mask to 4-byte representations
-	ip = ip.To4()
-	mask = mask.To4()
+	// Convert IP and mask to 4-byte representations, IPv6 keeps 16 bytes
+	if ip.To4() != nil {
+		ip = ip.To4()
+		mask = mask.To4()
+	} else {
+		mask = mask.To16()
+	}
 
 	if ip == nil || mask == nil {
 		return "", fmt.Errorf("invalid IP or subnet mask")
@@ -440,7 +783,7 @@ func GetNetworkTime() (time.Time, error) {
 // ----------------------------------------------------------------------------------------------------------
 // ShellAddClient 添加客户端证书
 // ----------------------------------------------------------------------------------------------------------
-func ShellAddClient(cliId string, cliAddr string) error {
+func ShellAddClient(cliId string, cliAddr string, network *net.IPNet) error {
 	headClient := fmt.Sprintf("%s/openvpn.txt", GlobalOpenVPNPath.ConfigPath)
 	caClient := fmt.Sprintf("%s/ca.crt", GlobalOpenVPNPath.PkiPath)
 	taClient := fmt.Sprintf("%s/ta.key", GlobalOpenVPNPath.PkiPath)
@@ -497,25 +840,21 @@ func ShellAddClient(cliId string, cliAddr string) error {
 		return fmt.Errorf("无法删除原ISSUE文件")
 	}
 
-	// 调用封装的函数
-	if err := ChangeDir(GlobalOpenVPNPath.EasyRsaPath); err != nil {
-		fmt.Println(err)
+	// 加载证书签发机构
+	ca, err := GetCA()
+	if err != nil {
+		return fmt.Errorf("无法加载CA: %v", err)
 	}
 
-	// 创建证书请求 (生成客户端私钥和CSR)
-	cmd := exec.Command(GlobalOpenVPNPath.EasyRsaFile, "build-client-full", cliId, "nopass")
-	cmd.Env = append(os.Environ(), "EASYRSA_BATCH=1") // 跳过确认步骤
-
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("无法创建私钥: %v Output: %s", err, string(output))
+	// 同名客户端的遗留证书一并吊销
+	err = RevokeClient(cliId, pki.ReasonSuperseded)
+	if err != nil {
+		return err
 	}
 
-	// 签发证书
-	cmd = exec.Command(GlobalOpenVPNPath.EasyRsaFile, "sign-req", "client", cliId)
-	cmd.Env = append(os.Environ(), "EASYRSA_BATCH=1") // 跳过确认步骤
-
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("无法签发证书: %v Output: %s", err, string(output))
+	// 生成客户端私钥、证书请求并签发证书
+	if _, err := ca.IssueClient(cliId); err != nil {
+		return fmt.Errorf("无法签发证书: %v", err)
 	}
 
 	// // 生成网络地址：
@@ -524,16 +863,9 @@ func ShellAddClient(cliId string, cliAddr string) error {
 	// 	return fmt.Errorf("无法生成网络地址: %s 目标地址 %s", err, clientNetworkAddr)
 	// }
 
-	changClientAddr := fmt.Sprintf("ifconfig-push %s %s\npush \"route %s.0.0 %s %s\"\n",
-		cliAddr,
-		GlobalJWireGuardini.SubnetMask,
-		GlobalJWireGuardini.IPPrefix,
-		GlobalJWireGuardini.NetworkMask,
-		cliAddr)
-
-	err = WriteToFile(ccdClient, changClientAddr)
+	err = WriteClientCcd(cliId, cliAddr, network)
 	if err != nil {
-		return fmt.Errorf("无法对ccd文件写入: %s file:%s ", err, ccdClient)
+		return err
 	}
 
 	for _, file := range files {
@@ -572,16 +904,37 @@ func ShellAddClient(cliId string, cliAddr string) error {
 
 // ----------------------------------------------------------------------------------------------------------
 // ShellUpdateClient 更新客户端证书
+// 先签发新证书并替换配置文件，成功后再吊销旧证书，失败时客户端原有的配置仍然可用
 // ----------------------------------------------------------------------------------------------------------
 func ShellUpdateClient(cliId string) error {
-	// log.Println("ShellUpdateClient")
+	cert, err := reissueClient(cliId)
+	if err != nil {
+		return err
+	}
+
+	// 吊销被替换的证书，避免旧的配置文件继续可用
+	return revokeClientExcept(cliId, pki.SerialString(cert), pki.ReasonSuperseded)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ShellRenewClient 续签客户端证书
+// 只签发新证书并替换配置文件，旧证书不吊销，到期后自然失效，尚未更新配置的客户端在此之前仍可连接
+// ----------------------------------------------------------------------------------------------------------
+func ShellRenewClient(cliId string) error {
+	_, err := reissueClient(cliId)
+	return err
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// reissueClient 签发新证书并重新生成 .ovpn，不吊销旧证书
+// 新的 .ovpn 先写入临时文件再替换，任何一步失败时原有的私钥、证书和配置文件保持不变
+// ----------------------------------------------------------------------------------------------------------
+func reissueClient(cliId string) (*x509.Certificate, error) {
 	headClient := fmt.Sprintf("%s/openvpn.txt", GlobalOpenVPNPath.ConfigPath)
 	caClient := fmt.Sprintf("%s/ca.crt", GlobalOpenVPNPath.PkiPath)
 	taClient := fmt.Sprintf("%s/ta.key", GlobalOpenVPNPath.PkiPath)
-	ccdClient := fmt.Sprintf("%s/%s", GlobalOpenVPNPath.CcdPath, cliId)
 	ovpnClient := fmt.Sprintf("%s/%s.ovpn", GlobalOpenVPNPath.ConfigPath, cliId)
 	privateClient := fmt.Sprintf("%s/%s.key", GlobalOpenVPNPath.PrivatePath, cliId)
-	reqsClient := fmt.Sprintf("%s/%s.req", GlobalOpenVPNPath.ReqsPath, cliId)
 	issuedClient := fmt.Sprintf("%s/%s.crt", GlobalOpenVPNPath.IssuedPath, cliId)
 
 	// Define file paths
@@ -592,162 +945,162 @@ func ShellUpdateClient(cliId string) error {
 		"tls-auth": taClient,
 	}
 
-	// log.Println("headClient: ", headClient)
-	// log.Println("caClient: ", caClient)
-	// log.Println("taClient: ", taClient)
-	// log.Println("ccdClient: ", ccdClient)
-	// log.Println("ovpnClient: ", ovpnClient)
-	// log.Println("privateClient: ", privateClient)
-	// log.Println("reqsClient: ", reqsClient)
-	// log.Println("issuedClient: ", issuedClient)
-
-	// 删除配置文件
-	err := DeleteFileIfExists(ovpnClient)
-	if err != nil {
-		return fmt.Errorf("无法删除原配置文件")
-	}
-
-	// 删除REQS文件
-	err = DeleteFileIfExists(reqsClient)
+	// 加载证书签发机构
+	ca, err := GetCA()
 	if err != nil {
-		return fmt.Errorf("无法删除原REQS文件")
+		return nil, fmt.Errorf("无法加载CA: %v", err)
 	}
 
-	// 删除PRIVATE文件
-	err = DeleteFileIfExists(privateClient)
+	// 生成客户端私钥、证书请求并签发证书，成功后才替换原有文件
+	cert, err := ca.IssueClient(cliId)
 	if err != nil {
-		return fmt.Errorf("无法删除原PRIVATE文件")
-	}
-
-	// 删除ISSUE文件
-	err = DeleteFileIfExists(issuedClient)
-	if err != nil {
-		return fmt.Errorf("无法删除原ISSUE文件")
-	}
-
-	// 调用封装的函数
-	if err := ChangeDir(GlobalOpenVPNPath.EasyRsaPath); err != nil {
-		fmt.Println(err)
-	}
-
-	// 创建证书请求 (生成客户端私钥和CSR)
-	cmd := exec.Command(GlobalOpenVPNPath.EasyRsaFile, "build-client-full", cliId, "nopass")
-	cmd.Env = append(os.Environ(), "EASYRSA_BATCH=1") // 跳过确认步骤
-
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("无法创建私钥: %v Output: %s", err, string(output))
-	}
-
-	// 签发证书
-	cmd = exec.Command(GlobalOpenVPNPath.EasyRsaFile, "sign-req", "client", cliId)
-	cmd.Env = append(os.Environ(), "EASYRSA_BATCH=1") // 跳过确认步骤
-
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("无法签发证书: %v Output: %s", err, string(output))
+		return nil, fmt.Errorf("无法签发证书: %v", err)
 	}
 
 	for _, file := range files {
 		if !CheckFileExists(file) {
-			return fmt.Errorf("该%s文件不存在", file)
+			return nil, fmt.Errorf("该%s文件不存在", file)
 		}
 	}
 
 	// Create the .ovpn file
-	err = CreateOVPNFile(headClient, ovpnClient, files)
+	ovpnTemp := ovpnClient + ".new"
+	err = CreateOVPNFile(headClient, ovpnTemp, files)
 	if err != nil {
-		// Log.Errorln("Error creating .ovpn file:", err)
-		return fmt.Errorf("无法合成%s.ovpn, err:%v", cliId, err)
+		DeleteFileIfExists(ovpnTemp)
+		return nil, fmt.Errorf("无法合成%s.ovpn, err:%v", cliId, err)
 	}
+	err = os.Rename(ovpnTemp, ovpnClient)
+	if err != nil {
+		DeleteFileIfExists(ovpnTemp)
+		return nil, fmt.Errorf("无法替换%s.ovpn, err:%v", cliId, err)
+	}
+	return cert, nil
+}
 
-	// log.Printf("Output:\n%s", output)
-	if (!CheckFileExists(privateClient)) ||
-		(!CheckFileExists(reqsClient)) ||
-		(!CheckFileExists(ovpnClient)) ||
-		(!CheckFileExists(issuedClient)) {
-
-		// 删除错误客户端文件
-		DeleteFileIfExists(ovpnClient)
-		DeleteFileIfExists(ccdClient)
-		DeleteFileIfExists(privateClient)
-		DeleteFileIfExists(reqsClient)
-		DeleteFileIfExists(issuedClient)
+// RevokeError 客户端文件已经删除，但证书吊销失败，证书在到期前仍然有效
+type RevokeError struct {
+	CliID string
+	Err   error
+}
 
-		return fmt.Errorf("文件中创建客户端失败")
-	}
+func (e *RevokeError) Error() string {
+	return fmt.Sprintf("客户端 %s 吊销证书失败: %v", e.CliID, e.Err)
+}
 
-	// fmt.Println("Client certificate generated and signed successfully.")
-	return nil
+func (e *RevokeError) Unwrap() error {
+	return e.Err
 }
 
 // ----------------------------------------------------------------------------------------------------------
-// ShellUpdateClient 更新客户端证书
+// ShellDelClient 吊销并删除客户端证书
+// 吊销失败时仍然删除全部文件，返回的错误中包含 *RevokeError，调用方应完成其余清理后单独报告
 // ----------------------------------------------------------------------------------------------------------
 func ShellDelClient(cliId string) error {
-	// log.Println("ShellDelClient")
+	// 吊销证书并更新CRL
+	var errs []error
+	if err := RevokeClient(cliId, pki.ReasonCessationOfOperation); err != nil {
+		Log.Errorf("[ShellDelClient] 客户端 %s 吊销证书失败, err:%v", cliId, err)
+		errs = append(errs, &RevokeError{CliID: cliId, Err: err})
+	}
+
 	ccdClient := fmt.Sprintf("%s/%s", GlobalOpenVPNPath.CcdPath, cliId)
 	ovpnClient := fmt.Sprintf("%s/%s.ovpn", GlobalOpenVPNPath.ConfigPath, cliId)
 	privateClient := fmt.Sprintf("%s/%s.key", GlobalOpenVPNPath.PrivatePath, cliId)
 	reqsClient := fmt.Sprintf("%s/%s.req", GlobalOpenVPNPath.ReqsPath, cliId)
 	issuedClient := fmt.Sprintf("%s/%s.crt", GlobalOpenVPNPath.IssuedPath, cliId)
 
-	// log.Println("ccdClient: ", ccdClient)
-	// log.Println("ovpnClient: ", ovpnClient)
-	// log.Println("privateClient: ", privateClient)
-	// log.Println("reqsClient: ", reqsClient)
-	// log.Println("issuedClient: ", issuedClient)
-
-	// 删除CCD
-	err := DeleteFileIfExists(ccdClient)
-	if err != nil {
-		return fmt.Errorf("无法删除原CCD文件")
+	// 删除CCD、配置文件、REQS、PRIVATE和ISSUE文件，某个文件删除失败时继续删除其它文件
+	for _, file := range []string{ccdClient, ovpnClient, reqsClient, privateClient, issuedClient} {
+		if err := DeleteFileIfExists(file); err != nil {
+			errs = append(errs, fmt.Errorf("无法删除%s: %v", file, err))
+		}
 	}
 
-	// 删除配置文件
-	err = DeleteFileIfExists(ovpnClient)
+	return errors.Join(errs...)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// RevokeClient 吊销客户端的所有有效证书并重新生成 crl.pem
+// ----------------------------------------------------------------------------------------------------------
+func RevokeClient(cliId string, reason string) error {
+	return revokeClientExcept(cliId, "", reason)
+}
+
+// revokeClientExcept 吊销客户端除 keep 序列号以外的有效证书并重新生成 crl.pem
+func revokeClientExcept(cliId string, keep string, reason string) error {
+	ca, err := GetCA()
 	if err != nil {
-		return fmt.Errorf("无法删除原配置文件")
+		return fmt.Errorf("无法加载CA: %v", err)
 	}
 
-	// 删除REQS文件
-	err = DeleteFileIfExists(reqsClient)
+	revoked, err := ca.RevokeExcept(cliId, keep, reason)
+	if err == pki.ErrNotIssued {
+		// 没有有效证书，无需吊销
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("无法删除原REQS文件")
+		return fmt.Errorf("无法吊销证书: %v", err)
 	}
 
-	// 删除PRIVATE文件
-	err = DeleteFileIfExists(privateClient)
-	if err != nil {
-		return fmt.Errorf("无法删除原PRIVATE文件")
+	for _, entry := range revoked {
+		Log.Infof("[RevokeClient] 客户端 %s 证书 %s 已吊销, 原因:%s", cliId, entry.Serial, reason)
 	}
 
-	// 删除ISSUE文件
-	err = DeleteFileIfExists(issuedClient)
+	err = ca.GenCRL()
 	if err != nil {
-		return fmt.Errorf("无法删除原ISSUE文件")
+		return fmt.Errorf("无法生成CRL: %v", err)
 	}
-
 	return nil
-
 }
 
 // ----------------------------------------------------------------------------------------------------------
-// ChangeDir 切换当前工作目录到指定路径
+// GetCA 获取证书签发机构，首次调用时从 PkiPath 加载 ca.crt 和 ca.key
 // ----------------------------------------------------------------------------------------------------------
-func ChangeDir(dir string) error {
-	// 切换到目标目录
-	err := os.Chdir(dir)
+func GetCA() (*pki.CA, error) {
+	globalCAMutex.Lock()
+	defer globalCAMutex.Unlock()
+
+	if globalCA != nil && globalCA.PkiPath == GlobalOpenVPNPath.PkiPath {
+		return globalCA, nil
+	}
+
+	ca, err := pki.LoadCA(GlobalOpenVPNPath.PkiPath)
 	if err != nil {
-		return fmt.Errorf("切换目录失败: %v", err)
+		return nil, err
+	}
+	globalCA = ca
+	return globalCA, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// GetManagement 获取 OpenVPN 管理接口连接，连接断开后重新连接
+// ----------------------------------------------------------------------------------------------------------
+func GetManagement() (*management.Client, error) {
+	globalManagementMutex.Lock()
+	defer globalManagementMutex.Unlock()
+
+	if globalManagement != nil && globalManagement.Err() == nil {
+		return globalManagement, nil
 	}
 
-	// 获取并返回当前工作目录
-	currentDir, err := os.Getwd()
+	client, err := management.Dial(GlobalJWireGuardini.ManagementAddr, GlobalJWireGuardini.ManagementPass, ManagementEventHandler)
 	if err != nil {
-		return fmt.Errorf("获取当前目录失败: %v", err)
+		return nil, fmt.Errorf("无法连接管理接口 %s: %v", GlobalJWireGuardini.ManagementAddr, err)
 	}
+	globalManagement = client
+	return globalManagement, nil
+}
 
-	Log.Debugln("当前目录:", currentDir)
-	return nil
+// ----------------------------------------------------------------------------------------------------------
+// KillClient 通过管理接口断开客户端的所有连接，返回断开的连接数量
+// ----------------------------------------------------------------------------------------------------------
+func KillClient(cliId string) (int, error) {
+	client, err := GetManagement()
+	if err != nil {
+		return 0, err
+	}
+	return client.Kill(cliId)
 }
 
 // ----------------------------------------------------------------------------------------------------------
@@ -832,39 +1185,70 @@ func CreateOVPNFile(templatePath, outputPath string, files map[string]string) er
 
 // checkIptablesRule checks if a specific iptables rule exists.
 func CheckIptablesRule(rule string) bool {
+	return checkForwardRule("iptables", rule)
+}
+
+// addIptablesRule adds an iptables rule.
+func AddIptablesRule(rule string) error {
+	return addForwardRule("iptables", rule)
+}
+
+// deleteIptablesRule deletes an iptables rule.
+func DeleteIptablesRule(rule string) error {
+	return deleteForwardRule("iptables", rule)
+}
+
+// CheckIp6tablesRule checks if a specific ip6tables rule exists.
+func CheckIp6tablesRule(rule string) bool {
+	return checkForwardRule("ip6tables", rule)
+}
+
+// AddIp6tablesRule adds an ip6tables rule.
+func AddIp6tablesRule(rule string) error {
+	return addForwardRule("ip6tables", rule)
+}
+
+// DeleteIp6tablesRule deletes an ip6tables rule.
+func DeleteIp6tablesRule(rule string) error {
+	return deleteForwardRule("ip6tables", rule)
+}
+
+func checkForwardRule(command string, rule string) bool {
 	args := append([]string{"-C", "FORWARD"}, strings.Fields(rule)...)
-	cmd := exec.Command("iptables", args...)
+	cmd := exec.Command(command, args...)
 	err := cmd.Run()
 	return err == nil
 }
 
-// addIptablesRule adds an iptables rule.
-func AddIptablesRule(rule string) error {
+func addForwardRule(command string, rule string) error {
 	args := append([]string{"-A", "FORWARD"}, strings.Fields(rule)...)
-	cmd := exec.Command("iptables", args...)
+	cmd := exec.Command(command, args...)
 	err := cmd.Run()
 	if err != nil {
-		return fmt.Errorf("failed to add iptables rule: %v", err)
+		return fmt.Errorf("failed to add %s rule: %v", command, err)
 	}
 	return nil
 }
 
-// deleteIptablesRule deletes an iptables rule.
-func DeleteIptablesRule(rule string) error {
+func deleteForwardRule(command string, rule string) error {
 	args := append([]string{"-D", "FORWARD"}, strings.Fields(rule)...)
-	cmd := exec.Command("iptables", args...)
+	cmd := exec.Command(command, args...)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	err := cmd.Run()
 	if err != nil {
-		return fmt.Errorf("failed to delete iptables rule: %v, output: %s", err, out.String())
+		return fmt.Errorf("failed to delete %s rule: %v, output: %s", command, err, out.String())
 	}
 	return nil
 }
 
 func SplitIP(ip string) (string, string) {
-	// 使用 strings.LastIndex 分割最后一个点的位置
-	lastDotIndex := strings.LastIndex(ip, ".")
+	// 使用 strings.LastIndex 分割最后一个点的位置，IPv6 地址分割最后一个冒号
+	sep := "."
+	if strings.Contains(ip, ":") {
+		sep = ":"
+	}
+	lastDotIndex := strings.LastIndex(ip, sep)
 	if lastDotIndex == -1 {
 		return "", ""
 	}
@@ -923,7 +1307,7 @@ func HandleConnection(conn net.Conn) {
 		_, err = conn.Write([]byte(message))
 		if err != nil {
 			// 如果写入数据时发生错误，打印错误并退出循环
-			Log.Errorf("[global] Error writing to connection:", err)
+			Log.Errorln("[global] Error writing to connection:", err)
 			break
 		}
 	}
@@ -951,14 +1335,3 @@ func IsValidEmail(email string) bool {
 	match, _ := regexp.MatchString(pattern, email)
 	return match
 }
-
-// 邮箱替换
-func ReplaceText(n *html.Node, old, new string) {
-	if n.Type == html.TextNode {
-		n.Data = strings.ReplaceAll(n.Data, old, new)
-	}
-
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		ReplaceText(c, old, new)
-	}
-}
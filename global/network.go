@@ -0,0 +1,356 @@
+package global
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ----------------------------------------------------------------------------------------------------------
+// Supernet 所有子网所在的网段(SUPERNET)
+// ----------------------------------------------------------------------------------------------------------
+func Supernet() (*net.IPNet, error) {
+	_, supernet, err := net.ParseCIDR(GlobalJWireGuardini.Supernet)
+	if err != nil {
+		return nil, fmt.Errorf("SUPERNET 格式错误: %v", err)
+	}
+	if supernet.IP.To4() == nil {
+		return nil, fmt.Errorf("SUPERNET 只支持 IPv4: %s", GlobalJWireGuardini.Supernet)
+	}
+	return supernet, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// LegacySubnetCIDR 旧版本按 IP_PREFIX.ser_num.0 和 SUBNET_MAKE 划分的子网，用于迁移已有数据
+// ----------------------------------------------------------------------------------------------------------
+func LegacySubnetCIDR(serNum int32) (string, error) {
+	bits, err := maskBits(GlobalJWireGuardini.SubnetMask)
+	if err != nil {
+		return "", err
+	}
+	_, network, err := net.ParseCIDR(fmt.Sprintf("%s.%d.0/%d", GlobalJWireGuardini.IPPrefix, serNum, bits))
+	if err != nil {
+		return "", err
+	}
+	return network.String(), nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ParseSubnetCIDR 解析子网网段，网段必须在 SUPERNET 中，且至少能容纳两个客户端
+// ----------------------------------------------------------------------------------------------------------
+func ParseSubnetCIDR(cidr string) (*net.IPNet, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if !ip.Equal(network.IP) {
+		return nil, fmt.Errorf("%s 不是网络地址, 应为 %s", cidr, network)
+	}
+	supernet, err := Supernet()
+	if err != nil {
+		return nil, err
+	}
+	if !NetworkContains(supernet, network) {
+		return nil, fmt.Errorf("%s 不在 SUPERNET %s 中", network, supernet)
+	}
+	if ones, _ := network.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("%s 太小", network)
+	}
+	return network, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// UserNetwork 用户客户端所在的网段，即 SUPERNET 中第一个 SUBNET_PREFIX_LEN 大小的网段
+// ----------------------------------------------------------------------------------------------------------
+func UserNetwork() (*net.IPNet, error) {
+	supernet, err := Supernet()
+	if err != nil {
+		return nil, err
+	}
+	mask := net.CIDRMask(GlobalJWireGuardini.SubnetPrefixLen, 32)
+	return &net.IPNet{IP: supernet.IP.Mask(mask), Mask: mask}, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// UserAddress 用户客户端使用的地址，即 SUPERNET 中的第一个地址
+// ----------------------------------------------------------------------------------------------------------
+func UserAddress() (string, error) {
+	supernet, err := Supernet()
+	if err != nil {
+		return "", err
+	}
+	return uint32ToIP(ipToUint32(supernet.IP) + 1).String(), nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ReservedAddresses 服务端和用户使用的地址，子网不能覆盖这些地址
+// ----------------------------------------------------------------------------------------------------------
+func ReservedAddresses() []net.IP {
+	var addresses []net.IP
+	if userAddress, err := UserAddress(); err == nil {
+		addresses = append(addresses, net.ParseIP(userAddress))
+	}
+	if ip := net.ParseIP(GlobalJWireGuardini.WireGuardAddress); ip != nil {
+		addresses = append(addresses, ip)
+	}
+	return addresses
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SubnetIptablesRule 允许子网访问 SUPERNET 的转发规则
+// ----------------------------------------------------------------------------------------------------------
+func SubnetIptablesRule(network *net.IPNet) (string, error) {
+	supernet, err := Supernet()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("-s %s -d %s -j ACCEPT", network, supernet), nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// AddSubnetRules 添加子网的 iptables 规则，启用 IPv6 时同时添加 ip6tables 规则
+// ----------------------------------------------------------------------------------------------------------
+func AddSubnetRules(network *net.IPNet) error {
+	rules, err := SubnetIptablesRule(network)
+	if err != nil {
+		return err
+	}
+	if !CheckIptablesRule(rules) {
+		if err := AddIptablesRule(rules); err != nil {
+			return fmt.Errorf("'%s': %v", rules, err)
+		}
+	}
+
+	rules6, err := SubnetIp6tablesRule(network)
+	if err != nil || rules6 == "" {
+		return err
+	}
+	if !CheckIp6tablesRule(rules6) {
+		if err := AddIp6tablesRule(rules6); err != nil {
+			return fmt.Errorf("'%s': %v", rules6, err)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// DeleteSubnetRules 删除子网的 iptables 和 ip6tables 规则
+// ----------------------------------------------------------------------------------------------------------
+func DeleteSubnetRules(network *net.IPNet) error {
+	rules, err := SubnetIptablesRule(network)
+	if err != nil {
+		return err
+	}
+	if CheckIptablesRule(rules) {
+		if err := DeleteIptablesRule(rules); err != nil {
+			return fmt.Errorf("'%s': %v", rules, err)
+		}
+	}
+
+	rules6, err := SubnetIp6tablesRule(network)
+	if err != nil || rules6 == "" {
+		return err
+	}
+	if CheckIp6tablesRule(rules6) {
+		if err := DeleteIp6tablesRule(rules6); err != nil {
+			return fmt.Errorf("'%s': %v", rules6, err)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ClientCcd 生成 OpenVPN 客户端 ccd 文件内容：分配子网中的地址并推送 SUPERNET 路由，
+// 启用 IPv6 时同时分配 IPv6 地址并推送 IPV6_PREFIX 路由
+// ----------------------------------------------------------------------------------------------------------
+func ClientCcd(cliAddr string, network *net.IPNet) (string, error) {
+	supernet, err := Supernet()
+	if err != nil {
+		return "", err
+	}
+	ccd := fmt.Sprintf("ifconfig-push %s %s\npush \"route %s %s %s\"\n",
+		cliAddr,
+		net.IP(network.Mask).String(),
+		supernet.IP.String(),
+		net.IP(supernet.Mask).String(),
+		cliAddr)
+
+	if !IPv6Enabled() {
+		return ccd, nil
+	}
+	cliAddr6, err := ClientAddress6(cliAddr)
+	if err != nil {
+		return "", err
+	}
+	network6, err := Network6(network)
+	if err != nil {
+		return "", err
+	}
+	prefix6, err := IPv6Prefix()
+	if err != nil {
+		return "", err
+	}
+	bits6, _ := network6.Mask.Size()
+	ccd += fmt.Sprintf("ifconfig-ipv6-push %s/%d\npush \"route-ipv6 %s\"\n", cliAddr6, bits6, prefix6)
+	return ccd, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// IPv6Enabled 是否配置了 IPV6_PREFIX
+// ----------------------------------------------------------------------------------------------------------
+func IPv6Enabled() bool {
+	return GlobalJWireGuardini.IPv6Prefix != ""
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// IPv6Prefix 客户端 IPv6 地址所在的 ULA 网段(IPV6_PREFIX)，SUPERNET 按偏移一一映射到该网段中
+// ----------------------------------------------------------------------------------------------------------
+func IPv6Prefix() (*net.IPNet, error) {
+	_, prefix, err := net.ParseCIDR(GlobalJWireGuardini.IPv6Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("IPV6_PREFIX 格式错误: %v", err)
+	}
+	if prefix.IP.To4() != nil {
+		return nil, fmt.Errorf("IPV6_PREFIX 不是 IPv6 网段: %s", GlobalJWireGuardini.IPv6Prefix)
+	}
+	return prefix, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ClientAddress6 由客户端 IPv4 地址计算 IPv6 地址，未启用 IPv6 时返回空字符串
+// ----------------------------------------------------------------------------------------------------------
+func ClientAddress6(cliAddr string) (string, error) {
+	if !IPv6Enabled() {
+		return "", nil
+	}
+	ip := net.ParseIP(cliAddr).To4()
+	if ip == nil {
+		return "", fmt.Errorf("invalid IPv4 address: %s", cliAddr)
+	}
+	ip6, err := mapIPv6(ip)
+	if err != nil {
+		return "", err
+	}
+	return ip6.String(), nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Network6 IPv4 子网对应的 IPv6 网段，前缀长度保持相同的主机位数
+// ----------------------------------------------------------------------------------------------------------
+func Network6(network *net.IPNet) (*net.IPNet, error) {
+	ip6, err := mapIPv6(network.IP.To4())
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := network.Mask.Size()
+	return &net.IPNet{IP: ip6, Mask: net.CIDRMask(128-(bits-ones), 128)}, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SubnetIp6tablesRule 允许子网访问 IPV6_PREFIX 的转发规则，未启用 IPv6 时返回空字符串
+// ----------------------------------------------------------------------------------------------------------
+func SubnetIp6tablesRule(network *net.IPNet) (string, error) {
+	if !IPv6Enabled() {
+		return "", nil
+	}
+	network6, err := Network6(network)
+	if err != nil {
+		return "", err
+	}
+	prefix6, err := IPv6Prefix()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("-s %s -d %s -j ACCEPT", network6, prefix6), nil
+}
+
+// mapIPv6 将 SUPERNET 中的地址按偏移映射到 IPV6_PREFIX 中
+func mapIPv6(ip net.IP) (net.IP, error) {
+	supernet, err := Supernet()
+	if err != nil {
+		return nil, err
+	}
+	prefix6, err := IPv6Prefix()
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil || !supernet.Contains(ip) {
+		return nil, fmt.Errorf("%s 不在 SUPERNET %s 中", ip, supernet)
+	}
+	superOnes, _ := supernet.Mask.Size()
+	prefixOnes, _ := prefix6.Mask.Size()
+	if prefixOnes > 128-(32-superOnes) {
+		return nil, fmt.Errorf("IPV6_PREFIX %s 太小，无法容纳 SUPERNET %s", prefix6, supernet)
+	}
+
+	offset := ipToUint32(ip) - ipToUint32(supernet.IP)
+	ip6 := make(net.IP, net.IPv6len)
+	copy(ip6, prefix6.IP.To16())
+	binary.BigEndian.PutUint32(ip6[12:], binary.BigEndian.Uint32(ip6[12:])|offset)
+	return ip6, nil
+}
+
+// NetworkContains 判断 inner 是否完全在 outer 中
+func NetworkContains(outer *net.IPNet, inner *net.IPNet) bool {
+	outerOnes, _ := outer.Mask.Size()
+	innerOnes, _ := inner.Mask.Size()
+	return outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// NetworksOverlap 判断两个网段是否重叠
+func NetworksOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// NextFreeSubnet 在 supernet 中找到第一个前缀长度为 prefixLen、与 used 不重叠且不包含 reserved 地址的网段
+// ----------------------------------------------------------------------------------------------------------
+func NextFreeSubnet(supernet *net.IPNet, prefixLen int, used []*net.IPNet, reserved []net.IP) (*net.IPNet, error) {
+	superOnes, _ := supernet.Mask.Size()
+	if prefixLen < superOnes || prefixLen > 30 {
+		return nil, fmt.Errorf("子网前缀长度 %d 无效, SUPERNET 为 %s", prefixLen, supernet)
+	}
+
+	mask := net.CIDRMask(prefixLen, 32)
+	base := ipToUint32(supernet.IP)
+	size := uint64(1) << uint(32-prefixLen)
+	count := uint64(1) << uint(prefixLen-superOnes)
+
+next:
+	for i := uint64(0); i < count; i++ {
+		candidate := &net.IPNet{IP: uint32ToIP(base + uint32(i*size)), Mask: mask}
+		for _, ip := range reserved {
+			if candidate.Contains(ip) {
+				continue next
+			}
+		}
+		for _, network := range used {
+			if NetworksOverlap(candidate, network) {
+				continue next
+			}
+		}
+		return candidate, nil
+	}
+	return nil, errors.New("SUPERNET 中没有可用的子网网段")
+}
+
+// SubnetNumber 子网在 SUPERNET 中的序号，/24 子网即为第三段地址
+func SubnetNumber(network *net.IPNet) int32 {
+	supernet, err := Supernet()
+	if err != nil {
+		return 0
+	}
+	ones, _ := network.Mask.Size()
+	return int32((ipToUint32(network.IP) - ipToUint32(supernet.IP)) >> uint(32-ones))
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
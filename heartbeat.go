@@ -0,0 +1,681 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/heartbeat"
+	"net"
+	"sync"
+	"time"
+)
+
+// 缓存的设备信息超过该时间后从数据库重新加载，使管理端的修改生效
+const heartbeatDeviceTTL = 5 * time.Minute
+
+var (
+	errCliNotFound  = errors.New("客户端不存在")
+	errRateLimited  = errors.New("心跳超过限速")
+	errHeartbeatV1  = errors.New("HTTP 心跳必须签名")
+	errNotAccepting = errors.New("心跳状态不是在线")
+)
+
+// 已使用的 v2 心跳随机数，防止重放
+var heartbeatNonces = heartbeat.NewNonceCache()
+
+// 在线设备的最近心跳，心跳时间戳定期批量写入数据库
+var heartbeatDevices = newDeviceCache()
+
+// 待写入的设备运行状态，与心跳时间戳一起批量写入
+var heartbeatTelemetry = newTelemetryBuffer()
+
+type heartbeatJob struct {
+	packet heartbeat.Packet
+	addr   *net.UDPAddr
+}
+
+// heartbeatPipeline 接收协程解析和限速后放入队列，由工作协程处理
+// 校验签名前 cli_id 不可信，只按来源地址限速；签名通过后再按设备限速
+type heartbeatPipeline struct {
+	conn          *net.UDPConn
+	jobs          chan heartbeatJob
+	sourceLimiter *heartbeat.RateLimiter
+	deviceLimiter *heartbeat.RateLimiter
+}
+
+func newHeartbeatPipeline(conn *net.UDPConn) *heartbeatPipeline {
+	return &heartbeatPipeline{
+		conn:          conn,
+		jobs:          make(chan heartbeatJob, global.GlobalJWireGuardini.HeartbeatQueueSize),
+		sourceLimiter: heartbeat.NewRateLimiter(global.GlobalJWireGuardini.HeartbeatSourceRate, global.GlobalJWireGuardini.HeartbeatSourceBurst),
+		deviceLimiter: heartbeat.NewRateLimiter(global.GlobalJWireGuardini.HeartbeatRate, global.GlobalJWireGuardini.HeartbeatBurst),
+	}
+}
+
+// Start 启动工作协程和批量写入协程
+func (p *heartbeatPipeline) Start() {
+	// 表只在启动时检查一次，不在每个心跳中检查
+	database.MonitorDatabase(global.GlobalDB)
+	clientConfig := database.CliConfig{}
+	clientConfig.CreateCliConfig(global.GlobalDB)
+	cliSecret := database.CliSecret{}
+	cliSecret.CreateCliSecret(global.GlobalDB)
+	cliTelemetry := database.CliTelemetry{}
+	cliTelemetry.CreateCliTelemetry(global.GlobalDB)
+	cliCommand := database.CliCommand{}
+	cliCommand.CreateCliCommand(global.GlobalDB)
+	cliEvent := database.CliEvent{}
+	cliEvent.CreateCliEvent(global.GlobalDB)
+
+	// 重启前未完成的命令
+	cliIds, err := database.GetOpenCommandCliIDs(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[heartbeat] 无法读取未完成的命令, err:%v", err)
+	}
+	for _, cliId := range cliIds {
+		heartbeat.PendingCommands.Mark(cliId)
+	}
+
+	for i := 0; i < global.GlobalJWireGuardini.HeartbeatWorkers; i++ {
+		go p.worker()
+	}
+	go p.flushLoop()
+	global.Log.Infof("[heartbeat] 已启动 %d 个工作协程, 队列长度 %d", global.GlobalJWireGuardini.HeartbeatWorkers, cap(p.jobs))
+}
+
+// Submit 解析心跳并放入队列，超过限速或队列已满时丢弃
+func (p *heartbeatPipeline) Submit(data []byte, addr *net.UDPAddr) {
+	heartbeat.Stats.Received.Add(1)
+
+	var packet heartbeat.Packet
+	if err := json.Unmarshal(data, &packet); err != nil || packet.CliID == "" {
+		heartbeat.Stats.Invalid.Add(1)
+		global.Log.Errorf("[heartbeat] 无法将接收UDP数据转为JSON数据, err:%v", err)
+		return
+	}
+
+	// 按来源地址限速，cli_id 此时未经校验，不能用来限速
+	if !p.sourceLimiter.Allow(addr.IP.String()) {
+		heartbeat.Stats.RateLimited.Add(1)
+		return
+	}
+
+	select {
+	case p.jobs <- heartbeatJob{packet: packet, addr: addr}:
+	default:
+		heartbeat.Stats.QueueFull.Add(1)
+		global.Log.Warnf("[heartbeat] 队列已满, 丢弃 cli_id %s 的心跳", packet.CliID)
+	}
+}
+
+func (p *heartbeatPipeline) worker() {
+	for job := range p.jobs {
+		p.process(job)
+	}
+}
+
+func (p *heartbeatPipeline) process(job heartbeatJob) {
+	sendData, err := p.handleHeartbeat(&job.packet, job.addr.String())
+	if err != nil {
+		return
+	}
+
+	// 回复客户端
+	jsonData, err := json.Marshal(sendData)
+	if err != nil {
+		global.Log.Errorf("[heartbeat] 无法将 cli_id %s 的设备转换回复信息, err:%v", job.packet.CliID, err)
+		return
+	}
+	if _, err = p.conn.WriteToUDP(jsonData, job.addr); err != nil {
+		global.Log.Errorf("[heartbeat] 无法向 cli_id %s 的设备回复心跳信息, err:%v", job.packet.CliID, err)
+	}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Handle 处理 HTTP 心跳，与 UDP 心跳共用限速、校验和状态更新，只接受签名的心跳
+// ----------------------------------------------------------------------------------------------------------
+func (p *heartbeatPipeline) Handle(packet *heartbeat.Packet, remote string) (*heartbeat.Reply, error) {
+	heartbeat.Stats.Received.Add(1)
+	if !packet.IsV2() {
+		heartbeat.Stats.Rejected.Add(1)
+		return nil, errHeartbeatV1
+	}
+
+	if !p.sourceLimiter.Allow(remoteHost(remote)) {
+		heartbeat.Stats.RateLimited.Add(1)
+		return nil, errRateLimited
+	}
+	return p.handleHeartbeat(packet, remote)
+}
+
+// remoteHost 去掉地址中的端口
+func remoteHost(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return remote
+	}
+	return host
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// handleHeartbeat 校验心跳并更新在线状态，UDP 和 HTTP 心跳共用
+// 返回错误时不回复设备
+// ----------------------------------------------------------------------------------------------------------
+func (p *heartbeatPipeline) handleHeartbeat(packet *heartbeat.Packet, remote string) (*heartbeat.Reply, error) {
+	global.Log.Debugf("[heartbeat] 收到来自 %s cli_id %s 的心跳数据", remote, packet.CliID)
+	if packet.CliStatus != "true" {
+		heartbeat.Stats.Processed.Add(1)
+		return nil, errNotAccepting
+	}
+
+	// 校验心跳签名，未通过时不更新在线状态
+	trusted, secret, err := authenticateHeartbeat(packet)
+	if err != nil {
+		heartbeat.Stats.Rejected.Add(1)
+		global.Log.Warnf("[heartbeat] 拒绝来自 %s cli_id %s 的心跳, err:%v", remote, packet.CliID, err)
+		return nil, err
+	}
+
+	// 签名通过后按设备限速，同一设备从多个地址发送的心跳共用额度
+	// 伪造的心跳无法通过签名，不会消耗设备的额度
+	if trusted && !p.deviceLimiter.Allow(packet.CliID) {
+		heartbeat.Stats.RateLimited.Add(1)
+		return nil, errRateLimited
+	}
+
+	now := time.Now().Unix()
+	sendData := &heartbeat.Reply{}
+	if heartbeatDevices.touch(packet.CliID, now) {
+		err = updateHeartbeatMapping(packet, trusted)
+	} else {
+		// 缓存中没有的设备从数据库加载，离线设备在这里转为在线
+		err = loadHeartbeatDevice(packet, remote, trusted, secret, now)
+	}
+	if errors.Is(err, errCliNotFound) {
+		heartbeat.Stats.Rejected.Add(1)
+		return nil, err
+	}
+	if err != nil {
+		sendData.Status = false
+		sendData.Message = "Client status update failed!"
+		global.Log.Errorf("[heartbeat] cli_id %s 心跳更新失败, err:%v", packet.CliID, err)
+	} else {
+		sendData.Status = true
+		sendData.Message = "Client status updated successfully!"
+		global.Log.Debugf("[heartbeat] cli_id %s 心跳更新成功", packet.CliID)
+
+		// 只保存签名心跳中的运行状态，命令也只下发给签名的心跳
+		if trusted {
+			recordTelemetry(packet, now)
+			ackCommands(packet, now)
+			sendData.Commands = deliverCommands(packet.CliID, now)
+		}
+	}
+	heartbeat.Stats.Processed.Add(1)
+	return sendData, nil
+}
+
+// loadHeartbeatDevice 从数据库读取设备，更新在线状态和时间戳后放入缓存
+func loadHeartbeatDevice(packet *heartbeat.Packet, remote string, trusted bool, secret *cachedSecret, now int64) error {
+	clientConfig := database.CliConfig{}
+	clientConfig.CliID.String = packet.CliID
+	if err := clientConfig.GetCliConfigByCliID(global.GlobalDB); err != nil {
+		return errCliNotFound
+	}
+
+	// 只有签名的心跳可以修改网络映射
+	if clientConfig.EditStatus.Int32&0x02 == 0 && trusted {
+		clientConfig.CliMapping.String = packet.CliMapping
+	}
+	clientConfig.Timestamp.Int64 = now
+	changed := clientConfig.CliStatus.String != "true"
+	if changed {
+		// 更新在线状态
+		clientConfig.CliStatus.String = "true"
+	}
+
+	if err := clientConfig.UpdateCliConfig(global.GlobalDB); err != nil {
+		return err
+	}
+	if changed {
+		statusChanged(clientConfig, database.EVENTCAUSEHEARTBEAT, "来自 "+remote, now)
+	}
+	heartbeatDevices.put(&heartbeatDevice{
+		editStatus: clientConfig.EditStatus.Int32,
+		mapping:    clientConfig.CliMapping.String,
+		secret:     secret,
+		lastSeen:   now,
+		flushed:    now,
+		loadedAt:   time.Now(),
+	}, packet.CliID)
+	return nil
+}
+
+// updateHeartbeatMapping 缓存中的设备只在网络映射变化时立即写入数据库
+func updateHeartbeatMapping(packet *heartbeat.Packet, trusted bool) error {
+	if !trusted || packet.CliMapping == "" || !heartbeatDevices.setMapping(packet.CliID, packet.CliMapping) {
+		return nil
+	}
+	clientConfig := database.CliConfig{}
+	clientConfig.CliID.String = packet.CliID
+	clientConfig.CliMapping.String = packet.CliMapping
+	return clientConfig.UpdateCliConfig(global.GlobalDB)
+}
+
+// recordTelemetry 按 TELEMETRY_INTERVAL 采样设备运行状态
+func recordTelemetry(packet *heartbeat.Packet, now int64) {
+	telemetry, err := packet.ParseTelemetry()
+	if err != nil {
+		global.Log.Warnf("[heartbeat] cli_id %s 的运行状态无法解析, err:%v", packet.CliID, err)
+		return
+	}
+	if telemetry != nil {
+		heartbeatTelemetry.add(packet.CliID, telemetry, now)
+	}
+}
+
+// ackCommands 记录设备回复的命令执行结果
+func ackCommands(packet *heartbeat.Packet, now int64) {
+	acks, err := packet.ParseAcks()
+	if err != nil {
+		global.Log.Warnf("[heartbeat] cli_id %s 的命令回执无法解析, err:%v", packet.CliID, err)
+		return
+	}
+	for _, ack := range acks {
+		ok, err := database.AckCliCommand(global.GlobalDB, packet.CliID, ack.ID, ack.Status, ack.Result, now)
+		if err != nil {
+			global.Log.Errorf("[heartbeat] 无法记录 cli_id %s 命令 %d 的回执, err:%v", packet.CliID, ack.ID, err)
+			continue
+		}
+		if ok {
+			global.Log.Infof("[heartbeat] cli_id %s 命令 %d 执行结果 %s", packet.CliID, ack.ID, ack.Status)
+		}
+	}
+}
+
+// deliverCommands 获取设备未完成的命令，收到回执前每次心跳都重复下发
+func deliverCommands(cliId string, now int64) []heartbeat.Command {
+	if !heartbeat.PendingCommands.Has(cliId) {
+		return nil
+	}
+	commands, err := database.GetOpenCliCommands(global.GlobalDB, cliId, now, heartbeat.COMMANDMAXPERREPLY)
+	if err != nil {
+		global.Log.Errorf("[heartbeat] 无法读取 cli_id %s 的命令, err:%v", cliId, err)
+		return nil
+	}
+	if len(commands) == 0 {
+		heartbeat.PendingCommands.Clear(cliId)
+		return nil
+	}
+	if err := database.MarkCliCommandsDelivered(global.GlobalDB, commands, now); err != nil {
+		global.Log.Errorf("[heartbeat] 无法记录 cli_id %s 的命令下发, err:%v", cliId, err)
+	}
+
+	delivered := make([]heartbeat.Command, 0, len(commands))
+	for _, command := range commands {
+		exported := command.ToExported()
+		delivered = append(delivered, heartbeat.Command{
+			ID:       exported.ID,
+			Type:     exported.Type,
+			Args:     exported.Args,
+			ExpireAt: exported.ExpireAt,
+		})
+	}
+	return delivered
+}
+
+// expireCommands 将过期未完成的命令标记为 expired
+func expireCommands() {
+	cliIds, err := database.ExpireCliCommands(global.GlobalDB, time.Now().Unix())
+	if err != nil {
+		global.Log.Errorf("[heartbeat] 无法处理过期的命令, err:%v", err)
+		return
+	}
+	if len(cliIds) > 0 {
+		global.Log.Infof("[heartbeat] 设备 %v 有命令已过期", cliIds)
+	}
+}
+
+// flushLoop 定期将缓存中的心跳时间戳和运行状态批量写入数据库
+// 每分钟处理过期的命令，每小时清理过期的运行状态和采样时间
+func (p *heartbeatPipeline) flushLoop() {
+	interval := time.Duration(global.GlobalJWireGuardini.HeartbeatFlushInterval) * time.Second
+	var lastPurge, lastExpire time.Time
+	for {
+		time.Sleep(interval)
+		flushHeartbeats()
+		flushTelemetry()
+
+		if time.Since(lastExpire) >= time.Minute {
+			expireCommands()
+			lastExpire = time.Now()
+		}
+
+		if time.Since(lastPurge) >= time.Hour {
+			heartbeatTelemetry.prune(time.Now().Unix())
+			purgeTelemetry()
+			lastPurge = time.Now()
+		}
+	}
+}
+
+func flushTelemetry() {
+	telemetries := heartbeatTelemetry.take()
+	if len(telemetries) == 0 {
+		return
+	}
+
+	database.MonitorDatabase(global.GlobalDB)
+	if err := database.InsertCliTelemetries(global.GlobalDB, telemetries); err != nil {
+		heartbeat.Stats.FlushErrors.Add(1)
+		heartbeatTelemetry.putBack(telemetries)
+		global.Log.Errorf("[heartbeat] 批量写入 %d 条运行状态失败, err:%v", len(telemetries), err)
+		return
+	}
+	heartbeat.Stats.TelemetryRows.Add(int64(len(telemetries)))
+}
+
+func purgeTelemetry() {
+	days := global.GlobalJWireGuardini.HeartbeatTelemetryRetention
+	if days <= 0 {
+		return
+	}
+	before := time.Now().AddDate(0, 0, -days).Unix()
+	count, err := database.PurgeCliTelemetry(global.GlobalDB, before)
+	if err != nil {
+		global.Log.Errorf("[heartbeat] 清理过期运行状态失败, err:%v", err)
+		return
+	}
+	if count > 0 {
+		global.Log.Infof("[heartbeat] 已清理 %d 条 %d 天前的运行状态", count, days)
+	}
+}
+
+func flushHeartbeats() {
+	timestamps := heartbeatDevices.dirty()
+	if len(timestamps) == 0 {
+		return
+	}
+
+	// 查询连接状态
+	database.MonitorDatabase(global.GlobalDB)
+	if err := database.UpdateCliTimestamps(global.GlobalDB, timestamps); err != nil {
+		// 写入失败的时间戳保留在缓存中，下次重试
+		heartbeat.Stats.FlushErrors.Add(1)
+		global.Log.Errorf("[heartbeat] 批量更新 %d 个设备的心跳失败, err:%v", len(timestamps), err)
+		return
+	}
+	heartbeatDevices.markFlushed(timestamps)
+	heartbeat.Stats.Flushes.Add(1)
+	heartbeat.Stats.FlushedRows.Add(int64(len(timestamps)))
+	global.Log.Debugf("[heartbeat] 已批量更新 %d 个设备的心跳", len(timestamps))
+}
+
+// authenticateHeartbeat 校验心跳，返回是否为签名校验通过的 v2 心跳以及设备的心跳密钥
+// v1 心跳只在 V1_UNTIL 之前、且设备从未发送过 v2 心跳时接受
+func authenticateHeartbeat(packet *heartbeat.Packet) (bool, *cachedSecret, error) {
+	secret, err := heartbeatSecret(packet.CliID)
+	if err != nil {
+		return false, nil, err
+	}
+
+	now := time.Now()
+	if !packet.IsV2() {
+		v1Until := global.GlobalJWireGuardini.HeartbeatV1Until
+		if v1Until.IsZero() || now.After(v1Until) {
+			return false, secret, errors.New("不再接受 v1 心跳")
+		}
+		if secret.exists && secret.verified {
+			return false, secret, errors.New("设备已使用 v2 心跳，拒绝 v1 心跳")
+		}
+		return false, secret, nil
+	}
+
+	if !secret.exists {
+		return false, secret, errors.New("设备没有心跳密钥")
+	}
+	skew := time.Duration(global.GlobalJWireGuardini.HeartbeatMaxSkew) * time.Second
+	if err := packet.Verify(secret.secret, now, skew, heartbeatNonces); err != nil {
+		return false, secret, err
+	}
+
+	if !secret.verified {
+		cliSecret := database.CliSecret{}
+		cliSecret.CliID.String = packet.CliID
+		if err := cliSecret.MarkVerified(global.GlobalDB); err != nil {
+			global.Log.Errorf("[authenticateHeartbeat] cli_id %s 无法记录 v2 心跳, err:%v", packet.CliID, err)
+		} else {
+			secret = &cachedSecret{secret: secret.secret, exists: true, verified: true}
+			heartbeatDevices.setSecret(packet.CliID, secret)
+		}
+	}
+	return true, secret, nil
+}
+
+// heartbeatSecret 返回设备的心跳密钥，缓存中没有或管理端修改过时从数据库加载
+func heartbeatSecret(cliId string) (*cachedSecret, error) {
+	if heartbeat.SecretChanged.Has(cliId) {
+		heartbeat.SecretChanged.Clear(cliId)
+		heartbeatDevices.setSecret(cliId, nil)
+	}
+	if secret := heartbeatDevices.secret(cliId); secret != nil {
+		return secret, nil
+	}
+
+	cliSecret := database.CliSecret{}
+	cliSecret.CliID.String = cliId
+	err := cliSecret.GetCliSecretByCliID(global.GlobalDB)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("无法读取心跳密钥: %v", err)
+	}
+	secret := &cachedSecret{
+		secret:   cliSecret.Secret.String,
+		exists:   err == nil,
+		verified: err == nil && cliSecret.VerifiedAt.Int64 != 0,
+	}
+	heartbeatDevices.setSecret(cliId, secret)
+	return secret, nil
+}
+
+// cachedSecret 缓存的心跳密钥，与设备信息一起过期，修改时整体替换
+type cachedSecret struct {
+	secret   string
+	exists   bool // 设备是否有心跳密钥
+	verified bool // 是否已收到过签名正确的 v2 心跳
+}
+
+// heartbeatDevice 缓存的设备信息
+type heartbeatDevice struct {
+	editStatus int32
+	mapping    string
+	secret     *cachedSecret // 为 nil 时从数据库加载
+	lastSeen   int64         // 最近一次心跳
+	flushed    int64         // 已写入数据库的心跳时间戳
+	loadedAt   time.Time     // 从数据库加载的时间
+}
+
+type deviceCache struct {
+	mu      sync.Mutex
+	devices map[string]*heartbeatDevice
+}
+
+func newDeviceCache() *deviceCache {
+	return &deviceCache{devices: make(map[string]*heartbeatDevice)}
+}
+
+// touch 更新缓存中设备的心跳时间，设备不在缓存中或需要重新加载时返回 false
+func (c *deviceCache) touch(cliId string, now int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	device, ok := c.devices[cliId]
+	if !ok || time.Since(device.loadedAt) > heartbeatDeviceTTL {
+		return false
+	}
+	device.lastSeen = now
+	return true
+}
+
+// setMapping 允许修改且网络映射变化时更新缓存并返回 true
+func (c *deviceCache) setMapping(cliId string, mapping string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	device, ok := c.devices[cliId]
+	if !ok || device.editStatus&0x02 != 0 || device.mapping == mapping {
+		return false
+	}
+	device.mapping = mapping
+	return true
+}
+
+// secret 返回缓存中未过期的心跳密钥，没有时返回 nil
+func (c *deviceCache) secret(cliId string) *cachedSecret {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	device, ok := c.devices[cliId]
+	if !ok || time.Since(device.loadedAt) > heartbeatDeviceTTL {
+		return nil
+	}
+	return device.secret
+}
+
+// setSecret 更新缓存中设备的心跳密钥，secret 为 nil 时下次心跳从数据库重新加载
+func (c *deviceCache) setSecret(cliId string, secret *cachedSecret) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if device, ok := c.devices[cliId]; ok {
+		device.secret = secret
+	}
+}
+
+func (c *deviceCache) put(device *heartbeatDevice, cliId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices[cliId] = device
+	heartbeat.Stats.CachedDevice.Store(int64(len(c.devices)))
+}
+
+func (c *deviceCache) remove(cliId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.devices, cliId)
+	heartbeat.Stats.CachedDevice.Store(int64(len(c.devices)))
+}
+
+// lastSeen 缓存中设备最近一次心跳的时间
+func (c *deviceCache) lastSeen(cliId string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	device, ok := c.devices[cliId]
+	if !ok {
+		return 0, false
+	}
+	return device.lastSeen, true
+}
+
+// dirty 需要写入数据库的心跳时间戳
+func (c *deviceCache) dirty() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timestamps := make(map[string]int64)
+	for cliId, device := range c.devices {
+		if device.lastSeen > device.flushed {
+			timestamps[cliId] = device.lastSeen
+		}
+	}
+	return timestamps
+}
+
+// markFlushed 记录已写入的时间戳，写入期间收到的新心跳保留到下次写入
+func (c *deviceCache) markFlushed(timestamps map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cliId, ts := range timestamps {
+		if device, ok := c.devices[cliId]; ok && device.flushed < ts {
+			device.flushed = ts
+		}
+	}
+}
+
+// 写入失败时保留的运行状态上限，超过后丢弃最早的记录
+const telemetryPendingMax = 10000
+
+type telemetryBuffer struct {
+	mu         sync.Mutex
+	lastSample map[string]int64 // 每个设备最近一次采样的时间
+	pending    []database.CliTelemetry
+}
+
+func newTelemetryBuffer() *telemetryBuffer {
+	return &telemetryBuffer{lastSample: make(map[string]int64)}
+}
+
+// add 距上次采样超过 TELEMETRY_INTERVAL 时记录运行状态
+func (b *telemetryBuffer) add(cliId string, telemetry *heartbeat.Telemetry, now int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now-b.lastSample[cliId] < int64(global.GlobalJWireGuardini.HeartbeatTelemetryInterval) {
+		return
+	}
+	b.lastSample[cliId] = now
+
+	row := database.CliTelemetry{}
+	row.CliID = sql.NullString{String: cliId, Valid: true}
+	row.Ts = sql.NullInt64{Int64: now, Valid: true}
+	// 未上报的字段保持 Valid=false，写入 NULL 而不是 0
+	if telemetry.Firmware != nil {
+		row.Firmware = sql.NullString{String: *telemetry.Firmware, Valid: true}
+	}
+	if telemetry.Uptime != nil {
+		row.Uptime = sql.NullInt64{Int64: *telemetry.Uptime, Valid: true}
+	}
+	if telemetry.CPU != nil {
+		row.CPU = sql.NullFloat64{Float64: *telemetry.CPU, Valid: true}
+	}
+	if telemetry.Memory != nil {
+		row.Memory = sql.NullFloat64{Float64: *telemetry.Memory, Valid: true}
+	}
+	if telemetry.WanIP != nil {
+		row.WanIP = sql.NullString{String: *telemetry.WanIP, Valid: true}
+	}
+	if telemetry.Signal != nil {
+		row.Signal = sql.NullInt64{Int64: int64(*telemetry.Signal), Valid: true}
+	}
+	if len(telemetry.Custom) > 0 {
+		custom, _ := json.Marshal(telemetry.Custom)
+		row.Custom = sql.NullString{String: string(custom), Valid: true}
+	}
+	b.pending = append(b.pending, row)
+}
+
+// prune 删除超过 TELEMETRY_INTERVAL 的采样时间，与没有记录等价，已删除或离线的设备不再占用内存
+func (b *telemetryBuffer) prune(now int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	interval := int64(global.GlobalJWireGuardini.HeartbeatTelemetryInterval)
+	for cliId, last := range b.lastSample {
+		if now-last >= interval {
+			delete(b.lastSample, cliId)
+		}
+	}
+}
+
+func (b *telemetryBuffer) take() []database.CliTelemetry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending := b.pending
+	b.pending = nil
+	return pending
+}
+
+// putBack 写入失败时放回，下次重试
+func (b *telemetryBuffer) putBack(telemetries []database.CliTelemetry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(telemetries, b.pending...)
+	if len(b.pending) > telemetryPendingMax {
+		b.pending = b.pending[len(b.pending)-telemetryPendingMax:]
+	}
+}
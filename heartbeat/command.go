@@ -0,0 +1,112 @@
+// heartbeat/command.go
+package heartbeat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	CMDREBOOT      = "reboot"       // 重启设备
+	CMDFETCHCONFIG = "fetch_config" // 重新获取配置
+	CMDSETMAPPING  = "set_mapping"  // 修改网络映射，参数 mapping
+	CMDDIAGNOSE    = "diagnose"     // 运行诊断，参数 target 可选
+
+	ACKDONE   = "done"   // 设备执行成功
+	ACKFAILED = "failed" // 设备执行失败
+
+	COMMANDMAXPERREPLY = 10   // 每个心跳回复最多下发的命令数
+	ACKMAXRESULT       = 1024 // 回执结果最大长度
+)
+
+// Command 在心跳回复中下发给设备的命令，设备按 ID 去重
+type Command struct {
+	ID       int64             `json:"id"`
+	Type     string            `json:"type"`
+	Args     map[string]string `json:"args,omitempty"`
+	ExpireAt int64             `json:"expire_at"`
+}
+
+// CommandAck 设备在之后的心跳中回复的命令执行结果
+type CommandAck struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Result string `json:"result"`
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ValidateCommand 检查命令类型和参数
+// ----------------------------------------------------------------------------------------------------------
+func ValidateCommand(cmdType string, args map[string]string) error {
+	switch cmdType {
+	case CMDREBOOT, CMDFETCHCONFIG, CMDDIAGNOSE:
+		return nil
+	case CMDSETMAPPING:
+		if args["mapping"] == "" {
+			return fmt.Errorf("命令 %s 缺少参数 mapping", cmdType)
+		}
+		return nil
+	default:
+		return fmt.Errorf("不支持的命令类型: %.32q", cmdType)
+	}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ParseAcks 解析心跳中的命令回执，忽略状态不正确的回执
+// ----------------------------------------------------------------------------------------------------------
+func (p *Packet) ParseAcks() ([]CommandAck, error) {
+	if len(p.Acks) == 0 || string(p.Acks) == "null" {
+		return nil, nil
+	}
+
+	var acks []CommandAck
+	if err := json.Unmarshal(p.Acks, &acks); err != nil {
+		return nil, fmt.Errorf("acks 格式错误: %w", err)
+	}
+	valid := acks[:0]
+	for _, ack := range acks {
+		if ack.ID <= 0 || (ack.Status != ACKDONE && ack.Status != ACKFAILED) {
+			continue
+		}
+		if len(ack.Result) > ACKMAXRESULT {
+			ack.Result = ack.Result[:ACKMAXRESULT]
+		}
+		valid = append(valid, ack)
+	}
+	return valid, nil
+}
+
+// PendingSet 有未完成命令的设备，心跳处理时只为这些设备查询数据库
+type PendingSet struct {
+	mu      sync.RWMutex
+	devices map[string]struct{}
+}
+
+// PendingCommands 管理端添加命令后标记设备
+var PendingCommands = &PendingSet{devices: make(map[string]struct{})}
+
+// SecretChanged 管理端重新签发或删除心跳密钥后标记设备，心跳处理时丢弃缓存的密钥
+var SecretChanged = &PendingSet{devices: make(map[string]struct{})}
+
+// Mark 标记设备有未完成的命令
+func (s *PendingSet) Mark(cliId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[cliId] = struct{}{}
+}
+
+// Clear 设备的命令全部完成或过期
+func (s *PendingSet) Clear(cliId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, cliId)
+}
+
+// Has 设备是否有未完成的命令
+func (s *PendingSet) Has(cliId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.devices[cliId]
+	return ok
+}
@@ -0,0 +1,200 @@
+// heartbeat/heartbeat.go
+package heartbeat
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VERSION1 = 1 // 旧版心跳，不带签名
+	VERSION2 = 2 // 带时间戳、随机数和 HMAC-SHA256 签名
+
+	SECRETLEN = 32 // 设备密钥长度(字节)
+	NONCEMIN  = 8  // 随机数最小长度(字节)
+)
+
+var (
+	ErrBadSignature = errors.New("heartbeat signature mismatch")
+	ErrStale        = errors.New("heartbeat timestamp out of window")
+	ErrReplay       = errors.New("heartbeat nonce already used")
+	ErrBadNonce     = errors.New("heartbeat nonce invalid")
+)
+
+// Packet 设备上报的心跳，version 为 0 或 1 时按旧版处理
+type Packet struct {
+	Version    int    `json:"version"`
+	CliID      string `json:"cli_id"`
+	CliMapping string `json:"cli_mapping"`
+	CliMac     string `json:"cli_mac"`
+	CliStatus  string `json:"cli_status"`
+	Timestamp  int64  `json:"ts"`
+	Nonce      string `json:"nonce"`
+	Sign       string `json:"sign"`
+
+	Telemetry json.RawMessage `json:"telemetry,omitempty"` // 设备运行状态，按收到的原文参与签名
+	Acks      json.RawMessage `json:"acks,omitempty"`      // 命令回执，按收到的原文参与签名
+}
+
+// Reply 心跳回复，UDP 和 HTTP 相同
+type Reply struct {
+	Status   bool      `json:"status"`
+	Message  string    `json:"message"`
+	Commands []Command `json:"commands,omitempty"` // 待执行的命令，只下发给签名的心跳
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// GenerateSecret 生成设备密钥，文本形式为 hex
+// ----------------------------------------------------------------------------------------------------------
+func GenerateSecret() (string, error) {
+	secret := make([]byte, SECRETLEN)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", fmt.Errorf("无法生成设备密钥: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// GenerateNonce 生成随机数，供设备端和测试工具使用
+// ----------------------------------------------------------------------------------------------------------
+func GenerateNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// IsV2 是否为带签名的心跳
+func (p *Packet) IsV2() bool {
+	return p.Version >= VERSION2
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SigningString 参与签名的字段，按固定顺序以换行连接
+// 带 telemetry 或 acks 时依次追加二者的原文，没有 telemetry 时该行为空
+// ----------------------------------------------------------------------------------------------------------
+func (p *Packet) SigningString() string {
+	fields := []string{
+		strconv.Itoa(p.Version),
+		p.CliID,
+		p.CliMapping,
+		p.CliMac,
+		p.CliStatus,
+		strconv.FormatInt(p.Timestamp, 10),
+		p.Nonce,
+	}
+	if len(p.Telemetry) > 0 || len(p.Acks) > 0 {
+		fields = append(fields, string(p.Telemetry))
+	}
+	if len(p.Acks) > 0 {
+		fields = append(fields, string(p.Acks))
+	}
+	return strings.Join(fields, "\n")
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ComputeSign 用设备密钥计算 HMAC-SHA256 签名
+// ----------------------------------------------------------------------------------------------------------
+func (p *Packet) ComputeSign(secret string) (string, error) {
+	key, err := hex.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("设备密钥格式错误: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(p.SigningString()))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SignWith 填写签名
+// ----------------------------------------------------------------------------------------------------------
+func (p *Packet) SignWith(secret string) error {
+	sign, err := p.ComputeSign(secret)
+	if err != nil {
+		return err
+	}
+	p.Sign = sign
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Verify 校验签名、时间戳和随机数，skew 为允许的时钟偏差
+// 随机数在签名校验通过后才记录，避免伪造的心跳占用随机数
+// ----------------------------------------------------------------------------------------------------------
+func (p *Packet) Verify(secret string, now time.Time, skew time.Duration, nonces *NonceCache) error {
+	expected, err := p.ComputeSign(secret)
+	if err != nil {
+		return err
+	}
+	sign, err := hex.DecodeString(p.Sign)
+	if err != nil || !hmac.Equal(sign, mustDecodeHex(expected)) {
+		return ErrBadSignature
+	}
+
+	ts := time.Unix(p.Timestamp, 0)
+	if ts.Before(now.Add(-skew)) || ts.After(now.Add(skew)) {
+		return ErrStale
+	}
+
+	nonce, err := hex.DecodeString(p.Nonce)
+	if err != nil || len(nonce) < NONCEMIN {
+		return ErrBadNonce
+	}
+	if !nonces.Add(p.CliID, p.Nonce, ts.Add(skew)) {
+		return ErrReplay
+	}
+	return nil
+}
+
+func mustDecodeHex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// NonceCache 记录时间窗口内用过的随机数，过期后清除
+type NonceCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time // cli_id + nonce -> 过期时间
+	sweep   time.Time
+}
+
+// NewNonceCache 创建随机数缓存
+func NewNonceCache() *NonceCache {
+	return &NonceCache{entries: make(map[string]time.Time)}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Add 记录随机数，已存在且未过期时返回 false
+// ----------------------------------------------------------------------------------------------------------
+func (c *NonceCache) Add(cliId string, nonce string, expire time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.sweep) > time.Minute {
+		for key, exp := range c.entries {
+			if now.After(exp) {
+				delete(c.entries, key)
+			}
+		}
+		c.sweep = now
+	}
+
+	key := cliId + "\n" + nonce
+	if exp, ok := c.entries[key]; ok && now.Before(exp) {
+		return false
+	}
+	c.entries[key] = expire
+	return true
+}
@@ -0,0 +1,60 @@
+// heartbeat/metrics.go
+package heartbeat
+
+import "sync/atomic"
+
+// Metrics 心跳处理计数，自进程启动起累计
+type Metrics struct {
+	Received     atomic.Int64 // 收到的数据包
+	Processed    atomic.Int64 // 处理完成的心跳
+	Invalid      atomic.Int64 // 无法解析的数据包
+	RateLimited  atomic.Int64 // 超过设备限速被丢弃
+	QueueFull    atomic.Int64 // 队列已满被丢弃
+	Rejected     atomic.Int64 // 设备不存在或校验失败
+	Flushes      atomic.Int64 // 批量写入次数
+	FlushedRows  atomic.Int64 // 批量写入的设备数
+	FlushErrors  atomic.Int64 // 批量写入失败次数
+	CachedDevice atomic.Int64 // 当前缓存的设备数
+
+	TelemetryRows atomic.Int64 // 写入的运行状态记录数
+}
+
+// MetricsSnapshot Metrics 某一时刻的值
+type MetricsSnapshot struct {
+	Received     int64 `json:"received"`
+	Processed    int64 `json:"processed"`
+	Dropped      int64 `json:"dropped"`
+	Invalid      int64 `json:"invalid"`
+	RateLimited  int64 `json:"rate_limited"`
+	QueueFull    int64 `json:"queue_full"`
+	Rejected     int64 `json:"rejected"`
+	Flushes      int64 `json:"flushes"`
+	FlushedRows  int64 `json:"flushed_rows"`
+	FlushErrors  int64 `json:"flush_errors"`
+	CachedDevice int64 `json:"cached_device"`
+
+	TelemetryRows int64 `json:"telemetry_rows"`
+}
+
+// Stats 心跳服务的计数
+var Stats Metrics
+
+// Snapshot 读取当前计数
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		Received:     m.Received.Load(),
+		Processed:    m.Processed.Load(),
+		Invalid:      m.Invalid.Load(),
+		RateLimited:  m.RateLimited.Load(),
+		QueueFull:    m.QueueFull.Load(),
+		Rejected:     m.Rejected.Load(),
+		Flushes:      m.Flushes.Load(),
+		FlushedRows:  m.FlushedRows.Load(),
+		FlushErrors:  m.FlushErrors.Load(),
+		CachedDevice: m.CachedDevice.Load(),
+
+		TelemetryRows: m.TelemetryRows.Load(),
+	}
+	snapshot.Dropped = snapshot.Invalid + snapshot.RateLimited + snapshot.QueueFull + snapshot.Rejected
+	return snapshot
+}
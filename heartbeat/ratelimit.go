@@ -0,0 +1,70 @@
+// heartbeat/ratelimit.go
+package heartbeat
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter 按 key 限速的令牌桶，每秒补充 rate 个令牌，最多积累 burst 个
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	sweep   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter 创建令牌桶，rate <= 0 时不限速
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Allow 消耗一个令牌，没有令牌时返回 false
+// ----------------------------------------------------------------------------------------------------------
+func (l *RateLimiter) Allow(key string) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// 令牌已补满的桶与新建的桶等价，定期清除
+	if now.Sub(l.sweep) > time.Minute {
+		full := time.Duration(l.burst / l.rate * float64(time.Second))
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, k)
+			}
+		}
+		l.sweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
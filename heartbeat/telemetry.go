@@ -0,0 +1,54 @@
+// heartbeat/telemetry.go
+package heartbeat
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	CUSTOMMAXKEYS  = 32  // 自定义字段最多个数
+	CUSTOMMAXKEY   = 64  // 自定义字段名最大长度
+	CUSTOMMAXVALUE = 256 // 自定义字段值最大长度
+
+	FIRMWAREMAX = 128 // 固件版本最大长度
+	WANIPMAX    = 64  // WAN 口地址最大长度
+)
+
+// Telemetry 心跳中携带的设备运行状态，未上报的字段为 nil
+type Telemetry struct {
+	Firmware *string           `json:"firmware"` // 固件版本
+	Uptime   *int64            `json:"uptime"`   // 运行时间(秒)
+	CPU      *float64          `json:"cpu"`      // CPU 使用率(%)
+	Memory   *float64          `json:"memory"`   // 内存使用率(%)
+	WanIP    *string           `json:"wan_ip"`   // WAN 口地址
+	Signal   *int              `json:"signal"`   // 信号强度(dBm)
+	Custom   map[string]string `json:"custom"`   // 自定义字段
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ParseTelemetry 解析心跳中的 telemetry，没有上报时返回 nil
+// ----------------------------------------------------------------------------------------------------------
+func (p *Packet) ParseTelemetry() (*Telemetry, error) {
+	if len(p.Telemetry) == 0 || string(p.Telemetry) == "null" {
+		return nil, nil
+	}
+
+	var telemetry Telemetry
+	if err := json.Unmarshal(p.Telemetry, &telemetry); err != nil {
+		return nil, fmt.Errorf("telemetry 格式错误: %w", err)
+	}
+	if (telemetry.Firmware != nil && len(*telemetry.Firmware) > FIRMWAREMAX) ||
+		(telemetry.WanIP != nil && len(*telemetry.WanIP) > WANIPMAX) {
+		return nil, fmt.Errorf("telemetry 固件版本或 WAN 口地址过长")
+	}
+	if len(telemetry.Custom) > CUSTOMMAXKEYS {
+		return nil, fmt.Errorf("telemetry 自定义字段超过 %d 个", CUSTOMMAXKEYS)
+	}
+	for key, value := range telemetry.Custom {
+		if key == "" || len(key) > CUSTOMMAXKEY || len(value) > CUSTOMMAXVALUE {
+			return nil, fmt.Errorf("telemetry 自定义字段 %.64q 过长", key)
+		}
+	}
+	return &telemetry, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/wireguard"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReconcileIPAM 对比 ip_address、cli_config 和 ccd/Peer 文件中的客户端地址，返回不一致的条目
+// 只做检查，不修改数据库和文件
+func ReconcileIPAM() ([]string, error) {
+	var err error
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		return nil, fmt.Errorf("数据库连接失败: %w", err)
+	}
+
+	clientConfig := database.CliConfig{}
+	clientConfig.CreateCliConfig(global.GlobalDB)
+	ipAddress := database.IPAddress{}
+	ipAddress.CreateIPAddress(global.GlobalDB)
+
+	clientConfigs, err := clientConfig.GetAllCliConfig(global.GlobalDB)
+	if err != nil {
+		return nil, fmt.Errorf("获取客户端列表失败: %w", err)
+	}
+	addresses, err := ipAddress.GetAllIPAddress(global.GlobalDB)
+	if err != nil {
+		return nil, fmt.Errorf("获取地址分配记录失败: %w", err)
+	}
+
+	subnet := database.Subnet{}
+	subnet.CreateSubnet(global.GlobalDB)
+	subnets, err := subnet.GetAllSubnet(global.GlobalDB)
+	if err != nil {
+		return nil, fmt.Errorf("获取子网列表失败: %w", err)
+	}
+	backends := make(map[string]string, len(subnets))
+	for _, s := range subnets {
+		backends[s.SerID.String] = s.Backend.String
+	}
+
+	ccdAddresses, err := readCcdAddresses(global.GlobalOpenVPNPath.CcdPath)
+	if err != nil {
+		return nil, err
+	}
+	peerAddresses, err := readPeerAddresses(global.GlobalWireGuardPath.PeerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	allocated := make(map[string]string, len(addresses))
+	for _, address := range addresses {
+		allocated[address.CliID.String] = address.Address.String
+	}
+
+	var drifts []string
+	clients := make(map[string]bool, len(clientConfigs))
+	owners := make(map[string][]string)
+	for _, config := range clientConfigs {
+		cliId := config.CliID.String
+		cliAddress := config.CliAddress.String
+		clients[cliId] = true
+
+		// 没有子网的客户端不参与地址分配
+		if config.SerID.String == "" {
+			continue
+		}
+		owners[cliAddress] = append(owners[cliAddress], cliId)
+
+		if address, ok := allocated[cliId]; !ok {
+			drifts = append(drifts, fmt.Sprintf("客户端 %s: cli_config 地址为 %s, ip_address 中没有记录", cliId, cliAddress))
+		} else if address != cliAddress {
+			drifts = append(drifts, fmt.Sprintf("客户端 %s: cli_config 地址为 %s, ip_address 地址为 %s", cliId, cliAddress, address))
+		}
+
+		backend, err := global.GetBackend(backends[config.SerID.String])
+		if err != nil {
+			drifts = append(drifts, fmt.Sprintf("客户端 %s: 子网 %s 的隧道后端无效, err:%v", cliId, config.SerID.String, err))
+			continue
+		}
+		files, kind := ccdAddresses, "ccd"
+		if backend.Name() == global.BACKENDWIREGUARD {
+			files, kind = peerAddresses, "Peer"
+		}
+		if address, ok := files[cliId]; !ok {
+			drifts = append(drifts, fmt.Sprintf("客户端 %s: 缺少 %s 文件", cliId, kind))
+		} else if address != cliAddress {
+			drifts = append(drifts, fmt.Sprintf("客户端 %s: cli_config 地址为 %s, %s 文件地址为 %s", cliId, cliAddress, kind, address))
+		}
+	}
+
+	for address, cliIds := range owners {
+		if len(cliIds) > 1 {
+			sort.Strings(cliIds)
+			drifts = append(drifts, fmt.Sprintf("地址 %s 同时分配给了 %s", address, strings.Join(cliIds, ", ")))
+		}
+	}
+
+	for _, address := range addresses {
+		if !clients[address.CliID.String] {
+			drifts = append(drifts, fmt.Sprintf("ip_address 中的地址 %s 属于不存在的客户端 %s", address.Address.String, address.CliID.String))
+		}
+	}
+	for cliId, address := range ccdAddresses {
+		if !clients[cliId] {
+			drifts = append(drifts, fmt.Sprintf("ccd 文件 %s (%s) 没有对应的客户端", cliId, address))
+		}
+	}
+	for cliId, address := range peerAddresses {
+		if !clients[cliId] {
+			drifts = append(drifts, fmt.Sprintf("Peer 文件 %s (%s) 没有对应的客户端", cliId, address))
+		}
+	}
+
+	sort.Strings(drifts)
+	return drifts, nil
+}
+
+// readCcdAddresses 读取 ccd 目录中每个客户端的 ifconfig-push 地址，文件名即客户端ID
+func readCcdAddresses(dir string) (map[string]string, error) {
+	addresses := make(map[string]string)
+	if dir == "" || !global.CheckFileExists(dir) {
+		return addresses, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		address, _, err := global.ParseConfigFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		addresses[entry.Name()] = address
+	}
+	return addresses, nil
+}
+
+// readPeerAddresses 读取 WireGuard Peer 目录中每个客户端的地址，文件名为 <客户端ID>.conf
+func readPeerAddresses(dir string) (map[string]string, error) {
+	addresses := make(map[string]string)
+	if dir == "" || !global.CheckFileExists(dir) {
+		return addresses, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取 %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".conf") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		peers, err := wireguard.ParsePeers(data)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 %s: %w", entry.Name(), err)
+		}
+
+		address := ""
+		if len(peers) > 0 && len(peers[0].AllowedIPs) > 0 {
+			if ip, _, err := net.ParseCIDR(peers[0].AllowedIPs[0]); err == nil {
+				address = ip.String()
+			}
+		}
+		addresses[strings.TrimSuffix(entry.Name(), ".conf")] = address
+	}
+	return addresses, nil
+}
@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"html/template"
 	"io"
 	"jwireguard/database"
 	"jwireguard/global"
 	"jwireguard/message"
+	"jwireguard/udpproxy"
 	webservice "jwireguard/webserver"
 	"log"
 	"net"
@@ -22,64 +20,6 @@ import (
 
 var sender message.EmailSender
 
-var emailTable string = "[VPN-上海服务器] 设备状态通知"
-var htmlTemplate string = `<!DOCTYPE html>
-<html>
-<head>
-<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
-<meta name="viewport" content="width=device-width, initial-scale=1.0">
-<style type="text/css">
-	body { margin: 0; padding: 0; font-family: Arial, sans-serif; }
-	.email-container {
-		width: 100%;
-		margin: 0 auto;
-		background: #0e9dbb url('https://rescdn.qqmail.com/zh_CN/htmledition/images/xinzhi/bg/a_09.jpg') repeat-x;
-	}
-	.content {
-		padding: 30px 15px;
-		color: #ffffff;
-		line-height: 1.6;
-		font-size: 16px;
-	}
-</style>
-</head>
-<body>
-<div class="email-container">
-	<div class="content">
-		<div class="message">
-			<b>客户端ID：</b>  {{.CliID}}<br>
-			<b>客户端名称:</b> {{.CliName}}<br>
-			<b>所在子网： </b> {{.SerName}}<br>
-			<b>网络映射： </b> {{.CliMapping}}<br>
-			<b>内网地址： </b> {{.CliAddress}}<br>
-			<b>在线状态： </b> {{.CliStatus}}<br>
-		</div>
-	</div>
-</div>
-</body>
-</html>`
-
-type UdpRecvData struct {
-	CliID      string `json:"cli_id"`
-	CliMapping string `json:"cli_mapping"`
-	CliMac     string `json:"cli_mac"`
-	CliStatus  string `json:"cli_status"`
-}
-
-type UdpSendData struct {
-	Status  bool   `json:"status"`
-	Message string `json:"message"`
-}
-
-type EditCliStatus struct {
-	CliID      string
-	CliName    string
-	SerName    string
-	CliMapping string
-	CliAddress string
-	CliStatus  string
-}
-
 func initLogger(logName string, logLevel logrus.Level) *logrus.Logger {
 	logger := logrus.New()
 
@@ -117,7 +57,7 @@ func OpenVPNServer() {
 func IsDevOnline() {
 	global.Log.Infof("[IsDevOnline] start")
 	for {
-		time.Sleep(60 * time.Second) // 等待 60 秒
+		time.Sleep(time.Duration(global.GlobalJWireGuardini.OfflineCheckInterval) * time.Second)
 		// 查询连接状态
 		database.MonitorDatabase(global.GlobalDB)
 		// 创建数据库连接
@@ -129,65 +69,43 @@ func IsDevOnline() {
 		if err != nil {
 			continue
 		}
+		// 子网和设备的离线判断参数
+		rules := loadOfflineRules()
 
 		for _, person := range clientConfigs {
-			// log.Println("person:", person)
 			// 获取当前时间戳
 			currentTime := time.Now().Unix()
 
-			// log.Println("Timestamp:", person.Timestamp.Int64)
-			// 时间戳大于60秒
-			if (currentTime - person.Timestamp.Int64) >= 60 {
-
-				// 只需要更改在线的设备
-				if person.CliStatus.String == "true" {
-
-					person.CliStatus.String = "false"
-					data := EditCliStatus{
-						CliID:      person.CliID.String,
-						CliName:    person.CliName.String,
-						SerName:    person.SerName.String,
-						CliMapping: person.CliMapping.String,
-						CliAddress: person.CliAddress.String,
-						CliStatus:  person.CliStatus.String,
-					}
-
-					// 渲染 HTML
-					var tpl bytes.Buffer
-					t := template.Must(template.New("html").Parse(htmlTemplate))
-					t.Execute(&tpl, data)
-					htmlBody := tpl.String()
-
-					global.Log.Debugf("[IsDevOnline] 客户端编码:[%s] 客户端名称:[%s] 时间戳:[%d] 客户端在线状态:[%s]",
-						person.CliID.String,
-						person.CliName.String,
-						person.Timestamp.Int64,
-						person.CliStatus.String)
-
-					// log.Printf("[IsDevOnline] message:%+v", message)
-					err := sender.SendMail(
-						[]string{global.GlobalJWireGuardini.To},
-						emailTable,
-						htmlBody,
-						true, // 使用 HTML 格式
-					)
-
-					if err != nil {
-						global.Log.Errorf("[IsDevOnline] 邮件发送失败：%+v", err)
-					} else {
-						global.Log.Debugln("[IsDevOnline] 邮件发送成功！")
-					}
-
-					// 将数据更新到数据库中
-					err = person.UpdateCliConfig(global.GlobalDB)
-					if err != nil {
-						global.Log.Errorf("[IsDevOnline] 无法将客户端ID: [%s]的状态转为false, err:%v", person.CliID.String, err)
-					}
+			// 心跳时间戳批量写入，以缓存中最近的心跳为准
+			if lastSeen, ok := heartbeatDevices.lastSeen(person.CliID.String); ok && lastSeen > person.Timestamp.Int64 {
+				person.Timestamp.Int64 = lastSeen
+			}
+
+			// 连续丢失 MISSED 次心跳，只需要更改在线的设备
+			if (currentTime-person.Timestamp.Int64) >= rules.timeout(person) && person.CliStatus.String == "true" {
+				// 下次心跳重新从数据库加载并转为在线
+				heartbeatDevices.remove(person.CliID.String)
+				person.CliStatus.String = "false"
+
+				global.Log.Debugf("[IsDevOnline] 客户端编码:[%s] 客户端名称:[%s] 时间戳:[%d] 客户端在线状态:[%s]",
+					person.CliID.String,
+					person.CliName.String,
+					person.Timestamp.Int64,
+					person.CliStatus.String)
 
+				// 将数据更新到数据库中
+				err = person.UpdateCliConfig(global.GlobalDB)
+				if err != nil {
+					global.Log.Errorf("[IsDevOnline] 无法将客户端ID: [%s]的状态转为false, err:%v", person.CliID.String, err)
+					continue
 				}
+				detail := fmt.Sprintf("最后心跳 %s, 超过 %d 秒", time.Unix(person.Timestamp.Int64, 0).Format("2006-01-02 15:04:05"), rules.timeout(person))
+				statusChanged(person, database.EVENTCAUSETIMEOUT, detail, currentTime)
 			}
 		}
 
+		// 不稳定的设备恢复稳定后通知
+		notifyStableDevices(time.Now().Unix())
 	}
 }
 
@@ -209,24 +127,36 @@ func IsIptablesSubnet() {
 
 		for _, person := range subnets {
 			// 配置Iptables
-			rules := fmt.Sprintf("-s %s.%d.0/24 -d %s.0.0/16 -j ACCEPT",
-				global.GlobalJWireGuardini.IPPrefix,
-				person.SerNum.Int32,
-				global.GlobalJWireGuardini.IPPrefix)
-
-			if !global.CheckIptablesRule(rules) {
-				err := global.AddIptablesRule(rules)
-				if err != nil {
-					global.Log.Errorf("[IsIptablesSubnet] 路由配置错误 '%s': %v", rules, err)
-				} else {
-					global.Log.Debugf("[IsIptablesSubnet] 路由配置成功 '%s'", rules)
-				}
+			network, err := person.Network()
+			if err != nil {
+				global.Log.Errorf("[IsIptablesSubnet] 子网 %s 网段错误, err:%v", person.SerID.String, err)
+				continue
+			}
+			// 启用 IPv6 时同时配置 ip6tables
+			if err := global.AddSubnetRules(network); err != nil {
+				global.Log.Errorf("[IsIptablesSubnet] 路由配置错误 %v", err)
 			}
 		}
 		time.Sleep(60 * time.Second) // 等待 60 秒
 	}
 }
 
+// RefreshCRL 启动时及每天重新生成 crl.pem，避免 CRL 过期导致所有客户端无法连接
+func RefreshCRL() {
+	global.Log.Infof("[RefreshCRL] start")
+	for {
+		ca, err := global.GetCA()
+		if err != nil {
+			global.Log.Errorf("[RefreshCRL] 无法加载CA, err:%v", err)
+		} else if err = ca.GenCRL(); err != nil {
+			global.Log.Errorf("[RefreshCRL] 无法生成CRL, err:%v", err)
+		} else {
+			global.Log.Debugf("[RefreshCRL] CRL 已更新: %s", ca.CRLFile())
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
 func startUDPListener(port int) {
 	// 1. 设置监听地址和端口
 	addr := net.UDPAddr{
@@ -244,197 +174,40 @@ func startUDPListener(port int) {
 
 	global.Log.Infoln("[main] UDP 服务器已启动，监听端口:", addr.Port)
 
-	// 3. 处理接收到的数据包
-	buffer := make([]byte, 1024)
+	// 3. 心跳由工作协程处理，接收协程只做解析和限速
+	pipeline := newHeartbeatPipeline(conn)
+	pipeline.Start()
+
+	// 无法使用 UDP 的设备通过 HTTP(S) 上报心跳
+	webservice.SetHeartbeatHandler(pipeline.Handle)
+
+	// 心跳中可能带有运行状态，缓冲区需要容纳完整的数据包
+	buffer := make([]byte, 8192)
 	for {
 		n, clientAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
 			global.Log.Errorf("[main] 无法接收到设备心跳信息, err:%v", err)
 			continue
 		}
-
-		// 4. 打印接收到的原始消息
-		// message := string(buffer[:n])
-		// log.Printf("[main] 收到来自 %s cli_id: %s", clientAddr.String(), message)
-
-		// 5. 解析 JSON 数据
-		var recvData UdpRecvData
-		err = json.Unmarshal(buffer[:n], &recvData)
-		if err != nil {
-			global.Log.Errorf("[main] 无法将接收UDP数据转为JSON数据, err:%v", err)
-			continue
-		}
-
-		// 6. 打印解析后的数据
-		// log.Printf("[main] 解析后的数据: %+v", recvData)
-		global.Log.Debugf("[main] 收到来自 %s cli_id %s 的心跳数据", clientAddr.String(), recvData.CliID)
-
-		if recvData.CliStatus == "true" {
-			var sendData UdpSendData
-			// 获取当前时间戳
-			currentTime := time.Now().Unix()
-			// 查询连接状态
-			database.MonitorDatabase(global.GlobalDB)
-			// 创建客户端
-			clientConfig := database.CliConfig{}
-			// 初始化数据库
-			clientConfig.CreateCliConfig(global.GlobalDB)
-			// 设置客户端ID和MAC地址
-			clientConfig.CliID.String = recvData.CliID
-			clientConfig.CliMac.String = recvData.CliMac
-			// 查询客户端信息
-			err = clientConfig.GetCliConfigByCliID(global.GlobalDB)
-			if err != nil {
-				sendData.Status = false
-				sendData.Message = "客户端不存在"
-				continue
-			} else {
-				numFunc := clientConfig.EditStatus.Int32 & 0x02
-				if numFunc == 0 {
-					clientConfig.CliMapping.String = recvData.CliMapping
-				}
-				clientConfig.Timestamp.Int64 = currentTime
-				if clientConfig.CliStatus.String != "true" {
-
-					// 更新在线状态
-					clientConfig.CliStatus.String = "true"
-					data := EditCliStatus{
-						CliID:      clientConfig.CliID.String,
-						CliName:    clientConfig.CliName.String,
-						SerName:    clientConfig.SerName.String,
-						CliMapping: clientConfig.CliMapping.String,
-						CliAddress: clientConfig.CliAddress.String,
-						CliStatus:  clientConfig.CliStatus.String,
-					}
-
-					// 渲染 HTML
-					var tpl bytes.Buffer
-					t := template.Must(template.New("html").Parse(htmlTemplate))
-					t.Execute(&tpl, data)
-					htmlBody := tpl.String()
-
-					err = sender.SendMail(
-						[]string{global.GlobalJWireGuardini.To},
-						emailTable,
-						htmlBody,
-						true, // 使用 HTML 格式
-					)
-
-					if err != nil {
-						global.Log.Errorf("[main] 邮件发送 cli_id %s 设备在线状态失败, err:%v", clientConfig.CliID.String, err)
-					} else {
-						global.Log.Debugf("[main] 邮件发送 cli_id %s 设备在线状态成功!", clientConfig.CliID.String)
-					}
-				}
-
-				// 判断MAC地址是否一样
-				// if recvData.CliMac != "" {
-				// 	if clientConfig.CliMac.String != recvData.CliMac {
-				// 		clientConfig.OnlineStatus.String = "false"
-				// 	} else {
-				// 		clientConfig.OnlineStatus.String = "true"
-				// 	}
-				// }
-
-				err = clientConfig.UpdateCliConfig(global.GlobalDB)
-				if err != nil {
-					sendData.Status = false
-					sendData.Message = "Client status update failed!"
-					global.Log.Errorf("[main] cli_id %s 心跳更新失败", clientConfig.CliID.String)
-				} else {
-					sendData.Status = true
-					sendData.Message = "Client status updated successfully!"
-					global.Log.Debugf("[main] cli_id %s 心跳更新成功", clientConfig.CliID.String)
-				}
-			}
-
-			// 7. 回复客户端
-			jsonData, err := json.Marshal(sendData)
-			if err != nil {
-				global.Log.Errorf("[main] 无法将 cli_id %s 的设备转换回复信息, err:%v", clientConfig.CliID.String, err)
-				continue
-			}
-
-			// 8、返回数据
-			// log.Printf("[main] 返回数据的数据: %s", string(jsonData))
-
-			// Send data
-			_, err = conn.WriteToUDP(jsonData, clientAddr)
-			if err != nil {
-				global.Log.Errorf("[main] 无法向 cli_id %s 的设备回复心跳信息, err:%v", clientConfig.CliID.String, err)
-			}
-
-		}
+		pipeline.Submit(buffer[:n], clientAddr)
 	}
 }
 
-// 简单的 int 转 string（不引入 strconv）
-func itoa(i int) string {
-	return fmt.Sprintf("%d", i)
-}
-
-// StartUDPForwarder 启动一个 UDP 转发器，将 fromPort 接收的数据转发到 toHost:toPort
-// StartTransparentUDPProxy 启动透明 UDP 转发（支持返回流量）
-func StartTransparentUDPProxy(fromPort int, toHost string, toPort int) error {
-	// 监听 fromPort
-	srcAddr, err := net.ResolveUDPAddr("udp", ":"+itoa(fromPort))
-	if err != nil {
-		return err
-	}
-	listener, err := net.ListenUDP("udp", srcAddr)
-	if err != nil {
-		return err
-	}
-
-	// 目标地址（toHost:toPort）
-	dstAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(toHost, itoa(toPort)))
+// StartTransparentUDPProxy 将 fromPort 收到的 UDP 数据转发到 upstream
+// 每个客户端地址使用单独的上游连接，回复返回给对应的客户端
+func StartTransparentUDPProxy(fromPort int, upstream string) error {
+	proxy, err := udpproxy.New(udpproxy.Config{
+		Listen:      fmt.Sprintf(":%d", fromPort),
+		Upstream:    upstream,
+		IdleTimeout: time.Duration(global.GlobalJWireGuardini.ProxyIdleTimeout) * time.Second,
+		MaxSessions: global.GlobalJWireGuardini.ProxyMaxSessions,
+	}, global.Log)
 	if err != nil {
 		return err
 	}
 
-	global.Log.Infof("透明UDP代理已启动: :%d ⇄ %s:%d", fromPort, toHost, toPort)
-
-	// 存储 clientAddr，以便从 dstAddr 收到数据后返回给客户端
-	var clientAddr *net.UDPAddr
-	// var lastSeen time.Time
-
-	go func() {
-		defer listener.Close()
-		buffer := make([]byte, 2048)
-
-		for {
-			n, addr, err := listener.ReadFromUDP(buffer)
-			if err != nil {
-				global.Log.Errorf("读取UDP失败: %v", err)
-				continue
-			}
-
-			data := buffer[:n]
-
-			// 判断来源地址
-			if addr.String() != dstAddr.String() {
-				// 是客户端发来的，转发给目标服务器
-				clientAddr = addr
-				// lastSeen = time.Now()
-
-				global.Log.Debugf("[→] 来自客户端 %s，转发 %d 字节到 %s", clientAddr, n, dstAddr)
-				_, err = listener.WriteToUDP(data, dstAddr)
-				if err != nil {
-					global.Log.Errorf("发送到目标失败: %v", err)
-				}
-			} else if clientAddr != nil {
-				// 是目标服务器返回的数据，转发给客户端
-				global.Log.Debugf("[←] 来自目标 %s, 返回 %d 字节到客户端 %s", dstAddr, n, clientAddr)
-				_, err = listener.WriteToUDP(data, clientAddr)
-				if err != nil {
-					global.Log.Errorf("返回客户端失败: %v", err)
-				}
-			} else {
-				global.Log.Errorf("收到目标数据，但无客户端记录，丢弃。")
-			}
-		}
-	}()
-
+	global.Log.Infof("透明UDP代理已启动: :%d ⇄ %s", fromPort, upstream)
+	go proxy.Serve()
 	return nil
 }
 
@@ -501,6 +274,47 @@ func main() {
 	global.Log.Infof("[main] [GENERAL SETTING] DEFAULT_USER %s\n", global.GlobalJWireGuardini.DefaultUser)
 	global.Log.Infof("[main] [GENERAL SETTING] SUBNET_MAKE %s\n", global.GlobalJWireGuardini.SubnetMask)
 	global.Log.Infof("[main] [GENERAL SETTING] SERVER_PORT %d\n", global.GlobalJWireGuardini.ServerPort)
+	global.Log.Infof("[main] [GENERAL SETTING] TUNNEL_BACKEND %s\n", global.GlobalJWireGuardini.TunnelBackend)
+	global.Log.Infof("[main] [GENERAL SETTING] SUPERNET %s\n", global.GlobalJWireGuardini.Supernet)
+	global.Log.Infof("[main] [GENERAL SETTING] SUBNET_PREFIX_LEN %d\n", global.GlobalJWireGuardini.SubnetPrefixLen)
+	global.Log.Infof("[main] [GENERAL SETTING] IPV6_PREFIX %s\n", global.GlobalJWireGuardini.IPv6Prefix)
+
+	global.Log.Infof("[main] [WIREGUARD SETTING] PATH %s\n", global.GlobalJWireGuardini.WireGuardPath)
+	global.Log.Infof("[main] [WIREGUARD SETTING] INTERFACE %s\n", global.GlobalJWireGuardini.WireGuardInterface)
+	global.Log.Infof("[main] [WIREGUARD SETTING] ADDRESS %s\n", global.GlobalJWireGuardini.WireGuardAddress)
+	global.Log.Infof("[main] [WIREGUARD SETTING] ENDPOINT %s\n", global.GlobalJWireGuardini.WireGuardEndpoint)
+
+	global.Log.Infof("[main] [HEARTBEAT SETTING] MAX_SKEW %d\n", global.GlobalJWireGuardini.HeartbeatMaxSkew)
+	global.Log.Infof("[main] [HEARTBEAT SETTING] V1_UNTIL %s\n", global.GlobalJWireGuardini.HeartbeatV1Until.Format(time.RFC3339))
+	global.Log.Infof("[main] [HEARTBEAT SETTING] WORKERS %d\n", global.GlobalJWireGuardini.HeartbeatWorkers)
+	global.Log.Infof("[main] [HEARTBEAT SETTING] QUEUE_SIZE %d\n", global.GlobalJWireGuardini.HeartbeatQueueSize)
+	global.Log.Infof("[main] [HEARTBEAT SETTING] RATE %g BURST %d\n", global.GlobalJWireGuardini.HeartbeatRate, global.GlobalJWireGuardini.HeartbeatBurst)
+	global.Log.Infof("[main] [HEARTBEAT SETTING] SOURCE_RATE %g SOURCE_BURST %d\n", global.GlobalJWireGuardini.HeartbeatSourceRate, global.GlobalJWireGuardini.HeartbeatSourceBurst)
+	global.Log.Infof("[main] [HEARTBEAT SETTING] FLUSH_INTERVAL %d\n", global.GlobalJWireGuardini.HeartbeatFlushInterval)
+	global.Log.Infof("[main] [HEARTBEAT SETTING] TELEMETRY_INTERVAL %d\n", global.GlobalJWireGuardini.HeartbeatTelemetryInterval)
+	global.Log.Infof("[main] [HEARTBEAT SETTING] TELEMETRY_RETENTION_DAYS %d\n", global.GlobalJWireGuardini.HeartbeatTelemetryRetention)
+	global.Log.Infof("[main] [HEARTBEAT SETTING] COMMAND_TTL %d\n", global.GlobalJWireGuardini.HeartbeatCommandTTL)
+	global.Log.Infof("[main] [PROXY SETTING] UPSTREAM %s\n", global.GlobalJWireGuardini.ProxyUpstream)
+	global.Log.Infof("[main] [PROXY SETTING] IDLE_TIMEOUT %d\n", global.GlobalJWireGuardini.ProxyIdleTimeout)
+	global.Log.Infof("[main] [PROXY SETTING] MAX_SESSIONS %d\n", global.GlobalJWireGuardini.ProxyMaxSessions)
+	global.Log.Infof("[main] [OFFLINE SETTING] CHECK_INTERVAL %d\n", global.GlobalJWireGuardini.OfflineCheckInterval)
+	global.Log.Infof("[main] [OFFLINE SETTING] INTERVAL %d\n", global.GlobalJWireGuardini.OfflineInterval)
+	global.Log.Infof("[main] [OFFLINE SETTING] MISSED %d\n", global.GlobalJWireGuardini.OfflineMissed)
+	global.Log.Infof("[main] [OFFLINE SETTING] FLAP_WINDOW %d\n", global.GlobalJWireGuardini.FlapWindow)
+	global.Log.Infof("[main] [OFFLINE SETTING] FLAP_COUNT %d\n", global.GlobalJWireGuardini.FlapCount)
+	global.Log.Infof("[main] [OFFLINE SETTING] STABLE_PERIOD %d\n", global.GlobalJWireGuardini.FlapStablePeriod)
+	global.Log.Infof("[main] [NOTIFY SETTING] INTERVAL %d\n", global.GlobalJWireGuardini.NotifyInterval)
+	global.Log.Infof("[main] [NOTIFY SETTING] RETRY_BASE %d\n", global.GlobalJWireGuardini.NotifyRetryBase)
+	global.Log.Infof("[main] [NOTIFY SETTING] RETRY_MAX %d\n", global.GlobalJWireGuardini.NotifyRetryMax)
+	global.Log.Infof("[main] [NOTIFY SETTING] MAX_ATTEMPTS %d\n", global.GlobalJWireGuardini.NotifyMaxAttempts)
+	global.Log.Infof("[main] [NOTIFY SETTING] RETENTION_DAYS %d\n", global.GlobalJWireGuardini.NotifyRetentionDays)
+	global.Log.Infof("[main] [TEMPLATE SETTING] DIR %s\n", global.GlobalJWireGuardini.TemplateDir)
+	global.Log.Infof("[main] [TEMPLATE SETTING] LOCALE %s\n", global.GlobalJWireGuardini.TemplateLocale)
+	global.Log.Infof("[main] [TEMPLATE SETTING] SERVER_NAME %s\n", global.GlobalJWireGuardini.ServerName)
+	global.Log.Infof("[main] [DIGEST SETTING] FREQUENCY %s\n", global.GlobalJWireGuardini.DigestFrequency)
+	global.Log.Infof("[main] [DIGEST SETTING] TIME %s\n", global.GlobalJWireGuardini.DigestTime)
+	global.Log.Infof("[main] [DIGEST SETTING] WEEKDAY %d\n", global.GlobalJWireGuardini.DigestWeekday)
+	global.Log.Infof("[main] [DIGEST SETTING] TOP_TRAFFIC %d\n", global.GlobalJWireGuardini.DigestTopTraffic)
 
 	global.Log.Infof("[main] [SSL PUSH] CERT_FILE %s\n", global.GlobalJWireGuardini.SslCertFile)
 	global.Log.Infof("[main] [SSL PUSH] KEY_FILE %s\n", global.GlobalJWireGuardini.SslKeyFiel)
@@ -512,6 +326,7 @@ func main() {
 	global.Log.Infof("[main] [EMAIL SETTING] FormEmail %s\n", global.GlobalJWireGuardini.FormEmail)
 	global.Log.Infof("[main] [EMAIL SETTING] FormName %s\n", global.GlobalJWireGuardini.FormName)
 	global.Log.Infof("[main] [EMAIL SETTING] To %s\n", global.GlobalJWireGuardini.To)
+	global.Log.Infof("[main] [EMAIL SETTING] Security %s\n", global.GlobalJWireGuardini.EmailSecurity)
 
 	sender = message.EmailSender{
 		Host:     global.GlobalJWireGuardini.EmailHost,
@@ -520,6 +335,7 @@ func main() {
 		Password: global.GlobalJWireGuardini.EmailPass,
 		From:     global.GlobalJWireGuardini.FormEmail,
 		Name:     global.GlobalJWireGuardini.FormName,
+		Security: global.GlobalJWireGuardini.EmailSecurity,
 	}
 
 	// 初始化OpenVPN路径
@@ -534,6 +350,13 @@ func main() {
 	global.GlobalOpenVPNPath.PrivatePath = global.GlobalOpenVPNPath.PkiPath + "/private"
 	global.GlobalOpenVPNPath.ReqsPath = global.GlobalOpenVPNPath.PkiPath + "/reqs"
 
+	// 初始化WireGuard路径
+	global.GlobalWireGuardPath.ServerFile = fmt.Sprintf("%s/%s.conf", global.GlobalJWireGuardini.WireGuardPath, global.GlobalJWireGuardini.WireGuardInterface)
+	global.GlobalWireGuardPath.HeadFile = global.GlobalJWireGuardini.WireGuardPath + "/server.txt"
+	global.GlobalWireGuardPath.KeyFile = global.GlobalJWireGuardini.WireGuardPath + "/server.key"
+	global.GlobalWireGuardPath.PeerPath = global.GlobalJWireGuardini.WireGuardPath + "/peers"
+	global.GlobalWireGuardPath.ClientPath = global.GlobalJWireGuardini.WireGuardPath + "/client"
+
 	// fmt.Println("BinPath:", global.GlobalOpenVPNPath.BinPath)
 	// fmt.Println("CcdPath:", global.GlobalOpenVPNPath.CcdPath)
 	// fmt.Println("ConfigPath:", global.GlobalOpenVPNPath.ConfigPath)
@@ -545,6 +368,26 @@ func main() {
 	// fmt.Println("PrivatePath:", global.GlobalOpenVPNPath.PrivatePath)
 	// fmt.Println("ReqsPath:", global.GlobalOpenVPNPath.ReqsPath)
 
+	// jwireguard reconcile: 检查数据库与 ccd/Peer 文件中的地址是否一致
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		drifts, err := ReconcileIPAM()
+		if err != nil {
+			global.Log.Errorf("[main] 地址核对失败, err:%v", err)
+			os.Exit(1)
+		}
+		for _, drift := range drifts {
+			fmt.Println(drift)
+		}
+		fmt.Printf("[main] 地址核对完成, 共 %d 处不一致\n", len(drifts))
+		if len(drifts) > 0 {
+			os.Exit(2)
+		}
+		return
+	}
+
+	// 管理接口实时通知，需在建立连接前设置
+	global.ManagementEventHandler = handleManagementEvent
+
 	// 启动WEB线程
 	go OpenVPNServer()
 
@@ -554,9 +397,32 @@ func main() {
 	// 启动判断IPTABLES
 	go IsIptablesSubnet()
 
+	// 定期更新证书吊销列表
+	go RefreshCRL()
+
+	// 证书到期扫描与自动续签
+	go CertExpiryMonitor()
+
+	// 发送通知发件箱
+	go NotifyOutboxWorker()
+
+	// 发送每日/每周摘要
+	go DigestScheduler()
+
+	// 免打扰结束后发送暂存的通知
+	go QuietHoursWorker()
+
+	// 连接OpenVPN管理接口
+	go ManagementMonitor()
+
+	// 采集客户端流量
+	go TrafficCollector()
+
 	// fmt.Println("UDPPort:", global.GlobalJWireGuardini.IPPrefix)
 	go startUDPListener(int(global.GlobalJWireGuardini.ServerPort))
-	go StartTransparentUDPProxy(int(global.GlobalJWireGuardini.ServerPortTls), "127.0.0.1", int(global.GlobalJWireGuardini.ServerPort))
+	if err := StartTransparentUDPProxy(int(global.GlobalJWireGuardini.ServerPortTls), global.GlobalJWireGuardini.ProxyUpstream); err != nil {
+		global.Log.Errorf("[IsDevOnline] 无法启动透明UDP代理, err:%v", err)
+	}
 
 	select {}
 }
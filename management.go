@@ -0,0 +1,66 @@
+package main
+
+import (
+	"jwireguard/global"
+	"jwireguard/management"
+	"time"
+)
+
+// ManagementMonitor 保持与 OpenVPN 管理接口的连接并记录客户端连接、断开通知
+func ManagementMonitor() {
+	global.Log.Infof("[ManagementMonitor] start")
+	for {
+		client, err := global.GetManagement()
+		if err != nil {
+			global.Log.Errorf("[ManagementMonitor] %v", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		<-client.Done()
+		global.Log.Warnf("[ManagementMonitor] 管理接口连接断开, err:%v", client.Err())
+		time.Sleep(time.Second)
+	}
+}
+
+// handleManagementEvent 处理管理接口实时通知
+func handleManagementEvent(event management.Event) {
+	switch event.Type {
+	case management.EventConnect, management.EventReauth:
+		// management-client-auth 下 OpenVPN 等待答复后才完成连接
+		// 证书已由 tls 握手和 crl-verify 校验，这里直接放行
+		// 重新连接时 GetManagement 持有锁，在协程中放行避免阻塞通知分发
+		if global.GlobalJWireGuardini.ManagementAuth {
+			go approveManagementClient(event)
+		}
+	case management.EventEstablished:
+		global.Log.Infof("[ManagementMonitor] 客户端ID: [%s] 已连接, 真实地址:[%s] 虚拟地址:[%s] CID:[%d]",
+			event.CommonName(),
+			event.RealAddress(),
+			event.VirtualAddress(),
+			event.CID)
+	case management.EventDisconnect:
+		global.Log.Infof("[ManagementMonitor] 客户端ID: [%s] 已断开, 真实地址:[%s] 接收:[%d] 发送:[%d] CID:[%d]",
+			event.CommonName(),
+			event.RealAddress(),
+			event.BytesReceived(),
+			event.BytesSent(),
+			event.CID)
+	case management.EventAddress:
+		global.Log.Debugf("[ManagementMonitor] CID:[%d] 地址:[%s]", event.CID, event.Address)
+	default:
+		global.Log.Debugf("[ManagementMonitor] %s", event.Raw)
+	}
+}
+
+// approveManagementClient 通过 client-auth-nt 放行客户端连接，客户端已断开时返回的错误只记录日志
+func approveManagementClient(event management.Event) {
+	client, err := global.GetManagement()
+	if err != nil {
+		global.Log.Errorf("[ManagementMonitor] 无法放行客户端 [%s] CID:[%d], err:%v", event.CommonName(), event.CID, err)
+		return
+	}
+	if err := client.ClientAuthNT(event.CID, event.KID); err != nil {
+		global.Log.Warnf("[ManagementMonitor] 放行客户端 [%s] CID:[%d] 失败, err:%v", event.CommonName(), event.CID, err)
+	}
+}
@@ -0,0 +1,341 @@
+// management/client.go
+package management
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULTTIMEOUT   = 10 * time.Second // 命令超时时间
+	eventBufferSize  = 256              // 实时通知缓冲数量
+	passwordPrompt   = "ENTER PASSWORD:"
+	responseSuccess  = "SUCCESS:"
+	responseError    = "ERROR:"
+	responseEnd      = "END"
+	unixAddrPrefix   = "unix://"
+	clientNotifyType = "CLIENT"
+)
+
+// ErrClosed 管理连接已关闭
+var ErrClosed = errors.New("管理接口连接已关闭")
+
+// ErrTimeout 命令执行超时
+var ErrTimeout = errors.New("管理接口命令超时")
+
+// CommandError 管理接口返回的 ERROR: 响应
+type CommandError struct {
+	Command string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("管理接口命令 '%s' 执行失败: %s", e.Command, e.Message)
+}
+
+// Client OpenVPN 管理接口客户端
+// 管理接口同一时间只接受一个连接，调用方应复用同一个 Client
+type Client struct {
+	Timeout time.Duration
+
+	conn    net.Conn
+	reader  *bufio.Reader
+	handler func(Event)
+
+	cmdMu  sync.Mutex // 同一时间只执行一条命令
+	lines  chan string
+	events chan Event
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+
+	pending *Event // 正在接收 >CLIENT:ENV 的通知
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Dial 连接管理接口，addr 为 host:port、unix:///path 或 /path
+// password 为空时不进行认证，handler 用于接收实时通知，可以为 nil
+// ----------------------------------------------------------------------------------------------------------
+func Dial(addr string, password string, handler func(Event)) (*Client, error) {
+	network, address := ParseAddr(addr)
+	conn, err := net.DialTimeout(network, address, DEFAULTTIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewClient(conn, handler)
+	if password != "" {
+		if err := c.Login(password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// ParseAddr 解析管理接口地址
+func ParseAddr(addr string) (network, address string) {
+	switch {
+	case strings.HasPrefix(addr, unixAddrPrefix):
+		return "unix", strings.TrimPrefix(addr, unixAddrPrefix)
+	case strings.HasPrefix(addr, "/"):
+		return "unix", addr
+	default:
+		return "tcp", addr
+	}
+}
+
+// NewClient 使用已建立的连接创建客户端，便于对接模拟的管理接口
+func NewClient(conn net.Conn, handler func(Event)) *Client {
+	c := &Client{
+		Timeout: DEFAULTTIMEOUT,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		handler: handler,
+		lines:   make(chan string),
+		events:  make(chan Event, eventBufferSize),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.dispatchLoop()
+	return c
+}
+
+// Login 发送管理接口密码
+func (c *Client) Login(password string) error {
+	_, err := c.exec(password, "password", false)
+	return err
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	c.closeWithErr(ErrClosed)
+	return nil
+}
+
+// Done 连接断开时关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 返回连接断开的原因
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Status 执行 status 3 并解析客户端列表和路由表
+// ----------------------------------------------------------------------------------------------------------
+func (c *Client) Status() (*Status, error) {
+	lines, err := c.exec("status 3", "status 3", true)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatusLines(lines)
+}
+
+// Kill 按 common name 或 真实地址(IP:port) 断开客户端，返回断开的客户端数量
+// 客户端未连接时返回 0 而不是错误
+func (c *Client) Kill(target string) (int, error) {
+	cmd := "kill " + QuoteArg(target)
+	lines, err := c.exec(cmd, cmd, false)
+	if cmdErr, ok := err.(*CommandError); ok && strings.Contains(cmdErr.Message, "not found") {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// SUCCESS: common name 'xxx' found, 1 client(s) killed
+	count := 0
+	if idx := strings.LastIndex(lines[0], "found, "); idx >= 0 {
+		fmt.Sscanf(lines[0][idx:], "found, %d", &count)
+	}
+	return count, nil
+}
+
+// ClientKill 按客户端ID(CID) 断开客户端，message 会发送给客户端(需 OpenVPN 2.4+)
+func (c *Client) ClientKill(cid int64, message string) error {
+	cmd := "client-kill " + strconv.FormatInt(cid, 10)
+	if message != "" {
+		cmd += " " + QuoteArg(message)
+	}
+	_, err := c.exec(cmd, cmd, false)
+	return err
+}
+
+// ClientAuthNT 允许 CONNECT/REAUTH 通知中的客户端连接，不下发额外配置
+func (c *Client) ClientAuthNT(cid, kid int64) error {
+	cmd := fmt.Sprintf("client-auth-nt %d %d", cid, kid)
+	_, err := c.exec(cmd, cmd, false)
+	return err
+}
+
+// Command 执行返回单行 SUCCESS:/ERROR: 的命令
+func (c *Client) Command(cmd string) (string, error) {
+	lines, err := c.exec(cmd, cmd, false)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimPrefix(lines[0], responseSuccess)), nil
+}
+
+// exec 发送命令并等待响应，multiline 为 true 时读取到 END 为止
+func (c *Client) exec(cmd string, name string, multiline bool) ([]string, error) {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil, c.err
+	default:
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	if _, err := c.conn.Write([]byte(cmd + "\n")); err != nil {
+		c.closeWithErr(err)
+		return nil, err
+	}
+
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+
+	var lines []string
+	for {
+		select {
+		case line := <-c.lines:
+			if strings.HasPrefix(line, responseError) {
+				return nil, &CommandError{Command: name, Message: strings.TrimSpace(strings.TrimPrefix(line, responseError))}
+			}
+			if !multiline {
+				return []string{line}, nil
+			}
+			if line == responseEnd {
+				return lines, nil
+			}
+			lines = append(lines, line)
+		case <-timer.C:
+			// 超时后响应与命令无法再对应，只能断开重连
+			c.closeWithErr(ErrTimeout)
+			return nil, ErrTimeout
+		case <-c.done:
+			return nil, c.err
+		}
+	}
+}
+
+// readLoop 读取管理接口输出，区分命令响应与实时通知
+func (c *Client) readLoop() {
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.closeWithErr(err)
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		// 密码提示没有换行，会与下一行响应拼接在一起
+		line = strings.TrimPrefix(line, passwordPrompt)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, ">") {
+			c.handleNotification(line[1:])
+			continue
+		}
+
+		select {
+		case c.lines <- line:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// handleNotification 解析实时通知，>CLIENT:ENV 会累积到 ENV,END 后一次分发
+func (c *Client) handleNotification(line string) {
+	source, body, _ := strings.Cut(line, ":")
+
+	if source == clientNotifyType {
+		if c.pending != nil && strings.HasPrefix(body, "ENV,") {
+			env := strings.TrimPrefix(body, "ENV,")
+			if env == "END" {
+				c.emit(*c.pending)
+				c.pending = nil
+				return
+			}
+			name, value, _ := strings.Cut(env, "=")
+			c.pending.Env[name] = value
+			return
+		}
+
+		event := parseClientEvent(body)
+		event.Raw = line
+		if event.hasEnv() {
+			c.pending = &event
+			return
+		}
+		c.emit(event)
+		return
+	}
+
+	c.emit(Event{Type: source, Message: body, Raw: line})
+}
+
+// emit 将通知放入分发队列
+func (c *Client) emit(event Event) {
+	select {
+	case c.events <- event:
+	case <-c.done:
+	}
+}
+
+// dispatchLoop 在独立协程中调用 handler，handler 中可以继续执行命令
+func (c *Client) dispatchLoop() {
+	for {
+		select {
+		case event := <-c.events:
+			if c.handler != nil {
+				c.handler(event)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) closeWithErr(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// QuoteArg 按管理接口的规则为参数加引号
+func QuoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"\\") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range arg {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
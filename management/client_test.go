@@ -0,0 +1,306 @@
+// management/client_test.go
+package management
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer 模拟 OpenVPN 管理接口的一端
+type fakeServer struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newPipe 创建通过 net.Pipe 连接的客户端和模拟管理接口
+func newPipe(t *testing.T, handler func(Event)) (*Client, *fakeServer) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	serverConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c := NewClient(clientConn, handler)
+	c.Timeout = 2 * time.Second
+	t.Cleanup(func() {
+		c.Close()
+		serverConn.Close()
+	})
+	return c, &fakeServer{t: t, conn: serverConn, reader: bufio.NewReader(serverConn)}
+}
+
+// expect 读取客户端发送的一条命令并与 want 比较
+func (s *fakeServer) expect(want string) {
+	s.t.Helper()
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		s.t.Fatalf("读取命令失败: %v", err)
+	}
+	if got := strings.TrimRight(line, "\n"); got != want {
+		s.t.Fatalf("收到命令 %q, want %q", got, want)
+	}
+}
+
+// send 按管理接口的格式发送若干行
+func (s *fakeServer) send(lines ...string) {
+	s.t.Helper()
+	for _, line := range lines {
+		if _, err := s.conn.Write([]byte(line + "\r\n")); err != nil {
+			s.t.Fatalf("发送失败: %v", err)
+		}
+	}
+}
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+// run 在协程中执行客户端命令，模拟管理接口在当前协程中应答
+func run(f func() (interface{}, error)) <-chan result {
+	ch := make(chan result, 1)
+	go func() {
+		value, err := f()
+		ch <- result{value, err}
+	}()
+	return ch
+}
+
+func wait(t *testing.T, ch <-chan result) result {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待命令结果超时")
+	}
+	return result{}
+}
+
+func TestClientLogin(t *testing.T) {
+	c, server := newPipe(t, nil)
+
+	done := run(func() (interface{}, error) { return nil, c.Login("secret") })
+	server.expect("secret")
+	// 密码提示没有换行，与响应在同一行
+	if _, err := server.conn.Write([]byte(passwordPrompt + "SUCCESS: password is correct\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if r := wait(t, done); r.err != nil {
+		t.Fatalf("Login() err = %v", r.err)
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	c, server := newPipe(t, nil)
+
+	done := run(func() (interface{}, error) { return c.Status() })
+	server.expect("status 3")
+	server.send(
+		"TITLE\tOpenVPN 2.5.9 x86_64-pc-linux-gnu",
+		"TIME\t2024-06-15 08:30:00\t1718440200",
+		"HEADER\tCLIENT_LIST\tCommon Name\tReal Address\tVirtual Address\tVirtual IPv6 Address\tBytes Received\tBytes Sent\tConnected Since\tConnected Since (time_t)\tUsername\tClient ID\tPeer ID\tData Channel Cipher",
+		"CLIENT_LIST\tclient1\t203.0.113.5:51820\t10.100.0.2\t\t1024\t2048\t2024-06-15 08:00:00\t1718438400\tUNDEF\t7\t0\tAES-256-GCM",
+		"CLIENT_LIST\tclient2\t198.51.100.9:40000\t10.100.0.3\t\t10\t20\t2024-06-15 08:10:00\t1718439000\tUNDEF\t9\t1\tAES-256-GCM",
+		// 命令响应之间可能穿插实时通知
+		">CLIENT:ADDRESS,9,10.100.0.3,1",
+		"HEADER\tROUTING_TABLE\tVirtual Address\tCommon Name\tReal Address\tLast Ref\tLast Ref (time_t)",
+		"ROUTING_TABLE\t10.100.0.2\tclient1\t203.0.113.5:51820\t2024-06-15 08:29:00\t1718440140",
+		"GLOBAL_STATS\tMax bcast/mcast queue length\t0",
+		"END",
+	)
+
+	r := wait(t, done)
+	if r.err != nil {
+		t.Fatalf("Status() err = %v", r.err)
+	}
+	status := r.value.(*Status)
+	if len(status.Clients) != 2 {
+		t.Fatalf("len(Clients) = %d, want 2", len(status.Clients))
+	}
+	client := status.Clients[0]
+	if client.CommonName != "client1" || client.RealAddress != "203.0.113.5:51820" || client.VirtualAddress != "10.100.0.2" {
+		t.Errorf("Clients[0] = %+v", client)
+	}
+	if client.ClientID != 7 || client.BytesReceived != 1024 || client.BytesSent != 2048 {
+		t.Errorf("Clients[0] = %+v", client)
+	}
+	if client.ConnectedSince.Unix() != 1718438400 {
+		t.Errorf("ConnectedSince = %v", client.ConnectedSince)
+	}
+	if sessions := status.FindClient("client2"); len(sessions) != 1 || sessions[0].ClientID != 9 {
+		t.Errorf("FindClient(client2) = %+v", sessions)
+	}
+	if len(status.Routes) != 1 || status.Routes[0].CommonName != "client1" {
+		t.Errorf("Routes = %+v", status.Routes)
+	}
+	if status.GlobalStats["Max bcast/mcast queue length"] != "0" {
+		t.Errorf("GlobalStats = %v", status.GlobalStats)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	c, server := newPipe(t, nil)
+
+	done := run(func() (interface{}, error) { return c.Kill("client1") })
+	server.expect("kill client1")
+	server.send("SUCCESS: common name 'client1' found, 2 client(s) killed")
+	if r := wait(t, done); r.err != nil || r.value.(int) != 2 {
+		t.Errorf("Kill(client1) = %v, %v, want 2, nil", r.value, r.err)
+	}
+
+	// 未连接的客户端不是错误
+	done = run(func() (interface{}, error) { return c.Kill("my client") })
+	server.expect(`kill "my client"`)
+	server.send("ERROR: common name 'my client' not found")
+	if r := wait(t, done); r.err != nil || r.value.(int) != 0 {
+		t.Errorf("Kill(my client) = %v, %v, want 0, nil", r.value, r.err)
+	}
+
+	done = run(func() (interface{}, error) { return c.Kill("client1") })
+	server.expect("kill client1")
+	server.send("ERROR: permission denied")
+	r := wait(t, done)
+	if _, ok := r.err.(*CommandError); !ok {
+		t.Errorf("Kill() err = %v, want *CommandError", r.err)
+	}
+}
+
+func TestClientClientKill(t *testing.T) {
+	c, server := newPipe(t, nil)
+
+	done := run(func() (interface{}, error) { return nil, c.ClientKill(7, `证书已"吊销"`) })
+	server.expect(`client-kill 7 "证书已\"吊销\""`)
+	server.send("SUCCESS: client-kill command succeeded")
+	if r := wait(t, done); r.err != nil {
+		t.Errorf("ClientKill() err = %v", r.err)
+	}
+
+	done = run(func() (interface{}, error) { return nil, c.ClientKill(8, "") })
+	server.expect("client-kill 8")
+	server.send("ERROR: client-kill command failed")
+	r := wait(t, done)
+	cmdErr, ok := r.err.(*CommandError)
+	if !ok || cmdErr.Command != "client-kill 8" {
+		t.Errorf("ClientKill() err = %v, want *CommandError", r.err)
+	}
+}
+
+func TestClientClientAuthNT(t *testing.T) {
+	c, server := newPipe(t, nil)
+
+	done := run(func() (interface{}, error) { return nil, c.ClientAuthNT(7, 1) })
+	server.expect("client-auth-nt 7 1")
+	server.send("SUCCESS: client-auth command succeeded")
+	if r := wait(t, done); r.err != nil {
+		t.Errorf("ClientAuthNT() err = %v", r.err)
+	}
+}
+
+func TestClientNotifications(t *testing.T) {
+	events := make(chan Event, 8)
+	_, server := newPipe(t, func(event Event) { events <- event })
+
+	next := func() Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("等待实时通知超时")
+		}
+		return Event{}
+	}
+
+	// 是否放行由 handler 决定，客户端不会自动发送 client-auth-nt
+	server.send(
+		">CLIENT:CONNECT,7,1",
+		">CLIENT:ENV,common_name=client1",
+		">CLIENT:ENV,trusted_ip=203.0.113.5",
+		">CLIENT:ENV,trusted_port=51820",
+		">CLIENT:ENV,END",
+	)
+	event := next()
+	if event.Type != EventConnect || event.CID != 7 || event.KID != 1 {
+		t.Errorf("CONNECT event = %+v", event)
+	}
+	if event.CommonName() != "client1" || event.RealAddress() != "203.0.113.5:51820" {
+		t.Errorf("CONNECT env = %v", event.Env)
+	}
+
+	server.send(
+		">CLIENT:ESTABLISHED,7",
+		">CLIENT:ENV,common_name=client1",
+		">CLIENT:ENV,ifconfig_pool_remote_ip=10.100.0.2",
+		">CLIENT:ENV,time_unix=1718438400",
+		">CLIENT:ENV,END",
+		">CLIENT:ADDRESS,7,10.100.0.2,1",
+	)
+	event = next()
+	if event.Type != EventEstablished || event.CID != 7 || event.VirtualAddress() != "10.100.0.2" {
+		t.Errorf("ESTABLISHED event = %+v", event)
+	}
+	if event.ConnectedSince().Unix() != 1718438400 {
+		t.Errorf("ConnectedSince = %v", event.ConnectedSince())
+	}
+	event = next()
+	if event.Type != EventAddress || event.CID != 7 || event.Address != "10.100.0.2" || !event.Primary {
+		t.Errorf("ADDRESS event = %+v", event)
+	}
+
+	server.send(
+		">CLIENT:DISCONNECT,7",
+		">CLIENT:ENV,common_name=client1",
+		">CLIENT:ENV,bytes_received=1024",
+		">CLIENT:ENV,bytes_sent=2048",
+		">CLIENT:ENV,END",
+		">INFO:OpenVPN Management Interface Version 3 -- type 'help' for more info",
+	)
+	event = next()
+	if event.Type != EventDisconnect || event.BytesReceived() != 1024 || event.BytesSent() != 2048 {
+		t.Errorf("DISCONNECT event = %+v", event)
+	}
+	event = next()
+	if event.Type != EventInfo || !strings.HasPrefix(event.Message, "OpenVPN Management Interface") {
+		t.Errorf("INFO event = %+v", event)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	c, server := newPipe(t, nil)
+	c.Timeout = 50 * time.Millisecond
+
+	done := run(func() (interface{}, error) { return c.Command("version") })
+	server.expect("version")
+	if r := wait(t, done); r.err != ErrTimeout {
+		t.Fatalf("Command() err = %v, want ErrTimeout", r.err)
+	}
+
+	// 超时后连接关闭，后续命令直接返回错误
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("超时后连接没有关闭")
+	}
+	if _, err := c.Command("version"); err != ErrTimeout {
+		t.Errorf("Command() err = %v, want ErrTimeout", err)
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	tests := map[string]string{
+		"client1":   "client1",
+		"":          `""`,
+		"a b":       `"a b"`,
+		`a"b\c`:     `"a\"b\\c"`,
+		"客户端":       "客户端",
+		"tab\there": "\"tab\there\"",
+	}
+	for in, want := range tests {
+		if got := QuoteArg(in); got != want {
+			t.Errorf("QuoteArg(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// management/event.go
+package management
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 实时通知类型
+const (
+	EventConnect     = "CONNECT"
+	EventReauth      = "REAUTH"
+	EventEstablished = "ESTABLISHED"
+	EventDisconnect  = "DISCONNECT"
+	EventAddress     = "ADDRESS"
+	EventCRResponse  = "CR_RESPONSE"
+	EventInfo        = "INFO"
+)
+
+// Event 管理接口的实时通知
+// >CLIENT: 通知的 Type 为 CONNECT/ESTABLISHED/DISCONNECT 等，其它通知的 Type 为来源，如 INFO、LOG
+type Event struct {
+	Type    string
+	CID     int64 // 客户端ID
+	KID     int64 // 密钥ID，仅 CONNECT/REAUTH
+	Address string
+	Primary bool // 仅 ADDRESS
+	Message string
+	Env     map[string]string
+	Raw     string
+}
+
+// CommonName 返回客户端证书的 common name
+func (e Event) CommonName() string {
+	return e.Env["common_name"]
+}
+
+// RealAddress 返回客户端真实地址
+func (e Event) RealAddress() string {
+	addr := e.Env["trusted_ip"]
+	if addr == "" {
+		addr = e.Env["trusted_ip6"]
+	}
+	if port := e.Env["trusted_port"]; addr != "" && port != "" {
+		return addr + ":" + port
+	}
+	return addr
+}
+
+// VirtualAddress 返回客户端虚拟地址
+func (e Event) VirtualAddress() string {
+	return e.Env["ifconfig_pool_remote_ip"]
+}
+
+// BytesReceived 断开时服务端收到的字节数
+func (e Event) BytesReceived() int64 {
+	n, _ := strconv.ParseInt(e.Env["bytes_received"], 10, 64)
+	return n
+}
+
+// BytesSent 断开时服务端发送的字节数
+func (e Event) BytesSent() int64 {
+	n, _ := strconv.ParseInt(e.Env["bytes_sent"], 10, 64)
+	return n
+}
+
+// ConnectedSince 返回客户端连接时间
+func (e Event) ConnectedSince() time.Time {
+	n, err := strconv.ParseInt(e.Env["time_unix"], 10, 64)
+	if err != nil || n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(n, 0)
+}
+
+// hasEnv 通知后面是否跟随 >CLIENT:ENV 行
+func (e Event) hasEnv() bool {
+	switch e.Type {
+	case EventConnect, EventReauth, EventEstablished, EventDisconnect, EventCRResponse:
+		return true
+	}
+	return false
+}
+
+// parseClientEvent 解析 >CLIENT: 后面的内容
+//
+//	CONNECT,{CID},{KID}
+//	REAUTH,{CID},{KID}
+//	ESTABLISHED,{CID}
+//	DISCONNECT,{CID}
+//	ADDRESS,{CID},{ADDR},{PRI}
+//	CR_RESPONSE,{CID},{KID},{response_base64}
+func parseClientEvent(body string) Event {
+	fields := strings.Split(body, ",")
+	event := Event{Type: fields[0], CID: -1, KID: -1, Env: make(map[string]string)}
+	if len(fields) > 1 {
+		event.CID = parseInt(fields[1], -1)
+	}
+
+	switch event.Type {
+	case EventConnect, EventReauth:
+		if len(fields) > 2 {
+			event.KID = parseInt(fields[2], -1)
+		}
+	case EventAddress:
+		if len(fields) > 2 {
+			event.Address = fields[2]
+		}
+		if len(fields) > 3 {
+			event.Primary = fields[3] == "1"
+		}
+	case EventCRResponse:
+		if len(fields) > 2 {
+			event.KID = parseInt(fields[2], -1)
+		}
+		if len(fields) > 3 {
+			event.Message = fields[3]
+		}
+	}
+	return event
+}
+
+func parseInt(s string, def int64) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,309 @@
+// management/status.go
+package management
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statusV1Title   = "OpenVPN CLIENT LIST"
+	statusV1Routing = "ROUTING TABLE"
+	statusV1Global  = "GLOBAL STATS"
+	statusV1Time    = "Updated"
+	statusV1Layout  = time.ANSIC // Thu Jun  1 12:00:00 2023
+)
+
+// ClientSession status 中的一个已连接客户端
+type ClientSession struct {
+	CommonName         string
+	RealAddress        string
+	VirtualAddress     string
+	VirtualIPv6Address string
+	BytesReceived      int64
+	BytesSent          int64
+	ConnectedSince     time.Time
+	Username           string
+	ClientID           int64 // 管理接口客户端ID，client-kill 使用，旧版本为 -1
+	PeerID             int64
+	Cipher             string
+}
+
+// RoutingEntry status 中的一条路由
+type RoutingEntry struct {
+	VirtualAddress string
+	CommonName     string
+	RealAddress    string
+	LastRef        time.Time
+}
+
+// Status 解析后的 status 输出
+type Status struct {
+	Title       string
+	Time        time.Time
+	Clients     []ClientSession
+	Routes      []RoutingEntry
+	GlobalStats map[string]string
+}
+
+// FindClient 按 common name 查找已连接客户端，同一证书可能有多个连接
+func (s *Status) FindClient(commonName string) []ClientSession {
+	var sessions []ClientSession
+	for _, client := range s.Clients {
+		if client.CommonName == commonName {
+			sessions = append(sessions, client)
+		}
+	}
+	return sessions
+}
+
+// ParseStatusFile 读取 status 文件，自动识别 1/2/3 版本格式
+func ParseStatusFile(path string) (*Status, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseStatus(file)
+}
+
+// ParseStatus 读取 status 输出，自动识别 1/2/3 版本格式
+func ParseStatus(r io.Reader) (*Status, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == responseEnd {
+			break
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseStatusLines(lines)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ParseStatusLines 解析 status 输出，列按 HEADER 行定位，兼容不同 OpenVPN 版本的字段差异
+// status 1 没有 HEADER 行，各段落之前为标题行和列名行
+// status 2 以逗号分隔，status 3 以制表符分隔
+// ----------------------------------------------------------------------------------------------------------
+func ParseStatusLines(lines []string) (*Status, error) {
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if line == statusV1Title {
+			return parseStatusV1(lines)
+		}
+		break
+	}
+
+	status := &Status{GlobalStats: make(map[string]string)}
+	headers := make(map[string]map[string]int)
+
+	for _, line := range lines {
+		if line == "" || line == responseEnd {
+			continue
+		}
+		fields := splitStatusLine(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "TITLE":
+			if len(fields) > 1 {
+				status.Title = fields[1]
+			}
+		case "TIME":
+			if len(fields) > 2 {
+				status.Time = parseUnix(fields[2])
+			}
+		case "HEADER":
+			if len(fields) < 2 {
+				continue
+			}
+			columns := make(map[string]int)
+			// fields[1] 为表名，数据行的第 0 列同样为表名
+			for i, name := range fields[2:] {
+				columns[name] = i + 1
+			}
+			headers[fields[1]] = columns
+		case "CLIENT_LIST":
+			columns, ok := headers["CLIENT_LIST"]
+			if !ok {
+				return nil, fmt.Errorf("CLIENT_LIST 缺少 HEADER")
+			}
+			row := statusRow{fields: fields, columns: columns}
+			status.Clients = append(status.Clients, ClientSession{
+				CommonName:         row.get("Common Name"),
+				RealAddress:        row.get("Real Address"),
+				VirtualAddress:     row.get("Virtual Address"),
+				VirtualIPv6Address: row.get("Virtual IPv6 Address"),
+				BytesReceived:      parseInt(row.get("Bytes Received"), 0),
+				BytesSent:          parseInt(row.get("Bytes Sent"), 0),
+				ConnectedSince:     parseUnix(row.get("Connected Since (time_t)")),
+				Username:           row.get("Username"),
+				ClientID:           parseInt(row.get("Client ID"), -1),
+				PeerID:             parseInt(row.get("Peer ID"), -1),
+				Cipher:             row.get("Data Channel Cipher"),
+			})
+		case "ROUTING_TABLE":
+			columns, ok := headers["ROUTING_TABLE"]
+			if !ok {
+				return nil, fmt.Errorf("ROUTING_TABLE 缺少 HEADER")
+			}
+			row := statusRow{fields: fields, columns: columns}
+			status.Routes = append(status.Routes, RoutingEntry{
+				VirtualAddress: row.get("Virtual Address"),
+				CommonName:     row.get("Common Name"),
+				RealAddress:    row.get("Real Address"),
+				LastRef:        parseUnix(row.get("Last Ref (time_t)")),
+			})
+		case "GLOBAL_STATS":
+			if len(fields) > 2 {
+				status.GlobalStats[fields[1]] = fields[2]
+			}
+		}
+	}
+	return status, nil
+}
+
+// parseStatusV1 解析 status-version 1 格式
+//
+//	OpenVPN CLIENT LIST
+//	Updated,Thu Jun  1 12:00:00 2023
+//	Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since
+//	...
+//	ROUTING TABLE
+//	Virtual Address,Common Name,Real Address,Last Ref
+//	...
+//	GLOBAL STATS
+//	Max bcast/mcast queue length,0
+//	END
+func parseStatusV1(lines []string) (*Status, error) {
+	status := &Status{GlobalStats: make(map[string]string)}
+
+	section := ""
+	var columns map[string]int
+	for _, line := range lines {
+		if line == "" || line == responseEnd {
+			continue
+		}
+
+		switch line {
+		case statusV1Title, statusV1Routing, statusV1Global:
+			section = line
+			columns = nil
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if section == statusV1Title && fields[0] == statusV1Time {
+			if len(fields) > 1 {
+				status.Time = parseANSIC(fields[1])
+			}
+			continue
+		}
+		if section == statusV1Global {
+			if len(fields) > 1 {
+				status.GlobalStats[fields[0]] = fields[1]
+			}
+			continue
+		}
+
+		// 每个段落的第一行为列名
+		if columns == nil {
+			columns = make(map[string]int)
+			for i, name := range fields {
+				columns[name] = i
+			}
+			continue
+		}
+		row := statusRow{fields: fields, columns: columns}
+
+		switch section {
+		case statusV1Title:
+			status.Clients = append(status.Clients, ClientSession{
+				CommonName:     row.get("Common Name"),
+				RealAddress:    row.get("Real Address"),
+				BytesReceived:  parseInt(row.get("Bytes Received"), 0),
+				BytesSent:      parseInt(row.get("Bytes Sent"), 0),
+				ConnectedSince: parseANSIC(row.get("Connected Since")),
+				ClientID:       -1,
+				PeerID:         -1,
+			})
+		case statusV1Routing:
+			status.Routes = append(status.Routes, RoutingEntry{
+				VirtualAddress: row.get("Virtual Address"),
+				CommonName:     row.get("Common Name"),
+				RealAddress:    row.get("Real Address"),
+				LastRef:        parseANSIC(row.get("Last Ref")),
+			})
+		default:
+			return nil, fmt.Errorf("无法识别的 status 行: %s", line)
+		}
+	}
+
+	// 客户端列表中没有虚拟地址，从路由表中补充（tap 模式下路由表中还有 MAC 地址）
+	for i := range status.Clients {
+		client := &status.Clients[i]
+		for _, route := range status.Routes {
+			if route.CommonName != client.CommonName || route.RealAddress != client.RealAddress {
+				continue
+			}
+			if net.ParseIP(route.VirtualAddress) != nil {
+				client.VirtualAddress = route.VirtualAddress
+				break
+			}
+		}
+	}
+	return status, nil
+}
+
+type statusRow struct {
+	fields  []string
+	columns map[string]int
+}
+
+func (r statusRow) get(name string) string {
+	idx, ok := r.columns[name]
+	if !ok || idx >= len(r.fields) {
+		return ""
+	}
+	return r.fields[idx]
+}
+
+// splitStatusLine 根据行首关键字后的分隔符拆分
+func splitStatusLine(line string) []string {
+	idx := strings.IndexAny(line, "\t,")
+	if idx < 0 {
+		return []string{line}
+	}
+	return strings.Split(line, string(line[idx]))
+}
+
+// parseANSIC status 1 中的时间为本地时间
+func parseANSIC(s string) time.Time {
+	t, err := time.ParseInLocation(statusV1Layout, strings.TrimSpace(s), time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func parseUnix(s string) time.Time {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(n, 0)
+}
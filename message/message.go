@@ -1,65 +1,380 @@
 package message
 
 import (
+	"bytes"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// 连接 SMTP 服务器的方式
+const (
+	SECURITYTLS      = "tls"      // 隐式 TLS，通常为 465 端口
+	SECURITYSTARTTLS = "starttls" // 明文连接后升级为 TLS，通常为 587 端口
+	SECURITYPLAIN    = "plain"    // 不加密，只用于内网或本机的 SMTP 服务
+)
+
+// 未设置 Timeout 时连接和发送的超时时间
+const smtpTimeout = 30 * time.Second
+
 type EmailSender struct {
 	Host     string
 	Port     int
-	Username string
+	Username string // 为空时不认证
 	Password string
 	From     string
 	Name     string
+	Security string        // tls/starttls/plain，为空时 465 端口使用 tls，其它端口使用 starttls
+	Timeout  time.Duration // 为 0 时使用 30 秒
+
+	// 以下字段为空时使用默认值，可替换为测试用的 SMTP 服务
+	Dial      func(network string, addr string) (net.Conn, error)
+	TLSConfig *tls.Config
 }
 
-// SendMail 发送邮件（支持 HTML）
-func (s *EmailSender) SendMail(to []string, subject string, body string, isHTML bool) error {
-	auth := smtp.PlainAuth("", s.Username, s.Password, s.Host)
+// Attachment 邮件附件，ContentType 为空时按文件扩展名判断
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
 
-	fromHeader := fmt.Sprintf("%s <%s>", s.Name, s.From)
+// Mail 一封邮件，Text 和 HTML 都设置时以 multipart/alternative 发送
+type Mail struct {
+	To          []string
+	Cc          []string
+	Bcc         []string // 只出现在 RCPT 中，不写入邮件头
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
 
-	headers := make(map[string]string)
-	headers["From"] = fromHeader
-	headers["To"] = to[0]
-	headers["Subject"] = subject
-	headers["MIME-Version"] = "1.0"
+// SendMail 发送邮件（支持 HTML）
+func (s *EmailSender) SendMail(to []string, subject string, body string, isHTML bool) error {
+	m := Mail{To: to, Subject: subject}
 	if isHTML {
-		headers["Content-Type"] = "text/html; charset=UTF-8"
+		m.HTML = body
 	} else {
-		headers["Content-Type"] = "text/plain; charset=UTF-8"
+		m.Text = body
 	}
+	return s.Send(m)
+}
 
-	// 构造消息体
-	message := ""
-	for k, v := range headers {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
+// SendAlternative 发送同时带纯文本和 HTML 的邮件，客户端选择能显示的格式
+func (s *EmailSender) SendAlternative(to []string, subject string, text string, htmlBody string) error {
+	return s.Send(Mail{To: to, Subject: subject, Text: text, HTML: htmlBody})
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Send 构造并发送邮件，收件人为 To、Cc 和 Bcc 的合集
+// ----------------------------------------------------------------------------------------------------------
+func (s *EmailSender) Send(m Mail) error {
+	data, err := s.Build(m, time.Now())
+	if err != nil {
+		return err
 	}
-	message += "\r\n" + body
 
-	// 连接 SMTP server
-	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
-	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: s.Host})
+	var rcpts []string
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		for _, addr := range list {
+			parsed, err := mail.ParseAddress(addr)
+			if err != nil {
+				return fmt.Errorf("invalid recipient %q: %v", addr, err)
+			}
+			rcpts = append(rcpts, parsed.Address)
+		}
+	}
+	return s.deliver(rcpts, data)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Build 按 RFC 5322 构造邮件，邮件头顺序固定，主题和显示名按 RFC 2047 编码
+// 正文使用 quoted-printable，附件使用 base64
+// ----------------------------------------------------------------------------------------------------------
+func (s *EmailSender) Build(m Mail, now time.Time) ([]byte, error) {
+	if len(m.To)+len(m.Cc)+len(m.Bcc) == 0 {
+		return nil, errors.New("no recipient")
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return nil, errors.New("subject cannot contain line breaks")
+	}
+	from, err := mail.ParseAddress(s.From)
 	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %v", s.From, err)
+	}
+	from.Name = s.Name
+
+	var buf bytes.Buffer
+	writeHeader := func(key string, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+	writeHeader("From", from.String())
+	if len(m.To) > 0 {
+		to, err := formatAddressList(m.To)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader("To", to)
+	}
+	if len(m.Cc) > 0 {
+		cc, err := formatAddressList(m.Cc)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader("Cc", cc)
+	}
+	writeHeader("Subject", mime.BEncoding.Encode("UTF-8", m.Subject))
+	writeHeader("Date", now.Format(time.RFC1123Z))
+	writeHeader("Message-ID", messageID(from.Address))
+	writeHeader("MIME-Version", "1.0")
+
+	header, content, err := buildBody(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Attachments) == 0 {
+		for _, key := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+			if value := header.Get(key); value != "" {
+				writeHeader(key, value)
+			}
+		}
+		buf.WriteString("\r\n")
+		buf.Write(content)
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	writeHeader("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()}))
+	buf.WriteString("\r\n")
+
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(content)
+	for _, attachment := range m.Attachments {
+		if err := writeAttachment(mixed, attachment); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// buildBody 返回正文的 MIME 头和编码后的内容，Text 和 HTML 都设置时为 multipart/alternative
+func buildBody(m Mail) (textproto.MIMEHeader, []byte, error) {
+	header := textproto.MIMEHeader{}
+	var buf bytes.Buffer
+
+	if m.Text != "" && m.HTML != "" {
+		alternative := multipart.NewWriter(&buf)
+		header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+		for _, body := range []struct{ contentType, content string }{
+			{"text/plain; charset=UTF-8", m.Text},
+			{"text/html; charset=UTF-8", m.HTML},
+		} {
+			partHeader := textproto.MIMEHeader{}
+			partHeader.Set("Content-Type", body.contentType)
+			partHeader.Set("Content-Transfer-Encoding", "quoted-printable")
+			part, err := alternative.CreatePart(partHeader)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := writeQuotedPrintable(part, body.content); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := alternative.Close(); err != nil {
+			return nil, nil, err
+		}
+		return header, buf.Bytes(), nil
+	}
+
+	header.Set("Content-Type", "text/plain; charset=UTF-8")
+	content := m.Text
+	if m.HTML != "" {
+		header.Set("Content-Type", "text/html; charset=UTF-8")
+		content = m.HTML
+	}
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	if err := writeQuotedPrintable(&buf, content); err != nil {
+		return nil, nil, err
+	}
+	return header, buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
 		return err
 	}
-	c, err := smtp.NewClient(conn, s.Host)
+	return qp.Close()
+}
+
+func writeAttachment(w *multipart.Writer, attachment Attachment) error {
+	if attachment.Filename == "" || strings.ContainsAny(attachment.Filename, "\r\n") {
+		return fmt.Errorf("invalid attachment filename %q", attachment.Filename)
+	}
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(extension(attachment.Filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	// base64 每行 76 个字符
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	_, err = part.Write([]byte(encoded + "\r\n"))
+	return err
+}
+
+func extension(filename string) string {
+	if index := strings.LastIndex(filename, "."); index >= 0 {
+		return filename[index:]
+	}
+	return ""
+}
+
+func formatAddressList(list []string) (string, error) {
+	formatted := make([]string, 0, len(list))
+	for _, addr := range list {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return "", fmt.Errorf("invalid recipient %q: %v", addr, err)
+		}
+		formatted = append(formatted, parsed.String())
+	}
+	return strings.Join(formatted, ", "), nil
+}
+
+// messageID 生成 <随机数@发件域名>
+func messageID(from string) string {
+	domain := "localhost"
+	if index := strings.LastIndex(from, "@"); index >= 0 {
+		domain = from[index+1:]
+	}
+	random := make([]byte, 12)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
+
+// security 返回实际使用的连接方式
+func (s *EmailSender) security() string {
+	if s.Security != "" {
+		return s.Security
+	}
+	if s.Port == 465 {
+		return SECURITYTLS
+	}
+	return SECURITYSTARTTLS
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// deliver 连接 SMTP 服务器发送已构造好的邮件
+// ----------------------------------------------------------------------------------------------------------
+func (s *EmailSender) deliver(rcpts []string, data []byte) error {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = smtpTimeout
+	}
+	dial := s.Dial
+	if dial == nil {
+		dialer := &net.Dialer{Timeout: timeout}
+		dial = dialer.Dial
+	}
+	tlsConfig := s.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: s.Host}
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	conn, err := dial("tcp", addr)
 	if err != nil {
 		return err
 	}
-	defer c.Quit()
+	conn.SetDeadline(time.Now().Add(timeout))
 
-	if err = c.Auth(auth); err != nil {
+	security := s.security()
+	switch security {
+	case SECURITYTLS:
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	case SECURITYSTARTTLS, SECURITYPLAIN:
+	default:
+		conn.Close()
+		return fmt.Errorf("unknown smtp security: %s", security)
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
 		return err
 	}
+	defer c.Close()
+
+	if security == SECURITYSTARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	// 配置了用户名时必须认证，服务器不支持 AUTH 时不能跳过认证直接发送
+	if s.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support AUTH")
+		}
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
 
-	if err = c.Mail(s.From); err != nil {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+	if err = c.Mail(from.Address); err != nil {
 		return err
 	}
-	for _, addr := range to {
-		if err = c.Rcpt(addr); err != nil {
+	for _, rcpt := range rcpts {
+		if err = c.Rcpt(rcpt); err != nil {
 			return err
 		}
 	}
@@ -67,9 +382,11 @@ func (s *EmailSender) SendMail(to []string, subject string, body string, isHTML
 	if err != nil {
 		return err
 	}
-	_, err = w.Write([]byte(message))
-	if err != nil {
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
 		return err
 	}
-	return w.Close()
+	return c.Quit()
 }
@@ -0,0 +1,424 @@
+// message/message_test.go
+package message
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSMTPHost = "mail.example.test"
+
+// smtpSession 模拟的 SMTP 服务收到的一次会话
+type smtpSession struct {
+	TLS   bool
+	Auth  string
+	From  string
+	Rcpts []string
+	Data  []byte
+}
+
+// fakeSMTP 进程内的 SMTP 服务，通过 EmailSender.Dial 接入
+type fakeSMTP struct {
+	implicitTLS bool // 连接建立后直接 TLS 握手
+	startTLS    bool // 支持 STARTTLS
+	auth        bool // 支持 AUTH PLAIN
+
+	serverTLS *tls.Config
+	clientTLS *tls.Config
+	sessions  chan smtpSession
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: testSMTPHost},
+		DNSNames:              []string{testSMTPHost},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &fakeSMTP{
+		serverTLS: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		clientTLS: &tls.Config{ServerName: testSMTPHost, RootCAs: pool},
+		sessions:  make(chan smtpSession, 1),
+	}
+}
+
+// sender 返回连接到模拟服务的 EmailSender
+func (f *fakeSMTP) sender(security string) *EmailSender {
+	return &EmailSender{
+		Host:     testSMTPHost,
+		Port:     587,
+		From:     "noreply@example.test",
+		Name:     "JWireGuard 通知",
+		Security: security,
+		Timeout:  5 * time.Second,
+		Dial: func(network string, addr string) (net.Conn, error) {
+			server, client := net.Pipe()
+			go f.serve(server)
+			return client, nil
+		},
+		TLSConfig: f.clientTLS,
+	}
+}
+
+// session 返回最近一次会话，连接关闭后才会返回
+func (f *fakeSMTP) session(t *testing.T) smtpSession {
+	t.Helper()
+	select {
+	case session := <-f.sessions:
+		return session
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待 SMTP 会话超时")
+	}
+	return smtpSession{}
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	var session smtpSession
+	raw := conn
+	defer func() {
+		raw.Close()
+		f.sessions <- session
+	}()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if f.implicitTLS {
+		tlsConn := tls.Server(conn, f.serverTLS)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		conn = tlsConn
+		session.TLS = true
+	}
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 %s ESMTP", testSMTPHost)
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{testSMTPHost}
+			if f.startTLS && !session.TLS {
+				lines = append(lines, "STARTTLS")
+			}
+			if f.auth {
+				lines = append(lines, "AUTH PLAIN")
+			}
+			lines = append(lines, "8BITMIME")
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(conn, f.serverTLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			session.TLS = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if !f.auth || mechanism != "PLAIN" || err != nil {
+				tp.PrintfLine("504 5.5.4 Unrecognized authentication type")
+				continue
+			}
+			session.Auth = string(decoded)
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			// MAIL FROM:<addr> BODY=8BITMIME
+			from, _, _ := strings.Cut(strings.TrimPrefix(arg, "FROM:"), " ")
+			session.From = strings.Trim(from, "<>")
+			tp.PrintfLine("250 2.1.0 Ok")
+		case "RCPT":
+			rcpt, _, _ := strings.Cut(strings.TrimPrefix(arg, "TO:"), " ")
+			session.Rcpts = append(session.Rcpts, strings.Trim(rcpt, "<>"))
+			tp.PrintfLine("250 2.1.5 Ok")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			session.Data, err = tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			tp.PrintfLine("250 2.0.0 Ok: queued")
+		case "QUIT":
+			tp.PrintfLine("221 2.0.0 Bye")
+			// net.Pipe 没有缓冲，等客户端发送 close_notify 并关闭连接后再返回
+			io.Copy(io.Discard, conn)
+			return
+		default:
+			tp.PrintfLine("250 2.0.0 Ok")
+		}
+	}
+}
+
+// readPart 读取 MIME 段并按 Content-Transfer-Encoding 解码
+func readPart(t *testing.T, part *multipart.Part) []byte {
+	t.Helper()
+	var r io.Reader = part
+	switch part.Header.Get("Content-Transfer-Encoding") {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, part)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(part)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSendStartTLS(t *testing.T) {
+	server := newFakeSMTP(t)
+	server.startTLS = true
+	server.auth = true
+	sender := server.sender(SECURITYSTARTTLS)
+	sender.Username = "noreply@example.test"
+	sender.Password = "secret"
+
+	ovpn := []byte("client\ndev tap\nproto udp\n")
+	archive := bytes.Repeat([]byte{0x50, 0x4b, 0x03, 0x04, 0x00, 0xff}, 40)
+	m := Mail{
+		To:      []string{"张三 <zhangsan@example.test>"},
+		Cc:      []string{"ops@example.test"},
+		Bcc:     []string{"audit@example.test"},
+		Subject: "客户端配置文件 client1",
+		Text:    "请查收附件中的配置文件。",
+		HTML:    "<p>请查收附件中的配置文件。</p>",
+		Attachments: []Attachment{
+			{Filename: "client1.ovpn", ContentType: "application/x-openvpn-profile", Data: ovpn},
+			{Filename: "client1.zip", Data: archive},
+		},
+	}
+	if err := sender.Send(m); err != nil {
+		t.Fatalf("Send() err = %v", err)
+	}
+	session := server.session(t)
+
+	if !session.TLS {
+		t.Error("没有通过 STARTTLS 升级连接")
+	}
+	if session.Auth != "\x00noreply@example.test\x00secret" {
+		t.Errorf("AUTH PLAIN = %q", session.Auth)
+	}
+	if session.From != "noreply@example.test" {
+		t.Errorf("MAIL FROM = %q", session.From)
+	}
+	// Bcc 只出现在 RCPT 中
+	wantRcpts := []string{"zhangsan@example.test", "ops@example.test", "audit@example.test"}
+	if !reflect.DeepEqual(session.Rcpts, wantRcpts) {
+		t.Errorf("RCPT TO = %v, want %v", session.Rcpts, wantRcpts)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(session.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawSubject := msg.Header.Get("Subject")
+	if !strings.HasPrefix(rawSubject, "=?UTF-8?b?") {
+		t.Errorf("Subject 没有按 RFC 2047 编码: %q", rawSubject)
+	}
+	decoder := new(mime.WordDecoder)
+	if subject, err := decoder.DecodeHeader(rawSubject); err != nil || subject != m.Subject {
+		t.Errorf("Subject = %q, %v, want %q", subject, err, m.Subject)
+	}
+	if from, err := msg.Header.AddressList("From"); err != nil || len(from) != 1 || from[0].Name != "JWireGuard 通知" {
+		t.Errorf("From = %v, %v", from, err)
+	}
+	if to, err := msg.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Name != "张三" {
+		t.Errorf("To = %v, %v", to, err)
+	}
+	if msg.Header.Get("Cc") != "<ops@example.test>" {
+		t.Errorf("Cc = %q", msg.Header.Get("Cc"))
+	}
+	if msg.Header.Get("Bcc") != "" || bytes.Contains(session.Data, []byte("audit@example.test")) {
+		t.Error("Bcc 收件人出现在邮件中")
+	}
+	if msg.Header.Get("Message-ID") == "" || msg.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("缺少 Message-ID 或 MIME-Version: %v", msg.Header)
+	}
+
+	// multipart/mixed: 正文(multipart/alternative) + 两个附件
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q, %v", msg.Header.Get("Content-Type"), err)
+	}
+	mixed := multipart.NewReader(msg.Body, params["boundary"])
+
+	body, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err = mime.ParseMediaType(body.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("正文 Content-Type = %q, %v", body.Header.Get("Content-Type"), err)
+	}
+	alternative := multipart.NewReader(body, params["boundary"])
+	for _, want := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		part, err := alternative.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.Header.Get("Content-Type") != want.contentType {
+			t.Errorf("Content-Type = %q, want %q", part.Header.Get("Content-Type"), want.contentType)
+		}
+		if got := string(readPart(t, part)); got != want.content {
+			t.Errorf("正文 = %q, want %q", got, want.content)
+		}
+	}
+	if _, err := alternative.NextPart(); err != io.EOF {
+		t.Errorf("multipart/alternative 多出了段落, err = %v", err)
+	}
+
+	for _, want := range []struct {
+		filename    string
+		contentType string
+		data        []byte
+	}{
+		{"client1.ovpn", "application/x-openvpn-profile", ovpn},
+		{"client1.zip", "application/zip", archive},
+	} {
+		part, err := mixed.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.FileName() != want.filename {
+			t.Errorf("附件文件名 = %q, want %q", part.FileName(), want.filename)
+		}
+		if part.Header.Get("Content-Type") != want.contentType {
+			t.Errorf("附件 Content-Type = %q, want %q", part.Header.Get("Content-Type"), want.contentType)
+		}
+		if got := readPart(t, part); !bytes.Equal(got, want.data) {
+			t.Errorf("附件 %s 内容不一致", want.filename)
+		}
+	}
+	if _, err := mixed.NextPart(); err != io.EOF {
+		t.Errorf("multipart/mixed 多出了段落, err = %v", err)
+	}
+}
+
+func TestSendImplicitTLS(t *testing.T) {
+	server := newFakeSMTP(t)
+	server.implicitTLS = true
+	sender := server.sender("")
+	sender.Port = 465
+
+	if err := sender.SendMail([]string{"ops@example.test"}, "test", "hello", false); err != nil {
+		t.Fatalf("SendMail() err = %v", err)
+	}
+	session := server.session(t)
+	if !session.TLS || session.Auth != "" {
+		t.Errorf("session = %+v", session)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(session.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("Subject") != "test" {
+		t.Errorf("Subject = %q", msg.Header.Get("Subject"))
+	}
+	if msg.Header.Get("Content-Type") != "text/plain; charset=UTF-8" ||
+		msg.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+		t.Errorf("单一正文的邮件头 = %v", msg.Header)
+	}
+}
+
+func TestSendRequiresAuth(t *testing.T) {
+	server := newFakeSMTP(t)
+	server.startTLS = true
+	sender := server.sender(SECURITYSTARTTLS)
+	sender.Username = "noreply@example.test"
+	sender.Password = "secret"
+
+	err := sender.SendMail([]string{"ops@example.test"}, "test", "hello", false)
+	if err == nil || !strings.Contains(err.Error(), "AUTH") {
+		t.Fatalf("SendMail() err = %v, want AUTH error", err)
+	}
+	// 认证失败时不能继续发送
+	if session := server.session(t); session.From != "" || session.Data != nil {
+		t.Errorf("没有认证就发送了邮件: %+v", session)
+	}
+}
+
+func TestSendRequiresStartTLS(t *testing.T) {
+	server := newFakeSMTP(t)
+	server.auth = true
+	sender := server.sender(SECURITYSTARTTLS)
+	sender.Username = "noreply@example.test"
+	sender.Password = "secret"
+
+	err := sender.SendMail([]string{"ops@example.test"}, "test", "hello", false)
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("SendMail() err = %v, want STARTTLS error", err)
+	}
+	if session := server.session(t); session.Auth != "" || session.Data != nil {
+		t.Errorf("明文连接上发送了凭据或邮件: %+v", session)
+	}
+}
+
+func TestBuildRejectsInvalidMail(t *testing.T) {
+	sender := &EmailSender{From: "noreply@example.test"}
+	tests := map[string]Mail{
+		"no recipient":     {Subject: "test", Text: "hello"},
+		"header injection": {To: []string{"ops@example.test"}, Subject: "test\r\nBcc: evil@example.test"},
+		"bad recipient":    {To: []string{"not an address"}, Subject: "test"},
+		"bad attachment":   {To: []string{"ops@example.test"}, Attachments: []Attachment{{Filename: "a\r\n.txt"}}},
+	}
+	for name, m := range tests {
+		if _, err := sender.Build(m, time.Now()); err == nil {
+			t.Errorf("%s: Build() 没有返回错误", name)
+		}
+	}
+}
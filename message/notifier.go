@@ -0,0 +1,273 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 通知事件类型
+const (
+	EVENTALL           = "*" // 路由规则中匹配所有事件
+	EVENTDEVICEONLINE  = "device_online"
+	EVENTDEVICEOFFLINE = "device_offline"
+	EVENTDEVICEFLAP    = "device_unstable" // 状态频繁变化
+	EVENTDEVICESTABLE  = "device_stable"   // 不稳定后恢复稳定
+	EVENTCERTEXPIRY    = "cert_expiry"
+	EVENTCERTRENEWED   = "cert_renewed"
+)
+
+// 通知渠道类型
+const (
+	CHANNELEMAIL    = "email"
+	CHANNELWEBHOOK  = "webhook"
+	CHANNELDINGTALK = "dingtalk"
+	CHANNELWECOM    = "wecom"
+	CHANNELFEISHU   = "feishu"
+)
+
+var events = map[string]bool{
+	EVENTALL:           true,
+	EVENTDEVICEONLINE:  true,
+	EVENTDEVICEOFFLINE: true,
+	EVENTDEVICEFLAP:    true,
+	EVENTDEVICESTABLE:  true,
+	EVENTCERTEXPIRY:    true,
+	EVENTCERTRENEWED:   true,
+}
+
+// ValidEvent 是否为路由规则可以使用的事件类型
+func ValidEvent(event string) bool {
+	return events[event]
+}
+
+// 事件级别，订阅时按最低级别过滤
+const (
+	SEVERITYINFO     = "info"
+	SEVERITYWARNING  = "warning"
+	SEVERITYCRITICAL = "critical"
+)
+
+var severityRanks = map[string]int{
+	SEVERITYINFO:     1,
+	SEVERITYWARNING:  2,
+	SEVERITYCRITICAL: 3,
+}
+
+var eventSeverities = map[string]string{
+	EVENTDEVICEONLINE:  SEVERITYINFO,
+	EVENTDEVICEOFFLINE: SEVERITYCRITICAL,
+	EVENTDEVICEFLAP:    SEVERITYWARNING,
+	EVENTDEVICESTABLE:  SEVERITYINFO,
+	EVENTCERTEXPIRY:    SEVERITYWARNING,
+	EVENTCERTRENEWED:   SEVERITYINFO,
+}
+
+// ValidSeverity 是否为有效的事件级别
+func ValidSeverity(severity string) bool {
+	return severityRanks[severity] > 0
+}
+
+// EventSeverity 返回事件的级别，未知事件为 info
+func EventSeverity(event string) string {
+	if severity, ok := eventSeverities[event]; ok {
+		return severity
+	}
+	return SEVERITYINFO
+}
+
+// SeverityAtLeast severity 是否不低于 min，min 为空时不过滤
+func SeverityAtLeast(severity string, min string) bool {
+	return min == "" || severityRanks[severity] >= severityRanks[min]
+}
+
+// Notification 一条通知，邮件使用 HTML，其它渠道使用 Title 和 Text
+type Notification struct {
+	Event string   `json:"event"`
+	SerID string   `json:"ser_id"`
+	CliID string   `json:"cli_id"`
+	Title string   `json:"title"`
+	Text  string   `json:"text"` // 纯文本，每行一项
+	HTML  string   `json:"-"`
+	To    []string `json:"-"` // 邮件渠道没有配置收件人时使用
+	Time  int64    `json:"time"`
+}
+
+// Notifier 通知渠道
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// ChannelConfig 通知渠道的配置，Secret 为空时不签名
+type ChannelConfig struct {
+	Type   string
+	URL    string
+	Secret string
+	To     []string // 邮件收件人
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// NewNotifier 按渠道类型创建 Notifier，邮件渠道使用 sender 发送
+// ----------------------------------------------------------------------------------------------------------
+func NewNotifier(config ChannelConfig, sender *EmailSender) (Notifier, error) {
+	switch config.Type {
+	case CHANNELEMAIL:
+		if sender == nil {
+			return nil, errors.New("email sender is not configured")
+		}
+		return &EmailNotifier{Sender: sender, To: config.To}, nil
+	case CHANNELWEBHOOK:
+		if config.URL == "" {
+			return nil, errors.New("webhook url cannot be empty")
+		}
+		return &WebhookNotifier{URL: config.URL, Secret: config.Secret}, nil
+	case CHANNELDINGTALK:
+		if config.URL == "" {
+			return nil, errors.New("dingtalk webhook cannot be empty")
+		}
+		return &DingTalkNotifier{Webhook: config.URL, Secret: config.Secret}, nil
+	case CHANNELWECOM:
+		if config.URL == "" {
+			return nil, errors.New("wecom webhook cannot be empty")
+		}
+		return &WeComNotifier{Webhook: config.URL}, nil
+	case CHANNELFEISHU:
+		if config.URL == "" {
+			return nil, errors.New("feishu webhook cannot be empty")
+		}
+		return &FeishuNotifier{Webhook: config.URL, Secret: config.Secret}, nil
+	}
+	return nil, fmt.Errorf("unknown channel type: %s", config.Type)
+}
+
+// EmailNotifier 通过邮件发送通知
+type EmailNotifier struct {
+	Sender *EmailSender
+	To     []string
+}
+
+func (e *EmailNotifier) Notify(n Notification) error {
+	to := e.To
+	if len(to) == 0 {
+		to = n.To
+	}
+	if len(to) == 0 {
+		return errors.New("no email recipient")
+	}
+	if n.HTML != "" && n.Text != "" {
+		return e.Sender.SendAlternative(to, n.Title, n.Text, n.HTML)
+	}
+	if n.HTML != "" {
+		return e.Sender.SendMail(to, n.Title, n.HTML, true)
+	}
+	return e.Sender.SendMail(to, n.Title, n.Text, false)
+}
+
+// Route 路由规则，Event 为 * 时匹配所有事件，SerID 为空时匹配所有子网
+type Route struct {
+	Event   string
+	SerID   string
+	Channel string
+}
+
+// Router 按事件类型和子网选择通知渠道
+type Router struct {
+	mu       sync.RWMutex
+	channels map[string]Notifier
+	routes   []Route
+	fallback Notifier
+}
+
+// NewRouter 创建路由，没有匹配的规则时使用 fallback
+func NewRouter(fallback Notifier) *Router {
+	return &Router{channels: make(map[string]Notifier), fallback: fallback}
+}
+
+// Set 替换全部渠道和规则
+func (r *Router) Set(channels map[string]Notifier, routes []Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.channels = channels
+	r.routes = routes
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Match 返回事件使用的渠道名称
+// 指定了子网的规则优先，子网没有匹配的规则时使用不限子网的规则
+// ----------------------------------------------------------------------------------------------------------
+func (r *Router) Match(event string, serId string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var subnet, common []string
+	for _, route := range r.routes {
+		if route.Event != EVENTALL && route.Event != event {
+			continue
+		}
+		if _, ok := r.channels[route.Channel]; !ok {
+			continue
+		}
+		if route.SerID == "" {
+			common = appendUnique(common, route.Channel)
+		} else if route.SerID == serId {
+			subnet = appendUnique(subnet, route.Channel)
+		}
+	}
+	if len(subnet) > 0 {
+		return subnet
+	}
+	return common
+}
+
+// Targets 返回事件使用的渠道名称，没有匹配的规则时返回空名称表示使用 fallback
+func (r *Router) Targets(event string, serId string) []string {
+	names := r.Match(event, serId)
+	if len(names) > 0 {
+		return names
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.fallback == nil {
+		return nil
+	}
+	return []string{""}
+}
+
+// Send 通过指定的渠道发送，空名称使用 fallback
+func (r *Router) Send(channel string, n Notification) error {
+	r.mu.RLock()
+	notifier, ok := r.channels[channel]
+	if channel == "" {
+		notifier, ok = r.fallback, r.fallback != nil
+	}
+	r.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("channel %q not found or disabled", channel)
+	}
+	return notifier.Notify(n)
+}
+
+// Notify 发送到所有匹配的渠道，返回各渠道的错误
+func (r *Router) Notify(n Notification) error {
+	var errs []string
+	for _, name := range r.Targets(n.Event, n.SerID) {
+		if err := r.Send(name, n); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,230 @@
+package message
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+)
+
+// 通知之外的模板
+const (
+	TEMPLATEMAILCODE        = "mail_code"
+	TEMPLATECLIPROFILE      = "cli_profile"          // 邮件发送客户端配置
+	TEMPLATEPROFILEPASSWORD = "cli_profile_password" // 通过其它渠道发送配置的解压密码
+	TEMPLATEDIGEST          = "digest"               // 每日/每周摘要
+	TEMPLATEQUIETSUMMARY    = "quiet_summary"        // 免打扰期间暂存的通知
+	TEMPLATELAYOUT          = "layout"               // 公共部分，不能单独渲染
+)
+
+// 没有对应语言的模板时使用
+const DefaultLocale = "zh"
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// 模板名称和语言只能包含字母、数字、下划线和连字符，避免读取模板目录以外的文件
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Rendered 渲染后的主题、纯文本和 HTML
+type Rendered struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// TemplateRegistry 按名称和语言读取模板，Dir 下的 <locale>/<name>.tmpl 优先于内置模板
+// 每个模板定义 subject、text 和 html 三个部分，可以引用同一语言 layout.tmpl 中的公共部分
+// 每次渲染都重新读取，修改模板文件后不需要重启
+// ----------------------------------------------------------------------------------------------------------
+type TemplateRegistry struct {
+	Dir    string // 自定义模板目录，为空时只使用内置模板
+	Locale string // 默认语言
+	Server string // 模板中的 {{.Server}}
+}
+
+// Names 返回所有可以渲染的模板名称
+func (r *TemplateRegistry) Names() []string {
+	return []string{
+		EVENTDEVICEONLINE,
+		EVENTDEVICEOFFLINE,
+		EVENTDEVICEFLAP,
+		EVENTDEVICESTABLE,
+		EVENTCERTEXPIRY,
+		EVENTCERTRENEWED,
+		TEMPLATEMAILCODE,
+		TEMPLATECLIPROFILE,
+		TEMPLATEPROFILEPASSWORD,
+		TEMPLATEDIGEST,
+		TEMPLATEQUIETSUMMARY,
+	}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Render 渲染模板，locale 为空时使用默认语言，data 中的 Server 由 TemplateRegistry 填写
+// ----------------------------------------------------------------------------------------------------------
+func (r *TemplateRegistry) Render(name string, locale string, data map[string]interface{}) (Rendered, error) {
+	if !templateNamePattern.MatchString(name) || name == TEMPLATELAYOUT {
+		return Rendered{}, fmt.Errorf("invalid template name: %q", name)
+	}
+	if locale == "" {
+		locale = r.Locale
+	}
+	if locale != "" && !templateNamePattern.MatchString(locale) {
+		return Rendered{}, fmt.Errorf("invalid locale: %q", locale)
+	}
+
+	body, locale, err := r.read(name, locale)
+	if err != nil {
+		return Rendered{}, err
+	}
+	layout, _, err := r.read(TEMPLATELAYOUT, locale)
+	if err != nil {
+		return Rendered{}, err
+	}
+
+	values := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		values[k] = v
+	}
+	values["Server"] = r.Server
+
+	textTpl := texttemplate.New(name).Option("missingkey=zero")
+	if _, err := textTpl.Parse(layout); err != nil {
+		return Rendered{}, fmt.Errorf("parse %s/%s: %v", locale, TEMPLATELAYOUT, err)
+	}
+	if _, err := textTpl.Parse(body); err != nil {
+		return Rendered{}, fmt.Errorf("parse %s/%s: %v", locale, name, err)
+	}
+	htmlTpl := htmltemplate.New(name).Option("missingkey=zero")
+	if _, err := htmlTpl.Parse(layout); err != nil {
+		return Rendered{}, fmt.Errorf("parse %s/%s: %v", locale, TEMPLATELAYOUT, err)
+	}
+	if _, err := htmlTpl.Parse(body); err != nil {
+		return Rendered{}, fmt.Errorf("parse %s/%s: %v", locale, name, err)
+	}
+
+	var rendered Rendered
+	var buf bytes.Buffer
+	if err := textTpl.ExecuteTemplate(&buf, "subject", values); err != nil {
+		return Rendered{}, fmt.Errorf("render %s/%s subject: %v", locale, name, err)
+	}
+	// 主题只保留一行
+	rendered.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	if err := textTpl.ExecuteTemplate(&buf, "text", values); err != nil {
+		return Rendered{}, fmt.Errorf("render %s/%s text: %v", locale, name, err)
+	}
+	rendered.Text = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := htmlTpl.ExecuteTemplate(&buf, "html", values); err != nil {
+		return Rendered{}, fmt.Errorf("render %s/%s html: %v", locale, name, err)
+	}
+	rendered.HTML = buf.String()
+	return rendered, nil
+}
+
+// read 读取模板，指定语言没有该模板时使用默认语言，返回实际使用的语言
+func (r *TemplateRegistry) read(name string, locale string) (string, string, error) {
+	locales := []string{locale, r.Locale, DefaultLocale}
+	for _, l := range locales {
+		if l == "" {
+			continue
+		}
+		if r.Dir != "" {
+			if content, err := os.ReadFile(filepath.Join(r.Dir, l, name+".tmpl")); err == nil {
+				return string(content), l, nil
+			} else if !os.IsNotExist(err) {
+				return "", "", err
+			}
+		}
+		if content, err := fs.ReadFile(embeddedTemplates, "templates/"+l+"/"+name+".tmpl"); err == nil {
+			return string(content), l, nil
+		}
+	}
+	return "", "", fmt.Errorf("template %s not found for locale %q", name, locale)
+}
+
+// SampleData 预览模板时使用的示例数据
+func SampleData(name string) map[string]interface{} {
+	switch name {
+	case EVENTCERTEXPIRY, EVENTCERTRENEWED:
+		return map[string]interface{}{
+			"CliID":    "c0a80101",
+			"CliName":  "demo-client",
+			"SerName":  "demo-subnet",
+			"Serial":   "3A:F1:09:7C",
+			"NotAfter": "2026-12-31 23:59:59",
+			"DaysLeft": 14,
+		}
+	case TEMPLATECLIPROFILE, TEMPLATEPROFILEPASSWORD:
+		return map[string]interface{}{
+			"CliID":     "c0a80101",
+			"CliName":   "demo-client",
+			"SerName":   "demo-subnet",
+			"Filename":  "demo-client.ovpn.zip",
+			"Encrypted": true,
+			"SentBy":    "admin",
+			"Recipient": "installer@example.com",
+			"Password":  "Xk7mP2qR9wTz4hNc",
+		}
+	case TEMPLATEDIGEST:
+		return map[string]interface{}{
+			"Frequency":   "daily",
+			"UserName":    "demo-user",
+			"Start":       "2026-01-01 08:00",
+			"End":         "2026-01-02 08:00",
+			"SubnetCount": 2,
+			"ClientCount": 12,
+			"RemindDays":  30,
+			"Offline": []map[string]interface{}{
+				{"CliID": "c0a80101", "CliName": "demo-client", "SerName": "demo-subnet", "CliAddress": "10.100.1.2", "LastSeen": "2026-01-01 21:14:05"},
+			},
+			"Added": []map[string]interface{}{
+				{"CliID": "c0a80102", "CliName": "new-client", "SerName": "demo-subnet", "Time": "2026-01-01 10:30:00", "Operator": "admin"},
+			},
+			"Deleted": []map[string]interface{}{},
+			"CertExpiring": []map[string]interface{}{
+				{"CliID": "c0a80103", "CliName": "old-client", "SerName": "demo-subnet", "NotAfter": "2026-01-20 23:59:59", "DaysLeft": 18},
+			},
+			"TopTraffic": []map[string]interface{}{
+				{"CliID": "c0a80101", "CliName": "demo-client", "SerName": "demo-subnet", "Received": "1.2 GB", "Sent": "300.5 MB", "Total": "1.5 GB"},
+			},
+		}
+	case TEMPLATEQUIETSUMMARY:
+		return map[string]interface{}{
+			"UserName": "demo-user",
+			"Count":    2,
+			"Start":    "2026-01-01 23:10",
+			"End":      "2026-01-02 02:45",
+			"Timezone": "Asia/Shanghai",
+			"Events": []map[string]interface{}{
+				{"Time": "2026-01-01 23:10:12", "Event": EVENTDEVICEOFFLINE, "Severity": SEVERITYCRITICAL, "Title": "[vpn.example.com] 设备离线通知 demo-client"},
+				{"Time": "2026-01-02 02:45:30", "Event": EVENTDEVICEONLINE, "Severity": SEVERITYINFO, "Title": "[vpn.example.com] 设备上线通知 demo-client"},
+			},
+		}
+	case TEMPLATEMAILCODE:
+		return map[string]interface{}{
+			"MailCode":      "123456",
+			"ExpireMinutes": 5,
+		}
+	}
+	return map[string]interface{}{
+		"CliID":      "c0a80101",
+		"CliName":    "demo-client",
+		"SerName":    "demo-subnet",
+		"CliMapping": "192.168.1.0/24",
+		"CliAddress": "10.100.1.2",
+		"CliStatus":  "true",
+		"Time":       "2026-01-01 08:00:00",
+	}
+}
@@ -0,0 +1,198 @@
+package message
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 机器人和 webhook 请求的超时时间
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// WebhookNotifier 以 JSON 格式 POST 通知
+// 配置了 Secret 时带 X-JWireGuard-Timestamp 和 X-JWireGuard-Signature 请求头，
+// 签名为 hex(HMAC-SHA256(secret, timestamp + "." + body))
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+}
+
+func (w *WebhookNotifier) Notify(n Notification) error {
+	if n.Time == 0 {
+		n.Time = time.Now().Unix()
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set("X-JWireGuard-Timestamp", timestamp)
+		req.Header.Set("X-JWireGuard-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	_, err = doRequest(req)
+	return err
+}
+
+// DingTalkNotifier 钉钉自定义机器人，Secret 为加签密钥
+type DingTalkNotifier struct {
+	Webhook string
+	Secret  string
+}
+
+func (d *DingTalkNotifier) Notify(n Notification) error {
+	target := d.Webhook
+	if d.Secret != "" {
+		// 加签: base64(HMAC-SHA256(secret, timestamp + "\n" + secret))，时间戳为毫秒
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(d.Secret))
+		mac.Write([]byte(timestamp + "\n" + d.Secret))
+		sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		target = appendQuery(target, url.Values{"timestamp": {timestamp}, "sign": {sign}})
+	}
+
+	payload := map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": n.Title,
+			"text":  markdown(n),
+		},
+	}
+	return postRobot(target, payload, "errcode", "errmsg")
+}
+
+// WeComNotifier 企业微信群机器人
+type WeComNotifier struct {
+	Webhook string
+}
+
+func (c *WeComNotifier) Notify(n Notification) error {
+	payload := map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"content": markdown(n),
+		},
+	}
+	return postRobot(c.Webhook, payload, "errcode", "errmsg")
+}
+
+// FeishuNotifier 飞书自定义机器人，Secret 为签名校验密钥
+type FeishuNotifier struct {
+	Webhook string
+	Secret  string
+}
+
+func (f *FeishuNotifier) Notify(n Notification) error {
+	payload := map[string]interface{}{
+		"msg_type": "text",
+		"content": map[string]string{
+			"text": n.Title + "\n" + n.Text,
+		},
+	}
+	if f.Secret != "" {
+		// 签名: base64(HMAC-SHA256(key = timestamp + "\n" + secret, 空消息))，时间戳为秒
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(timestamp+"\n"+f.Secret))
+		payload["timestamp"] = timestamp
+		payload["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return postRobot(f.Webhook, payload, "code", "msg")
+}
+
+// markdown 标题加粗，每行一项
+func markdown(n Notification) string {
+	var buf bytes.Buffer
+	buf.WriteString("**" + n.Title + "**\n\n")
+	for _, line := range bytes.Split([]byte(n.Text), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		buf.WriteString("> ")
+		buf.Write(line)
+		buf.WriteString("\n\n")
+	}
+	return buf.String()
+}
+
+// postRobot 发送机器人消息，返回的 JSON 中 codeKey 不为 0 时返回错误
+func postRobot(target string, payload interface{}, codeKey string, msgKey string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	respBody, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("invalid response: %s", truncate(respBody))
+	}
+	if code, ok := result[codeKey].(float64); ok && code != 0 {
+		return fmt.Errorf("%s %v: %v", codeKey, code, result[msgKey])
+	}
+	return nil
+}
+
+// doRequest 发送请求，状态码不是 2xx 时返回错误
+func doRequest(req *http.Request) ([]byte, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("http status %d: %s", resp.StatusCode, truncate(body))
+	}
+	return body, nil
+}
+
+func appendQuery(target string, values url.Values) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	query := u.Query()
+	for k, v := range values {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func truncate(body []byte) string {
+	if len(body) > 256 {
+		return string(body[:256]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,152 @@
+package message
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// WinZip AES 加密参数，见 https://www.winzip.com/en/support/aes-encryption/
+const (
+	zipMethodAES      = 99     // 加密后的压缩方法
+	zipExtraAES       = 0x9901 // AES 扩展字段
+	zipAESVersion     = 2      // AE-2，不写 CRC
+	zipAESStrength256 = 3
+	zipAESKeyLen      = 32
+	zipAESSaltLen     = 16
+	zipAESIterations  = 1000
+	zipAESAuthLen     = 10
+)
+
+// ----------------------------------------------------------------------------------------------------------
+// EncryptedZip 将 data 以 filename 打包为 AES-256 加密的 zip，可用 7-Zip、WinRAR、Bandizip 等解压
+// password 为空时不加密
+// ----------------------------------------------------------------------------------------------------------
+func EncryptedZip(filename string, data []byte, password string, modified time.Time) ([]byte, error) {
+	if filename == "" {
+		return nil, errors.New("filename cannot be empty")
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if password == "" {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: filename, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	encrypted, err := zipAESEncrypt(compressed.Bytes(), password)
+	if err != nil {
+		return nil, err
+	}
+
+	// 扩展字段: 版本、厂商 "AE"、密钥长度、实际的压缩方法
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], zipExtraAES)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], zipAESVersion)
+	copy(extra[6:], "AE")
+	extra[8] = zipAESStrength256
+	binary.LittleEndian.PutUint16(extra[9:], zip.Deflate)
+
+	header := &zip.FileHeader{
+		Name:               filename,
+		Method:             zipMethodAES,
+		Flags:              0x1, // 已加密
+		Modified:           modified,
+		Extra:              extra,
+		CompressedSize64:   uint64(len(encrypted)),
+		UncompressedSize64: uint64(len(data)),
+	}
+	header.SetMode(0600)
+	// CreateRaw 不会根据 Modified 填写 DOS 时间
+	header.ModifiedDate, header.ModifiedTime = msDosTime(modified)
+	w, err := zw.CreateRaw(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(encrypted); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zipAESEncrypt 返回 盐 + 密码校验值 + 密文 + 认证码
+func zipAESEncrypt(plain []byte, password string) ([]byte, error) {
+	salt := make([]byte, zipAESSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	keys := pbkdf2.Key([]byte(password), salt, zipAESIterations, 2*zipAESKeyLen+2, sha1.New)
+	encKey, macKey, verifier := keys[:zipAESKeyLen], keys[zipAESKeyLen:2*zipAESKeyLen], keys[2*zipAESKeyLen:]
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	// WinZip 的 CTR 计数器从 1 开始，按小端序递增，与 cipher.NewCTR 不同
+	encrypted := make([]byte, len(plain))
+	var counter, stream [aes.BlockSize]byte
+	for offset := 0; offset < len(plain); offset += aes.BlockSize {
+		for i := range counter {
+			counter[i]++
+			if counter[i] != 0 {
+				break
+			}
+		}
+		block.Encrypt(stream[:], counter[:])
+		for i := offset; i < offset+aes.BlockSize && i < len(plain); i++ {
+			encrypted[i] = plain[i] ^ stream[i-offset]
+		}
+	}
+
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(encrypted)
+
+	out := make([]byte, 0, len(salt)+len(verifier)+len(encrypted)+zipAESAuthLen)
+	out = append(out, salt...)
+	out = append(out, verifier...)
+	out = append(out, encrypted...)
+	return append(out, mac.Sum(nil)[:zipAESAuthLen]...), nil
+}
+
+// msDosTime 转换为 zip 文件头中的 DOS 日期和时间
+func msDosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		return 0, 0
+	}
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
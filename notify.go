@@ -0,0 +1,267 @@
+package main
+
+import (
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/message"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------------------------------------
+// loadNotifyRouter 读取启用的通知渠道和路由规则
+// 没有匹配的规则时通过邮件发送给通知中的收件人，与未配置渠道时的行为一致
+// ----------------------------------------------------------------------------------------------------------
+func loadNotifyRouter() *message.Router {
+	router := message.NewRouter(&message.EmailNotifier{Sender: &sender})
+
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	channels, err := notifyChannel.GetAllNotifyChannel(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[notify] 无法读取通知渠道, err:%v", err)
+		return router
+	}
+	notifyRule := database.NotifyRule{}
+	rules, err := notifyRule.GetAllNotifyRule(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[notify] 无法读取通知路由规则, err:%v", err)
+		return router
+	}
+
+	notifiers := make(map[string]message.Notifier)
+	names := make(map[int64]string)
+	for _, channel := range channels {
+		if !channel.Enabled.Bool {
+			continue
+		}
+		notifier, err := message.NewNotifier(message.ChannelConfig{
+			Type:   channel.Type.String,
+			URL:    channel.URL.String,
+			Secret: channel.Secret.String,
+			To:     channel.RecipientList(),
+		}, &sender)
+		if err != nil {
+			global.Log.Errorf("[notify] 通知渠道 %s 配置错误, err:%v", channel.Name.String, err)
+			continue
+		}
+		notifiers[channel.Name.String] = notifier
+		names[channel.ID.Int64] = channel.Name.String
+	}
+
+	routes := make([]message.Route, 0, len(rules))
+	for _, rule := range rules {
+		if name, ok := names[rule.ChannelID.Int64]; ok {
+			routes = append(routes, message.Route{Event: rule.Event.String, SerID: rule.SerID.String, Channel: name})
+		}
+	}
+	router.Set(notifiers, routes)
+	return router
+}
+
+// 每次从发件箱取出的通知数
+const outboxBatch = 100
+
+// outboxWake 有新通知时唤醒发件箱，不必等到下一次检查
+var outboxWake = make(chan struct{}, 1)
+
+// ----------------------------------------------------------------------------------------------------------
+// dispatchNotification 按路由规则和用户订阅为每个渠道写入一条发件箱记录，由 NotifyOutboxWorker 发送
+// 订阅用户处于免打扰时段时暂存，路由规则和订阅都没有匹配时发送给通知中的收件人
+// ----------------------------------------------------------------------------------------------------------
+func dispatchNotification(tag string, n message.Notification) bool {
+	if n.Time == 0 {
+		n.Time = time.Now().Unix()
+	}
+	queued := true
+	routed := make(map[string]bool)
+	for _, channel := range loadNotifyRouter().Match(n.Event, n.SerID) {
+		routed[channel] = true
+		if !queueNotification(tag, channel, n) {
+			queued = false
+		}
+	}
+
+	targets := subscriberTargets(tag, n)
+	for _, target := range targets {
+		if target.Channel != "" && routed[target.Channel] {
+			continue
+		}
+		if !deliverToSubscriber(tag, target, n) {
+			queued = false
+		}
+	}
+
+	if len(routed) == 0 && len(targets) == 0 {
+		if !queueNotification(tag, "", n) {
+			queued = false
+		}
+	}
+	return queued
+}
+
+// queueNotification 为指定渠道写入一条发件箱记录并唤醒发件箱，空渠道使用默认邮件
+func queueNotification(tag string, channel string, n message.Notification) bool {
+	if n.Time == 0 {
+		n.Time = time.Now().Unix()
+	}
+
+	notifyOutbox := database.NotifyOutbox{}
+	notifyOutbox.CreateNotifyOutbox(global.GlobalDB)
+	item := database.NotifyOutbox{}
+	item.Event.String = n.Event
+	item.SerID.String = n.SerID
+	item.CliID.String = n.CliID
+	item.Channel.String = channel
+	item.Title.String = n.Title
+	item.Text.String = n.Text
+	item.HTML.String = n.HTML
+	item.Recipients.String = strings.Join(n.To, ",")
+	item.CreatedAt.Int64 = n.Time
+	if err := item.InsertNotifyOutbox(global.GlobalDB); err != nil {
+		global.Log.Errorf("[%s] 通知 %s 无法写入发件箱, channel:[%s] err:%v", tag, n.Event, channel, err)
+		return false
+	}
+	global.Log.Debugf("[%s] 通知 %s 已写入发件箱, id:%d channel:[%s]", tag, n.Event, item.ID.Int64, channel)
+
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// NotifyOutboxWorker 发送发件箱中到期的通知，失败后按指数退避重试，重试次数用完后标记为 dead
+// ----------------------------------------------------------------------------------------------------------
+func NotifyOutboxWorker() {
+	global.Log.Infof("[NotifyOutbox] start")
+
+	notifyOutbox := database.NotifyOutbox{}
+	notifyOutbox.CreateNotifyOutbox(global.GlobalDB)
+	lastPurge := time.Now()
+	for {
+		deliverOutbox(time.Now().Unix())
+
+		if time.Since(lastPurge) >= time.Hour {
+			lastPurge = time.Now()
+			before := lastPurge.AddDate(0, 0, -global.GlobalJWireGuardini.NotifyRetentionDays).Unix()
+			if count, err := database.PurgeNotifyOutbox(global.GlobalDB, before); err != nil {
+				global.Log.Errorf("[NotifyOutbox] 清理已发送的通知失败, err:%v", err)
+			} else if count > 0 {
+				global.Log.Infof("[NotifyOutbox] 清理已发送的通知 %d 条", count)
+			}
+		}
+
+		select {
+		case <-outboxWake:
+		case <-time.After(time.Duration(global.GlobalJWireGuardini.NotifyInterval) * time.Second):
+		}
+	}
+}
+
+// deliverOutbox 发送一批到期的通知，一批发满时继续取下一批
+func deliverOutbox(now int64) {
+	var err error
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[NotifyOutbox] 数据库连接失败, err:%v", err)
+		return
+	}
+
+	for {
+		items, err := database.GetDueNotifyOutbox(global.GlobalDB, now, outboxBatch)
+		if err != nil {
+			global.Log.Errorf("[NotifyOutbox] 读取发件箱失败, err:%v", err)
+			return
+		}
+		if len(items) == 0 {
+			return
+		}
+
+		router := loadNotifyRouter()
+		for _, item := range items {
+			deliverOutboxItem(router, item)
+		}
+		if len(items) < outboxBatch {
+			return
+		}
+	}
+}
+
+func deliverOutboxItem(router *message.Router, item database.NotifyOutbox) {
+	err := router.Send(item.Channel.String, message.Notification{
+		Event: item.Event.String,
+		SerID: item.SerID.String,
+		CliID: item.CliID.String,
+		Title: item.Title.String,
+		Text:  item.Text.String,
+		HTML:  item.HTML.String,
+		To:    item.RecipientList(),
+		Time:  item.CreatedAt.Int64,
+	})
+	now := time.Now().Unix()
+	if err == nil {
+		if err := item.MarkNotifyOutboxSent(global.GlobalDB, now); err != nil {
+			global.Log.Errorf("[NotifyOutbox] 无法更新通知 %d 的状态, err:%v", item.ID.Int64, err)
+		}
+		global.Log.Debugf("[NotifyOutbox] 通知 %d %s 发送成功, channel:[%s]", item.ID.Int64, item.Event.String, item.Channel.String)
+		return
+	}
+
+	attempts := item.Attempts.Int64 + 1
+	dead := attempts >= int64(global.GlobalJWireGuardini.NotifyMaxAttempts)
+	nextAt := now + outboxBackoff(attempts)
+	if dead {
+		global.Log.Errorf("[NotifyOutbox] 通知 %d %s 发送失败 %d 次, 不再重试, channel:[%s] err:%v",
+			item.ID.Int64, item.Event.String, attempts, item.Channel.String, err)
+	} else {
+		global.Log.Warnf("[NotifyOutbox] 通知 %d %s 第 %d 次发送失败, %s 重试, channel:[%s] err:%v",
+			item.ID.Int64, item.Event.String, attempts, time.Unix(nextAt, 0).Format("2006-01-02 15:04:05"), item.Channel.String, err)
+	}
+	if err := item.MarkNotifyOutboxFailed(global.GlobalDB, err.Error(), nextAt, dead); err != nil {
+		global.Log.Errorf("[NotifyOutbox] 无法更新通知 %d 的状态, err:%v", item.ID.Int64, err)
+	}
+}
+
+// outboxBackoff 第 attempts 次失败后的等待秒数: RETRY_BASE * 2^(attempts-1)，不超过 RETRY_MAX
+func outboxBackoff(attempts int64) int64 {
+	delay := int64(global.GlobalJWireGuardini.NotifyRetryBase)
+	limit := int64(global.GlobalJWireGuardini.NotifyRetryMax)
+	for i := int64(1); i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// notifyTemplates 使用 [TEMPLATE SETTING] 的模板目录、语言和服务器名称
+func notifyTemplates() *message.TemplateRegistry {
+	return &message.TemplateRegistry{
+		Dir:    global.GlobalJWireGuardini.TemplateDir,
+		Locale: global.GlobalJWireGuardini.TemplateLocale,
+		Server: global.GlobalJWireGuardini.ServerName,
+	}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// dispatchTemplate 用事件对应的模板渲染通知后写入发件箱，to 为邮件渠道没有配置收件人时的收件人
+// ----------------------------------------------------------------------------------------------------------
+func dispatchTemplate(tag string, event string, serId string, cliId string, to []string, data map[string]interface{}) bool {
+	rendered, err := notifyTemplates().Render(event, "", data)
+	if err != nil {
+		global.Log.Errorf("[%s] 渲染通知模板 %s 失败, err:%v", tag, event, err)
+		return false
+	}
+	return dispatchNotification(tag, message.Notification{
+		Event: event,
+		SerID: serId,
+		CliID: cliId,
+		Title: rendered.Subject,
+		Text:  rendered.Text,
+		HTML:  rendered.HTML,
+		To:    to,
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/message"
+	"sync"
+	"time"
+)
+
+// 设备状态频繁变化时只发送一次不稳定通知，恢复稳定后再通知
+var deviceFlaps = newFlapTracker()
+
+// offlineRules 离线判断参数，设备的设置优先于子网
+type offlineRules struct {
+	devices map[string]int64 // cli_id -> 离线秒数
+	subnets map[string]int64 // ser_id -> 离线秒数
+}
+
+// loadOfflineRules 读取子网和设备的离线判断参数，读取失败时全部使用默认值
+func loadOfflineRules() offlineRules {
+	rules := offlineRules{
+		devices: make(map[string]int64),
+		subnets: make(map[string]int64),
+	}
+
+	offlinePolicy := database.OfflinePolicy{}
+	offlinePolicy.CreateOfflinePolicy(global.GlobalDB)
+	policies, err := offlinePolicy.GetAllOfflinePolicy(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[IsDevOnline] 无法读取离线判断参数, err:%v", err)
+		return rules
+	}
+	for _, policy := range policies {
+		timeout := policy.Interval.Int64 * policy.Missed.Int64
+		switch policy.Scope.String {
+		case database.OFFLINESCOPEDEVICE:
+			rules.devices[policy.TargetID.String] = timeout
+		case database.OFFLINESCOPESUBNET:
+			rules.subnets[policy.TargetID.String] = timeout
+		}
+	}
+	return rules
+}
+
+// timeout 超过该秒数没有心跳时判断为离线
+func (r offlineRules) timeout(clientConfig database.CliConfig) int64 {
+	if timeout, ok := r.devices[clientConfig.CliID.String]; ok {
+		return timeout
+	}
+	if timeout, ok := r.subnets[clientConfig.SerID.String]; ok {
+		return timeout
+	}
+	return int64(global.GlobalJWireGuardini.OfflineInterval) * int64(global.GlobalJWireGuardini.OfflineMissed)
+}
+
+type flapState struct {
+	changes    []int64 // 窗口内状态变化的时间
+	unstable   bool
+	lastChange int64
+}
+
+type flapTracker struct {
+	mu      sync.Mutex
+	devices map[string]*flapState
+}
+
+func newFlapTracker() *flapTracker {
+	return &flapTracker{devices: make(map[string]*flapState)}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// record 记录状态变化，返回是否需要通知，以及设备是否刚变为不稳定
+// 不稳定期间的状态变化不再通知
+// ----------------------------------------------------------------------------------------------------------
+func (t *flapTracker) record(cliId string, now int64) (notify bool, unstable bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.devices[cliId]
+	if !ok {
+		state = &flapState{}
+		t.devices[cliId] = state
+	}
+	state.lastChange = now
+	if state.unstable {
+		return false, false
+	}
+
+	window := now - int64(global.GlobalJWireGuardini.FlapWindow)
+	changes := state.changes[:0]
+	for _, change := range state.changes {
+		if change > window {
+			changes = append(changes, change)
+		}
+	}
+	state.changes = append(changes, now)
+
+	if global.GlobalJWireGuardini.FlapCount > 0 && len(state.changes) >= global.GlobalJWireGuardini.FlapCount {
+		state.unstable = true
+		state.changes = nil
+		return true, true
+	}
+	return true, false
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// settle 返回持续 STABLE_PERIOD 没有状态变化的不稳定设备，并清除其状态
+// ----------------------------------------------------------------------------------------------------------
+func (t *flapTracker) settle(now int64) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var settled []string
+	for cliId, state := range t.devices {
+		if state.unstable {
+			if now-state.lastChange >= int64(global.GlobalJWireGuardini.FlapStablePeriod) {
+				settled = append(settled, cliId)
+				delete(t.devices, cliId)
+			}
+			continue
+		}
+		// 窗口内没有状态变化的设备不再保留
+		if now-state.lastChange >= int64(global.GlobalJWireGuardini.FlapWindow) {
+			delete(t.devices, cliId)
+		}
+	}
+	return settled
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// statusChanged 记录设备在线状态的变化并通知
+// ----------------------------------------------------------------------------------------------------------
+func statusChanged(clientConfig database.CliConfig, cause string, detail string, now int64) {
+	cliEvent := database.CliEvent{}
+	cliEvent.CliID.String = clientConfig.CliID.String
+	cliEvent.SerID.String = clientConfig.SerID.String
+	cliEvent.Status.String = database.EVENTOFFLINE
+	if clientConfig.CliStatus.String == "true" {
+		cliEvent.Status.String = database.EVENTONLINE
+	}
+	cliEvent.Cause.String = cause
+	cliEvent.Detail.String = detail
+	cliEvent.Ts.Int64 = now
+	if err := cliEvent.InsertCliEvent(global.GlobalDB); err != nil {
+		global.Log.Errorf("[notify] 无法记录 cli_id %s 的状态变化, err:%v", clientConfig.CliID.String, err)
+	}
+
+	notifyStatusChange(clientConfig, now)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// notifyStatusChange 设备在线状态变化时通知，状态频繁变化时合并为一次不稳定通知
+// ----------------------------------------------------------------------------------------------------------
+func notifyStatusChange(clientConfig database.CliConfig, now int64) {
+	notify, unstable := deviceFlaps.record(clientConfig.CliID.String, now)
+	if !notify {
+		global.Log.Debugf("[notify] cli_id %s 状态不稳定, 不发送状态 %s 的通知", clientConfig.CliID.String, clientConfig.CliStatus.String)
+		return
+	}
+	if unstable {
+		global.Log.Warnf("[notify] cli_id %s 在 %d 秒内状态变化 %d 次, 判断为不稳定",
+			clientConfig.CliID.String, global.GlobalJWireGuardini.FlapWindow, global.GlobalJWireGuardini.FlapCount)
+		sendStatusNotification(clientConfig, message.EVENTDEVICEFLAP)
+		return
+	}
+	event := message.EVENTDEVICEOFFLINE
+	if clientConfig.CliStatus.String == "true" {
+		event = message.EVENTDEVICEONLINE
+	}
+	sendStatusNotification(clientConfig, event)
+}
+
+// notifyStableDevices 不稳定的设备恢复稳定后发送当前状态
+func notifyStableDevices(now int64) {
+	for _, cliId := range deviceFlaps.settle(now) {
+		clientConfig := database.CliConfig{}
+		clientConfig.CliID.String = cliId
+		if err := clientConfig.GetCliConfigByCliID(global.GlobalDB); err != nil {
+			continue
+		}
+		global.Log.Infof("[notify] cli_id %s 已恢复稳定, 当前状态 %s", cliId, clientConfig.CliStatus.String)
+		sendStatusNotification(clientConfig, message.EVENTDEVICESTABLE)
+	}
+}
+
+// sendStatusNotification 发送设备在线状态变化的通知
+func sendStatusNotification(clientConfig database.CliConfig, event string) {
+	dispatchTemplate("notify", event, clientConfig.SerID.String, clientConfig.CliID.String,
+		[]string{global.GlobalJWireGuardini.To}, map[string]interface{}{
+			"CliID":      clientConfig.CliID.String,
+			"CliName":    clientConfig.CliName.String,
+			"SerName":    clientConfig.SerName.String,
+			"CliMapping": clientConfig.CliMapping.String,
+			"CliAddress": clientConfig.CliAddress.String,
+			"CliStatus":  clientConfig.CliStatus.String,
+			"Time":       time.Now().Format("2006-01-02 15:04:05"),
+		})
+}
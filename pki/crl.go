@@ -0,0 +1,153 @@
+// pki/crl.go
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotIssued 客户端没有有效证书
+var ErrNotIssued = errors.New("证书不存在或已吊销")
+
+// CRLFile 返回 crl.pem 路径
+func (ca *CA) CRLFile() string {
+	return filepath.Join(ca.PkiPath, "crl.pem")
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Revoke 吊销指定客户端名称下所有有效证书，相当于 easyrsa revoke <name>
+// ----------------------------------------------------------------------------------------------------------
+func (ca *CA) Revoke(name string, reason string) ([]IndexEntry, error) {
+	return ca.RevokeExcept(name, "", reason)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// RevokeExcept 吊销指定客户端名称下除 keep 序列号以外的有效证书，用于重新签发后吊销被替换的证书
+// ----------------------------------------------------------------------------------------------------------
+func (ca *CA) RevokeExcept(name string, keep string, reason string) ([]IndexEntry, error) {
+	if _, ok := reasonCodes[reason]; !ok {
+		return nil, fmt.Errorf("不支持的吊销原因: %s", reason)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	entries, err := ca.readIndexLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var revoked []IndexEntry
+	for i := range entries {
+		if entries[i].Status != "V" || entries[i].CommonName != name || strings.EqualFold(entries[i].Serial, keep) {
+			continue
+		}
+		entries[i].Status = "R"
+		entries[i].RevokedAt = now
+		entries[i].Reason = reason
+		revoked = append(revoked, entries[i])
+	}
+
+	if len(revoked) == 0 {
+		return nil, ErrNotIssued
+	}
+
+	if err := ca.writeIndexLocked(entries); err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+// ListRevoked 返回 index.txt 中所有已吊销的证书
+func (ca *CA) ListRevoked() ([]IndexEntry, error) {
+	entries, err := ca.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []IndexEntry
+	for _, e := range entries {
+		if e.Status == "R" {
+			revoked = append(revoked, e)
+		}
+	}
+	return revoked, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// GenCRL 根据 index.txt 重新生成 crl.pem，相当于 easyrsa gen-crl
+// ----------------------------------------------------------------------------------------------------------
+func (ca *CA) GenCRL() error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	entries, err := ca.readIndexLocked()
+	if err != nil {
+		return err
+	}
+
+	var revoked []x509.RevocationListEntry
+	for _, e := range entries {
+		if e.Status != "R" {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(e.Serial, 16)
+		if !ok {
+			return fmt.Errorf("index.txt 中的序列号错误: %s", e.Serial)
+		}
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: e.RevokedAt,
+			ReasonCode:     reasonCodes[e.Reason],
+		})
+	}
+
+	number, err := ca.nextCRLNumberLocked()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Issuer:                    ca.cert.Subject,
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.AddDate(0, 0, ca.CrlDays),
+		RevokedCertificateEntries: revoked,
+	}, ca.cert, ca.key)
+	if err != nil {
+		return fmt.Errorf("无法生成CRL: %w", err)
+	}
+
+	// OpenVPN 以 nobody 身份运行时需要能读取 crl.pem
+	if err := writePEM(ca.CRLFile(), "X509 CRL", der, 0644); err != nil {
+		return fmt.Errorf("无法写入crl.pem: %w", err)
+	}
+	return nil
+}
+
+// nextCRLNumberLocked 读取并递增 crlnumber 文件
+func (ca *CA) nextCRLNumberLocked() (*big.Int, error) {
+	numberFile := filepath.Join(ca.PkiPath, "crlnumber")
+
+	number := big.NewInt(1)
+	if data, err := os.ReadFile(numberFile); err == nil {
+		if n, ok := new(big.Int).SetString(strings.TrimSpace(string(data)), 16); ok {
+			number = n
+		}
+	}
+
+	next := new(big.Int).Add(number, big.NewInt(1))
+	if err := writeFileAtomic(numberFile, []byte(serialString(next)+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("无法写入crlnumber: %w", err)
+	}
+	return number, nil
+}
@@ -0,0 +1,79 @@
+// pki/expiry.go
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IssuedCert pki/issued 目录下的一个客户端证书
+type IssuedCert struct {
+	Name      string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ExpiresWithin 判断证书是否在指定时间内到期（已过期也算）
+func (c IssuedCert) ExpiresWithin(d time.Duration) bool {
+	return time.Until(c.NotAfter) <= d
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ScanIssued 解析 pki/issued 下的全部证书，单个文件解析失败不影响其它证书
+// ----------------------------------------------------------------------------------------------------------
+func (ca *CA) ScanIssued() ([]IssuedCert, []error) {
+	files, err := filepath.Glob(filepath.Join(ca.PkiPath, "issued", "*.crt"))
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var certs []IssuedCert
+	var errs []error
+	for _, file := range files {
+		cert, err := ParseCertificateFile(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		certs = append(certs, NewIssuedCert(strings.TrimSuffix(filepath.Base(file), ".crt"), cert))
+	}
+
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].NotAfter.Before(certs[j].NotAfter)
+	})
+	return certs, errs
+}
+
+// NewIssuedCert 由证书生成 IssuedCert
+func NewIssuedCert(name string, cert *x509.Certificate) IssuedCert {
+	return IssuedCert{
+		Name:      name,
+		Serial:    serialString(cert.SerialNumber),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+}
+
+// ParseCertificateFile 读取 PEM 格式证书文件
+func ParseCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s 不是有效的证书文件", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析证书 %s: %w", path, err)
+	}
+	return cert, nil
+}
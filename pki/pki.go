@@ -0,0 +1,530 @@
+// pki/pki.go
+package pki
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULTCERTDAYS = 3650 // 与 vars 中 EASYRSA_CERT_EXPIRE 保持一致
+	DEFAULTKEYSIZE  = 2048 // 与 vars 中 EASYRSA_KEY_SIZE 保持一致
+	DEFAULTCRLDAYS  = 180  // 与 vars 中 EASYRSA_CRL_DAYS 保持一致
+	indexTimeLayout = "060102150405Z"
+)
+
+// 吊销原因，与 openssl ca -crl_reason 的取值一致
+const (
+	ReasonUnspecified          = "unspecified"
+	ReasonKeyCompromise        = "keyCompromise"
+	ReasonAffiliationChanged   = "affiliationChanged"
+	ReasonSuperseded           = "superseded"
+	ReasonCessationOfOperation = "cessationOfOperation"
+)
+
+var reasonCodes = map[string]int{
+	ReasonUnspecified:          0,
+	ReasonKeyCompromise:        1,
+	ReasonAffiliationChanged:   3,
+	ReasonSuperseded:           4,
+	ReasonCessationOfOperation: 5,
+}
+
+// CA 进程内证书签发机构，兼容 easyrsa 的 pki 目录结构
+type CA struct {
+	mu       sync.Mutex
+	PkiPath  string
+	CertDays int
+	CrlDays  int
+	KeySize  int
+	cert     *x509.Certificate
+	key      crypto.Signer
+}
+
+// IndexEntry index.txt 中的一行
+type IndexEntry struct {
+	Status     string    // V 有效, R 吊销, E 过期
+	NotAfter   time.Time // 证书到期时间
+	RevokedAt  time.Time // 吊销时间
+	Reason     string    // 吊销原因
+	Serial     string    // 十六进制序列号(大写)
+	Subject    string    // /CN=xxx
+	CommonName string
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// LoadCA 从 pki 目录中加载 ca.crt 与 private/ca.key
+// ----------------------------------------------------------------------------------------------------------
+func LoadCA(pkiPath string) (*CA, error) {
+	certPEM, err := os.ReadFile(filepath.Join(pkiPath, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("无法读取CA证书: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("CA证书格式错误")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析CA证书: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(filepath.Join(pkiPath, "private", "ca.key"))
+	if err != nil {
+		return nil, fmt.Errorf("无法读取CA私钥: %w", err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析CA私钥: %w", err)
+	}
+
+	return &CA{
+		PkiPath:  pkiPath,
+		CertDays: DEFAULTCERTDAYS,
+		CrlDays:  DEFAULTCRLDAYS,
+		KeySize:  DEFAULTKEYSIZE,
+		cert:     cert,
+		key:      key,
+	}, nil
+}
+
+// parsePrivateKey 支持 PKCS#1、PKCS#8 和 EC 格式的私钥
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("私钥不是PEM格式")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("不支持带密码的私钥")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case crypto.Signer:
+		return k, nil
+	}
+	return nil, errors.New("不支持的私钥类型")
+}
+
+// SerialString 以 index.txt 中的格式输出证书序列号
+func SerialString(cert *x509.Certificate) string {
+	return serialString(cert.SerialNumber)
+}
+
+// Certificate 返回 CA 证书
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// ClientFiles 返回客户端私钥、请求和证书文件路径
+func (ca *CA) ClientFiles(name string) (keyFile, reqFile, crtFile string) {
+	keyFile = filepath.Join(ca.PkiPath, "private", name+".key")
+	reqFile = filepath.Join(ca.PkiPath, "reqs", name+".req")
+	crtFile = filepath.Join(ca.PkiPath, "issued", name+".crt")
+	return
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// IssueClient 生成客户端私钥、证书请求并签发客户端证书
+// 相当于 easyrsa build-client-full <name> nopass
+// ----------------------------------------------------------------------------------------------------------
+func (ca *CA) IssueClient(name string) (*x509.Certificate, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("无效的客户端名称: %s", name)
+	}
+
+	// 生成私钥和请求不需要加锁
+	key, err := rsa.GenerateKey(rand.Reader, ca.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("无法生成私钥: %w", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: name},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("无法生成证书请求: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("无法编码私钥: %w", err)
+	}
+
+	// 私钥、请求和证书先写入临时文件，签发成功后一起替换，失败时原有的文件保持不变
+	keyFile, reqFile, crtFile := ca.ClientFiles(name)
+	var tmpFiles []pendingFile
+	defer func() {
+		for _, f := range tmpFiles {
+			if f.tmp != "" {
+				os.Remove(f.tmp)
+			}
+		}
+	}()
+
+	tmpKey, err := writePEMTemp(keyFile, "PRIVATE KEY", keyDER, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("无法写入私钥: %w", err)
+	}
+	tmpFiles = append(tmpFiles, pendingFile{tmpKey, keyFile})
+	tmpReq, err := writePEMTemp(reqFile, "CERTIFICATE REQUEST", csrDER, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("无法写入证书请求: %w", err)
+	}
+	tmpFiles = append(tmpFiles, pendingFile{tmpReq, reqFile})
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	cert, err := ca.signLocked(name, &key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// index.txt 中已有新证书，之后失败时吊销新证书，避免留下没有对应私钥的有效证书
+	tmpCrt, err := writePEMTemp(crtFile, "CERTIFICATE", cert.Raw, 0644)
+	if err != nil {
+		return nil, ca.abandonLocked(cert, fmt.Errorf("无法写入证书: %w", err))
+	}
+	tmpFiles = append(tmpFiles, pendingFile{tmpCrt, crtFile})
+
+	for i, f := range tmpFiles {
+		if err := os.Rename(f.tmp, f.path); err != nil {
+			return nil, ca.abandonLocked(cert, fmt.Errorf("无法替换 %s: %w", f.path, err))
+		}
+		tmpFiles[i].tmp = ""
+	}
+	return cert, nil
+}
+
+// pendingFile 等待替换的临时文件
+type pendingFile struct {
+	tmp  string
+	path string
+}
+
+// abandonLocked 吊销签发后未能写入文件的证书，返回 cause，调用方需持有 ca.mu
+// 下次生成 CRL 时包含该证书
+func (ca *CA) abandonLocked(cert *x509.Certificate, cause error) error {
+	entries, err := ca.readIndexLocked()
+	if err == nil {
+		serial := serialString(cert.SerialNumber)
+		for i := range entries {
+			if entries[i].Status == "V" && strings.EqualFold(entries[i].Serial, serial) {
+				entries[i].Status = "R"
+				entries[i].RevokedAt = time.Now().UTC()
+				entries[i].Reason = ReasonUnspecified
+			}
+		}
+		err = ca.writeIndexLocked(entries)
+	}
+	if err != nil {
+		return fmt.Errorf("%w (无法吊销证书 %s: %v)", cause, serialString(cert.SerialNumber), err)
+	}
+	return cause
+}
+
+// signLocked 签发证书并维护 serial 与 index.txt，调用方需持有 ca.mu
+func (ca *CA) signLocked(name string, pub crypto.PublicKey) (*x509.Certificate, error) {
+	entries, err := ca.readIndexLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := ca.newSerial(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("无法编码公钥: %w", err)
+	}
+	ski := sha1.Sum(pubDER)
+
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, ca.CertDays),
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		SubjectKeyId:          ski[:],
+		AuthorityKeyId:        ca.cert.SubjectKeyId,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("无法签发证书: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析新证书: %w", err)
+	}
+
+	serialHex := serialString(serial)
+
+	// certs_by_serial/<SERIAL>.pem
+	byserial := filepath.Join(ca.PkiPath, "certs_by_serial", serialHex+".pem")
+	if err := writePEM(byserial, "CERTIFICATE", der, 0644); err != nil {
+		return nil, fmt.Errorf("无法写入证书副本: %w", err)
+	}
+
+	// 与 openssl ca 一致: serial.old 保存本次序列号, serial 保存下一个序列号
+	if err := writeFileAtomic(filepath.Join(ca.PkiPath, "serial.old"), []byte(serialHex+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("无法写入serial.old: %w", err)
+	}
+	next := new(big.Int).Add(serial, big.NewInt(1))
+	if err := writeFileAtomic(filepath.Join(ca.PkiPath, "serial"), []byte(serialString(next)+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("无法写入serial: %w", err)
+	}
+
+	entries = append(entries, IndexEntry{
+		Status:     "V",
+		NotAfter:   cert.NotAfter,
+		Serial:     serialHex,
+		Subject:    "/CN=" + name,
+		CommonName: name,
+	})
+	if err := ca.writeIndexLocked(entries); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// newSerial 生成与 easyrsa 相同的 128 位随机序列号，并保证不与 index.txt 重复
+func (ca *CA) newSerial(entries []IndexEntry) (*big.Int, error) {
+	used := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		used[e.Serial] = struct{}{}
+	}
+
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	for i := 0; i < 16; i++ {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return nil, fmt.Errorf("无法生成序列号: %w", err)
+		}
+		// 保证最高位非零，长度与 easyrsa 一致
+		if n.BitLen() < 121 {
+			continue
+		}
+		if _, ok := used[serialString(n)]; ok {
+			continue
+		}
+		return n, nil
+	}
+	return nil, errors.New("无法生成唯一的序列号")
+}
+
+// ReadIndex 读取 index.txt
+func (ca *CA) ReadIndex() ([]IndexEntry, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return ca.readIndexLocked()
+}
+
+func (ca *CA) readIndexLocked() ([]IndexEntry, error) {
+	file, err := os.Open(filepath.Join(ca.PkiPath, "index.txt"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("无法打开index.txt: %w", err)
+	}
+	defer file.Close()
+
+	var entries []IndexEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseIndexLine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取index.txt错误: %w", err)
+	}
+	return entries, nil
+}
+
+// parseIndexLine 解析 index.txt 行: 状态 到期时间 吊销时间[,原因] 序列号 文件名 主题
+func parseIndexLine(line string) (IndexEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 6 {
+		return IndexEntry{}, fmt.Errorf("index.txt 行格式错误: %q", line)
+	}
+
+	entry := IndexEntry{
+		Status:  fields[0],
+		Serial:  strings.ToUpper(fields[3]),
+		Subject: fields[5],
+	}
+	if t, err := time.Parse(indexTimeLayout, fields[1]); err == nil {
+		entry.NotAfter = t
+	}
+	if fields[2] != "" {
+		revoked := strings.SplitN(fields[2], ",", 2)
+		if t, err := time.Parse(indexTimeLayout, revoked[0]); err == nil {
+			entry.RevokedAt = t
+		}
+		if len(revoked) == 2 {
+			entry.Reason = revoked[1]
+		}
+	}
+	for _, part := range strings.Split(entry.Subject, "/") {
+		if strings.HasPrefix(part, "CN=") {
+			entry.CommonName = strings.TrimPrefix(part, "CN=")
+		}
+	}
+	return entry, nil
+}
+
+// String 还原为 index.txt 行
+func (e IndexEntry) String() string {
+	revoked := ""
+	if e.Status == "R" {
+		revoked = e.RevokedAt.UTC().Format(indexTimeLayout)
+		if e.Reason != "" {
+			revoked += "," + e.Reason
+		}
+	}
+	return strings.Join([]string{
+		e.Status,
+		e.NotAfter.UTC().Format(indexTimeLayout),
+		revoked,
+		e.Serial,
+		"unknown",
+		e.Subject,
+	}, "\t")
+}
+
+// writeIndexLocked 写入 index.txt，并与 openssl 一样保留 index.txt.old
+func (ca *CA) writeIndexLocked(entries []IndexEntry) error {
+	indexFile := filepath.Join(ca.PkiPath, "index.txt")
+
+	if old, err := os.ReadFile(indexFile); err == nil {
+		if err := writeFileAtomic(indexFile+".old", old, 0644); err != nil {
+			return fmt.Errorf("无法备份index.txt: %w", err)
+		}
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.String())
+		b.WriteString("\n")
+	}
+	if err := writeFileAtomic(indexFile, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("无法写入index.txt: %w", err)
+	}
+
+	attrFile := filepath.Join(ca.PkiPath, "index.txt.attr")
+	if _, err := os.Stat(attrFile); os.IsNotExist(err) {
+		if err := writeFileAtomic(attrFile, []byte("unique_subject = no\n"), 0644); err != nil {
+			return fmt.Errorf("无法写入index.txt.attr: %w", err)
+		}
+	}
+	return nil
+}
+
+// serialString 以 openssl 的格式输出序列号(大写十六进制, 偶数位)
+func serialString(n *big.Int) string {
+	s := strings.ToUpper(n.Text(16))
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	return s
+}
+
+// validName 客户端名称只允许出现在文件名中安全的字符
+func validName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\t\n\r\x00")
+}
+
+func writePEM(path string, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return writeFileAtomic(path, data, perm)
+}
+
+// writePEMTemp 将 PEM 写入 path 所在目录的临时文件，返回临时文件名，由调用方重命名
+func writePEMTemp(path string, blockType string, der []byte, perm os.FileMode) (string, error) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return writeTemp(path, data, perm)
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免写入一半的文件被 OpenVPN 读到
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpName, err := writeTemp(path, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// writeTemp 在 path 所在目录写入临时文件
+func writeTemp(path string, data []byte, perm os.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return "", err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return "", err
+	}
+	return tmpName, nil
+}
@@ -0,0 +1,413 @@
+// pki/pki_test.go
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCA 在临时目录中生成 easyrsa 结构的 CA
+func newTestCA(t *testing.T) *CA {
+	t.Helper()
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Easy-RSA CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Easy-RSA CA"},
+	}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEM(filepath.Join(dir, "ca.crt"), "CERTIFICATE", der, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePEM(filepath.Join(dir, "private", "ca.key"), "PRIVATE KEY", keyDER, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := LoadCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 测试中使用较短的密钥，加快生成速度
+	ca.KeySize = 1024
+	return ca
+}
+
+func readTrimmed(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestIssueClient(t *testing.T) {
+	ca := newTestCA(t)
+
+	cert, err := ca.IssueClient("client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "client1" {
+		t.Errorf("CommonName = %q, want client1", cert.Subject.CommonName)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("ExtKeyUsage = %v, want clientAuth", cert.ExtKeyUsage)
+	}
+	if err := cert.CheckSignatureFrom(ca.Certificate()); err != nil {
+		t.Errorf("证书签名校验失败: %v", err)
+	}
+
+	keyFile, reqFile, crtFile := ca.ClientFiles("client1")
+	for _, file := range []string{keyFile, reqFile, crtFile} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("缺少文件 %s: %v", file, err)
+		}
+	}
+	if info, err := os.Stat(keyFile); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("私钥权限 = %v, want 0600", info.Mode().Perm())
+	}
+	onDisk, err := ParseCertificateFile(crtFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !onDisk.Equal(cert) {
+		t.Error("issued 下的证书与返回的证书不一致")
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsePrivateKey(data); err != nil {
+		t.Errorf("无法解析客户端私钥: %v", err)
+	}
+
+	if _, err := ca.IssueClient("../client1"); err == nil {
+		t.Error("IssueClient 接受了包含路径的名称")
+	}
+}
+
+func TestIssueClientWriteFailure(t *testing.T) {
+	ca := newTestCA(t)
+
+	old, err := ca.IssueClient("client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _, _ := ca.ClientFiles("client1")
+	oldKey := readTrimmed(t, keyFile)
+
+	// issued 被文件占用，证书签发后无法写入
+	issued := filepath.Join(ca.PkiPath, "issued")
+	if err := os.Rename(issued, issued+".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(issued, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.IssueClient("client1"); err == nil {
+		t.Fatal("IssueClient() 在无法写入证书时没有返回错误")
+	}
+
+	if readTrimmed(t, keyFile) != oldKey {
+		t.Error("写入失败后原有私钥被替换")
+	}
+	for _, dir := range []string{"private", "reqs"} {
+		files, _ := filepath.Glob(filepath.Join(ca.PkiPath, dir, ".*.tmp*"))
+		if len(files) > 0 {
+			t.Errorf("%s 中残留临时文件 %v", dir, files)
+		}
+	}
+
+	// 原有证书保持有效，新签发的证书已吊销
+	entries, err := ca.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2", len(entries))
+	}
+	for _, e := range entries {
+		wantStatus := "R"
+		if e.Serial == SerialString(old) {
+			wantStatus = "V"
+		}
+		if e.Status != wantStatus {
+			t.Errorf("序列号 %s 状态 = %s, want %s", e.Serial, e.Status, wantStatus)
+		}
+	}
+}
+
+func TestIndexEasyrsaCompat(t *testing.T) {
+	ca := newTestCA(t)
+
+	// easyrsa 生成的 index.txt，包含服务端证书和一个已吊销的客户端证书
+	existing := []string{
+		"V\t340101000000Z\t\t7F3A1C2D4E5B6A798812345678ABCDEF\tunknown\t/CN=server",
+		"R\t340101000000Z\t240615083000Z,superseded\t0B1C2D3E4F5A6B7C8D9E0F1A2B3C4D5E\tunknown\t/CN=client1",
+	}
+	indexFile := filepath.Join(ca.PkiPath, "index.txt")
+	if err := os.WriteFile(indexFile, []byte(strings.Join(existing, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ca.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2", len(entries))
+	}
+	if entries[0].CommonName != "server" || entries[0].Status != "V" {
+		t.Errorf("entries[0] = %+v", entries[0])
+	}
+	if entries[1].Reason != ReasonSuperseded || entries[1].RevokedAt.IsZero() {
+		t.Errorf("entries[1] = %+v", entries[1])
+	}
+	for i, e := range entries {
+		if e.String() != existing[i] {
+			t.Errorf("String() = %q, want %q", e.String(), existing[i])
+		}
+	}
+
+	cert, err := ca.IssueClient("client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := SerialString(cert)
+
+	// 原有的行保持不变，新证书追加在末尾
+	lines := strings.Split(readTrimmed(t, indexFile), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("index.txt 行数 = %d, want 3", len(lines))
+	}
+	for i := range existing {
+		if lines[i] != existing[i] {
+			t.Errorf("index.txt 第 %d 行 = %q, want %q", i+1, lines[i], existing[i])
+		}
+	}
+	want := fmt.Sprintf("V\t%s\t\t%s\tunknown\t/CN=client1", cert.NotAfter.UTC().Format(indexTimeLayout), serial)
+	if lines[2] != want {
+		t.Errorf("index.txt 新行 = %q, want %q", lines[2], want)
+	}
+	if old := readTrimmed(t, indexFile+".old"); old != strings.Join(existing, "\n") {
+		t.Errorf("index.txt.old = %q", old)
+	}
+	if attr := readTrimmed(t, indexFile+".attr"); attr != "unique_subject = no" {
+		t.Errorf("index.txt.attr = %q", attr)
+	}
+
+	// serial.old 为本次序列号，serial 为下一个序列号，与 openssl ca 一致
+	if got := readTrimmed(t, filepath.Join(ca.PkiPath, "serial.old")); got != serial {
+		t.Errorf("serial.old = %q, want %q", got, serial)
+	}
+	next := serialString(new(big.Int).Add(cert.SerialNumber, big.NewInt(1)))
+	if got := readTrimmed(t, filepath.Join(ca.PkiPath, "serial")); got != next {
+		t.Errorf("serial = %q, want %q", got, next)
+	}
+	if len(serial) != 32 || serial != strings.ToUpper(serial) {
+		t.Errorf("序列号 %q 不是 32 位大写十六进制", serial)
+	}
+	if _, err := ParseCertificateFile(filepath.Join(ca.PkiPath, "certs_by_serial", serial+".pem")); err != nil {
+		t.Errorf("certs_by_serial 中缺少证书: %v", err)
+	}
+}
+
+func TestRevokeAndGenCRL(t *testing.T) {
+	ca := newTestCA(t)
+
+	oldCert, err := ca.IssueClient("client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert, err := ca.IssueClient("client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ca.IssueClient("client2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新签发后只吊销被替换的证书
+	revoked, err := ca.RevokeExcept("client1", SerialString(newCert), ReasonSuperseded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0].Serial != SerialString(oldCert) {
+		t.Fatalf("RevokeExcept 吊销了 %+v, want %s", revoked, SerialString(oldCert))
+	}
+
+	if err := ca.GenCRL(); err != nil {
+		t.Fatal(err)
+	}
+	crl := readCRL(t, ca)
+	if crl.Number.Int64() != 1 {
+		t.Errorf("CRL Number = %v, want 1", crl.Number)
+	}
+	assertRevoked(t, crl, map[string]int{SerialString(oldCert): 4})
+
+	// 删除客户端时吊销剩余的全部证书
+	revoked, err = ca.Revoke("client1", ReasonCessationOfOperation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0].Serial != SerialString(newCert) {
+		t.Fatalf("Revoke 吊销了 %+v, want %s", revoked, SerialString(newCert))
+	}
+	if _, err := ca.Revoke("client1", ReasonCessationOfOperation); !errors.Is(err, ErrNotIssued) {
+		t.Errorf("重复吊销 err = %v, want ErrNotIssued", err)
+	}
+	if _, err := ca.Revoke("client2", "bogus"); err == nil {
+		t.Error("Revoke 接受了不支持的吊销原因")
+	}
+
+	if err := ca.GenCRL(); err != nil {
+		t.Fatal(err)
+	}
+	crl = readCRL(t, ca)
+	if crl.Number.Int64() != 2 {
+		t.Errorf("CRL Number = %v, want 2", crl.Number)
+	}
+	assertRevoked(t, crl, map[string]int{
+		SerialString(oldCert): 4,
+		SerialString(newCert): 5,
+	})
+
+	list, err := ca.ListRevoked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("ListRevoked() 返回 %d 条, want 2", len(list))
+	}
+	for _, e := range list {
+		if e.Serial == SerialString(other) {
+			t.Error("client2 的证书不应被吊销")
+		}
+	}
+}
+
+func readCRL(t *testing.T, ca *CA) *x509.RevocationList {
+	t.Helper()
+	data, err := os.ReadFile(ca.CRLFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "X509 CRL" {
+		t.Fatal("crl.pem 不是有效的 PEM")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca.Certificate()); err != nil {
+		t.Fatalf("CRL 签名校验失败: %v", err)
+	}
+	if !crl.NextUpdate.After(time.Now().AddDate(0, 0, ca.CrlDays-1)) {
+		t.Errorf("CRL NextUpdate = %v", crl.NextUpdate)
+	}
+	return crl
+}
+
+// assertRevoked 检查 CRL 中的序列号和吊销原因代码
+func assertRevoked(t *testing.T, crl *x509.RevocationList, want map[string]int) {
+	t.Helper()
+	if len(crl.RevokedCertificateEntries) != len(want) {
+		t.Fatalf("CRL 中有 %d 个证书, want %d", len(crl.RevokedCertificateEntries), len(want))
+	}
+	for _, e := range crl.RevokedCertificateEntries {
+		reason, ok := want[serialString(e.SerialNumber)]
+		if !ok {
+			t.Errorf("CRL 中出现了未吊销的证书 %s", serialString(e.SerialNumber))
+			continue
+		}
+		if e.ReasonCode != reason {
+			t.Errorf("证书 %s 的吊销原因 = %d, want %d", serialString(e.SerialNumber), e.ReasonCode, reason)
+		}
+	}
+}
+
+func TestIssueClientConcurrent(t *testing.T) {
+	ca := newTestCA(t)
+
+	const count = 8
+	var wg sync.WaitGroup
+	certs := make([]*x509.Certificate, count)
+	errs := make([]error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			certs[i], errs[i] = ca.IssueClient(fmt.Sprintf("client%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	serials := make(map[string]bool, count)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("client%d: %v", i, err)
+		}
+		serials[SerialString(certs[i])] = true
+	}
+	if len(serials) != count {
+		t.Errorf("序列号重复, 只有 %d 个不同的序列号", len(serials))
+	}
+
+	// 并发签发不能丢失 index.txt 中的记录
+	entries, err := ca.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != count {
+		t.Fatalf("index.txt 中有 %d 条记录, want %d", len(entries), count)
+	}
+	for _, e := range entries {
+		if !serials[e.Serial] {
+			t.Errorf("index.txt 中的序列号 %s 不属于任何签发的证书", e.Serial)
+		}
+	}
+
+	issued, errList := ca.ScanIssued()
+	if len(errList) != 0 {
+		t.Fatalf("ScanIssued 错误: %v", errList)
+	}
+	if len(issued) != count {
+		t.Errorf("issued 下有 %d 个证书, want %d", len(issued), count)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/message"
+	"strings"
+	"time"
+)
+
+// 免打扰结束检查间隔
+const quietCheckInterval = time.Minute
+
+// subscriberTarget 订阅匹配的一个发送目标
+type subscriberTarget struct {
+	User    database.User
+	Channel string   // 渠道名称，为空时发送到 To
+	To      []string // 用户邮箱
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// subscriberTargets 返回订阅了该通知的用户和渠道，同一渠道和收件人只返回一次
+// 只匹配用户当前可见的子网，指定的渠道不存在或已停用时改为发送到用户邮箱
+// ----------------------------------------------------------------------------------------------------------
+func subscriberTargets(tag string, n message.Notification) []subscriberTarget {
+	notifySubscription := database.NotifySubscription{}
+	notifySubscription.CreateNotifySubscription(global.GlobalDB)
+	subscriptions, err := notifySubscription.GetAllNotifySubscription(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[%s] 无法读取通知订阅, err:%v", tag, err)
+		return nil
+	}
+
+	severity := message.EventSeverity(n.Event)
+	users := make(map[string]*database.User)
+	visible := make(map[string]bool)
+	channels := make(map[int64]string)
+	seen := make(map[string]bool)
+	var targets []subscriberTarget
+	for _, subscription := range subscriptions {
+		userId := subscription.UserID.String
+		if subscription.Event.String != message.EVENTALL && subscription.Event.String != n.Event {
+			continue
+		}
+		if subscription.SerID.String != "" && subscription.SerID.String != n.SerID {
+			continue
+		}
+		if !message.SeverityAtLeast(severity, subscription.MinSeverity.String) {
+			continue
+		}
+
+		user, ok := users[userId]
+		if !ok {
+			user = &database.User{}
+			user.UserID.String = userId
+			if err := user.GetUserByID(global.GlobalDB); err != nil {
+				global.Log.Warnf("[%s] 订阅用户 %s 不存在, err:%v", tag, userId, err)
+				user = nil
+			} else if canSee, err := userCanSee(*user, n); err != nil {
+				global.Log.Errorf("[%s] 无法获取用户 %s 的子网, err:%v", tag, user.UserName.String, err)
+				user = nil
+			} else {
+				visible[userId] = canSee
+			}
+			users[userId] = user
+		}
+		if user == nil || !visible[userId] {
+			continue
+		}
+
+		target := subscriberTarget{User: *user}
+		if channelId := subscription.ChannelID.Int64; channelId != 0 {
+			name, ok := channels[channelId]
+			if !ok {
+				notifyChannel := database.NotifyChannel{}
+				notifyChannel.ID.Int64 = channelId
+				if err := notifyChannel.GetNotifyChannelByID(global.GlobalDB); err == nil && notifyChannel.Enabled.Bool {
+					name = notifyChannel.Name.String
+				} else {
+					global.Log.Warnf("[%s] 用户 %s 订阅的渠道 %d 不存在或已停用, 改为发送到用户邮箱", tag, user.UserName.String, channelId)
+				}
+				channels[channelId] = name
+			}
+			target.Channel = name
+		}
+		if target.Channel == "" {
+			if !global.IsValidEmail(user.UserEmail.String) {
+				global.Log.Debugf("[%s] 用户 %s 没有邮箱, 不发送订阅的通知", tag, user.UserName.String)
+				continue
+			}
+			target.To = []string{user.UserEmail.String}
+		}
+
+		key := target.Channel + "|" + strings.ToLower(strings.Join(target.To, ","))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// userCanSee 用户是否可以看到通知所属的子网，没有子网的用户客户端只有本人和管理员可见
+func userCanSee(user database.User, n message.Notification) (bool, error) {
+	userId := user.UserID.String
+	if global.IsAdmin(userId) {
+		return true, nil
+	}
+	if n.SerID == "" {
+		return n.CliID == userId, nil
+	}
+
+	userIds, err := user.QueryUserIds(global.GlobalDB, userId)
+	if err != nil {
+		return false, err
+	}
+	serIds, err := user.GetSubnetIdsByUserIds(global.GlobalDB, userIds)
+	if err != nil {
+		return false, err
+	}
+	for _, serId := range serIds {
+		if serId == n.SerID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// quietLocation 返回免打扰时段使用的时区，未设置或无法识别时使用服务器时区
+// ----------------------------------------------------------------------------------------------------------
+func quietLocation(quietHours database.QuietHours) *time.Location {
+	if quietHours.Timezone.String == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(quietHours.Timezone.String)
+	if err != nil {
+		global.Log.Warnf("[QuietHours] 用户 %s 的时区 %s 无法识别, 使用服务器时区, err:%v",
+			quietHours.UserID.String, quietHours.Timezone.String, err)
+		return time.Local
+	}
+	return location
+}
+
+// inQuietHours now 是否在用户的免打扰时段内，start 大于 end 时时段跨越零点
+func inQuietHours(quietHours database.QuietHours, now time.Time) bool {
+	if !quietHours.Enabled.Bool {
+		return false
+	}
+	start, err := time.Parse("15:04", quietHours.Start.String)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", quietHours.End.String)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(quietLocation(quietHours))
+	minute := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from == to {
+		return false
+	}
+	if from < to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// deliverToSubscriber 用户在免打扰时段内时暂存通知，否则写入发件箱
+// ----------------------------------------------------------------------------------------------------------
+func deliverToSubscriber(tag string, target subscriberTarget, n message.Notification) bool {
+	quietHours := database.QuietHours{}
+	quietHours.UserID.String = target.User.UserID.String
+	if err := quietHours.GetQuietHoursByUserID(global.GlobalDB); err == nil && inQuietHours(quietHours, time.Now()) {
+		held := database.NotifyHeld{}
+		held.UserID.String = target.User.UserID.String
+		held.Channel.String = target.Channel
+		held.Recipients.String = strings.Join(target.To, ",")
+		held.Event.String = n.Event
+		held.Severity.String = message.EventSeverity(n.Event)
+		held.SerID.String = n.SerID
+		held.CliID.String = n.CliID
+		held.Title.String = n.Title
+		held.Text.String = n.Text
+		held.CreatedAt.Int64 = n.Time
+		if err := held.InsertNotifyHeld(global.GlobalDB); err != nil {
+			global.Log.Errorf("[%s] 用户 %s 处于免打扰时段, 通知 %s 无法暂存, err:%v", tag, target.User.UserName.String, n.Event, err)
+			return false
+		}
+		global.Log.Debugf("[%s] 用户 %s 处于免打扰时段, 通知 %s 已暂存, channel:[%s]", tag, target.User.UserName.String, n.Event, target.Channel)
+		return true
+	}
+
+	if target.Channel == "" {
+		n.To = target.To
+	}
+	return queueNotification(tag, target.Channel, n)
+}
+
+// QuietHoursWorker 免打扰时段结束后将暂存的通知合并为摘要发送
+func QuietHoursWorker() {
+	global.Log.Infof("[QuietHours] start")
+	for {
+		releaseHeldNotifications(time.Now())
+		time.Sleep(quietCheckInterval)
+	}
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// releaseHeldNotifications 按用户、渠道和收件人合并已不在免打扰时段内的暂存通知，写入发件箱后删除
+// ----------------------------------------------------------------------------------------------------------
+func releaseHeldNotifications(now time.Time) {
+	var err error
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[QuietHours] 数据库连接失败, err:%v", err)
+		return
+	}
+
+	notifySubscription := database.NotifySubscription{}
+	notifySubscription.CreateNotifySubscription(global.GlobalDB)
+	held := database.NotifyHeld{}
+	items, err := held.GetAllNotifyHeld(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[QuietHours] 读取暂存的通知失败, err:%v", err)
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+	quietHours := database.QuietHours{}
+	allQuietHours, err := quietHours.GetAllQuietHours(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[QuietHours] 读取免打扰设置失败, err:%v", err)
+		return
+	}
+
+	var keys []string
+	groups := make(map[string][]database.NotifyHeld)
+	for _, item := range items {
+		if inQuietHours(allQuietHours[item.UserID.String], now) {
+			continue
+		}
+		key := item.UserID.String + "|" + item.Channel.String + "|" + item.Recipients.String
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], item)
+	}
+
+	for _, key := range keys {
+		group := groups[key]
+		if !sendHeldSummary(group, allQuietHours[group[0].UserID.String]) {
+			continue
+		}
+		ids := make([]int64, 0, len(group))
+		for _, item := range group {
+			ids = append(ids, item.ID.Int64)
+		}
+		if err := database.DeleteNotifyHeld(global.GlobalDB, ids); err != nil {
+			global.Log.Errorf("[QuietHours] 删除已发送的暂存通知失败, err:%v", err)
+		}
+	}
+}
+
+// sendHeldSummary 将同一用户、渠道和收件人的暂存通知渲染为一条摘要写入发件箱，时间按用户时区显示
+func sendHeldSummary(group []database.NotifyHeld, quietHours database.QuietHours) bool {
+	location := quietLocation(quietHours)
+	userName := group[0].UserID.String
+	user := database.User{}
+	user.UserID.String = group[0].UserID.String
+	if err := user.GetUserByID(global.GlobalDB); err == nil {
+		userName = user.UserName.String
+	}
+
+	events := make([]map[string]interface{}, 0, len(group))
+	for _, item := range group {
+		events = append(events, map[string]interface{}{
+			"Time":     time.Unix(item.CreatedAt.Int64, 0).In(location).Format("2006-01-02 15:04:05"),
+			"Event":    item.Event.String,
+			"Severity": item.Severity.String,
+			"Title":    item.Title.String,
+		})
+	}
+	rendered, err := notifyTemplates().Render(message.TEMPLATEQUIETSUMMARY, "", map[string]interface{}{
+		"UserName": userName,
+		"Count":    len(group),
+		"Start":    time.Unix(group[0].CreatedAt.Int64, 0).In(location).Format("2006-01-02 15:04"),
+		"End":      time.Unix(group[len(group)-1].CreatedAt.Int64, 0).In(location).Format("2006-01-02 15:04"),
+		"Timezone": location.String(),
+		"Events":   events,
+	})
+	if err != nil {
+		global.Log.Errorf("[QuietHours] 渲染免打扰摘要模板失败, err:%v", err)
+		return false
+	}
+
+	global.Log.Infof("[QuietHours] 用户 %s 的免打扰时段已结束, 发送暂存的 %d 条通知, channel:[%s]", userName, len(group), group[0].Channel.String)
+	return queueNotification("QuietHours", group[0].Channel.String, message.Notification{
+		Event: message.TEMPLATEQUIETSUMMARY,
+		Title: rendered.Subject,
+		Text:  rendered.Text,
+		HTML:  rendered.HTML,
+		To:    group[0].RecipientList(),
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/management"
+	"time"
+)
+
+// TrafficCollector 定期解析 OpenVPN status 文件，记录每个客户端的连接和流量
+func TrafficCollector() {
+	global.Log.Infof("[TrafficCollector] start")
+	for {
+		interval := global.GlobalJWireGuardini.TrafficInterval
+		if interval <= 0 {
+			interval = 60
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		CollectTraffic()
+	}
+}
+
+// CollectTraffic 执行一次流量采集
+func CollectTraffic() {
+	statusFile := global.GlobalJWireGuardini.TrafficStatusFile
+	status, err := management.ParseStatusFile(statusFile)
+	if err != nil {
+		global.Log.Errorf("[TrafficCollector] 无法解析 %s, err:%v", statusFile, err)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[TrafficCollector] 数据库连接失败, err:%v", err)
+		return
+	}
+
+	trafficSession := database.TrafficSession{}
+	trafficSession.CreateTrafficSession(global.GlobalDB)
+	trafficDaily := database.TrafficDaily{}
+	trafficDaily.CreateTrafficDaily(global.GlobalDB)
+
+	clientConfig := database.CliConfig{}
+	clientConfig.CreateCliConfig(global.GlobalDB)
+	clientConfigs, err := clientConfig.GetAllCliConfig(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[TrafficCollector] 获取客户端列表失败, err:%v", err)
+		return
+	}
+
+	serIds := make(map[string]string, len(clientConfigs))
+	for _, config := range clientConfigs {
+		serIds[config.CliID.String] = config.SerID.String
+	}
+
+	var samples []database.TrafficSession
+	for _, client := range status.Clients {
+		serId, ok := serIds[client.CommonName]
+		if !ok {
+			global.Log.Debugf("[TrafficCollector] 未知的客户端: [%s]", client.CommonName)
+			continue
+		}
+		var connectedSince int64
+		if !client.ConnectedSince.IsZero() {
+			connectedSince = client.ConnectedSince.Unix()
+		}
+		samples = append(samples, database.TrafficSession{
+			CliID:          sql.NullString{String: client.CommonName, Valid: true},
+			SerID:          sql.NullString{String: serId, Valid: serId != ""},
+			RealAddress:    sql.NullString{String: client.RealAddress, Valid: true},
+			VirtualAddress: sql.NullString{String: client.VirtualAddress, Valid: client.VirtualAddress != ""},
+			ConnectedSince: sql.NullInt64{Int64: connectedSince, Valid: true},
+			BytesReceived:  sql.NullInt64{Int64: client.BytesReceived, Valid: true},
+			BytesSent:      sql.NullInt64{Int64: client.BytesSent, Valid: true},
+		})
+	}
+
+	err = database.SaveTrafficSample(global.GlobalDB, samples, time.Now())
+	if err != nil {
+		global.Log.Errorf("[TrafficCollector] 保存流量记录失败, err:%v", err)
+		return
+	}
+	global.Log.Debugf("[TrafficCollector] 已记录 %d 个连接", len(samples))
+}
@@ -0,0 +1,352 @@
+// udpproxy/udpproxy.go
+package udpproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 单个 UDP 数据包的最大长度
+const bufferSize = 65535
+
+// ErrTooManySessions 会话数已达上限，新客户端的数据包被丢弃
+var ErrTooManySessions = errors.New("udp proxy session limit reached")
+
+// Logger 记录代理日志，由调用方传入 global.Log
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Config 代理配置
+type Config struct {
+	Listen      string        // 监听地址，如 :1195
+	Upstream    string        // 转发目标，如 127.0.0.1:1194
+	IdleTimeout time.Duration // 会话空闲超时
+	MaxSessions int           // 最大会话数
+}
+
+// Proxy 按客户端地址建立会话，每个会话使用单独的上游连接，回复按会话返回给对应的客户端
+type Proxy struct {
+	cfg      Config
+	conn     *net.UDPConn
+	upstream *net.UDPAddr
+	log      Logger
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	closed   bool
+
+	rejected atomic.Int64 // 超过会话上限被丢弃的数据包
+}
+
+type session struct {
+	client    *net.UDPAddr
+	upstream  *net.UDPConn
+	createdAt time.Time
+
+	lastActive atomic.Int64 // unix 纳秒
+	bytesIn    atomic.Int64 // 客户端 -> 上游
+	bytesOut   atomic.Int64 // 上游 -> 客户端
+	packetsIn  atomic.Int64
+	packetsOut atomic.Int64
+}
+
+// SessionStats 会话计数
+type SessionStats struct {
+	Listen     string `json:"listen"`
+	Client     string `json:"client"`
+	Upstream   string `json:"upstream"`
+	CreatedAt  int64  `json:"created_at"`
+	LastActive int64  `json:"last_active"`
+	BytesIn    int64  `json:"bytes_in"`
+	BytesOut   int64  `json:"bytes_out"`
+	PacketsIn  int64  `json:"packets_in"`
+	PacketsOut int64  `json:"packets_out"`
+}
+
+// 正在运行的代理，供管理接口查看会话
+var running struct {
+	mu      sync.Mutex
+	proxies []*Proxy
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// New 监听 cfg.Listen，上游地址可以是任意主机
+// ----------------------------------------------------------------------------------------------------------
+func New(cfg Config, log Logger) (*Proxy, error) {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = time.Minute
+	}
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = 1024
+	}
+
+	upstream, err := net.ResolveUDPAddr("udp", cfg.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析上游地址 %s: %w", cfg.Upstream, err)
+	}
+	listen, err := net.ResolveUDPAddr("udp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析监听地址 %s: %w", cfg.Listen, err)
+	}
+	conn, err := net.ListenUDP("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Proxy{
+		cfg:      cfg,
+		conn:     conn,
+		upstream: upstream,
+		log:      log,
+		sessions: make(map[string]*session),
+	}, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// Serve 转发客户端数据包，直到 Close
+// ----------------------------------------------------------------------------------------------------------
+func (p *Proxy) Serve() error {
+	running.mu.Lock()
+	running.proxies = append(running.proxies, p)
+	running.mu.Unlock()
+	defer p.unregister()
+
+	go p.sweepLoop()
+
+	buffer := make([]byte, bufferSize)
+	for {
+		n, addr, err := p.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+			p.log.Errorf("[udpproxy] 读取UDP失败: %v", err)
+			continue
+		}
+
+		if err := p.forward(addr, buffer[:n]); err != nil {
+			p.log.Errorf("[udpproxy] 从 %s 转发到 %s 失败: %v", addr, p.upstream, err)
+		}
+	}
+}
+
+// forward 通过客户端的会话转发数据包
+// 会话可能在取出后被关闭(上游读取失败)，此时用新的会话重试一次
+func (p *Proxy) forward(addr *net.UDPAddr, data []byte) error {
+	for retry := 0; ; retry++ {
+		s, err := p.session(addr)
+		if err != nil {
+			if p.rejected.Add(1)%1000 == 1 {
+				p.log.Warnf("[udpproxy] 丢弃来自 %s 的数据包, err:%v", addr, err)
+			}
+			return nil
+		}
+		s.bytesIn.Add(int64(len(data)))
+		s.packetsIn.Add(1)
+		_, err = s.upstream.Write(data)
+		if errors.Is(err, net.ErrClosed) && retry == 0 && !p.isClosed() {
+			p.remove(s)
+			continue
+		}
+		return err
+	}
+}
+
+// session 获取客户端的会话并更新活动时间，不存在时建立上游连接
+// 活动时间在 p.mu 中更新，sweepLoop 不会关闭刚取出的会话
+func (p *Proxy) session(addr *net.UDPAddr) (*session, error) {
+	key := addr.String()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.sessions[key]; ok {
+		s.touch()
+		return s, nil
+	}
+	if p.closed {
+		return nil, net.ErrClosed
+	}
+	if len(p.sessions) >= p.cfg.MaxSessions {
+		return nil, ErrTooManySessions
+	}
+
+	upstream, err := net.DialUDP("udp", nil, p.upstream)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		client:    addr,
+		upstream:  upstream,
+		createdAt: time.Now(),
+	}
+	s.touch()
+	p.sessions[key] = s
+	go p.reply(s)
+
+	p.log.Debugf("[udpproxy] 新会话 %s ⇄ %s (本地 %s), 当前 %d 个会话", key, p.upstream, upstream.LocalAddr(), len(p.sessions))
+	return s, nil
+}
+
+// reply 将上游的回复返回给会话对应的客户端，上游连接关闭后退出
+func (p *Proxy) reply(s *session) {
+	buffer := make([]byte, bufferSize)
+	for {
+		n, err := s.upstream.Read(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.log.Warnf("[udpproxy] 会话 %s 读取上游失败: %v", s.client, err)
+			}
+			p.remove(s)
+			return
+		}
+		s.touch()
+		s.bytesOut.Add(int64(n))
+		s.packetsOut.Add(1)
+		if _, err := p.conn.WriteToUDP(buffer[:n], s.client); err != nil {
+			p.log.Errorf("[udpproxy] 返回客户端 %s 失败: %v", s.client, err)
+		}
+	}
+}
+
+// sweepLoop 关闭空闲超时的会话
+func (p *Proxy) sweepLoop() {
+	interval := p.cfg.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if p.isClosed() {
+			return
+		}
+		deadline := time.Now().Add(-p.cfg.IdleTimeout).UnixNano()
+
+		p.mu.Lock()
+		var idle []*session
+		for _, s := range p.sessions {
+			if s.lastActive.Load() < deadline {
+				idle = append(idle, s)
+			}
+		}
+		p.mu.Unlock()
+
+		for _, s := range idle {
+			p.removeIdle(s, deadline)
+		}
+	}
+}
+
+// remove 关闭会话的上游连接并记录流量
+func (p *Proxy) remove(s *session) {
+	p.removeIdle(s, 0)
+}
+
+// removeIdle 会话在 deadline 之前没有活动时关闭，deadline 为 0 时直接关闭
+// 在 p.mu 中重新检查活动时间，收集空闲会话后又收到数据包的会话不会被关闭
+func (p *Proxy) removeIdle(s *session, deadline int64) {
+	key := s.client.String()
+
+	p.mu.Lock()
+	current, ok := p.sessions[key]
+	remove := ok && current == s && (deadline == 0 || s.lastActive.Load() < deadline)
+	if remove {
+		delete(p.sessions, key)
+	}
+	p.mu.Unlock()
+	if !remove {
+		return
+	}
+
+	s.upstream.Close()
+	p.log.Infof("[udpproxy] 会话 %s 已关闭, 持续 %s, 上行 %d 字节, 下行 %d 字节",
+		key, time.Since(s.createdAt).Truncate(time.Second), s.bytesIn.Load(), s.bytesOut.Load())
+}
+
+// Sessions 当前会话的计数，按客户端地址排序
+func (p *Proxy) Sessions() []SessionStats {
+	p.mu.Lock()
+	stats := make([]SessionStats, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		stats = append(stats, SessionStats{
+			Listen:     p.cfg.Listen,
+			Client:     s.client.String(),
+			Upstream:   p.upstream.String(),
+			CreatedAt:  s.createdAt.Unix(),
+			LastActive: time.Unix(0, s.lastActive.Load()).Unix(),
+			BytesIn:    s.bytesIn.Load(),
+			BytesOut:   s.bytesOut.Load(),
+			PacketsIn:  s.packetsIn.Load(),
+			PacketsOut: s.packetsOut.Load(),
+		})
+	}
+	p.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Client < stats[j].Client })
+	return stats
+}
+
+// Addr 监听地址
+func (p *Proxy) Addr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+// Close 停止监听并关闭所有会话
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	sessions := make([]*session, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.mu.Unlock()
+
+	for _, s := range sessions {
+		p.remove(s)
+	}
+	return p.conn.Close()
+}
+
+func (p *Proxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *Proxy) unregister() {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	for i, proxy := range running.proxies {
+		if proxy == p {
+			running.proxies = append(running.proxies[:i], running.proxies[i+1:]...)
+			return
+		}
+	}
+}
+
+// AllSessions 所有运行中代理的会话
+func AllSessions() []SessionStats {
+	running.mu.Lock()
+	proxies := append([]*Proxy(nil), running.proxies...)
+	running.mu.Unlock()
+
+	var stats []SessionStats
+	for _, p := range proxies {
+		stats = append(stats, p.Sessions()...)
+	}
+	return stats
+}
+
+func (s *session) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
@@ -0,0 +1,141 @@
+// udpproxy/udpproxy_test.go
+package udpproxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLogger 将代理日志输出到测试日志
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Debugf(format string, args ...interface{}) { l.t.Logf(format, args...) }
+func (l testLogger) Infof(format string, args ...interface{})  { l.t.Logf(format, args...) }
+func (l testLogger) Warnf(format string, args ...interface{})  { l.t.Logf(format, args...) }
+func (l testLogger) Errorf(format string, args ...interface{}) { l.t.Errorf(format, args...) }
+
+// newEchoServer 在本地回环地址启动上游，回复 "<来源地址>|<数据>"
+func newEchoServer(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, bufferSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(append([]byte(addr.String()+"|"), buffer[:n]...), addr)
+		}
+	}()
+	return conn
+}
+
+// newTestProxy 启动转发到 upstream 的代理
+func newTestProxy(t *testing.T, upstream net.Addr, idleTimeout time.Duration) *Proxy {
+	t.Helper()
+	p, err := New(Config{
+		Listen:      "127.0.0.1:0",
+		Upstream:    upstream.String(),
+		IdleTimeout: idleTimeout,
+		MaxSessions: 8,
+	}, testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve()
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// newClient 建立连接到代理的客户端
+func newClient(t *testing.T, p *Proxy) *net.UDPConn {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, p.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// roundTrip 发送 data 并返回上游看到的来源地址
+func roundTrip(t *testing.T, conn *net.UDPConn, data string) string {
+	t.Helper()
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, bufferSize)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatalf("等待回复失败: %v", err)
+	}
+	source, payload, ok := strings.Cut(string(buffer[:n]), "|")
+	if !ok || payload != data {
+		t.Fatalf("回复 = %q, want <来源地址>|%s", buffer[:n], data)
+	}
+	return source
+}
+
+func TestProxySessions(t *testing.T) {
+	upstream := newEchoServer(t)
+	p := newTestProxy(t, upstream.LocalAddr(), time.Minute)
+
+	client1 := newClient(t, p)
+	client2 := newClient(t, p)
+
+	// 每个客户端使用单独的上游连接，回复只返回给对应的客户端
+	source1 := roundTrip(t, client1, "hello from 1")
+	source2 := roundTrip(t, client2, "hello from 2")
+	if source1 == source2 {
+		t.Errorf("两个客户端共用上游地址 %s", source1)
+	}
+	if again := roundTrip(t, client1, "again from 1"); again != source1 {
+		t.Errorf("同一客户端的上游地址 = %s, want %s", again, source1)
+	}
+
+	stats := p.Sessions()
+	if len(stats) != 2 {
+		t.Fatalf("len(Sessions) = %d, want 2", len(stats))
+	}
+	for _, s := range stats {
+		if s.Client == client1.LocalAddr().String() {
+			if s.PacketsIn != 2 || s.PacketsOut != 2 {
+				t.Errorf("client1 会话计数 = %+v, want 2/2", s)
+			}
+		}
+	}
+}
+
+func TestProxyIdleTimeout(t *testing.T) {
+	upstream := newEchoServer(t)
+	p := newTestProxy(t, upstream.LocalAddr(), 200*time.Millisecond)
+
+	client := newClient(t, p)
+	source := roundTrip(t, client, "first")
+
+	// 清理间隔最短 1 秒
+	deadline := time.Now().Add(3 * time.Second)
+	for len(p.Sessions()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("空闲会话没有关闭")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// 会话关闭后客户端重新发送，建立新的上游连接
+	if again := roundTrip(t, client, "second"); again == source {
+		t.Errorf("空闲超时后仍使用原有的上游地址 %s", source)
+	}
+	if len(p.Sessions()) != 1 {
+		t.Errorf("len(Sessions) = %d, want 1", len(p.Sessions()))
+	}
+}
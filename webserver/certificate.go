@@ -0,0 +1,202 @@
+// webservice/certificate.go
+package webservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RevokedCert struct {
+	Serial    string `json:"serial"`
+	CliID     string `json:"cli_id"`
+	Reason    string `json:"reason"`
+	RevokedAt int64  `json:"revoked_at"`
+	NotAfter  int64  `json:"not_after"`
+}
+
+type ResponseRevokedList struct {
+	Status  bool          `json:"status"`
+	Message string        `json:"message"`
+	Data    []RevokedCert `json:"data"`
+}
+
+func registerCertRoutes() {
+	http.HandleFunc("/get_revoked_list", ValidateSessionMiddleware(GetRevokedList))
+	http.HandleFunc("/get_cert_expiring", ValidateSessionMiddleware(GetCertExpiring))
+}
+
+// GetRevokedList 获取已吊销证书列表
+func GetRevokedList(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_revoked_list] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_revoked_list] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_revoked_list] client [%s:%s]", ip, port)
+	// 解析 URL 参数, cli_id 为空时返回全部
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	global.Log.Debugf("[get_revoked_list] cli_id:[%s]", cliId)
+
+	ca, err := global.GetCA()
+	if err != nil {
+		global.Log.Errorf("[get_revoked_list] 无法加载CA, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法加载CA, err:%v", err),
+			Error:   3401,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	entries, err := ca.ListRevoked()
+	if err != nil {
+		global.Log.Errorf("[get_revoked_list] 无法读取证书索引, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法读取证书索引, err:%v", err),
+			Error:   3402,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	revokedCerts := []RevokedCert{}
+	for _, entry := range entries {
+		if cliId != "" && entry.CommonName != cliId {
+			continue
+		}
+		revokedCerts = append(revokedCerts, RevokedCert{
+			Serial:    entry.Serial,
+			CliID:     entry.CommonName,
+			Reason:    entry.Reason,
+			RevokedAt: entry.RevokedAt.Unix(),
+			NotAfter:  entry.NotAfter.Unix(),
+		})
+	}
+
+	responseRevokedList := ResponseRevokedList{
+		Status:  true,
+		Message: "获取吊销列表成功!",
+		Data:    revokedCerts,
+	}
+
+	// 将JSON对象转为字符串
+	jsonData, err := json.Marshal(responseRevokedList)
+	if err != nil {
+		global.Log.Errorf("[get_revoked_list] 无法将JSON对象转为字符串, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法将JSON对象转为字符串, err:%v", err),
+			Error:   3403,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	// 设置响应头，指明内容类型为 JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// GetCertExpiring 获取 N 天内到期的客户端证书列表
+func GetCertExpiring(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cert_expiring] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cert_expiring] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cert_expiring] client [%s:%s]", ip, port)
+	// 解析 URL 参数, days 为空时使用配置的提醒天数, ser_id 为空时返回全部
+	query := r.URL.Query()
+	serId := query.Get("ser_id")
+	days := global.GlobalJWireGuardini.CertRemindDays
+	if daysStr := query.Get("days"); daysStr != "" {
+		days, err = strconv.Atoi(daysStr)
+		if err != nil || days < 0 {
+			global.Log.Errorf("[get_cert_expiring] 天数参数错误:[%s]", daysStr)
+			responseError := ResponseError{
+				Status:  false,
+				Message: "天数参数错误",
+				Error:   3404,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+	}
+	global.Log.Debugf("[get_cert_expiring] ser_id:[%s] days:[%d]", serId, days)
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cert_expiring] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliConfig := database.CliConfig{}
+	cliConfig.CreateCliConfig(global.GlobalDB)
+	cliConfig.SerID.String = serId
+	before := time.Now().AddDate(0, 0, days).Unix()
+	cliConfigs, err := cliConfig.GetCliConfigCertExpiring(global.GlobalDB, before)
+	if err != nil {
+		global.Log.Errorf("[get_cert_expiring] 获取证书到期列表失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取证书到期列表失败, err:%v", err),
+			Error:   3405,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseCliList := ResponseCliList{
+		Status:  true,
+		Message: "获取证书到期列表成功!",
+		Data:    database.ConvertCliConfigs(cliConfigs),
+	}
+
+	// 将JSON对象转为字符串
+	jsonData, err := json.Marshal(responseCliList)
+	if err != nil {
+		global.Log.Errorf("[get_cert_expiring] 无法将JSON对象转为字符串, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法将JSON对象转为字符串, err:%v", err),
+			Error:   3406,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	// 设置响应头，指明内容类型为 JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
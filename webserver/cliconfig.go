@@ -4,6 +4,7 @@ package webservice
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"jwireguard/database"
@@ -36,9 +37,10 @@ type ResponseCliList struct {
 }
 
 type ResponseCliInfo struct {
-	Status  bool                       `json:"status"`
-	Message string                     `json:"message"`
-	Data    database.ExportedCliConfig `json:"data"`
+	Status    bool                           `json:"status"`
+	Message   string                         `json:"message"`
+	Data      database.ExportedCliConfig     `json:"data"`
+	Telemetry *database.ExportedCliTelemetry `json:"telemetry,omitempty"` // 最近一次上报的运行状态
 }
 
 type PostCliConfig struct {
@@ -320,42 +322,26 @@ func GetCliConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	headClient := fmt.Sprintf("%s/openvpn.txt", global.GlobalOpenVPNPath.ConfigPath)
-	caClient := fmt.Sprintf("%s/ca.crt", global.GlobalOpenVPNPath.PkiPath)
-	taClient := fmt.Sprintf("%s/ta.key", global.GlobalOpenVPNPath.PkiPath)
-	ovpnClient := fmt.Sprintf("%s/%s.ovpn", global.GlobalOpenVPNPath.ConfigPath, cliId)
-	privateClient := fmt.Sprintf("%s/%s.key", global.GlobalOpenVPNPath.PrivatePath, cliId)
-	issuedClient := fmt.Sprintf("%s/%s.crt", global.GlobalOpenVPNPath.IssuedPath, cliId)
-
-	// Define file paths
-	files := map[string]string{
-		"key":      privateClient,
-		"cert":     issuedClient,
-		"ca":       caClient,
-		"tls-auth": taClient,
-	}
-
-	for _, file := range files {
-		if !global.CheckFileExists(file) {
-			global.Log.Errorf("[get_cli_config] 该%s文件不存在", file)
-			responseError := ResponseError{
-				Status:  false,
-				Message: fmt.Sprintf("该%s文件不存在", file),
-				Error:   1203,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(responseError)
-			return
+	// 按子网的隧道后端生成客户端配置
+	backend, err := cliBackend(cliConfig)
+	if err != nil {
+		global.Log.Errorf("[get_cli_config] 无法获取客户端的隧道后端, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法获取客户端的隧道后端, err:%v", err),
+			Error:   1203,
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
 	}
 
-	// Create the .ovpn file
-	err = global.CreateOVPNFile(headClient, ovpnClient, files)
+	cliConfigFile, err := backend.ClientConfigFile(cliId)
 	if err != nil {
-		global.Log.Errorf("[get_cli_config] 无法合成%s.ovpn, err:%v", cliId, err)
+		global.Log.Errorf("[get_cli_config] 无法生成客户端配置, err:%v", err)
 		responseError := ResponseError{
 			Status:  false,
-			Message: fmt.Sprintf("无法合成%s.ovpn, err:%v", cliId, err),
+			Message: fmt.Sprintf("无法生成客户端配置, err:%v", err),
 			Error:   1204,
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -364,7 +350,7 @@ func GetCliConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 检查配置文件是否存在
-	if !global.CheckFileExists(ovpnClient) {
+	if !global.CheckFileExists(cliConfigFile) {
 		global.Log.Errorln("[get_cli_config] 客户端配置不存在")
 		responseError := ResponseError{
 			Status:  false,
@@ -376,7 +362,7 @@ func GetCliConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cliConfigText, err := ioutil.ReadFile(ovpnClient)
+	cliConfigText, err := ioutil.ReadFile(cliConfigFile)
 	if err != nil {
 		global.Log.Errorf("[get_cli_config] 客户端配置读取失败, err:%v", err)
 		responseError := ResponseError{
@@ -423,7 +409,7 @@ func GetCliConfig(w http.ResponseWriter, r *http.Request) {
 
 func GetCliList(w http.ResponseWriter, r *http.Request) {
 	XUserID := r.Header.Get("X-User-ID")
-	global.Log.Debugf("[get_cli_list] userID:", XUserID)
+	global.Log.Debugln("[get_cli_list] userID:", XUserID)
 
 	addr := r.RemoteAddr
 	ip, port, err := net.SplitHostPort(addr)
@@ -588,18 +574,10 @@ func GetCliInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	changClientFile := fmt.Sprintf("%s/%s",
-		global.GlobalOpenVPNPath.CcdPath,
-		cliConfig.CliID.String)
-
-	changClientAddr := fmt.Sprintf("ifconfig-push %s %s\npush \"route %s.0.0 %s %s\"\n",
-		cliConfig.CliAddress.String,
-		global.GlobalJWireGuardini.SubnetMask,
-		global.GlobalJWireGuardini.IPPrefix,
-		global.GlobalJWireGuardini.NetworkMask,
-		cliConfig.CliAddress.String)
-
-	err = global.WriteToFile(changClientFile, changClientAddr)
+	network, err := cliNetwork(cliConfig)
+	if err == nil {
+		err = global.WriteClientCcd(cliConfig.CliID.String, cliConfig.CliAddress.String, network)
+	}
 	if err != nil {
 		// 如果参数为空，返回 JSON 错误响应
 		global.Log.Errorf("[update_cli_addr] 在文件中修改客户端IP地址失败, err:%v", err)
@@ -614,9 +592,10 @@ func GetCliInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	responseCliInfo := ResponseCliInfo{
-		Status:  true,
-		Message: "获取客户端列表成功!",
-		Data:    cliConfig.ToExported(),
+		Status:    true,
+		Message:   "获取客户端列表成功!",
+		Data:      cliConfig.ToExported(),
+		Telemetry: getLatestCliTelemetry(cliId),
 	}
 
 	// 将JSON对象转为字符串
@@ -759,23 +738,27 @@ func AddCLiConfig(w http.ResponseWriter, r *http.Request) {
 	subnet.SerID.String = serNameSHA3
 	err = subnet.GetSubnetBySerId(global.GlobalDB)
 	if err != nil {
-		//获取新的网段
-		newSubNum, err := subnet.GetNewSubnetNumber(global.GlobalDB)
-		if err != nil {
-			global.Log.Errorf("[add_cli_config] 子网网段已满, err:%v", err)
+		//获取新的网段，网段的分配和插入在同一事务中
+		subnet.CliNum.Int32 = 1
+		subnet.SerName.String = portCliConfig.SerName
+		subnet.Backend.String = global.GlobalJWireGuardini.TunnelBackend
+		var resolveErr error
+		_, err = subnet.InsertSubnetWithNetwork(global.GlobalDB, func(used []*net.IPNet) (*net.IPNet, error) {
+			network, err := database.NewSubnetNetwork(global.GlobalJWireGuardini.SubnetPrefixLen, used)
+			resolveErr = err
+			return network, err
+		})
+		if resolveErr != nil {
+			global.Log.Errorf("[add_cli_config] 子网网段已满, err:%v", resolveErr)
 			responseError := ResponseError{
 				Status:  false,
-				Message: fmt.Sprintf("子网网段已满, err:%v", err),
+				Message: fmt.Sprintf("子网网段已满, err:%v", resolveErr),
 				Error:   1506,
 			}
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(responseError)
 			return
 		}
-		subnet.SerNum.Int32 = newSubNum
-		subnet.CliNum.Int32 = 1
-		subnet.SerName.String = portCliConfig.SerName
-		err = subnet.InsertSubnet(global.GlobalDB)
 		if err != nil {
 			global.Log.Errorf("[add_cli_config] 子网添加失败, err:%v", err)
 			responseError := ResponseError{
@@ -805,9 +788,37 @@ func AddCLiConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取客户端可用的IP地址
-	ipPrefix := fmt.Sprintf("%s.%d", global.GlobalJWireGuardini.IPPrefix, subnet.SerNum.Int32)
-	cliAddress, err := FindUnusedIP(global.GlobalOpenVPNPath.CcdPath, ipPrefix)
+	// 按子网选择隧道后端
+	backend, err := global.GetBackend(subnet.Backend.String)
+	if err != nil {
+		global.Log.Errorf("[add_cli_config] 无法获取子网的隧道后端, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法获取子网的隧道后端, err:%v", err),
+			Error:   1512,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 分配客户端IP地址
+	network, err := subnet.Network()
+	var cliAddress string
+	if err == nil {
+		cliAddress, err = allocateCliAddress(subnet.SerID.String, portCliConfig.CliID, network)
+	}
+	if errors.Is(err, database.ErrAddressExists) {
+		global.Log.Errorf("[add_cli_config] 客户端已分配地址, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("客户端已存在, err:%v", err),
+			Error:   1515,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
 	if err != nil {
 		global.Log.Errorf("[add_cli_config] 无法获取到当前可用的客户端IP, err:%v", err)
 		responseError := ResponseError{
@@ -821,10 +832,24 @@ func AddCLiConfig(w http.ResponseWriter, r *http.Request) {
 	}
 	// fmt.Println("cliAddress：", cliAddress)
 	cliConfig.CliAddress.String = cliAddress
+	cliConfig.CliAddress6.String, err = global.ClientAddress6(cliAddress)
+	if err != nil {
+		releaseCliAddress("add_cli_config", portCliConfig.CliID, cliAddress)
+		global.Log.Errorf("[add_cli_config] 无法获取客户端IPv6地址, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法获取客户端IPv6地址, err:%v", err),
+			Error:   1514,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
 
 	// 添加客户端
-	err = global.ShellAddClient(portCliConfig.CliID, cliAddress)
+	err = backend.AddClient(portCliConfig.CliID, cliAddress, network)
 	if err != nil {
+		releaseCliAddress("add_cli_config", portCliConfig.CliID, cliAddress)
 		global.Log.Errorf("[add_cli_config] 无法添加客户端, err:%v", err)
 		responseError := ResponseError{
 			Status:  false,
@@ -848,6 +873,7 @@ func AddCLiConfig(w http.ResponseWriter, r *http.Request) {
 
 	err = cliConfig.InsertCliConfig(global.GlobalDB)
 	if err != nil {
+		releaseCliAddress("add_cli_config", portCliConfig.CliID, cliAddress)
 		global.Log.Errorf("[add_cli_config] 数据库创建客户端失败, err:%v", err)
 		responseError := ResponseError{
 			Status:  false,
@@ -859,12 +885,30 @@ func AddCLiConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	responseSuccess := ResponseSuccess{
+	recordCliChange("add_cli_config", cliConfig, database.CLICHANGEADD, XUserID)
+
+	// 签发心跳密钥，只在创建时返回一次，丢失后通过 reset_cli_secret 重新签发
+	secret, err := issueCliSecret(portCliConfig.CliID)
+	if err != nil {
+		global.Log.Errorf("[add_cli_config] 无法签发心跳密钥, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法签发心跳密钥, err:%v", err),
+			Error:   1513,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseCliSecret := ResponseCliSecret{
 		Status:  true,
 		Message: "客户端创建成功!",
+		CliID:   portCliConfig.CliID,
+		Secret:  secret,
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(responseSuccess)
+	json.NewEncoder(w).Encode(responseCliSecret)
 }
 
 func UpdateCliConfig(w http.ResponseWriter, r *http.Request) {
@@ -992,7 +1036,10 @@ func UpdateCliConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = global.ShellUpdateClient(portCliConfig.CliID)
+	backend, err := cliBackend(cliConfig)
+	if err == nil {
+		err = backend.UpdateClient(portCliConfig.CliID)
+	}
 	if err != nil {
 		global.Log.Errorf("[update_cli_config] 客户端更新失败, err:%v", err)
 		responseError := ResponseError{
@@ -1108,9 +1155,30 @@ func DelCliConfig(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(responseError)
 		return
 	}
+	deleteCliHeartbeat("del_cli_config", cliId, XUserID)
+	recordCliChange("del_cli_config", cliConfig, database.CLICHANGEDELETE, XUserID)
+
+	// 删除隧道后端中的客户端
+	backend, err := cliBackend(cliConfig)
+	if err == nil {
+		err = backend.DelClient(cliId)
+	}
 
-	// 执行SHELL命令
-	err = global.ShellDelClient(cliId)
+	// 断开已连接的客户端，后端删除失败时也要断开
+	killCliSession("del_cli_config", cliId)
+
+	var revokeErr *global.RevokeError
+	if errors.As(err, &revokeErr) {
+		global.Log.Errorf("[del_cli_config] 客户端已删除, 但证书吊销失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("客户端已删除, 但证书吊销失败, err:%v", err),
+			Error:   1706,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
 	if err != nil {
 		global.Log.Errorf("[del_cli_config] 无法删除客户端, err:%v", err)
 		responseError := ResponseError{
@@ -1226,7 +1294,21 @@ func UpdateCliInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 更新数据
+	// 更新数据，IPv6 地址随 IPv4 地址一起修改
+	if postClientInfo.CliAddress.String != "" {
+		postClientInfo.CliAddress6.String, err = global.ClientAddress6(postClientInfo.CliAddress.String)
+		if err != nil {
+			global.Log.Errorf("[update_cli_info] 无法获取客户端IPv6地址, err:%v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: fmt.Sprintf("无法获取客户端IPv6地址, err:%v", err),
+				Error:   1807,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+	}
 	err = postClientInfo.UpdateCliConfig(global.GlobalDB)
 	if err != nil {
 		// 如果参数为空，返回 JSON 错误响应
@@ -1243,22 +1325,10 @@ func UpdateCliInfo(w http.ResponseWriter, r *http.Request) {
 
 	// 如果有IP地址更改则修改IP地址
 	if postClientInfo.CliAddress.String != "" {
-		changClientFile := fmt.Sprintf("%s/%s",
-			global.GlobalOpenVPNPath.CcdPath,
-			postClientInfo.CliID.String)
-
-		// changClientAddr := fmt.Sprintf("ifconfig-push %s %s\n",
-		// 	postClientInfo.CliAddress.String,
-		// 	global.GlobalJWireGuardini.SubnetMask)
-
-		changClientAddr := fmt.Sprintf("ifconfig-push %s %s\npush \"route %s.0.0 %s %s\"\n",
-			postClientInfo.CliAddress.String,
-			global.GlobalJWireGuardini.SubnetMask,
-			global.GlobalJWireGuardini.IPPrefix,
-			global.GlobalJWireGuardini.NetworkMask,
-			postClientInfo.CliAddress.String)
-
-		err = global.WriteToFile(changClientFile, changClientAddr)
+		network, err := cliNetwork(postClientInfoBak)
+		if err == nil {
+			err = global.WriteClientCcd(postClientInfo.CliID.String, postClientInfo.CliAddress.String, network)
+		}
 		if err != nil {
 			// 如果参数为空，返回 JSON 错误响应
 			global.Log.Errorf("[update_cli_info] 修改客户端IP地址失败, err:%v", err)
@@ -1271,6 +1341,11 @@ func UpdateCliInfo(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(responseError)
 			return
 		}
+
+		// 地址变更后重新连接以获取新的地址
+		if postClientInfo.CliAddress.String != postClientInfoBak.CliAddress.String {
+			killCliSession("update_cli_info", postClientInfo.CliID.String)
+		}
 	}
 
 	// 返回结果
@@ -1357,9 +1432,16 @@ func UpdateCliAddr(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ipPrefix, _ := global.SplitIP(postClientAddress.Address)
-
-	cliAddress, err := FindUnusedIP(global.GlobalOpenVPNPath.CcdPath, ipPrefix)
+	// 在客户端所在子网中分配新地址，原有地址同时释放
+	network, err := cliNetwork(clientConfig)
+	if err == nil && !network.Contains(net.ParseIP(postClientAddress.Address)) {
+		err = fmt.Errorf("%s 不在子网 %s 中", postClientAddress.Address, network)
+	}
+	var cliAddress string
+	var previous database.IPAddress
+	if err == nil {
+		cliAddress, previous, err = reallocateCliAddress(clientConfig.SerID.String, postClientAddress.CliID, network)
+	}
 	if err != nil {
 		global.Log.Errorf("[update_subnet_cli_addr] 无法获取到当前可用的客户端IP, err:%v", err)
 		responseError := ResponseError{
@@ -1372,42 +1454,14 @@ func UpdateCliAddr(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 更新客户端IP地址
-	clientConfig.CliAddress.String = cliAddress
-	// 更新数据
-	err = clientConfig.UpdateCliConfig(global.GlobalDB)
-	if err != nil {
-		// 如果参数为空，返回 JSON 错误响应
-		global.Log.Errorf("[update_cli_addr] 在数据库中修改客户端IP地址失败, err:%v", err)
-		responseError := ResponseError{
-			Status:  false,
-			Message: fmt.Sprintf("在数据库中修改客户端IP地址失败, err:%v", err),
-			Error:   1906,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(responseError)
-		return
+	// 在隧道后端中修改客户端地址
+	backend, err := cliBackend(clientConfig)
+	if err == nil {
+		err = backend.SetClientAddress(postClientAddress.CliID, cliAddress, network)
 	}
-
-	changClientFile := fmt.Sprintf("%s/%s",
-		global.GlobalOpenVPNPath.CcdPath,
-		postClientAddress.CliID)
-	// changClientAddr := fmt.Sprintf("ifconfig-push %s 255.255.0.0", postClientAddress.Address)
-
-	// changClientAddr := fmt.Sprintf("ifconfig-push %s %s\n",
-	// 	cliAddress,
-	// 	global.GlobalJWireGuardini.SubnetMask)
-
-	changClientAddr := fmt.Sprintf("ifconfig-push %s %s\npush \"route %s.0.0 %s %s\"\n",
-		cliAddress,
-		global.GlobalJWireGuardini.SubnetMask,
-		global.GlobalJWireGuardini.IPPrefix,
-		global.GlobalJWireGuardini.NetworkMask,
-		cliAddress)
-
-	err = global.WriteToFile(changClientFile, changClientAddr)
 	if err != nil {
-		// 如果参数为空，返回 JSON 错误响应
+		// 文件仍使用原有地址，恢复分配，避免原有地址被分配给其他客户端
+		restoreCliAddress("update_cli_addr", postClientAddress.CliID, cliAddress, previous)
 		global.Log.Errorf("[update_cli_addr] 在文件中修改客户端IP地址失败, err:%v", err)
 		responseError := ResponseError{
 			Status:  false,
@@ -1419,6 +1473,9 @@ func UpdateCliAddr(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 断开客户端，重新连接后使用新的地址
+	killCliSession("update_cli_addr", postClientAddress.CliID)
+
 	// 返回结果
 	responseSuccess := ResponseAddrSuccess{
 		Status:  true,
@@ -1505,6 +1562,69 @@ func UpdateCliMap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// OpenVPN 通过 ccd 下发路由，其它后端由后端自己设置路由
+	backend, err := cliBackend(clientConfig)
+	var routeBackend global.RouteBackend
+	if err == nil && backend.Name() != global.BACKENDOPENVPN {
+		var ok bool
+		if routeBackend, ok = backend.(global.RouteBackend); !ok {
+			err = fmt.Errorf("%s 客户端不支持网络映射", backend.Name())
+		}
+	}
+	if err != nil {
+		global.Log.Errorf("[update_cli_map] 无法修改客户端映射, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法修改客户端映射, err:%v", err),
+			Error:   2009,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// WireGuard 将映射的网络加入 Peer 的 AllowedIPs，立即生效，不需要断开客户端
+	if routeBackend != nil {
+		var routes []string
+		for _, cidr := range strings.Split(postClientAddressMapping.CliMapping, ",") {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				global.Log.Errorf("[update_cli_map] 解析 %s 时出错, err:%v", cidr, err)
+				responseError := ResponseError{
+					Status:  false,
+					Message: fmt.Sprintf("解析 %s 时出错, err:%v", cidr, err),
+					Error:   2012,
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(responseError)
+				return
+			}
+			routes = append(routes, ipNet.String())
+		}
+
+		err = routeBackend.SetClientRoutes(postClientAddressMapping.CliID, routes)
+		if err != nil {
+			global.Log.Errorf("[update_cli_map] 无法修改客户端映射, err:%v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: fmt.Sprintf("无法修改客户端映射, err:%v", err),
+				Error:   2011,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+
+		global.Log.Infof("[update_cli_map] 客户端 %s 的映射已修改为 %v", postClientAddressMapping.CliID, routes)
+		responseSuccess := ResponseSuccess{
+			Status:  true,
+			Message: "客户端映射成功!",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseSuccess)
+		return
+	}
+
 	changClientFile := fmt.Sprintf("%s/%s",
 		global.GlobalOpenVPNPath.CcdPath,
 		postClientAddressMapping.CliID)
@@ -1528,12 +1648,22 @@ func UpdateCliMap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	changClientAddr := fmt.Sprintf("ifconfig-push %s %s\npush \"route %s.0.0 %s %s\"\n",
-		cliAddress,
-		global.GlobalJWireGuardini.SubnetMask,
-		global.GlobalJWireGuardini.IPPrefix,
-		global.GlobalJWireGuardini.NetworkMask,
-		cliAddress)
+	cliNet, err := cliNetwork(clientConfig)
+	var changClientAddr string
+	if err == nil {
+		changClientAddr, err = global.ClientCcd(cliAddress, cliNet)
+	}
+	if err != nil {
+		global.Log.Errorf("[update_cli_map] 无法获取客户端所在子网, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法获取客户端所在子网, err:%v", err),
+			Error:   2010,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
 
 	// 判断是否有逗号分隔符
 	if strings.Contains(postClientAddressMapping.CliMapping, ",") {
@@ -1557,7 +1687,7 @@ func UpdateCliMap(w http.ResponseWriter, r *http.Request) {
 
 			// 输出解析结果
 			global.Log.Debugf("[update_cli_map] IP 地址: %s, 子网掩码: %s, 网络地址: %s", ip, mask, network)
-			changClientAddr = fmt.Sprintf("%spush \"route %s %s %s\"\n", changClientAddr, network, mask, postClientAddressMapping.Address)
+			changClientAddr += mappingCcd(cidr, network, mask, postClientAddressMapping.Address)
 		}
 
 	} else {
@@ -1577,7 +1707,7 @@ func UpdateCliMap(w http.ResponseWriter, r *http.Request) {
 
 		// 输出解析结果
 		global.Log.Debugf("[update_cli_map] IP 地址: %s, 子网掩码: %s, 网络地址: %s", ip, mask, network)
-		changClientAddr = fmt.Sprintf("%spush \"route %s %s %s\"\n", changClientAddr, network, mask, postClientAddressMapping.Address)
+		changClientAddr += mappingCcd(postClientAddressMapping.CliMapping, network, mask, postClientAddressMapping.Address)
 	}
 
 	err = global.WriteToFile(changClientFile, changClientAddr)
@@ -1594,6 +1724,9 @@ func UpdateCliMap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 断开客户端，重新连接后使用新的路由
+	killCliSession("update_cli_map", postClientAddressMapping.CliID)
+
 	// 返回结果
 	responseSuccess := ResponseSuccess{
 		Status:  true,
@@ -1605,7 +1738,7 @@ func UpdateCliMap(w http.ResponseWriter, r *http.Request) {
 
 func UpdataSubnetCliAddr(w http.ResponseWriter, r *http.Request) {
 	XUserID := r.Header.Get("X-User-ID")
-	global.Log.Debugf("[update_subnet_cli_addr] userID:", XUserID)
+	global.Log.Debugln("[update_subnet_cli_addr] userID:", XUserID)
 
 	addr := r.RemoteAddr
 	ip, port, err := net.SplitHostPort(addr)
@@ -1715,59 +1848,47 @@ func UpdataSubnetCliAddr(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 获取客户端可用的IP地址
-	ipPrefix := fmt.Sprintf("%s.%d",
-		global.GlobalJWireGuardini.IPPrefix,
-		subnet.SerNum.Int32)
-
-	cliAddress, err := FindUnusedIP(global.GlobalOpenVPNPath.CcdPath, ipPrefix)
+	// 客户端只能迁移到同一种隧道后端的子网
+	backend, err := cliBackend(cliConfig)
+	if err == nil && backend.Name() != subnetBackendName(subnet) {
+		err = fmt.Errorf("客户端使用 %s, 目标子网使用 %s", backend.Name(), subnetBackendName(subnet))
+	}
 	if err != nil {
-		global.Log.Errorf("[update_subnet_cli_addr] 无法获取到当前可用的客户端IP, err:%v", err)
+		global.Log.Errorf("[update_subnet_cli_addr] 目标子网的隧道后端与客户端不一致, err:%v", err)
 		responseError := ResponseError{
 			Status:  false,
-			Message: fmt.Sprintf("无法获取到当前可用的客户端IP, err:%v", err),
-			Error:   2107,
+			Message: fmt.Sprintf("目标子网的隧道后端与客户端不一致, err:%v", err),
+			Error:   2108,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(responseError)
 		return
 	}
 
-	// 更新数据
-	cliConfig.CliAddress.String = cliAddress
-	err = cliConfig.UpdateCliConfig(global.GlobalDB)
+	// 在目标子网中分配新地址，原有地址同时释放
+	network, err := subnet.Network()
+	var cliAddress string
+	var previous database.IPAddress
+	if err == nil {
+		cliAddress, previous, err = reallocateCliAddress(subnet.SerID.String, portUpdateClientAddress.CliID, network)
+	}
 	if err != nil {
-		// 如果参数为空，返回 JSON 错误响应
-		global.Log.Errorf("[update_subnet_cli_addr] 在数据库中修改客户端IP地址失败, err:%v", err)
+		global.Log.Errorf("[update_subnet_cli_addr] 无法获取到当前可用的客户端IP, err:%v", err)
 		responseError := ResponseError{
 			Status:  false,
-			Message: fmt.Sprintf("在数据库中修改客户端IP地址失败, err:%v", err),
-			Error:   2105,
+			Message: fmt.Sprintf("无法获取到当前可用的客户端IP, err:%v", err),
+			Error:   2107,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(responseError)
 		return
 	}
 
-	changClientFile := fmt.Sprintf("%s/%s",
-		global.GlobalOpenVPNPath.CcdPath,
-		portUpdateClientAddress.CliID)
-	// changClientAddr := fmt.Sprintf("ifconfig-push %s 255.255.0.0", cliAddress)
-
-	// changClientAddr := fmt.Sprintf("ifconfig-push %s %s\n",
-	// 	cliAddress,
-	// 	global.GlobalJWireGuardini.SubnetMask)
-
-	changClientAddr := fmt.Sprintf("ifconfig-push %s %s\npush \"route %s.0.0 %s %s\"\n",
-		cliAddress,
-		global.GlobalJWireGuardini.SubnetMask,
-		global.GlobalJWireGuardini.IPPrefix,
-		global.GlobalJWireGuardini.NetworkMask,
-		cliAddress)
-
-	err = global.WriteToFile(changClientFile, changClientAddr)
+	// 在隧道后端中修改客户端地址
+	err = backend.SetClientAddress(portUpdateClientAddress.CliID, cliAddress, network)
 	if err != nil {
-		// 如果参数为空，返回 JSON 错误响应
+		// 文件仍使用原有地址，恢复分配，避免原有地址被分配给其他客户端
+		restoreCliAddress("update_subnet_cli_addr", portUpdateClientAddress.CliID, cliAddress, previous)
 		global.Log.Errorf("[update_subnet_cli_addr] 在文件中修改客户端IP地址失败, err:%v", err)
 		responseError := ResponseError{
 			Status:  false,
@@ -1779,6 +1900,9 @@ func UpdataSubnetCliAddr(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 断开客户端，重新连接后使用新的地址
+	killCliSession("update_subnet_cli_addr", portUpdateClientAddress.CliID)
+
 	// 返回结果
 	responseSuccess := ResponseAddrSuccess{
 		Status:  true,
@@ -1788,3 +1912,92 @@ func UpdataSubnetCliAddr(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(responseSuccess)
 }
+
+// cliBackend 获取客户端所在子网的隧道后端，没有子网的客户端(如用户)使用 OpenVPN
+func cliBackend(cliConfig database.CliConfig) (global.TunnelBackend, error) {
+	if cliConfig.SerID.String == "" {
+		return global.GetBackend(global.BACKENDOPENVPN)
+	}
+
+	subnet := database.Subnet{}
+	subnet.SerID.String = cliConfig.SerID.String
+	err := subnet.GetSubnetBySerId(global.GlobalDB)
+	if err != nil {
+		return nil, err
+	}
+	return global.GetBackend(subnet.Backend.String)
+}
+
+// recordCliChange 记录客户端的添加和删除，用于摘要报告，失败只记录日志
+func recordCliChange(tag string, cliConfig database.CliConfig, action string, operator string) {
+	cliChange := database.CliChange{}
+	cliChange.CliID.String = cliConfig.CliID.String
+	cliChange.SerID.String = cliConfig.SerID.String
+	cliChange.CliName.String = cliConfig.CliName.String
+	cliChange.Action.String = action
+	cliChange.Operator.String = operator
+	cliChange.Ts.Int64 = time.Now().Unix()
+
+	cliChange.CreateCliChange(global.GlobalDB)
+	if err := cliChange.InsertCliChange(global.GlobalDB); err != nil {
+		global.Log.Errorf("[%s] 无法记录客户端变更, cli_id:%s action:%s err:%v", tag, cliChange.CliID.String, action, err)
+	}
+}
+
+// cliNetwork 获取客户端所在子网的网段，没有子网的客户端(如用户)使用用户网段
+func cliNetwork(cliConfig database.CliConfig) (*net.IPNet, error) {
+	if cliConfig.SerID.String == "" {
+		return global.UserNetwork()
+	}
+
+	subnet := database.Subnet{}
+	subnet.SerID.String = cliConfig.SerID.String
+	err := subnet.GetSubnetBySerId(global.GlobalDB)
+	if err != nil {
+		return nil, err
+	}
+	return subnet.Network()
+}
+
+// allocateCliAddress 在 network 中为新客户端分配地址
+func allocateCliAddress(serId string, cliId string, network *net.IPNet) (string, error) {
+	createIPAMTables()
+	return database.AllocateIPAddress(global.GlobalDB, serId, cliId, network)
+}
+
+// reallocateCliAddress 在 network 中为客户端分配新地址，返回释放的原有地址
+func reallocateCliAddress(serId string, cliId string, network *net.IPNet) (string, database.IPAddress, error) {
+	createIPAMTables()
+	return database.ReallocateIPAddress(global.GlobalDB, serId, cliId, network)
+}
+
+func createIPAMTables() {
+	ipAddress := database.IPAddress{}
+	ipAddress.CreateIPAddress(global.GlobalDB)
+	ipReserved := database.IPReserved{}
+	ipReserved.CreateIPReserved(global.GlobalDB)
+}
+
+// releaseCliAddress 添加客户端失败时释放本次分配的地址
+func releaseCliAddress(tag string, cliId string, address string) {
+	err := database.ReleaseIPAddress(global.GlobalDB, cliId, address)
+	if err != nil {
+		global.Log.Errorf("[%s] 无法释放客户端ID: [%s] 的地址 %s, err:%v", tag, cliId, address, err)
+	}
+}
+
+// restoreCliAddress 修改地址失败时恢复客户端原有的地址
+func restoreCliAddress(tag string, cliId string, address string, previous database.IPAddress) {
+	err := database.RestoreIPAddress(global.GlobalDB, cliId, address, previous)
+	if err != nil {
+		global.Log.Errorf("[%s] 无法恢复客户端ID: [%s] 的地址 %s, err:%v", tag, cliId, previous.Address.String, err)
+	}
+}
+
+// mappingCcd 生成映射网段的 ccd 配置，IPv4 网段推送路由，IPv6 网段使用 iroute-ipv6 路由到客户端
+func mappingCcd(cidr, network, mask, gateway string) string {
+	if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.IP.To4() == nil {
+		return fmt.Sprintf("iroute-ipv6 %s\n", ipNet)
+	}
+	return fmt.Sprintf("push \"route %s %s %s\"\n", network, mask, gateway)
+}
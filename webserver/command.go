@@ -0,0 +1,374 @@
+// webservice/command.go
+package webservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/heartbeat"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type PostCliCommand struct {
+	CliID string            `json:"cli_id"`
+	Type  string            `json:"type"`
+	Args  map[string]string `json:"args"`
+	TTL   int64             `json:"ttl"` // 有效期(秒)，为 0 时使用 COMMAND_TTL
+}
+
+type ResponseCliCommand struct {
+	Status  bool                        `json:"status"`
+	Message string                      `json:"message"`
+	Data    database.ExportedCliCommand `json:"data"`
+}
+
+type ResponseCliCommandList struct {
+	Status  bool                          `json:"status"`
+	Message string                        `json:"message"`
+	Total   int                           `json:"total"`
+	Data    []database.ExportedCliCommand `json:"data"`
+}
+
+type ResponseCliCommandStatus struct {
+	Status  bool                             `json:"status"`
+	Message string                           `json:"message"`
+	Data    database.ExportedCliCommand      `json:"data"`
+	Logs    []database.ExportedCliCommandLog `json:"logs"`
+}
+
+func registerCommandRoutes() {
+	http.HandleFunc("/queue_cli_command", ValidateSessionMiddleware(QueueCliCommand))
+	http.HandleFunc("/get_cli_command_list", ValidateSessionMiddleware(GetCliCommandList))
+	http.HandleFunc("/get_cli_command_status", ValidateSessionMiddleware(GetCliCommandStatus))
+}
+
+// QueueCliCommand 添加下发给设备的命令，设备下次心跳时收到
+func QueueCliCommand(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[queue_cli_command] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[queue_cli_command] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   3931,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[queue_cli_command] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[queue_cli_command] client [%s:%s]", ip, port)
+	// 确保请求方法是POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		global.Log.Errorln("[queue_cli_command] 请求类型不是Post")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求类型不是Post",
+			Error:   3932,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	postCliCommand := PostCliCommand{}
+	if err := parseJSONBody(r, &postCliCommand); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		global.Log.Errorf("[queue_cli_command] 解析JSON请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("解析JSON请求参数错误, err:%v", err),
+			Error:   3933,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Debugf("[queue_cli_command] json:[%+v]", postCliCommand)
+	if postCliCommand.CliID == "" || postCliCommand.TTL < 0 {
+		global.Log.Errorln("[queue_cli_command] 请求参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求参数为空",
+			Error:   3934,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	if err := heartbeat.ValidateCommand(postCliCommand.Type, postCliCommand.Args); err != nil {
+		global.Log.Errorf("[queue_cli_command] 命令格式错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("命令格式错误, err:%v", err),
+			Error:   3935,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[queue_cli_command] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliConfig := database.CliConfig{}
+	cliConfig.CreateCliConfig(global.GlobalDB)
+	cliConfig.CliID.String = postCliCommand.CliID
+	err = cliConfig.GetCliConfigByCliID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[queue_cli_command] 客户端不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("客户端不存在, err:%v", err),
+			Error:   3936,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	ttl := postCliCommand.TTL
+	if ttl == 0 {
+		ttl = int64(global.GlobalJWireGuardini.HeartbeatCommandTTL)
+	}
+	now := time.Now().Unix()
+	cliCommand := database.CliCommand{}
+	cliCommand.CreateCliCommand(global.GlobalDB)
+	cliCommand.CliID.String = postCliCommand.CliID
+	cliCommand.Type.String = postCliCommand.Type
+	if len(postCliCommand.Args) > 0 {
+		args, _ := json.Marshal(postCliCommand.Args)
+		cliCommand.Args.String = string(args)
+	}
+	cliCommand.CreatedBy.String = XUserID
+	cliCommand.CreatedAt.Int64 = now
+	cliCommand.ExpireAt.Int64 = now + ttl
+	err = cliCommand.InsertCliCommand(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[queue_cli_command] 添加命令失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("添加命令失败, err:%v", err),
+			Error:   3937,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	heartbeat.PendingCommands.Mark(postCliCommand.CliID)
+
+	global.Log.Infof("[queue_cli_command] userID %s 为 cli_id %s 添加命令 %d [%s]", XUserID, postCliCommand.CliID, cliCommand.ID.Int64, postCliCommand.Type)
+	responseCliCommand := ResponseCliCommand{
+		Status:  true,
+		Message: "添加命令成功!",
+		Data:    cliCommand.ToExported(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseCliCommand)
+}
+
+// GetCliCommandList 分页获取设备的命令，可按状态筛选
+func GetCliCommandList(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cli_command_list] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_cli_command_list] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   3941,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cli_command_list] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cli_command_list] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	status := query.Get("status")
+	page, _ := strconv.Atoi(query.Get("page"))
+	global.Log.Debugf("[get_cli_command_list] cli_id:[%s] status:[%s] page:[%d]", cliId, status, page)
+	// 判断参数是否为空
+	if cliId == "" {
+		global.Log.Errorln("[get_cli_command_list] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3942,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_command_list] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliCommand := database.CliCommand{}
+	cliCommand.CreateCliCommand(global.GlobalDB)
+	cliCommand.CliID.String = cliId
+	commands, total, err := cliCommand.GetCliCommandsByCliID(global.GlobalDB, status, page)
+	if err != nil {
+		global.Log.Errorf("[get_cli_command_list] 获取命令列表失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取命令列表失败, err:%v", err),
+			Error:   3943,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedCommands := make([]database.ExportedCliCommand, len(commands))
+	for i, command := range commands {
+		exportedCommands[i] = command.ToExported()
+	}
+
+	responseCliCommandList := ResponseCliCommandList{
+		Status:  true,
+		Message: "获取命令列表成功!",
+		Total:   total,
+		Data:    exportedCommands,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseCliCommandList)
+}
+
+// GetCliCommandStatus 获取命令的状态和状态变化记录
+func GetCliCommandStatus(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cli_command_status] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_cli_command_status] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   3951,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cli_command_status] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cli_command_status] client [%s:%s]", ip, port)
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		global.Log.Errorln("[get_cli_command_status] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3952,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_command_status] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliCommand := database.CliCommand{}
+	cliCommand.CreateCliCommand(global.GlobalDB)
+	cliCommand.ID.Int64 = id
+	err = cliCommand.GetCliCommandByID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_command_status] 命令不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("命令不存在, err:%v", err),
+			Error:   3953,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	logs, err := cliCommand.GetCliCommandLogs(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_command_status] 获取命令记录失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取命令记录失败, err:%v", err),
+			Error:   3954,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedLogs := make([]database.ExportedCliCommandLog, len(logs))
+	for i, log := range logs {
+		exportedLogs[i] = log.ToExported()
+	}
+
+	responseCliCommandStatus := ResponseCliCommandStatus{
+		Status:  true,
+		Message: "获取命令状态成功!",
+		Data:    cliCommand.ToExported(),
+		Logs:    exportedLogs,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseCliCommandStatus)
+}
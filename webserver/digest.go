@@ -0,0 +1,287 @@
+// webservice/digest.go
+package webservice
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DigestDefault [DIGEST SETTING] 中的默认值，用户设置为空时使用
+type DigestDefault struct {
+	Frequency string `json:"frequency"`
+	SendTime  string `json:"send_time"`
+	Weekday   int    `json:"weekday"`
+}
+
+type ResponseDigestSchedule struct {
+	Status  bool                            `json:"status"`
+	Message string                          `json:"message"`
+	Default DigestDefault                   `json:"default"`
+	Data    database.ExportedDigestSchedule `json:"data"`
+}
+
+func registerDigestRoutes() {
+	http.HandleFunc("/get_digest_schedule", ValidateSessionMiddleware(GetDigestSchedule))
+	http.HandleFunc("/set_digest_schedule", ValidateSessionMiddleware(SetDigestSchedule))
+}
+
+// GetDigestSchedule 获取用户的摘要设置，user_id 为空时获取自己的，管理员可以获取其他用户的
+func GetDigestSchedule(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_digest_schedule] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_digest_schedule] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_digest_schedule] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	userId := query.Get("user_id")
+	if userId == "" {
+		userId = XUserID
+	}
+	if userId != XUserID && !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_digest_schedule] 权限不足, userID:%s user_id:%s", XUserID, userId)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4181,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_digest_schedule] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	user := database.User{}
+	user.UserID.String = userId
+	err = user.GetUserByID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_digest_schedule] 用户不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("用户不存在, err:%v", err),
+			Error:   4182,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 没有设置时全部使用默认值
+	digestSchedule := database.DigestSchedule{}
+	digestSchedule.CreateDigestSchedule(global.GlobalDB)
+	digestSchedule.UserID.String = userId
+	err = digestSchedule.GetDigestScheduleByUserID(global.GlobalDB)
+	if err == sql.ErrNoRows {
+		digestSchedule = database.DigestSchedule{}
+		digestSchedule.UserID.String = userId
+		err = nil
+	}
+	if err != nil {
+		global.Log.Errorf("[get_digest_schedule] 获取摘要设置失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取摘要设置失败, err:%v", err),
+			Error:   4183,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseDigestSchedule := ResponseDigestSchedule{
+		Status:  true,
+		Message: "获取摘要设置成功!",
+		Default: DigestDefault{
+			Frequency: global.GlobalJWireGuardini.DigestFrequency,
+			SendTime:  global.GlobalJWireGuardini.DigestTime,
+			Weekday:   global.GlobalJWireGuardini.DigestWeekday,
+		},
+		Data: digestSchedule.ToExported(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseDigestSchedule)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SetDigestSchedule 保存用户的摘要设置，user_id 为空时保存自己的，管理员可以修改其他用户的
+// frequency/send_time 为空、weekday 为 -1 时使用默认值，channel_id 为 0 时发送到用户邮箱
+// ----------------------------------------------------------------------------------------------------------
+func SetDigestSchedule(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[set_digest_schedule] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[set_digest_schedule] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[set_digest_schedule] client [%s:%s]", ip, port)
+	// 确保请求方法是POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		global.Log.Errorln("[set_digest_schedule] 请求类型不是Post")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求类型不是Post",
+			Error:   4191,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedDigestSchedule := database.ExportedDigestSchedule{Weekday: -1}
+	if err := parseJSONBody(r, &exportedDigestSchedule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		global.Log.Errorf("[set_digest_schedule] 解析JSON请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("解析JSON请求参数错误, err:%v", err),
+			Error:   4192,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	if exportedDigestSchedule.UserID == "" {
+		exportedDigestSchedule.UserID = XUserID
+	}
+	global.Log.Debugf("[set_digest_schedule] user_id:[%s] frequency:[%s] send_time:[%s] weekday:[%d] channel_id:[%d]",
+		exportedDigestSchedule.UserID, exportedDigestSchedule.Frequency, exportedDigestSchedule.SendTime,
+		exportedDigestSchedule.Weekday, exportedDigestSchedule.ChannelID)
+
+	if exportedDigestSchedule.UserID != XUserID && !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[set_digest_schedule] 权限不足, userID:%s user_id:%s", XUserID, exportedDigestSchedule.UserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4193,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	if err := validateDigestSchedule(exportedDigestSchedule); err != nil {
+		global.Log.Errorf("[set_digest_schedule] 请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("请求参数错误, err:%v", err),
+			Error:   4194,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[set_digest_schedule] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	user := database.User{}
+	user.UserID.String = exportedDigestSchedule.UserID
+	err = user.GetUserByID(global.GlobalDB)
+	if err == nil && exportedDigestSchedule.ChannelID != 0 {
+		notifyChannel := database.NotifyChannel{}
+		notifyChannel.CreateNotifyChannel(global.GlobalDB)
+		notifyChannel.ID.Int64 = exportedDigestSchedule.ChannelID
+		if channelErr := notifyChannel.GetNotifyChannelByID(global.GlobalDB); channelErr != nil {
+			err = fmt.Errorf("notify channel %d: %v", exportedDigestSchedule.ChannelID, channelErr)
+		}
+	}
+	if err != nil {
+		global.Log.Errorf("[set_digest_schedule] 用户或通知渠道不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("用户或通知渠道不存在, err:%v", err),
+			Error:   4195,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedDigestSchedule.UpdatedBy = XUserID
+	exportedDigestSchedule.UpdatedAt = time.Now().Unix()
+	digestSchedule := exportedDigestSchedule.ConvertToDigestSchedule()
+	digestSchedule.CreateDigestSchedule(global.GlobalDB)
+	err = digestSchedule.SaveDigestSchedule(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[set_digest_schedule] 保存摘要设置失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("保存摘要设置失败, err:%v", err),
+			Error:   4196,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[set_digest_schedule] 用户 %s 的摘要设置已保存, frequency:[%s] send_time:[%s] weekday:[%d] channel_id:[%d]",
+		user.UserName.String, exportedDigestSchedule.Frequency, exportedDigestSchedule.SendTime,
+		exportedDigestSchedule.Weekday, exportedDigestSchedule.ChannelID)
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "保存摘要设置成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
+
+// validateDigestSchedule 检查摘要频率、发送时间和发送日
+func validateDigestSchedule(schedule database.ExportedDigestSchedule) error {
+	switch schedule.Frequency {
+	case "", database.DIGESTOFF, database.DIGESTDAILY, database.DIGESTWEEKLY:
+	default:
+		return fmt.Errorf("invalid frequency: %s", schedule.Frequency)
+	}
+	if schedule.SendTime != "" {
+		if _, err := time.Parse("15:04", schedule.SendTime); err != nil {
+			return fmt.Errorf("invalid send_time: %s", schedule.SendTime)
+		}
+	}
+	if schedule.Weekday < -1 || schedule.Weekday > 6 {
+		return fmt.Errorf("invalid weekday: %d", schedule.Weekday)
+	}
+	if schedule.ChannelID < 0 {
+		return fmt.Errorf("invalid channel_id: %d", schedule.ChannelID)
+	}
+	return nil
+}
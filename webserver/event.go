@@ -0,0 +1,425 @@
+// webservice/event.go
+package webservice
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 未指定日期时统计最近 30 天
+const uptimeDefaultDays = 30
+
+type ResponseCliEventList struct {
+	Status  bool                        `json:"status"`
+	Message string                      `json:"message"`
+	Total   int                         `json:"total"`
+	Data    []database.ExportedCliEvent `json:"data"`
+}
+
+type ResponseCliUptime struct {
+	Status  bool            `json:"status"`
+	Message string          `json:"message"`
+	Data    database.Uptime `json:"data"`
+}
+
+type ResponseSubnetUptime struct {
+	Status  bool              `json:"status"`
+	Message string            `json:"message"`
+	SerID   string            `json:"ser_id"`
+	Online  int64             `json:"online"`
+	Offline int64             `json:"offline"`
+	Percent float64           `json:"percent"` // 所有设备合计的在线百分比，没有记录时为 -1
+	Data    []database.Uptime `json:"data"`
+}
+
+func registerEventRoutes() {
+	http.HandleFunc("/get_cli_events", ValidateSessionMiddleware(GetCliEvents))
+	http.HandleFunc("/get_cli_uptime", ValidateSessionMiddleware(GetCliUptime))
+	http.HandleFunc("/get_subnet_uptime", ValidateSessionMiddleware(GetSubnetUptime))
+}
+
+// GetCliEvents 分页获取设备的在线状态变化，start/end 为 YYYY-MM-DD
+func GetCliEvents(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cli_events] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cli_events] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cli_events] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	page, _ := strconv.Atoi(query.Get("page"))
+	global.Log.Debugf("[get_cli_events] cli_id:[%s] start:[%s] end:[%s] page:[%d]", cliId, query.Get("start"), query.Get("end"), page)
+	// 判断参数是否为空
+	if cliId == "" {
+		global.Log.Errorln("[get_cli_events] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4031,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	start, end, err := parseReportRange(query.Get("start"), query.Get("end"))
+	if err != nil {
+		global.Log.Errorf("[get_cli_events] 参数格式错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("参数格式错误, err:%v", err),
+			Error:   4032,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_events] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliEvent := database.CliEvent{}
+	cliEvent.CreateCliEvent(global.GlobalDB)
+	cliEvent.CliID.String = cliId
+	events, total, err := cliEvent.GetCliEventsByCliID(global.GlobalDB, start, end, page)
+	if err != nil {
+		global.Log.Errorf("[get_cli_events] 获取状态变化记录失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取状态变化记录失败, err:%v", err),
+			Error:   4033,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedEvents := make([]database.ExportedCliEvent, len(events))
+	for i, event := range events {
+		exportedEvents[i] = event.ToExported()
+	}
+
+	responseCliEventList := ResponseCliEventList{
+		Status:  true,
+		Message: "获取状态变化记录成功!",
+		Total:   total,
+		Data:    exportedEvents,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseCliEventList)
+}
+
+// GetCliUptime 统计设备的在线率和离线列表，format=csv 时导出离线列表
+func GetCliUptime(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cli_uptime] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cli_uptime] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cli_uptime] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	format := query.Get("format")
+	global.Log.Debugf("[get_cli_uptime] cli_id:[%s] start:[%s] end:[%s] format:[%s]", cliId, query.Get("start"), query.Get("end"), format)
+	// 判断参数是否为空
+	if cliId == "" {
+		global.Log.Errorln("[get_cli_uptime] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4041,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	start, end, err := parseReportRange(query.Get("start"), query.Get("end"))
+	if err != nil {
+		global.Log.Errorf("[get_cli_uptime] 参数格式错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("参数格式错误, err:%v", err),
+			Error:   4042,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_uptime] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliEvent := database.CliEvent{}
+	cliEvent.CreateCliEvent(global.GlobalDB)
+	uptimes, err := database.GetUptimes(global.GlobalDB, []string{cliId}, start, end)
+	if err != nil {
+		global.Log.Errorf("[get_cli_uptime] 统计在线率失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("统计在线率失败, err:%v", err),
+			Error:   4043,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	uptime := uptimes[0]
+
+	if format == "csv" {
+		records := [][]string{{"cli_id", "outage_start", "outage_end", "duration_seconds", "cause"}}
+		for _, outage := range uptime.Outages {
+			records = append(records, []string{
+				cliId,
+				formatReportTime(outage.Start),
+				formatReportTime(outage.End),
+				strconv.FormatInt(outage.Duration, 10),
+				outage.Cause,
+			})
+		}
+		writeCSV(w, fmt.Sprintf("uptime_%s_%s.csv", cliId, time.Unix(start, 0).Format(database.TRAFFICDAYLAYOUT)), records)
+		return
+	}
+
+	responseCliUptime := ResponseCliUptime{
+		Status:  true,
+		Message: "统计在线率成功!",
+		Data:    uptime,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseCliUptime)
+}
+
+// GetSubnetUptime 统计子网内每个设备的在线率，format=csv 时导出
+func GetSubnetUptime(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_subnet_uptime] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_subnet_uptime] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_subnet_uptime] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	serId := query.Get("ser_id")
+	format := query.Get("format")
+	global.Log.Debugf("[get_subnet_uptime] ser_id:[%s] start:[%s] end:[%s] format:[%s]", serId, query.Get("start"), query.Get("end"), format)
+	// 判断参数是否为空
+	if serId == "" {
+		global.Log.Errorln("[get_subnet_uptime] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4051,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	start, end, err := parseReportRange(query.Get("start"), query.Get("end"))
+	if err != nil {
+		global.Log.Errorf("[get_subnet_uptime] 参数格式错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("参数格式错误, err:%v", err),
+			Error:   4052,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_subnet_uptime] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 按子网当前的设备统计
+	cliConfig := database.CliConfig{}
+	cliConfig.CreateCliConfig(global.GlobalDB)
+	cliConfig.SerID.String = serId
+	cliConfigs, err := cliConfig.GetCliConfigBySerID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_subnet_uptime] 获取子网客户端失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取子网客户端失败, err:%v", err),
+			Error:   4053,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	cliIds := make([]string, 0, len(cliConfigs))
+	cliNames := make(map[string]string)
+	for _, config := range cliConfigs {
+		cliIds = append(cliIds, config.CliID.String)
+		cliNames[config.CliID.String] = config.CliName.String
+	}
+
+	cliEvent := database.CliEvent{}
+	cliEvent.CreateCliEvent(global.GlobalDB)
+	uptimes, err := database.GetUptimes(global.GlobalDB, cliIds, start, end)
+	if err != nil {
+		global.Log.Errorf("[get_subnet_uptime] 统计在线率失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("统计在线率失败, err:%v", err),
+			Error:   4054,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	if format == "csv" {
+		records := [][]string{{"cli_id", "cli_name", "online_seconds", "offline_seconds", "unknown_seconds", "uptime_percent", "outages", "longest_outage_seconds"}}
+		for _, uptime := range uptimes {
+			records = append(records, []string{
+				uptime.CliID,
+				cliNames[uptime.CliID],
+				strconv.FormatInt(uptime.Online, 10),
+				strconv.FormatInt(uptime.Offline, 10),
+				strconv.FormatInt(uptime.Unknown, 10),
+				formatPercent(uptime.Percent),
+				strconv.Itoa(uptime.Count),
+				strconv.FormatInt(uptime.Longest, 10),
+			})
+		}
+		writeCSV(w, fmt.Sprintf("uptime_%s_%s.csv", serId, time.Unix(start, 0).Format(database.TRAFFICDAYLAYOUT)), records)
+		return
+	}
+
+	responseSubnetUptime := ResponseSubnetUptime{
+		Status:  true,
+		Message: "统计在线率成功!",
+		SerID:   serId,
+		Percent: -1,
+		Data:    uptimes,
+	}
+	for _, uptime := range uptimes {
+		responseSubnetUptime.Online += uptime.Online
+		responseSubnetUptime.Offline += uptime.Offline
+	}
+	if known := responseSubnetUptime.Online + responseSubnetUptime.Offline; known > 0 {
+		responseSubnetUptime.Percent = float64(responseSubnetUptime.Online) * 100 / float64(known)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSubnetUptime)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// parseReportRange 解析 YYYY-MM-DD 格式的起止日期，包含结束日期当天，结束时间不超过当前时间
+// ----------------------------------------------------------------------------------------------------------
+func parseReportRange(startDate string, endDate string) (int64, int64, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	endDay := today
+	if endDate != "" {
+		day, err := time.ParseInLocation(database.TRAFFICDAYLAYOUT, endDate, time.Local)
+		if err != nil {
+			return 0, 0, err
+		}
+		endDay = day
+	}
+	startDay := endDay.AddDate(0, 0, 1-uptimeDefaultDays)
+	if startDate != "" {
+		day, err := time.ParseInLocation(database.TRAFFICDAYLAYOUT, startDate, time.Local)
+		if err != nil {
+			return 0, 0, err
+		}
+		startDay = day
+	}
+
+	start := startDay.Unix()
+	end := endDay.AddDate(0, 0, 1).Unix()
+	if end > now.Unix() {
+		end = now.Unix()
+	}
+	if start >= end {
+		return 0, 0, fmt.Errorf("start %s is not before end %s", startDate, endDate)
+	}
+	return start, end, nil
+}
+
+func formatReportTime(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+}
+
+func formatPercent(percent float64) string {
+	if percent < 0 {
+		return ""
+	}
+	return strconv.FormatFloat(percent, 'f', 3, 64)
+}
+
+// writeCSV 以附件形式返回 CSV，带 BOM 以便 Excel 识别 UTF-8
+func writeCSV(w http.ResponseWriter, filename string, records [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("\xEF\xBB\xBF"))
+
+	writer := csv.NewWriter(w)
+	writer.WriteAll(records)
+	if err := writer.Error(); err != nil {
+		global.Log.Errorf("[csv] 导出 %s 失败, err:%v", filename, err)
+	}
+}
@@ -0,0 +1,391 @@
+// webservice/heartbeat.go
+package webservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/heartbeat"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTP 心跳请求体的最大长度，与 UDP 接收缓冲区相同
+const heartbeatBodyMax = 8192
+
+var (
+	heartbeatHandlerMu sync.RWMutex
+	heartbeatHandler   func(packet *heartbeat.Packet, remote string) (*heartbeat.Reply, error)
+)
+
+// SetHeartbeatHandler 设置 HTTP 心跳的处理函数，与 UDP 心跳共用
+func SetHeartbeatHandler(handler func(packet *heartbeat.Packet, remote string) (*heartbeat.Reply, error)) {
+	heartbeatHandlerMu.Lock()
+	defer heartbeatHandlerMu.Unlock()
+	heartbeatHandler = handler
+}
+
+type ResponseCliSecret struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	CliID   string `json:"cli_id"`
+	Secret  string `json:"secret"`
+}
+
+type ResponseCliTelemetryList struct {
+	Status  bool                            `json:"status"`
+	Message string                          `json:"message"`
+	Total   int                             `json:"total"`
+	Data    []database.ExportedCliTelemetry `json:"data"`
+}
+
+type ResponseHeartbeatMetrics struct {
+	Status  bool                      `json:"status"`
+	Message string                    `json:"message"`
+	Data    heartbeat.MetricsSnapshot `json:"data"`
+}
+
+func registerHeartbeatRoutes() {
+	// 设备没有登录会话，由心跳签名认证
+	http.HandleFunc("/heartbeat", PostHeartbeat)
+	http.HandleFunc("/reset_cli_secret", ValidateSessionMiddleware(ResetCliSecret))
+	http.HandleFunc("/get_heartbeat_metrics", ValidateSessionMiddleware(GetHeartbeatMetrics))
+	http.HandleFunc("/get_cli_telemetry", ValidateSessionMiddleware(GetCliTelemetry))
+}
+
+// PostHeartbeat 通过 HTTP(S) 上报心跳，供无法使用 UDP 的设备使用，回复与 UDP 心跳相同
+func PostHeartbeat(w http.ResponseWriter, r *http.Request) {
+	global.Log.Debugf("[heartbeat] client [%s]", r.RemoteAddr)
+	// 确保请求方法是POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		global.Log.Errorln("[heartbeat] 请求类型不是Post")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求类型不是Post",
+			Error:   3961,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	heartbeatHandlerMu.RLock()
+	handler := heartbeatHandler
+	heartbeatHandlerMu.RUnlock()
+	if handler == nil {
+		global.Log.Errorln("[heartbeat] 心跳服务未启动")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "心跳服务未启动",
+			Error:   3962,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	packet := heartbeat.Packet{}
+	r.Body = http.MaxBytesReader(w, r.Body, heartbeatBodyMax)
+	if err := parseJSONBody(r, &packet); err != nil || packet.CliID == "" {
+		heartbeat.Stats.Received.Add(1)
+		heartbeat.Stats.Invalid.Add(1)
+		global.Log.Errorf("[heartbeat] 解析JSON请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "解析JSON请求参数错误",
+			Error:   3963,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	reply, err := handler(&packet, r.RemoteAddr)
+	if err != nil {
+		// 拒绝的原因只记录日志，不返回给设备，避免泄露校验细节
+		global.Log.Warnf("[heartbeat] 拒绝来自 %s cli_id %s 的 HTTP 心跳, err:%v", r.RemoteAddr, packet.CliID, err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "心跳被拒绝",
+			Error:   3964,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// ResetCliSecret 重新签发设备的心跳密钥，旧密钥立即失效
+func ResetCliSecret(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[reset_cli_secret] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[reset_cli_secret] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   3901,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[reset_cli_secret] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[reset_cli_secret] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	cliId := r.URL.Query().Get("cli_id")
+	if cliId == "" {
+		global.Log.Errorln("[reset_cli_secret] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3902,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[reset_cli_secret] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliConfig := database.CliConfig{}
+	cliConfig.CreateCliConfig(global.GlobalDB)
+	cliConfig.CliID.String = cliId
+	err = cliConfig.GetCliConfigByCliID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[reset_cli_secret] 客户端不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("客户端不存在, err:%v", err),
+			Error:   3903,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	secret, err := issueCliSecret(cliId)
+	if err != nil {
+		global.Log.Errorf("[reset_cli_secret] 无法签发心跳密钥, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法签发心跳密钥, err:%v", err),
+			Error:   3904,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[reset_cli_secret] 已重新签发 cli_id %s 的心跳密钥", cliId)
+	responseCliSecret := ResponseCliSecret{
+		Status:  true,
+		Message: "心跳密钥签发成功!",
+		CliID:   cliId,
+		Secret:  secret,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseCliSecret)
+}
+
+// GetHeartbeatMetrics 获取心跳处理的计数，包括处理和丢弃的数据包
+func GetHeartbeatMetrics(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_heartbeat_metrics] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_heartbeat_metrics] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   3911,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseHeartbeatMetrics := ResponseHeartbeatMetrics{
+		Status:  true,
+		Message: "获取心跳统计成功!",
+		Data:    heartbeat.Stats.Snapshot(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseHeartbeatMetrics)
+}
+
+// GetCliTelemetry 分页获取设备上报的运行状态，start/end 为 unix 时间戳
+func GetCliTelemetry(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cli_telemetry] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cli_telemetry] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cli_telemetry] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	page, _ := strconv.Atoi(query.Get("page"))
+	global.Log.Debugf("[get_cli_telemetry] cli_id:[%s] start:[%s] end:[%s] page:[%d]", cliId, query.Get("start"), query.Get("end"), page)
+	// 判断参数是否为空
+	if cliId == "" {
+		global.Log.Errorln("[get_cli_telemetry] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3921,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	start, errStart := parseUnixParam(query.Get("start"))
+	end, errEnd := parseUnixParam(query.Get("end"))
+	if errStart != nil || errEnd != nil || (end > 0 && start > end) {
+		global.Log.Errorln("[get_cli_telemetry] 参数格式错误")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数格式错误",
+			Error:   3922,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_telemetry] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliTelemetry := database.CliTelemetry{}
+	cliTelemetry.CreateCliTelemetry(global.GlobalDB)
+	cliTelemetry.CliID.String = cliId
+	telemetries, total, err := cliTelemetry.GetCliTelemetryByCliID(global.GlobalDB, start, end, page)
+	if err != nil {
+		global.Log.Errorf("[get_cli_telemetry] 获取运行状态失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取运行状态失败, err:%v", err),
+			Error:   3923,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedTelemetries := make([]database.ExportedCliTelemetry, len(telemetries))
+	for i, telemetry := range telemetries {
+		exportedTelemetries[i] = telemetry.ToExported()
+	}
+
+	responseCliTelemetryList := ResponseCliTelemetryList{
+		Status:  true,
+		Message: "获取运行状态成功!",
+		Total:   total,
+		Data:    exportedTelemetries,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseCliTelemetryList)
+}
+
+// parseUnixParam 解析 unix 时间戳参数，为空时返回 0
+func parseUnixParam(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ts < 0 {
+		return 0, fmt.Errorf("invalid timestamp: %s", value)
+	}
+	return ts, nil
+}
+
+// getLatestCliTelemetry 获取设备最近一次上报的运行状态，没有上报时返回 nil
+func getLatestCliTelemetry(cliId string) *database.ExportedCliTelemetry {
+	cliTelemetry := database.CliTelemetry{}
+	cliTelemetry.CreateCliTelemetry(global.GlobalDB)
+	cliTelemetry.CliID.String = cliId
+	if err := cliTelemetry.GetLatestCliTelemetry(global.GlobalDB); err != nil {
+		return nil
+	}
+	exported := cliTelemetry.ToExported()
+	return &exported
+}
+
+// issueCliSecret 为设备签发新的心跳密钥
+func issueCliSecret(cliId string) (string, error) {
+	secret, err := heartbeat.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	cliSecret := database.CliSecret{}
+	cliSecret.CreateCliSecret(global.GlobalDB)
+	cliSecret.CliID.String = cliId
+	cliSecret.Secret.String = secret
+	if err := cliSecret.SaveCliSecret(global.GlobalDB); err != nil {
+		return "", err
+	}
+	heartbeat.SecretChanged.Mark(cliId)
+	return secret, nil
+}
+
+// deleteCliHeartbeat 删除客户端时删除心跳密钥和运行状态，取消未完成的命令，失败只记录日志
+// 状态变化记录(cli_event)保留，删除后仍可查询客户端的历史
+func deleteCliHeartbeat(tag string, cliId string, operator string) {
+	cliSecret := database.CliSecret{}
+	cliSecret.CliID.String = cliId
+	if err := cliSecret.DeleteCliSecret(global.GlobalDB); err != nil {
+		global.Log.Errorf("[%s] 无法删除 cli_id %s 的心跳密钥, err:%v", tag, cliId, err)
+	}
+	heartbeat.SecretChanged.Mark(cliId)
+	cliTelemetry := database.CliTelemetry{}
+	cliTelemetry.CliID.String = cliId
+	if err := cliTelemetry.DeleteCliTelemetry(global.GlobalDB); err != nil {
+		global.Log.Errorf("[%s] 无法删除 cli_id %s 的运行状态, err:%v", tag, cliId, err)
+	}
+	if err := database.CancelCliCommands(global.GlobalDB, cliId, operator, time.Now().Unix()); err != nil {
+		global.Log.Errorf("[%s] 无法取消 cli_id %s 的命令, err:%v", tag, cliId, err)
+	}
+	heartbeat.PendingCommands.Clear(cliId)
+}
@@ -0,0 +1,270 @@
+// webservice/ipam.go
+package webservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+type ResponseIPReservedList struct {
+	Status  bool                          `json:"status"`
+	Message string                        `json:"message"`
+	Data    []database.ExportedIPReserved `json:"data"`
+}
+
+type ResponseIPReserved struct {
+	Status  bool                        `json:"status"`
+	Message string                      `json:"message"`
+	Data    database.ExportedIPReserved `json:"data"`
+}
+
+func registerIPAMRoutes() {
+	http.HandleFunc("/get_ip_reserved", ValidateSessionMiddleware(GetIPReserved))
+	http.HandleFunc("/add_ip_reserved", ValidateSessionMiddleware(AddIPReserved))
+	http.HandleFunc("/del_ip_reserved", ValidateSessionMiddleware(DelIPReserved))
+}
+
+// GetIPReserved 获取保留地址范围
+func GetIPReserved(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_ip_reserved] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_ip_reserved] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_ip_reserved] client [%s:%s]", ip, port)
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_ip_reserved] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	ipReserved := database.IPReserved{}
+	ipReserved.CreateIPReserved(global.GlobalDB)
+
+	ranges, err := ipReserved.GetAllIPReserved(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_ip_reserved] 获取保留地址失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取保留地址失败, err:%v", err),
+			Error:   3801,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedRanges := []database.ExportedIPReserved{}
+	for _, reserved := range ranges {
+		exportedRanges = append(exportedRanges, reserved.ToExported())
+	}
+
+	responseIPReservedList := ResponseIPReservedList{
+		Status:  true,
+		Message: "获取保留地址成功!",
+		Data:    exportedRanges,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseIPReservedList)
+}
+
+// AddIPReserved 添加保留地址范围，范围内的地址不再分配给客户端
+func AddIPReserved(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[add_ip_reserved] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[add_ip_reserved] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   3811,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[add_ip_reserved] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[add_ip_reserved] client [%s:%s]", ip, port)
+	// 确保请求方法是POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		global.Log.Errorln("[add_ip_reserved] 请求类型不是Post")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求类型不是Post",
+			Error:   3812,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedIPReserved := database.ExportedIPReserved{}
+	if err := parseJSONBody(r, &exportedIPReserved); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		global.Log.Errorf("[add_ip_reserved] 解析JSON请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("解析JSON请求参数错误, err:%v", err),
+			Error:   3813,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Debugf("[add_ip_reserved] json:[%+v]", exportedIPReserved)
+	// 只填写起始地址时保留单个地址
+	if exportedIPReserved.EndAddress == "" {
+		exportedIPReserved.EndAddress = exportedIPReserved.StartAddress
+	}
+	ipReserved := exportedIPReserved.ConvertToIPReserved()
+	if ipReserved.StartAddress.String == "" {
+		global.Log.Errorln("[add_ip_reserved] 请求参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求参数为空",
+			Error:   3814,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[add_ip_reserved] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	ipReserved.CreateIPReserved(global.GlobalDB)
+	err = ipReserved.InsertIPReserved(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[add_ip_reserved] 添加保留地址失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("添加保留地址失败, err:%v", err),
+			Error:   3815,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[add_ip_reserved] 已添加保留地址 [%s - %s]", ipReserved.StartAddress.String, ipReserved.EndAddress.String)
+	responseIPReserved := ResponseIPReserved{
+		Status:  true,
+		Message: "添加保留地址成功!",
+		Data:    ipReserved.ToExported(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseIPReserved)
+}
+
+// DelIPReserved 删除保留地址范围
+func DelIPReserved(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[del_ip_reserved] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[del_ip_reserved] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   3821,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[del_ip_reserved] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[del_ip_reserved] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		global.Log.Errorln("[del_ip_reserved] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3822,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[del_ip_reserved] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	ipReserved := database.IPReserved{}
+	ipReserved.CreateIPReserved(global.GlobalDB)
+	ipReserved.ID.Int64 = id
+	err = ipReserved.DeleteIPReserved(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[del_ip_reserved] 删除保留地址失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("删除保留地址失败, err:%v", err),
+			Error:   3823,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "删除保留地址成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
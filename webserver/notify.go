@@ -0,0 +1,1001 @@
+// webservice/notify.go
+package webservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/message"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 列表中隐藏密钥，保存时为该值表示不修改
+const secretMask = "******"
+
+type ResponseNotifyChannelList struct {
+	Status  bool                             `json:"status"`
+	Message string                           `json:"message"`
+	Data    []database.ExportedNotifyChannel `json:"data"`
+}
+
+type ResponseNotifyOutboxList struct {
+	Status  bool                            `json:"status"`
+	Message string                          `json:"message"`
+	Total   int                             `json:"total"`
+	Data    []database.ExportedNotifyOutbox `json:"data"`
+}
+
+type ResponseNotifyOutboxRetry struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Count   int64  `json:"count"`
+}
+
+type ResponseTemplatePreview struct {
+	Status  bool             `json:"status"`
+	Message string           `json:"message"`
+	Names   []string         `json:"names"` // 可以预览的模板
+	Data    message.Rendered `json:"data"`
+}
+
+type ResponseNotifyRuleList struct {
+	Status  bool                          `json:"status"`
+	Message string                        `json:"message"`
+	Data    []database.ExportedNotifyRule `json:"data"`
+}
+
+func registerNotifyRoutes() {
+	http.HandleFunc("/get_notify_channel", ValidateSessionMiddleware(GetNotifyChannel))
+	http.HandleFunc("/set_notify_channel", ValidateSessionMiddleware(SetNotifyChannel))
+	http.HandleFunc("/del_notify_channel", ValidateSessionMiddleware(DelNotifyChannel))
+	http.HandleFunc("/test_notify_channel", ValidateSessionMiddleware(TestNotifyChannel))
+	http.HandleFunc("/get_notify_rule", ValidateSessionMiddleware(GetNotifyRule))
+	http.HandleFunc("/add_notify_rule", ValidateSessionMiddleware(AddNotifyRule))
+	http.HandleFunc("/del_notify_rule", ValidateSessionMiddleware(DelNotifyRule))
+	http.HandleFunc("/get_notify_outbox", ValidateSessionMiddleware(GetNotifyOutbox))
+	http.HandleFunc("/retry_notify_outbox", ValidateSessionMiddleware(RetryNotifyOutbox))
+	http.HandleFunc("/preview_template", ValidateSessionMiddleware(PreviewTemplate))
+}
+
+// GetNotifyChannel 获取通知渠道，密钥以 ****** 显示
+func GetNotifyChannel(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_notify_channel] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_notify_channel] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4061,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_notify_channel] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_notify_channel] client [%s:%s]", ip, port)
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_notify_channel] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	channels, err := notifyChannel.GetAllNotifyChannel(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_notify_channel] 获取通知渠道失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取通知渠道失败, err:%v", err),
+			Error:   4062,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedChannels := []database.ExportedNotifyChannel{}
+	for _, channel := range channels {
+		exported := channel.ToExported()
+		if exported.Secret != "" {
+			exported.Secret = secretMask
+		}
+		exportedChannels = append(exportedChannels, exported)
+	}
+
+	responseNotifyChannelList := ResponseNotifyChannelList{
+		Status:  true,
+		Message: "获取通知渠道成功!",
+		Data:    exportedChannels,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseNotifyChannelList)
+}
+
+// SetNotifyChannel 新建(id 为 0)或修改通知渠道，enabled 为 false 的渠道不会发送通知
+func SetNotifyChannel(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[set_notify_channel] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[set_notify_channel] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4071,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[set_notify_channel] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[set_notify_channel] client [%s:%s]", ip, port)
+	// 确保请求方法是POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		global.Log.Errorln("[set_notify_channel] 请求类型不是Post")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求类型不是Post",
+			Error:   4072,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedNotifyChannel := database.ExportedNotifyChannel{}
+	if err := parseJSONBody(r, &exportedNotifyChannel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		global.Log.Errorf("[set_notify_channel] 解析JSON请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("解析JSON请求参数错误, err:%v", err),
+			Error:   4073,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	global.Log.Debugf("[set_notify_channel] id:[%d] name:[%s] type:[%s]", exportedNotifyChannel.ID, exportedNotifyChannel.Name, exportedNotifyChannel.Type)
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[set_notify_channel] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	// 修改时密钥为 ****** 表示保留原密钥
+	if exportedNotifyChannel.ID != 0 && exportedNotifyChannel.Secret == secretMask {
+		notifyChannel.ID.Int64 = exportedNotifyChannel.ID
+		if err := notifyChannel.GetNotifyChannelByID(global.GlobalDB); err == nil {
+			exportedNotifyChannel.Secret = notifyChannel.Secret.String
+		}
+	}
+
+	if err := validateNotifyChannel(exportedNotifyChannel); err != nil {
+		global.Log.Errorf("[set_notify_channel] 请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("请求参数错误, err:%v", err),
+			Error:   4074,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedNotifyChannel.UpdatedBy = XUserID
+	exportedNotifyChannel.UpdatedAt = time.Now().Unix()
+	notifyChannel = exportedNotifyChannel.ConvertToNotifyChannel()
+	err = notifyChannel.SaveNotifyChannel(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[set_notify_channel] 保存通知渠道失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("保存通知渠道失败, err:%v", err),
+			Error:   4075,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[set_notify_channel] 通知渠道 %d %s (%s) 已保存, enabled:%v",
+		notifyChannel.ID.Int64, notifyChannel.Name.String, notifyChannel.Type.String, notifyChannel.Enabled.Bool)
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "保存通知渠道成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
+
+// DelNotifyChannel 删除通知渠道及其路由规则
+func DelNotifyChannel(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[del_notify_channel] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[del_notify_channel] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4081,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[del_notify_channel] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[del_notify_channel] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	id, _ := strconv.ParseInt(query.Get("id"), 10, 64)
+	if id <= 0 {
+		global.Log.Errorln("[del_notify_channel] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4082,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[del_notify_channel] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	notifyChannel.ID.Int64 = id
+	err = notifyChannel.DeleteNotifyChannel(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[del_notify_channel] 删除通知渠道失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("删除通知渠道失败, err:%v", err),
+			Error:   4083,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[del_notify_channel] 通知渠道 %d 已被 %s 删除", id, XUserID)
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "删除通知渠道成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
+
+// TestNotifyChannel 向通知渠道发送一条测试消息，未启用的渠道也可以测试
+func TestNotifyChannel(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[test_notify_channel] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[test_notify_channel] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4091,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[test_notify_channel] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[test_notify_channel] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	id, _ := strconv.ParseInt(query.Get("id"), 10, 64)
+	if id <= 0 {
+		global.Log.Errorln("[test_notify_channel] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4092,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[test_notify_channel] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	notifyChannel.ID.Int64 = id
+	err = notifyChannel.GetNotifyChannelByID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[test_notify_channel] 通知渠道不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("通知渠道不存在, err:%v", err),
+			Error:   4093,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	sender := notifySender()
+	notifier, err := message.NewNotifier(message.ChannelConfig{
+		Type:   notifyChannel.Type.String,
+		URL:    notifyChannel.URL.String,
+		Secret: notifyChannel.Secret.String,
+		To:     notifyChannel.RecipientList(),
+	}, &sender)
+	if err != nil {
+		global.Log.Errorf("[test_notify_channel] 通知渠道配置错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("通知渠道配置错误, err:%v", err),
+			Error:   4094,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	err = notifier.Notify(message.Notification{
+		Event: "test",
+		Title: "[JWireGuard] 通知渠道测试",
+		Text:  fmt.Sprintf("通知渠道: %s\n发送人: %s", notifyChannel.Name.String, XUserID),
+		To:    []string{global.GlobalJWireGuardini.To},
+		Time:  time.Now().Unix(),
+	})
+	if err != nil {
+		global.Log.Errorf("[test_notify_channel] 发送测试消息失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("发送测试消息失败, err:%v", err),
+			Error:   4095,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "发送测试消息成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
+
+// GetNotifyRule 获取通知路由规则
+func GetNotifyRule(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_notify_rule] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_notify_rule] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4101,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_notify_rule] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_notify_rule] client [%s:%s]", ip, port)
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_notify_rule] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	notifyRule := database.NotifyRule{}
+	rules, err := notifyRule.GetAllNotifyRule(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_notify_rule] 获取通知路由规则失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取通知路由规则失败, err:%v", err),
+			Error:   4102,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedRules := []database.ExportedNotifyRule{}
+	for _, rule := range rules {
+		exportedRules = append(exportedRules, rule.ToExported())
+	}
+
+	responseNotifyRuleList := ResponseNotifyRuleList{
+		Status:  true,
+		Message: "获取通知路由规则成功!",
+		Data:    exportedRules,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseNotifyRuleList)
+}
+
+// AddNotifyRule 添加通知路由规则，event 为 * 时匹配所有事件，ser_id 为空时匹配所有子网
+// 子网有自己的规则时不再使用不限子网的规则
+func AddNotifyRule(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[add_notify_rule] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[add_notify_rule] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4111,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[add_notify_rule] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[add_notify_rule] client [%s:%s]", ip, port)
+	// 确保请求方法是POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		global.Log.Errorln("[add_notify_rule] 请求类型不是Post")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求类型不是Post",
+			Error:   4112,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedNotifyRule := database.ExportedNotifyRule{}
+	if err := parseJSONBody(r, &exportedNotifyRule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		global.Log.Errorf("[add_notify_rule] 解析JSON请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("解析JSON请求参数错误, err:%v", err),
+			Error:   4113,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Debugf("[add_notify_rule] json:[%+v]", exportedNotifyRule)
+	if !message.ValidEvent(exportedNotifyRule.Event) || exportedNotifyRule.ChannelID <= 0 {
+		global.Log.Errorln("[add_notify_rule] 请求参数错误")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求参数错误",
+			Error:   4114,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[add_notify_rule] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 渠道和子网必须存在
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	notifyChannel.ID.Int64 = exportedNotifyRule.ChannelID
+	err = notifyChannel.GetNotifyChannelByID(global.GlobalDB)
+	if err == nil && exportedNotifyRule.SerID != "" {
+		subnet := database.Subnet{}
+		subnet.CreateSubnet(global.GlobalDB)
+		subnet.SerID.String = exportedNotifyRule.SerID
+		err = subnet.GetSubnetBySerId(global.GlobalDB)
+	}
+	if err != nil {
+		global.Log.Errorf("[add_notify_rule] 通知渠道或子网不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("通知渠道或子网不存在, err:%v", err),
+			Error:   4115,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedNotifyRule.UpdatedBy = XUserID
+	exportedNotifyRule.UpdatedAt = time.Now().Unix()
+	notifyRule := exportedNotifyRule.ConvertToNotifyRule()
+	err = notifyRule.InsertNotifyRule(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[add_notify_rule] 添加通知路由规则失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("添加通知路由规则失败, err:%v", err),
+			Error:   4116,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[add_notify_rule] 事件 %s 子网 [%s] 使用通知渠道 %s",
+		notifyRule.Event.String, notifyRule.SerID.String, notifyChannel.Name.String)
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "添加通知路由规则成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
+
+// DelNotifyRule 删除通知路由规则
+func DelNotifyRule(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[del_notify_rule] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[del_notify_rule] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4121,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[del_notify_rule] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[del_notify_rule] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	id, _ := strconv.ParseInt(query.Get("id"), 10, 64)
+	if id <= 0 {
+		global.Log.Errorln("[del_notify_rule] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4122,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[del_notify_rule] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	notifyRule := database.NotifyRule{}
+	notifyRule.ID.Int64 = id
+	err = notifyRule.DeleteNotifyRule(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[del_notify_rule] 删除通知路由规则失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("删除通知路由规则失败, err:%v", err),
+			Error:   4123,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "删除通知路由规则成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
+
+// GetNotifyOutbox 分页获取发件箱中的通知，status 为 pending/sent/dead，为空时返回全部
+func GetNotifyOutbox(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_notify_outbox] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_notify_outbox] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4131,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_notify_outbox] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_notify_outbox] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	status := query.Get("status")
+	page, _ := strconv.Atoi(query.Get("page"))
+	if status != "" && status != database.OUTBOXPENDING && status != database.OUTBOXSENT && status != database.OUTBOXDEAD {
+		global.Log.Errorf("[get_notify_outbox] 请求参数错误, status:%s", status)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求参数错误",
+			Error:   4132,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_notify_outbox] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifyOutbox := database.NotifyOutbox{}
+	notifyOutbox.CreateNotifyOutbox(global.GlobalDB)
+	items, total, err := database.GetNotifyOutboxByStatus(global.GlobalDB, status, page)
+	if err != nil {
+		global.Log.Errorf("[get_notify_outbox] 获取发件箱失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取发件箱失败, err:%v", err),
+			Error:   4133,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedItems := []database.ExportedNotifyOutbox{}
+	for _, item := range items {
+		exportedItems = append(exportedItems, item.ToExported())
+	}
+
+	responseNotifyOutboxList := ResponseNotifyOutboxList{
+		Status:  true,
+		Message: "获取发件箱成功!",
+		Total:   total,
+		Data:    exportedItems,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseNotifyOutboxList)
+}
+
+// RetryNotifyOutbox 重新发送失败(dead)的通知，指定 id 时只重试一条，否则重试全部
+func RetryNotifyOutbox(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[retry_notify_outbox] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[retry_notify_outbox] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4141,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[retry_notify_outbox] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[retry_notify_outbox] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	id, err := strconv.ParseInt(query.Get("id"), 10, 64)
+	if query.Get("id") != "" && (err != nil || id <= 0) {
+		global.Log.Errorf("[retry_notify_outbox] 请求参数错误, id:%s", query.Get("id"))
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求参数错误",
+			Error:   4142,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[retry_notify_outbox] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifyOutbox := database.NotifyOutbox{}
+	notifyOutbox.CreateNotifyOutbox(global.GlobalDB)
+	count, err := database.RetryNotifyOutbox(global.GlobalDB, id, time.Now().Unix())
+	if err != nil {
+		global.Log.Errorf("[retry_notify_outbox] 重试通知失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("重试通知失败, err:%v", err),
+			Error:   4143,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[retry_notify_outbox] %s 重试了 %d 条失败的通知", XUserID, count)
+	responseNotifyOutboxRetry := ResponseNotifyOutboxRetry{
+		Status:  true,
+		Message: "通知已重新放入发件箱!",
+		Count:   count,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseNotifyOutboxRetry)
+}
+
+// PreviewTemplate 用示例数据渲染模板，format=html 时直接返回 HTML
+func PreviewTemplate(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[preview_template] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[preview_template] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4151,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[preview_template] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[preview_template] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	name := query.Get("name")
+	locale := query.Get("locale")
+	format := query.Get("format")
+	global.Log.Debugf("[preview_template] name:[%s] locale:[%s] format:[%s]", name, locale, format)
+
+	templates := notifyTemplates()
+	if name == "" {
+		global.Log.Errorln("[preview_template] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4152,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	rendered, err := templates.Render(name, locale, message.SampleData(name))
+	if err != nil {
+		global.Log.Errorf("[preview_template] 渲染模板失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("渲染模板失败, err:%v", err),
+			Error:   4153,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(rendered.HTML))
+		return
+	}
+
+	responseTemplatePreview := ResponseTemplatePreview{
+		Status:  true,
+		Message: "渲染模板成功!",
+		Names:   templates.Names(),
+		Data:    rendered,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseTemplatePreview)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// validateNotifyChannel 检查渠道类型、地址和邮件收件人
+// ----------------------------------------------------------------------------------------------------------
+func validateNotifyChannel(channel database.ExportedNotifyChannel) error {
+	if channel.Name == "" || len(channel.Name) > 64 {
+		return fmt.Errorf("invalid name: %q", channel.Name)
+	}
+	if channel.Type == message.CHANNELEMAIL {
+		for _, recipient := range channel.Recipients {
+			if !global.IsValidEmail(recipient) {
+				return fmt.Errorf("invalid recipient: %s", recipient)
+			}
+		}
+	} else if channel.URL != "" {
+		u, err := url.Parse(channel.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url: %s", channel.URL)
+		}
+	}
+	sender := notifySender()
+	_, err := message.NewNotifier(message.ChannelConfig{
+		Type:   channel.Type,
+		URL:    channel.URL,
+		Secret: channel.Secret,
+		To:     channel.Recipients,
+	}, &sender)
+	return err
+}
+
+// notifySender 使用 [EMAIL SETTING] 的发件设置
+func notifySender() message.EmailSender {
+	return message.EmailSender{
+		Host:     global.GlobalJWireGuardini.EmailHost,
+		Port:     global.GlobalJWireGuardini.EmailPort,
+		Username: global.GlobalJWireGuardini.EmailUser,
+		Password: global.GlobalJWireGuardini.EmailPass,
+		From:     global.GlobalJWireGuardini.FormEmail,
+		Name:     global.GlobalJWireGuardini.FormName,
+		Security: global.GlobalJWireGuardini.EmailSecurity,
+	}
+}
+
+// notifyTemplates 使用 [TEMPLATE SETTING] 的模板目录、语言和服务器名称
+func notifyTemplates() *message.TemplateRegistry {
+	return &message.TemplateRegistry{
+		Dir:    global.GlobalJWireGuardini.TemplateDir,
+		Locale: global.GlobalJWireGuardini.TemplateLocale,
+		Server: global.GlobalJWireGuardini.ServerName,
+	}
+}
@@ -0,0 +1,294 @@
+// webservice/offline.go
+package webservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"net"
+	"net/http"
+	"time"
+)
+
+type ResponseOfflinePolicyList struct {
+	Status  bool                             `json:"status"`
+	Message string                           `json:"message"`
+	Default database.ExportedOfflinePolicy   `json:"default"` // 未设置的子网和设备使用的参数
+	Data    []database.ExportedOfflinePolicy `json:"data"`
+}
+
+func registerOfflineRoutes() {
+	http.HandleFunc("/get_offline_policy", ValidateSessionMiddleware(GetOfflinePolicy))
+	http.HandleFunc("/set_offline_policy", ValidateSessionMiddleware(SetOfflinePolicy))
+	http.HandleFunc("/del_offline_policy", ValidateSessionMiddleware(DelOfflinePolicy))
+}
+
+// GetOfflinePolicy 获取子网和设备的离线判断参数
+func GetOfflinePolicy(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_offline_policy] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_offline_policy] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_offline_policy] client [%s:%s]", ip, port)
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_offline_policy] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	offlinePolicy := database.OfflinePolicy{}
+	offlinePolicy.CreateOfflinePolicy(global.GlobalDB)
+	policies, err := offlinePolicy.GetAllOfflinePolicy(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_offline_policy] 获取离线判断参数失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取离线判断参数失败, err:%v", err),
+			Error:   4001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedPolicies := []database.ExportedOfflinePolicy{}
+	for _, policy := range policies {
+		exportedPolicies = append(exportedPolicies, policy.ToExported())
+	}
+
+	responseOfflinePolicyList := ResponseOfflinePolicyList{
+		Status:  true,
+		Message: "获取离线判断参数成功!",
+		Default: database.ExportedOfflinePolicy{
+			Interval: int64(global.GlobalJWireGuardini.OfflineInterval),
+			Missed:   int64(global.GlobalJWireGuardini.OfflineMissed),
+		},
+		Data: exportedPolicies,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseOfflinePolicyList)
+}
+
+// SetOfflinePolicy 设置子网或设备的心跳间隔和连续丢失次数，已存在时覆盖
+func SetOfflinePolicy(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[set_offline_policy] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[set_offline_policy] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4011,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[set_offline_policy] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[set_offline_policy] client [%s:%s]", ip, port)
+	// 确保请求方法是POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		global.Log.Errorln("[set_offline_policy] 请求类型不是Post")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求类型不是Post",
+			Error:   4012,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedOfflinePolicy := database.ExportedOfflinePolicy{}
+	if err := parseJSONBody(r, &exportedOfflinePolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		global.Log.Errorf("[set_offline_policy] 解析JSON请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("解析JSON请求参数错误, err:%v", err),
+			Error:   4013,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Debugf("[set_offline_policy] json:[%+v]", exportedOfflinePolicy)
+	offlinePolicy := exportedOfflinePolicy.ConvertToOfflinePolicy()
+	if (offlinePolicy.Scope.String != database.OFFLINESCOPESUBNET && offlinePolicy.Scope.String != database.OFFLINESCOPEDEVICE) ||
+		offlinePolicy.TargetID.String == "" || offlinePolicy.Interval.Int64 <= 0 || offlinePolicy.Missed.Int64 <= 0 {
+		global.Log.Errorln("[set_offline_policy] 请求参数错误")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求参数错误",
+			Error:   4014,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[set_offline_policy] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 子网或设备必须存在
+	if offlinePolicy.Scope.String == database.OFFLINESCOPESUBNET {
+		subnet := database.Subnet{}
+		subnet.CreateSubnet(global.GlobalDB)
+		subnet.SerID.String = offlinePolicy.TargetID.String
+		err = subnet.GetSubnetBySerId(global.GlobalDB)
+	} else {
+		cliConfig := database.CliConfig{}
+		cliConfig.CreateCliConfig(global.GlobalDB)
+		cliConfig.CliID.String = offlinePolicy.TargetID.String
+		err = cliConfig.GetCliConfigByCliID(global.GlobalDB)
+	}
+	if err != nil {
+		global.Log.Errorf("[set_offline_policy] 子网或客户端不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("子网或客户端不存在, err:%v", err),
+			Error:   4015,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	offlinePolicy.UpdatedBy.String = XUserID
+	offlinePolicy.UpdatedAt.Int64 = time.Now().Unix()
+	offlinePolicy.CreateOfflinePolicy(global.GlobalDB)
+	err = offlinePolicy.SaveOfflinePolicy(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[set_offline_policy] 设置离线判断参数失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("设置离线判断参数失败, err:%v", err),
+			Error:   4016,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[set_offline_policy] %s %s 心跳间隔 %d 秒, 连续丢失 %d 次判断为离线",
+		offlinePolicy.Scope.String, offlinePolicy.TargetID.String, offlinePolicy.Interval.Int64, offlinePolicy.Missed.Int64)
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "设置离线判断参数成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
+
+// DelOfflinePolicy 删除子网或设备的离线判断参数，恢复使用上一级的设置
+func DelOfflinePolicy(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[del_offline_policy] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[del_offline_policy] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4021,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[del_offline_policy] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[del_offline_policy] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	scope := query.Get("scope")
+	targetId := query.Get("target_id")
+	if scope == "" || targetId == "" {
+		global.Log.Errorln("[del_offline_policy] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4022,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[del_offline_policy] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	offlinePolicy := database.OfflinePolicy{}
+	offlinePolicy.CreateOfflinePolicy(global.GlobalDB)
+	offlinePolicy.Scope.String = scope
+	offlinePolicy.TargetID.String = targetId
+	err = offlinePolicy.DeleteOfflinePolicy(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[del_offline_policy] 删除离线判断参数失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("删除离线判断参数失败, err:%v", err),
+			Error:   4023,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "删除离线判断参数成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
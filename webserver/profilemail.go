@@ -0,0 +1,462 @@
+// webservice/profilemail.go
+package webservice
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/message"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 解压密码的字符集，去掉了容易混淆的 0/O、1/l/I
+const profilePasswordChars = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789"
+
+const profilePasswordLength = 16
+
+type ResponseSendCliConfig struct {
+	Status   bool   `json:"status"`
+	Message  string `json:"message"`
+	Password string `json:"password,omitempty"` // 未指定密码渠道时返回，由操作人另行告知收件人
+}
+
+type ResponseProfileMailList struct {
+	Status  bool                           `json:"status"`
+	Message string                         `json:"message"`
+	Total   int                            `json:"total"`
+	Data    []database.ExportedProfileMail `json:"data"`
+}
+
+func registerProfileMailRoutes() {
+	http.HandleFunc("/send_cli_config", ValidateSessionMiddleware(SendCliConfig))
+	http.HandleFunc("/get_cli_config_mail", ValidateSessionMiddleware(GetCliConfigMail))
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SendCliConfig 将客户端配置作为附件发送到邮箱，to 为空时发送给客户端所属用户的邮箱
+// zip=true 时附件为 AES 加密的 zip，密码通过 password_channel 指定的通知渠道发送，未指定时在响应中返回
+// 每次发送都记录审计日志
+// ----------------------------------------------------------------------------------------------------------
+func SendCliConfig(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[send_cli_config] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[send_cli_config] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[send_cli_config] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	to := query.Get("to")
+	encrypted := query.Get("zip") == "true"
+	passwordChannelId, _ := strconv.ParseInt(query.Get("password_channel"), 10, 64)
+	global.Log.Debugf("[send_cli_config] cli_id:[%s] to:[%s] zip:[%v] password_channel:[%d]", cliId, to, encrypted, passwordChannelId)
+
+	if cliId == "" {
+		global.Log.Errorln("[send_cli_config] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   4161,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	if (to != "" && !global.IsValidEmail(to)) || (passwordChannelId != 0 && !encrypted) {
+		global.Log.Errorf("[send_cli_config] 请求参数错误, to:%s password_channel:%d", to, passwordChannelId)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求参数错误",
+			Error:   4162,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[send_cli_config] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliConfig := database.CliConfig{}
+	cliConfig.CreateCliConfig(global.GlobalDB)
+	cliConfig.CliID.String = cliId
+	err = cliConfig.GetCliConfigByCliID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[send_cli_config] 客户端不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("客户端不存在, err:%v", err),
+			Error:   4163,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	allowed, err := canManageCli(XUserID, cliConfig)
+	if err != nil || !allowed {
+		global.Log.Errorf("[send_cli_config] 权限不足, userID:%s cli_id:%s err:%v", XUserID, cliId, err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4164,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 未指定收件人时发送给客户端所属的用户
+	if to == "" {
+		owner := database.User{}
+		owner.UserID.String = cliId
+		if owner.GetUserByID(global.GlobalDB) == nil && global.IsValidEmail(owner.UserEmail.String) {
+			to = owner.UserEmail.String
+		}
+	}
+	if to == "" {
+		global.Log.Errorf("[send_cli_config] 客户端没有所属用户的邮箱, 需要指定收件人, cli_id:%s", cliId)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "客户端没有所属用户的邮箱, 需要指定收件人",
+			Error:   4165,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 密码渠道不能把密码发到同一个邮箱
+	var passwordChannel database.NotifyChannel
+	var passwordNotifier message.Notifier
+	sender := notifySender()
+	if passwordChannelId != 0 {
+		passwordChannel.CreateNotifyChannel(global.GlobalDB)
+		passwordChannel.ID.Int64 = passwordChannelId
+		err = passwordChannel.GetNotifyChannelByID(global.GlobalDB)
+		if err == nil && !passwordChannel.Enabled.Bool {
+			err = fmt.Errorf("channel %s is disabled", passwordChannel.Name.String)
+		}
+		if err == nil && passwordChannel.Type.String == message.CHANNELEMAIL {
+			recipients := passwordChannel.RecipientList()
+			if len(recipients) == 0 {
+				err = fmt.Errorf("channel %s has no recipient", passwordChannel.Name.String)
+			}
+			for _, recipient := range recipients {
+				if strings.EqualFold(recipient, to) {
+					err = fmt.Errorf("channel %s sends to the same address %s", passwordChannel.Name.String, to)
+				}
+			}
+		}
+		if err == nil {
+			passwordNotifier, err = message.NewNotifier(message.ChannelConfig{
+				Type:   passwordChannel.Type.String,
+				URL:    passwordChannel.URL.String,
+				Secret: passwordChannel.Secret.String,
+				To:     passwordChannel.RecipientList(),
+			}, &sender)
+		}
+		if err != nil {
+			global.Log.Errorf("[send_cli_config] 密码渠道不可用, err:%v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: fmt.Sprintf("密码渠道不可用, err:%v", err),
+				Error:   4166,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+	}
+
+	sentBy := XUserID
+	operator := database.User{}
+	operator.UserID.String = XUserID
+	if operator.GetUserByID(global.GlobalDB) == nil && operator.UserName.String != "" {
+		sentBy = operator.UserName.String
+	}
+
+	profileMail := database.ProfileMail{}
+	profileMail.CreateProfileMail(global.GlobalDB)
+	profileMail.CliID.String = cliId
+	profileMail.SerID.String = cliConfig.SerID.String
+	profileMail.Recipient.String = to
+	profileMail.Encrypted.Bool = encrypted
+	profileMail.PasswordChannel.String = passwordChannel.Name.String
+	profileMail.SentBy.String = sentBy
+	profileMail.ClientIP.String = ip
+	// audit 记录发送结果，记录失败只写日志
+	audit := func(status string, err error) {
+		profileMail.Status.String = status
+		if err != nil {
+			profileMail.Error.String = err.Error()
+		}
+		profileMail.CreatedAt.Int64 = time.Now().Unix()
+		if err := profileMail.InsertProfileMail(global.GlobalDB); err != nil {
+			global.Log.Errorf("[send_cli_config] 无法记录审计日志, cli_id:%s to:%s err:%v", cliId, to, err)
+		}
+	}
+
+	filename, data, err := readCliConfigFile(cliConfig)
+	if err != nil {
+		audit(database.PROFILEMAILFAILED, err)
+		global.Log.Errorf("[send_cli_config] 客户端配置读取失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("客户端配置读取失败, err:%v", err),
+			Error:   4167,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	attachment := message.Attachment{Filename: filename, Data: data}
+	password := ""
+	if encrypted {
+		password, err = randomPassword(profilePasswordLength)
+		if err == nil {
+			attachment.Data, err = message.EncryptedZip(filename, data, password, time.Now())
+		}
+		attachment.Filename, attachment.ContentType = filename+".zip", "application/zip"
+	}
+	profileMail.Filename.String = attachment.Filename
+
+	values := map[string]interface{}{
+		"CliID":     cliId,
+		"CliName":   cliConfig.CliName.String,
+		"SerName":   cliConfig.SerName.String,
+		"Filename":  attachment.Filename,
+		"Encrypted": encrypted,
+		"SentBy":    sentBy,
+		"Recipient": to,
+	}
+	var rendered message.Rendered
+	if err == nil {
+		rendered, err = notifyTemplates().Render(message.TEMPLATECLIPROFILE, "", values)
+	}
+	if err == nil {
+		err = sender.Send(message.Mail{
+			To:          []string{to},
+			Subject:     rendered.Subject,
+			Text:        rendered.Text,
+			HTML:        rendered.HTML,
+			Attachments: []message.Attachment{attachment},
+		})
+	}
+	if err != nil {
+		audit(database.PROFILEMAILFAILED, err)
+		global.Log.Errorf("[send_cli_config] 邮件发送失败, cli_id:%s to:%s err:%v", cliId, to, err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("邮件发送失败, err:%v", err),
+			Error:   4168,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 配置已发出后再发送密码，发送失败时把密码返回给操作人
+	if passwordNotifier != nil {
+		// 密码只出现在单独发送的密码通知中，不传给配置邮件的模板
+		passwordValues := map[string]interface{}{"Password": password}
+		for key, value := range values {
+			passwordValues[key] = value
+		}
+		rendered, err = notifyTemplates().Render(message.TEMPLATEPROFILEPASSWORD, "", passwordValues)
+		if err == nil {
+			err = passwordNotifier.Notify(message.Notification{
+				Event: message.TEMPLATEPROFILEPASSWORD,
+				SerID: cliConfig.SerID.String,
+				CliID: cliId,
+				Title: rendered.Subject,
+				Text:  rendered.Text,
+				HTML:  rendered.HTML,
+				Time:  time.Now().Unix(),
+			})
+		}
+		if err != nil {
+			audit(database.PROFILEMAILPASSWORDFAILED, err)
+			global.Log.Errorf("[send_cli_config] 配置已发送, 密码发送失败, cli_id:%s to:%s channel:%s err:%v",
+				cliId, to, passwordChannel.Name.String, err)
+			responseSendCliConfig := ResponseSendCliConfig{
+				Status:   false,
+				Message:  fmt.Sprintf("配置已发送, 密码发送失败, 请通过其它方式告知收件人, err:%v", err),
+				Password: password,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseSendCliConfig)
+			return
+		}
+		password = ""
+	}
+
+	audit(database.PROFILEMAILSENT, nil)
+	global.Log.Infof("[send_cli_config] 客户端配置已发送, cli_id:%s to:%s zip:%v by:%s", cliId, to, encrypted, sentBy)
+	responseSendCliConfig := ResponseSendCliConfig{
+		Status:   true,
+		Message:  "发送客户端配置成功!",
+		Password: password,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSendCliConfig)
+}
+
+// GetCliConfigMail 获取发送客户端配置的审计日志，cli_id 为空时获取全部
+func GetCliConfigMail(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cli_config_mail] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_cli_config_mail] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4171,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cli_config_mail] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cli_config_mail] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	page, _ := strconv.Atoi(query.Get("page"))
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_config_mail] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	profileMail := database.ProfileMail{}
+	profileMail.CreateProfileMail(global.GlobalDB)
+	records, total, err := database.GetProfileMail(global.GlobalDB, cliId, page)
+	if err != nil {
+		global.Log.Errorf("[get_cli_config_mail] 获取审计日志失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取审计日志失败, err:%v", err),
+			Error:   4172,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedRecords := []database.ExportedProfileMail{}
+	for _, record := range records {
+		exportedRecords = append(exportedRecords, record.ToExported())
+	}
+
+	responseProfileMailList := ResponseProfileMailList{
+		Status:  true,
+		Message: "获取审计日志成功!",
+		Total:   total,
+		Data:    exportedRecords,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseProfileMailList)
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// canManageCli 管理员、客户端所属用户以及能管理客户端所在子网的用户可以操作客户端
+// ----------------------------------------------------------------------------------------------------------
+func canManageCli(userId string, cliConfig database.CliConfig) (bool, error) {
+	if global.IsAdmin(userId) || userId == cliConfig.CliID.String {
+		return true, nil
+	}
+	if cliConfig.SerID.String == "" {
+		return false, nil
+	}
+
+	user := database.User{}
+	userIds, err := user.QueryUserIds(global.GlobalDB, userId)
+	if err != nil {
+		return false, err
+	}
+	serIds, err := user.GetSubnetIdsByUserIds(global.GlobalDB, userIds)
+	if err != nil {
+		return false, err
+	}
+	for _, serId := range serIds {
+		if serId == cliConfig.SerID.String {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readCliConfigFile 按子网的隧道后端读取客户端配置，返回文件名和内容
+func readCliConfigFile(cliConfig database.CliConfig) (string, []byte, error) {
+	backend, err := cliBackend(cliConfig)
+	if err != nil {
+		return "", nil, err
+	}
+	cliConfigFile, err := backend.ClientConfigFile(cliConfig.CliID.String)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := os.ReadFile(cliConfigFile)
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Base(cliConfigFile), data, nil
+}
+
+// randomPassword 生成随机的解压密码
+func randomPassword(length int) (string, error) {
+	max := big.NewInt(int64(len(profilePasswordChars)))
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = profilePasswordChars[n.Int64()]
+	}
+	return string(password), nil
+}
@@ -0,0 +1,361 @@
+// webservice/session.go
+package webservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/management"
+	"net"
+	"net/http"
+)
+
+type CliSession struct {
+	CliID              string `json:"cli_id"`
+	CliName            string `json:"cli_name"`
+	SerID              string `json:"ser_id"`
+	Online             bool   `json:"online"`
+	RealAddress        string `json:"real_address"`
+	VirtualAddress     string `json:"virtual_address"`
+	VirtualIPv6Address string `json:"virtual_ipv6_address"`
+	BytesReceived      int64  `json:"bytes_received"`
+	BytesSent          int64  `json:"bytes_sent"`
+	ConnectedSince     int64  `json:"connected_since"`
+	ClientID           int64  `json:"client_id"`
+}
+
+type ResponseKillCli struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+type ResponseSessionList struct {
+	Status  bool         `json:"status"`
+	Message string       `json:"message"`
+	Data    []CliSession `json:"data"`
+}
+
+func registerSessionRoutes() {
+	http.HandleFunc("/get_session_list", ValidateSessionMiddleware(GetSessionList))
+	http.HandleFunc("/get_cli_session", ValidateSessionMiddleware(GetCliSession))
+	http.HandleFunc("/kill_cli", ValidateSessionMiddleware(KillCli))
+}
+
+// newCliSession 合并客户端配置与管理接口中的连接信息
+func newCliSession(cliConfig database.CliConfig, session *management.ClientSession) CliSession {
+	cliSession := CliSession{
+		CliID:    cliConfig.CliID.String,
+		CliName:  cliConfig.CliName.String,
+		SerID:    cliConfig.SerID.String,
+		ClientID: -1,
+	}
+	if session == nil {
+		return cliSession
+	}
+
+	cliSession.Online = true
+	cliSession.RealAddress = session.RealAddress
+	cliSession.VirtualAddress = session.VirtualAddress
+	cliSession.VirtualIPv6Address = session.VirtualIPv6Address
+	cliSession.BytesReceived = session.BytesReceived
+	cliSession.BytesSent = session.BytesSent
+	cliSession.ClientID = session.ClientID
+	if !session.ConnectedSince.IsZero() {
+		cliSession.ConnectedSince = session.ConnectedSince.Unix()
+	}
+	return cliSession
+}
+
+// GetSessionList 获取子网下客户端的实时连接状态, ser_id 为空时返回所有已连接的客户端
+func GetSessionList(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_session_list] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_session_list] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_session_list] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	serId := query.Get("ser_id")
+	global.Log.Debugf("[get_session_list] ser_id:[%s]", serId)
+
+	status, err := getManagementStatus()
+	if err != nil {
+		global.Log.Errorf("[get_session_list] 获取连接状态失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取连接状态失败, err:%v", err),
+			Error:   3501,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_session_list] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliConfig := database.CliConfig{}
+	cliConfig.CreateCliConfig(global.GlobalDB)
+
+	var cliConfigs []database.CliConfig
+	if serId != "" {
+		cliConfig.SerID.String = serId
+		cliConfigs, err = cliConfig.GetCliConfigBySerID(global.GlobalDB)
+	} else {
+		cliConfigs, err = cliConfig.GetAllCliConfig(global.GlobalDB)
+	}
+	if err != nil {
+		global.Log.Errorf("[get_session_list] 获取客户端列表失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取客户端列表失败, err:%v", err),
+			Error:   3502,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	sessions := make(map[string]management.ClientSession, len(status.Clients))
+	for _, client := range status.Clients {
+		sessions[client.CommonName] = client
+	}
+
+	cliSessions := []CliSession{}
+	for _, config := range cliConfigs {
+		session, ok := sessions[config.CliID.String]
+		if serId == "" && !ok {
+			// 不指定子网时只返回已连接的客户端
+			continue
+		}
+		if ok {
+			cliSessions = append(cliSessions, newCliSession(config, &session))
+		} else {
+			cliSessions = append(cliSessions, newCliSession(config, nil))
+		}
+	}
+
+	responseSessionList := ResponseSessionList{
+		Status:  true,
+		Message: "获取连接状态成功!",
+		Data:    cliSessions,
+	}
+
+	// 将JSON对象转为字符串
+	jsonData, err := json.Marshal(responseSessionList)
+	if err != nil {
+		global.Log.Errorf("[get_session_list] 无法将JSON对象转为字符串, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法将JSON对象转为字符串, err:%v", err),
+			Error:   3503,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	// 设置响应头，指明内容类型为 JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// GetCliSession 获取单个客户端的实时连接状态
+func GetCliSession(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cli_session] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cli_session] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cli_session] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	global.Log.Debugf("[get_cli_session] cli_id:[%s]", cliId)
+	// 判断参数是否为空
+	if cliId == "" {
+		global.Log.Errorln("[get_cli_session] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3504,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_session] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	cliConfig := database.CliConfig{}
+	cliConfig.CreateCliConfig(global.GlobalDB)
+	cliConfig.CliID.String = cliId
+	err = cliConfig.GetCliConfigByCliID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_session] 获取客户端信息失败!, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取客户端信息失败!, err:%v", err),
+			Error:   3505,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	status, err := getManagementStatus()
+	if err != nil {
+		global.Log.Errorf("[get_cli_session] 获取连接状态失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取连接状态失败, err:%v", err),
+			Error:   3506,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 同一证书可能存在多个连接，全部返回
+	cliSessions := []CliSession{}
+	for _, session := range status.FindClient(cliId) {
+		session := session
+		cliSessions = append(cliSessions, newCliSession(cliConfig, &session))
+	}
+	if len(cliSessions) == 0 {
+		cliSessions = append(cliSessions, newCliSession(cliConfig, nil))
+	}
+
+	responseSessionList := ResponseSessionList{
+		Status:  true,
+		Message: "获取连接状态成功!",
+		Data:    cliSessions,
+	}
+
+	// 将JSON对象转为字符串
+	jsonData, err := json.Marshal(responseSessionList)
+	if err != nil {
+		global.Log.Errorf("[get_cli_session] 无法将JSON对象转为字符串, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法将JSON对象转为字符串, err:%v", err),
+			Error:   3507,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	// 设置响应头，指明内容类型为 JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// getManagementStatus 通过管理接口获取 status
+func getManagementStatus() (*management.Status, error) {
+	client, err := global.GetManagement()
+	if err != nil {
+		return nil, err
+	}
+	return client.Status()
+}
+
+// KillCli 通过管理接口强制断开客户端
+func KillCli(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[kill_cli] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[kill_cli] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[kill_cli] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	global.Log.Debugf("[kill_cli] cli_id:[%s]", cliId)
+	// 判断参数是否为空
+	if cliId == "" {
+		global.Log.Errorln("[kill_cli] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3601,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	count, err := global.KillClient(cliId)
+	if err != nil {
+		global.Log.Errorf("[kill_cli] 断开客户端失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("断开客户端失败, err:%v", err),
+			Error:   3602,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	global.Log.Infof("[kill_cli] 客户端ID: [%s] 已断开 %d 个连接", cliId, count)
+
+	// 返回结果
+	responseKillCli := ResponseKillCli{
+		Status:  true,
+		Message: "客户端已断开!",
+		Count:   count,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseKillCli)
+}
+
+// killCliSession 客户端配置变更后断开连接，使新的 ccd 配置或删除操作立即生效
+// 管理接口不可用时只记录日志，不影响原操作的结果
+func killCliSession(tag string, cliId string) {
+	count, err := global.KillClient(cliId)
+	if err != nil {
+		global.Log.Warnf("[%s] 无法断开客户端ID: [%s], err:%v", tag, cliId, err)
+		return
+	}
+	if count > 0 {
+		global.Log.Infof("[%s] 客户端ID: [%s] 已断开 %d 个连接", tag, cliId, count)
+	}
+}
@@ -83,6 +83,24 @@ func AddSubnet(w http.ResponseWriter, r *http.Request) {
 		portSubnet.SerID.String = global.GenerateMD5(portSubnet.SerName.String)
 	}
 
+	// 未指定隧道后端时使用默认后端
+	if portSubnet.Backend.String == "" {
+		portSubnet.Backend.String = global.GlobalJWireGuardini.TunnelBackend
+	}
+	backend, err := global.GetBackend(portSubnet.Backend.String)
+	if err != nil {
+		global.Log.Errorf("[add_subnet] 不支持的隧道后端, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("不支持的隧道后端, err:%v", err),
+			Error:   2207,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	portSubnet.Backend.String = backend.Name()
+
 	// 查询连接状态
 	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
 	if err != nil {
@@ -116,8 +134,24 @@ func AddSubnet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 添加数据库
-	err = portSubnet.InsertSubnet(global.GlobalDB)
+	// 确定子网网段并添加数据库，网段的重叠检查和插入在同一事务中
+	var resolveErr error
+	network, err := portSubnet.InsertSubnetWithNetwork(global.GlobalDB, func(used []*net.IPNet) (*net.IPNet, error) {
+		network, err := resolveSubnetNetwork(&portSubnet, exportPortSubnet.PrefixLen, used)
+		resolveErr = err
+		return network, err
+	})
+	if resolveErr != nil {
+		global.Log.Errorf("[add_subnet] 子网网段无效, err:%v", resolveErr)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("子网网段无效, err:%v", resolveErr),
+			Error:   2208,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
 	if err != nil {
 		// 如果参数为空，返回 JSON 错误响应
 		global.Log.Errorf("[add_subnet] 添加子网失败, err:%v", err)
@@ -131,25 +165,18 @@ func AddSubnet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 配置Iptables
-	rules := fmt.Sprintf("-s %s.%d.0/24 -d %s.0.0/16 -j ACCEPT",
-		global.GlobalJWireGuardini.IPPrefix,
-		portSubnet.SerNum.Int32,
-		global.GlobalJWireGuardini.IPPrefix)
-
-	if !global.CheckIptablesRule(rules) {
-		err := global.AddIptablesRule(rules)
-		if err != nil {
-			global.Log.Errorf("[add_subnet] 路由配置错误 '%s': %v", rules, err)
-			responseError := ResponseError{
-				Status:  false,
-				Message: fmt.Sprintf("路由配置错误 '%s': %v", rules, err),
-				Error:   2206,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(responseError)
-			return
+	// 配置Iptables，启用 IPv6 时同时配置 ip6tables
+	err = global.AddSubnetRules(network)
+	if err != nil {
+		global.Log.Errorf("[add_subnet] 路由配置错误 %v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("路由配置错误 %v", err),
+			Error:   2206,
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
 	}
 
 	// 返回结果
@@ -256,8 +283,98 @@ func EditSubnet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 修改隧道后端时，子网中不能有客户端
+	if portSubnet.Backend.String != "" {
+		newBackend, err := global.GetBackend(portSubnet.Backend.String)
+		if err != nil {
+			global.Log.Errorf("[edit_subnet] 不支持的隧道后端, err:%v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: fmt.Sprintf("不支持的隧道后端, err:%v", err),
+				Error:   2308,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+		portSubnet.Backend.String = newBackend.Name()
+	}
+	if portSubnet.Backend.String != "" && portSubnet.Backend.String != subnetBackendName(portSubnetbak) {
+		cliConfig := database.CliConfig{}
+		cliConfig.CreateCliConfig(global.GlobalDB)
+		cliConfig.SerID = portSubnet.SerID
+		cliConfigs, err := cliConfig.GetCliConfigBySerID(global.GlobalDB)
+		if err != nil || len(cliConfigs) > 0 {
+			global.Log.Errorf("[edit_subnet] 子网中存在客户端，不能修改隧道后端, err:%v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: "子网中存在客户端，不能修改隧道后端",
+				Error:   2309,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+	}
+
+	// 修改网段时，子网中不能有客户端
+	oldNetwork, _ := portSubnetbak.Network()
+	newNetwork := oldNetwork
+	portSubnet.SerNum = portSubnetbak.SerNum
+	updated := false
+	if portSubnet.CIDR.String != "" && portSubnet.CIDR.String != portSubnetbak.CIDR.String {
+		cliConfig := database.CliConfig{}
+		cliConfig.CreateCliConfig(global.GlobalDB)
+		cliConfig.SerID = portSubnet.SerID
+		cliConfigs, err := cliConfig.GetCliConfigBySerID(global.GlobalDB)
+		if err != nil || len(cliConfigs) > 0 {
+			global.Log.Errorf("[edit_subnet] 子网中存在客户端，不能修改网段, err:%v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: "子网中存在客户端，不能修改网段",
+				Error:   2310,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+
+		// 网段的重叠检查和更新在同一事务中
+		var resolveErr error
+		newNetwork, err = portSubnet.UpdateSubnetWithNetwork(global.GlobalDB, func(used []*net.IPNet) (*net.IPNet, error) {
+			network, err := resolveSubnetNetwork(&portSubnet, 0, used)
+			resolveErr = err
+			return network, err
+		})
+		if resolveErr != nil {
+			global.Log.Errorf("[edit_subnet] 子网网段无效, err:%v", resolveErr)
+			responseError := ResponseError{
+				Status:  false,
+				Message: fmt.Sprintf("子网网段无效, err:%v", resolveErr),
+				Error:   2311,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+		if err != nil {
+			global.Log.Errorf("[edit_subnet] 子网网段更新失败, err:%v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: fmt.Sprintf("子网网段更新失败, err:%v", err),
+				Error:   2312,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
+		}
+		updated = true
+	}
+
 	// 添加数据库
-	err = portSubnet.UpdateSubnet(global.GlobalDB)
+	if !updated {
+		err = portSubnet.UpdateSubnet(global.GlobalDB)
+	}
 	if err != nil {
 		// 如果参数为空，返回 JSON 错误响应
 		global.Log.Errorf("[edit_subnet] 子网更新失败, err:%v", err)
@@ -271,49 +388,35 @@ func EditSubnet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	global.Log.Debugln("旧的：", portSubnetbak.SerNum.Int32)
-	global.Log.Debugln("新的：", portSubnet.SerNum.Int32)
-	if portSubnetbak.SerNum.Int32 != portSubnet.SerNum.Int32 {
+	global.Log.Debugln("旧的：", oldNetwork)
+	global.Log.Debugln("新的：", newNetwork)
+	if oldNetwork != nil && newNetwork.String() != oldNetwork.String() {
 		// 配置Iptables
-		delRules := fmt.Sprintf("-s %s.%d.0/24 -d %s.0.0/16 -j ACCEPT",
-			global.GlobalJWireGuardini.IPPrefix,
-			portSubnetbak.SerNum.Int32,
-			global.GlobalJWireGuardini.IPPrefix)
-
-		if global.CheckIptablesRule(delRules) {
-			err := global.DeleteIptablesRule(delRules)
-			if err != nil {
-				global.Log.Errorf("[edit_subnet] 路由删除错误 '%s': %v", delRules, err)
-				responseError := ResponseError{
-					Status:  false,
-					Message: fmt.Sprintf("路由删除错误 '%s': %v", delRules, err),
-					Error:   2306,
-				}
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(responseError)
-				return
+		err := global.DeleteSubnetRules(oldNetwork)
+		if err != nil {
+			global.Log.Errorf("[edit_subnet] 路由删除错误 %v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: fmt.Sprintf("路由删除错误 %v", err),
+				Error:   2306,
 			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
 		}
 
 		// 配置Iptables
-		addRules := fmt.Sprintf("-s %s.%d.0/24 -d %s.0.0/16 -j ACCEPT",
-			global.GlobalJWireGuardini.IPPrefix,
-			portSubnet.SerNum.Int32,
-			global.GlobalJWireGuardini.IPPrefix)
-
-		if !global.CheckIptablesRule(addRules) {
-			err := global.AddIptablesRule(addRules)
-			if err != nil {
-				global.Log.Errorf("[edit_subnet] 路由配置错误 '%s': %v", addRules, err)
-				responseError := ResponseError{
-					Status:  false,
-					Message: fmt.Sprintf("路由配置错误 '%s': %v", addRules, err),
-					Error:   2307,
-				}
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(responseError)
-				return
+		err = global.AddSubnetRules(newNetwork)
+		if err != nil {
+			global.Log.Errorf("[edit_subnet] 路由配置错误 %v", err)
+			responseError := ResponseError{
+				Status:  false,
+				Message: fmt.Sprintf("路由配置错误 %v", err),
+				Error:   2307,
 			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(responseError)
+			return
 		}
 	}
 
@@ -407,23 +510,20 @@ func DelSubnet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 删除Iptables
-	rules := fmt.Sprintf("-s %s.%d.0/24 -d %s.0.0/16 -j ACCEPT",
-		global.GlobalJWireGuardini.IPPrefix,
-		subnet.SerNum.Int32,
-		global.GlobalJWireGuardini.IPPrefix)
-	if global.CheckIptablesRule(rules) {
-		err := global.DeleteIptablesRule(rules)
-		if err != nil {
-			log.Printf("[del_subnet] 路由删除错误 '%s': %v", rules, err)
-			responseError := ResponseError{
-				Status:  false,
-				Message: fmt.Sprintf("路由删除错误 '%s': %v", rules, err),
-				Error:   2404,
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(responseError)
-			return
+	network, err := subnet.Network()
+	if err == nil {
+		err = global.DeleteSubnetRules(network)
+	}
+	if err != nil {
+		log.Printf("[del_subnet] 路由删除错误 %v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("路由删除错误 %v", err),
+			Error:   2404,
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
 	}
 
 	// 返回结果
@@ -434,3 +534,47 @@ func DelSubnet(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(responseSuccess)
 }
+
+// subnetBackendName 子网使用的隧道后端名称，未设置后端的旧子网视为 OpenVPN
+func subnetBackendName(subnet database.Subnet) string {
+	backend, err := global.GetBackend(subnet.Backend.String)
+	if err != nil {
+		return subnet.Backend.String
+	}
+	return backend.Name()
+}
+
+// resolveSubnetNetwork 根据其他子网已占用的网段 used 确定子网网段：指定 cidr 时校验其在 SUPERNET 中且不与其他子网重叠，
+// 只指定 ser_num 时沿用旧版本的 IP_PREFIX.ser_num.0 网段，否则按 prefixLen 分配第一个可用网段
+func resolveSubnetNetwork(subnet *database.Subnet, prefixLen int, used []*net.IPNet) (*net.IPNet, error) {
+	if subnet.CIDR.String == "" && subnet.SerNum.Int32 > 0 {
+		cidr, err := global.LegacySubnetCIDR(subnet.SerNum.Int32)
+		if err != nil {
+			return nil, err
+		}
+		subnet.CIDR.String = cidr
+	}
+
+	if subnet.CIDR.String == "" {
+		if prefixLen == 0 {
+			prefixLen = global.GlobalJWireGuardini.SubnetPrefixLen
+		}
+		return database.NewSubnetNetwork(prefixLen, used)
+	}
+
+	network, err := global.ParseSubnetCIDR(subnet.CIDR.String)
+	if err != nil {
+		return nil, err
+	}
+	for _, usedNetwork := range used {
+		if global.NetworksOverlap(network, usedNetwork) {
+			return nil, fmt.Errorf("%s 与已有子网 %s 重叠", network, usedNetwork)
+		}
+	}
+	for _, ip := range global.ReservedAddresses() {
+		if network.Contains(ip) {
+			return nil, fmt.Errorf("%s 包含服务端地址 %s", network, ip)
+		}
+	}
+	return network, nil
+}
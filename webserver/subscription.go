@@ -0,0 +1,416 @@
+// webservice/subscription.go
+package webservice
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"jwireguard/message"
+	"net"
+	"net/http"
+	"time"
+)
+
+// UserNotifyConfig 用户的通知订阅和免打扰时段
+type UserNotifyConfig struct {
+	UserID        string                                `json:"user_id"`
+	QuietHours    database.ExportedQuietHours           `json:"quiet_hours"`
+	Subscriptions []database.ExportedNotifySubscription `json:"subscriptions"`
+}
+
+// SubscribableChannel 用户可以选择的通知渠道，不包含地址和密钥
+type SubscribableChannel struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type ResponseUserNotifyConfig struct {
+	Status   bool                  `json:"status"`
+	Message  string                `json:"message"`
+	Held     int                   `json:"held"` // 免打扰期间暂存的通知数
+	Channels []SubscribableChannel `json:"channels"`
+	Data     UserNotifyConfig      `json:"data"`
+}
+
+func registerSubscriptionRoutes() {
+	http.HandleFunc("/get_user_notify_config", ValidateSessionMiddleware(GetUserNotifyConfig))
+	http.HandleFunc("/set_user_notify_config", ValidateSessionMiddleware(SetUserNotifyConfig))
+}
+
+// GetUserNotifyConfig 获取用户的通知订阅和免打扰时段，user_id 为空时获取自己的，管理员可以获取其他用户的
+func GetUserNotifyConfig(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_user_notify_config] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_user_notify_config] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_user_notify_config] client [%s:%s]", ip, port)
+
+	// 解析 URL 参数
+	query := r.URL.Query()
+	userId := query.Get("user_id")
+	if userId == "" {
+		userId = XUserID
+	}
+	if userId != XUserID && !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_user_notify_config] 权限不足, userID:%s user_id:%s", XUserID, userId)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4201,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_user_notify_config] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	user := database.User{}
+	user.UserID.String = userId
+	err = user.GetUserByID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_user_notify_config] 用户不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("用户不存在, err:%v", err),
+			Error:   4202,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	notifySubscription := database.NotifySubscription{}
+	notifySubscription.CreateNotifySubscription(global.GlobalDB)
+	notifySubscription.UserID.String = userId
+	config, held, channels, err := readUserNotifyConfig(notifySubscription)
+	if err != nil {
+		global.Log.Errorf("[get_user_notify_config] 获取通知订阅失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取通知订阅失败, err:%v", err),
+			Error:   4203,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseUserNotifyConfig := ResponseUserNotifyConfig{
+		Status:   true,
+		Message:  "获取通知订阅成功!",
+		Held:     held,
+		Channels: channels,
+		Data:     config,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseUserNotifyConfig)
+}
+
+// readUserNotifyConfig 读取用户的订阅、免打扰时段、暂存的通知数和可以选择的渠道
+func readUserNotifyConfig(notifySubscription database.NotifySubscription) (UserNotifyConfig, int, []SubscribableChannel, error) {
+	userId := notifySubscription.UserID.String
+	config := UserNotifyConfig{
+		UserID:        userId,
+		QuietHours:    database.ExportedQuietHours{UserID: userId},
+		Subscriptions: []database.ExportedNotifySubscription{},
+	}
+
+	subscriptions, err := notifySubscription.GetNotifySubscriptionByUserID(global.GlobalDB)
+	if err != nil {
+		return config, 0, nil, err
+	}
+	for _, subscription := range subscriptions {
+		config.Subscriptions = append(config.Subscriptions, subscription.ToExported())
+	}
+
+	quietHours := database.QuietHours{}
+	quietHours.UserID.String = userId
+	err = quietHours.GetQuietHoursByUserID(global.GlobalDB)
+	if err == nil {
+		config.QuietHours = quietHours.ToExported()
+	} else if err != sql.ErrNoRows {
+		return config, 0, nil, err
+	}
+
+	held := database.NotifyHeld{}
+	held.UserID.String = userId
+	count, err := held.CountNotifyHeld(global.GlobalDB)
+	if err != nil {
+		return config, 0, nil, err
+	}
+
+	notifyChannel := database.NotifyChannel{}
+	notifyChannel.CreateNotifyChannel(global.GlobalDB)
+	allChannels, err := notifyChannel.GetAllNotifyChannel(global.GlobalDB)
+	if err != nil {
+		return config, 0, nil, err
+	}
+	channels := []SubscribableChannel{}
+	for _, channel := range allChannels {
+		if channel.Enabled.Bool {
+			channels = append(channels, SubscribableChannel{ID: channel.ID.Int64, Name: channel.Name.String, Type: channel.Type.String})
+		}
+	}
+	return config, count, channels, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// SetUserNotifyConfig 保存用户的通知订阅和免打扰时段，subscriptions 替换用户现有的全部订阅
+// user_id 为空时保存自己的，管理员可以修改其他用户的，只能订阅该用户可见的子网
+// ----------------------------------------------------------------------------------------------------------
+func SetUserNotifyConfig(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[set_user_notify_config] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[set_user_notify_config] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[set_user_notify_config] client [%s:%s]", ip, port)
+	// 确保请求方法是POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		global.Log.Errorln("[set_user_notify_config] 请求类型不是Post")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "请求类型不是Post",
+			Error:   4211,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	var userNotifyConfig UserNotifyConfig
+	if err := parseJSONBody(r, &userNotifyConfig); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		global.Log.Errorf("[set_user_notify_config] 解析JSON请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("解析JSON请求参数错误, err:%v", err),
+			Error:   4212,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	if userNotifyConfig.UserID == "" {
+		userNotifyConfig.UserID = XUserID
+	}
+	global.Log.Debugf("[set_user_notify_config] json:[%+v]", userNotifyConfig)
+
+	if userNotifyConfig.UserID != XUserID && !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[set_user_notify_config] 权限不足, userID:%s user_id:%s", XUserID, userNotifyConfig.UserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   4213,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	if err := validateUserNotifyConfig(userNotifyConfig); err != nil {
+		global.Log.Errorf("[set_user_notify_config] 请求参数错误, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("请求参数错误, err:%v", err),
+			Error:   4214,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[set_user_notify_config] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	user := database.User{}
+	user.UserID.String = userNotifyConfig.UserID
+	err = user.GetUserByID(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[set_user_notify_config] 用户不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("用户不存在, err:%v", err),
+			Error:   4215,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 子网必须对该用户可见，渠道必须存在
+	err = checkSubscriptionTargets(user, userNotifyConfig.Subscriptions)
+	if err != nil {
+		global.Log.Errorf("[set_user_notify_config] 子网不可见或通知渠道不存在, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("子网不可见或通知渠道不存在, err:%v", err),
+			Error:   4216,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	now := time.Now().Unix()
+	subscriptions := make([]database.NotifySubscription, 0, len(userNotifyConfig.Subscriptions))
+	for _, exported := range userNotifyConfig.Subscriptions {
+		exported.UserID = userNotifyConfig.UserID
+		exported.UpdatedBy = XUserID
+		exported.UpdatedAt = now
+		subscriptions = append(subscriptions, exported.ConvertToNotifySubscription())
+	}
+	userNotifyConfig.QuietHours.UserID = userNotifyConfig.UserID
+	userNotifyConfig.QuietHours.UpdatedBy = XUserID
+	userNotifyConfig.QuietHours.UpdatedAt = now
+	quietHours := userNotifyConfig.QuietHours.ConvertToQuietHours()
+
+	notifySubscription := database.NotifySubscription{}
+	notifySubscription.CreateNotifySubscription(global.GlobalDB)
+	err = database.ReplaceNotifySubscription(global.GlobalDB, userNotifyConfig.UserID, subscriptions)
+	if err == nil {
+		err = quietHours.SaveQuietHours(global.GlobalDB)
+	}
+	if err != nil {
+		global.Log.Errorf("[set_user_notify_config] 保存通知订阅失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("保存通知订阅失败, err:%v", err),
+			Error:   4217,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	global.Log.Infof("[set_user_notify_config] 用户 %s 的通知订阅已保存, 订阅 %d 条, 免打扰 %v %s-%s %s",
+		user.UserName.String, len(subscriptions), quietHours.Enabled.Bool, quietHours.Start.String, quietHours.End.String, quietHours.Timezone.String)
+	responseSuccess := ResponseSuccess{
+		Status:  true,
+		Message: "保存通知订阅成功!",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseSuccess)
+}
+
+// validateUserNotifyConfig 检查订阅的事件和级别、免打扰时段和时区，同一事件、子网和渠道只能订阅一次
+func validateUserNotifyConfig(config UserNotifyConfig) error {
+	seen := make(map[string]bool)
+	for _, subscription := range config.Subscriptions {
+		if !message.ValidEvent(subscription.Event) {
+			return fmt.Errorf("invalid event: %s", subscription.Event)
+		}
+		if subscription.MinSeverity != "" && !message.ValidSeverity(subscription.MinSeverity) {
+			return fmt.Errorf("invalid min_severity: %s", subscription.MinSeverity)
+		}
+		if subscription.ChannelID < 0 {
+			return fmt.Errorf("invalid channel_id: %d", subscription.ChannelID)
+		}
+		key := fmt.Sprintf("%s|%s|%d", subscription.Event, subscription.SerID, subscription.ChannelID)
+		if seen[key] {
+			return fmt.Errorf("duplicate subscription: event %s ser_id [%s] channel_id %d", subscription.Event, subscription.SerID, subscription.ChannelID)
+		}
+		seen[key] = true
+	}
+
+	quietHours := config.QuietHours
+	for _, value := range []string{quietHours.Start, quietHours.End} {
+		if value == "" && !quietHours.Enabled {
+			continue
+		}
+		if _, err := time.Parse("15:04", value); err != nil {
+			return fmt.Errorf("invalid quiet hours time: %q", value)
+		}
+	}
+	if quietHours.Enabled && quietHours.Start == quietHours.End {
+		return errors.New("quiet hours start and end cannot be the same")
+	}
+	if quietHours.Timezone != "" {
+		if _, err := time.LoadLocation(quietHours.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone: %s", quietHours.Timezone)
+		}
+	}
+	return nil
+}
+
+// checkSubscriptionTargets 订阅的子网必须对用户可见，管理员可以订阅任何存在的子网，渠道必须存在
+func checkSubscriptionTargets(user database.User, subscriptions []database.ExportedNotifySubscription) error {
+	var visible map[string]bool
+	for _, subscription := range subscriptions {
+		if subscription.SerID != "" {
+			if global.IsAdmin(user.UserID.String) {
+				subnet := database.Subnet{}
+				subnet.SerID.String = subscription.SerID
+				if err := subnet.GetSubnetBySerId(global.GlobalDB); err != nil {
+					return fmt.Errorf("subnet %s: %v", subscription.SerID, err)
+				}
+			} else {
+				if visible == nil {
+					userIds, err := user.QueryUserIds(global.GlobalDB, user.UserID.String)
+					if err != nil {
+						return err
+					}
+					serIds, err := user.GetSubnetIdsByUserIds(global.GlobalDB, userIds)
+					if err != nil {
+						return err
+					}
+					visible = make(map[string]bool, len(serIds))
+					for _, serId := range serIds {
+						visible[serId] = true
+					}
+				}
+				if !visible[subscription.SerID] {
+					return fmt.Errorf("subnet %s is not visible to user %s", subscription.SerID, user.UserName.String)
+				}
+			}
+		}
+
+		if subscription.ChannelID != 0 {
+			notifyChannel := database.NotifyChannel{}
+			notifyChannel.CreateNotifyChannel(global.GlobalDB)
+			notifyChannel.ID.Int64 = subscription.ChannelID
+			if err := notifyChannel.GetNotifyChannelByID(global.GlobalDB); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
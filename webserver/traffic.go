@@ -0,0 +1,248 @@
+// webservice/traffic.go
+package webservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwireguard/database"
+	"jwireguard/global"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ResponseTrafficList struct {
+	Status  bool                    `json:"status"`
+	Message string                  `json:"message"`
+	Data    []database.TrafficTotal `json:"data"`
+}
+
+type ResponseTrafficSessionList struct {
+	Status  bool                              `json:"status"`
+	Message string                            `json:"message"`
+	Total   int                               `json:"total"`
+	Data    []database.ExportedTrafficSession `json:"data"`
+}
+
+func registerTrafficRoutes() {
+	http.HandleFunc("/get_cli_traffic", ValidateSessionMiddleware(GetCliTraffic))
+	http.HandleFunc("/get_subnet_traffic", ValidateSessionMiddleware(GetSubnetTraffic))
+	http.HandleFunc("/get_cli_session_history", ValidateSessionMiddleware(GetCliSessionHistory))
+}
+
+// GetCliTraffic 获取客户端的每日/每月流量
+func GetCliTraffic(w http.ResponseWriter, r *http.Request) {
+	getTraffic(w, r, "get_cli_traffic", "cli_id")
+}
+
+// GetSubnetTraffic 获取子网的每日/每月流量
+func GetSubnetTraffic(w http.ResponseWriter, r *http.Request) {
+	getTraffic(w, r, "get_subnet_traffic", "ser_id")
+}
+
+// getTraffic 按 cli_id 或 ser_id 汇总流量
+// type 为 day(默认) 或 month，start/end 为 YYYY-MM-DD
+func getTraffic(w http.ResponseWriter, r *http.Request, tag string, key string) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugf("[%s] userID:%s", tag, XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[%s] 解析 IP 地址代码时出错 %d", tag, http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[%s] client [%s:%s]", tag, ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	id := query.Get(key)
+	totalType := query.Get("type")
+	start := query.Get("start")
+	end := query.Get("end")
+	global.Log.Debugf("[%s] %s:[%s] type:[%s] start:[%s] end:[%s]", tag, key, id, totalType, start, end)
+	// 判断参数是否为空
+	if id == "" {
+		global.Log.Errorf("[%s] 参数为空", tag)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3701,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	if !validTrafficDate(start) || !validTrafficDate(end) || (totalType != "" && totalType != "day" && totalType != "month") {
+		global.Log.Errorf("[%s] 参数格式错误", tag)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数格式错误",
+			Error:   3702,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[%s] 数据库连接失败, err:%v", tag, err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	trafficDaily := database.TrafficDaily{}
+	trafficDaily.CreateTrafficDaily(global.GlobalDB)
+
+	var cliId, serId string
+	if key == "cli_id" {
+		cliId = id
+	} else {
+		serId = id
+	}
+	totals, err := database.GetTrafficTotals(global.GlobalDB, cliId, serId, totalType == "month", start, end)
+	if err != nil {
+		global.Log.Errorf("[%s] 获取流量统计失败, err:%v", tag, err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取流量统计失败, err:%v", err),
+			Error:   3703,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	responseTrafficList := ResponseTrafficList{
+		Status:  true,
+		Message: "获取流量统计成功!",
+		Data:    totals,
+	}
+
+	// 将JSON对象转为字符串
+	jsonData, err := json.Marshal(responseTrafficList)
+	if err != nil {
+		global.Log.Errorf("[%s] 无法将JSON对象转为字符串, err:%v", tag, err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法将JSON对象转为字符串, err:%v", err),
+			Error:   3704,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	// 设置响应头，指明内容类型为 JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// GetCliSessionHistory 分页获取客户端的连接记录
+func GetCliSessionHistory(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_cli_session_history] userID:", XUserID)
+
+	addr := r.RemoteAddr
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		global.Log.Errorf("[get_cli_session_history] 解析 IP 地址代码时出错 %d", http.StatusInternalServerError)
+		return
+	}
+	global.Log.Debugf("[get_cli_session_history] client [%s:%s]", ip, port)
+	// 解析 URL 参数
+	query := r.URL.Query()
+	cliId := query.Get("cli_id")
+	page, _ := strconv.Atoi(query.Get("page"))
+	global.Log.Debugf("[get_cli_session_history] cli_id:[%s] page:[%d]", cliId, page)
+	// 判断参数是否为空
+	if cliId == "" {
+		global.Log.Errorln("[get_cli_session_history] 参数为空")
+		responseError := ResponseError{
+			Status:  false,
+			Message: "参数为空",
+			Error:   3705,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	// 查询连接状态
+	global.GlobalDB, err = database.MonitorDatabase(global.GlobalDB)
+	if err != nil {
+		global.Log.Errorf("[get_cli_session_history] 数据库连接失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("数据库连接失败, err:%v", err),
+			Error:   0001,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	trafficSession := database.TrafficSession{}
+	trafficSession.CreateTrafficSession(global.GlobalDB)
+	trafficSession.CliID.String = cliId
+	sessions, total, err := trafficSession.GetTrafficSessionsByCliID(global.GlobalDB, page)
+	if err != nil {
+		global.Log.Errorf("[get_cli_session_history] 获取连接记录失败, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("获取连接记录失败, err:%v", err),
+			Error:   3706,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	exportedSessions := make([]database.ExportedTrafficSession, len(sessions))
+	for i, session := range sessions {
+		exportedSessions[i] = session.ToExported()
+	}
+
+	responseTrafficSessionList := ResponseTrafficSessionList{
+		Status:  true,
+		Message: "获取连接记录成功!",
+		Total:   total,
+		Data:    exportedSessions,
+	}
+
+	// 将JSON对象转为字符串
+	jsonData, err := json.Marshal(responseTrafficSessionList)
+	if err != nil {
+		global.Log.Errorf("[get_cli_session_history] 无法将JSON对象转为字符串, err:%v", err)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法将JSON对象转为字符串, err:%v", err),
+			Error:   3707,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	// 设置响应头，指明内容类型为 JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// validTrafficDate 日期参数为空或 YYYY-MM-DD
+func validTrafficDate(date string) bool {
+	if date == "" {
+		return true
+	}
+	_, err := time.Parse(database.TRAFFICDAYLAYOUT, date)
+	return err == nil
+}
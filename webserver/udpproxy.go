@@ -0,0 +1,47 @@
+// webservice/udpproxy.go
+package webservice
+
+import (
+	"encoding/json"
+	"jwireguard/global"
+	"jwireguard/udpproxy"
+	"net/http"
+)
+
+type ResponseUDPProxySessions struct {
+	Status  bool                    `json:"status"`
+	Message string                  `json:"message"`
+	Total   int                     `json:"total"`
+	Data    []udpproxy.SessionStats `json:"data"`
+}
+
+func registerUDPProxyRoutes() {
+	http.HandleFunc("/get_udp_proxy_sessions", ValidateSessionMiddleware(GetUDPProxySessions))
+}
+
+// GetUDPProxySessions 获取 UDP 代理当前的会话和流量
+func GetUDPProxySessions(w http.ResponseWriter, r *http.Request) {
+	XUserID := r.Header.Get("X-User-ID")
+	global.Log.Debugln("[get_udp_proxy_sessions] userID:", XUserID)
+	if !global.IsAdmin(XUserID) {
+		global.Log.Errorf("[get_udp_proxy_sessions] 权限不足, userID:%s", XUserID)
+		responseError := ResponseError{
+			Status:  false,
+			Message: "权限不足",
+			Error:   3971,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+
+	sessions := udpproxy.AllSessions()
+	responseUDPProxySessions := ResponseUDPProxySessions{
+		Status:  true,
+		Message: "获取代理会话成功!",
+		Total:   len(sessions),
+		Data:    sessions,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responseUDPProxySessions)
+}
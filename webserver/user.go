@@ -2,6 +2,7 @@ package webservice
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"jwireguard/database"
 	"jwireguard/global"
@@ -9,10 +10,7 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"strings"
 	"time"
-
-	"golang.org/x/net/html"
 )
 
 type UserEditConfig struct {
@@ -611,10 +609,17 @@ func AddUser(w http.ResponseWriter, r *http.Request) {
 	// 初始化数据库
 	cliConfig.CreateCliConfig(global.GlobalDB)
 
-	userAddr := fmt.Sprintf("%s.0.1", global.GlobalJWireGuardini.IPPrefix)
+	// 用户使用 SUPERNET 中的第一个地址
+	userAddr, err := global.UserAddress()
+	var userNetwork *net.IPNet
+	if err == nil {
+		userNetwork, err = global.UserNetwork()
+	}
 
 	// 添加客户端
-	err = global.ShellAddClient(portUser.UserID.String, userAddr)
+	if err == nil {
+		err = global.ShellAddClient(portUser.UserID.String, userAddr, userNetwork)
+	}
 	if err != nil {
 		// 如果参数为空，返回 JSON 错误响应
 		global.Log.Errorf("[add_user] 添加用户配置失败, err:%v", err)
@@ -919,19 +924,11 @@ func DelUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 执行SHELL命令
-	err = global.ShellDelClient(targetUserID)
-	if err != nil {
-		global.Log.Errorf("[del_user] 无法删除客户端, err:%v", err)
-		responseError := ResponseError{
-			Status:  false,
-			Message: fmt.Sprintf("无法删除客户端, err:%v", err),
-			Error:   2805,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(responseError)
-		return
-	}
+	// 执行SHELL命令，失败时先完成其余清理再返回错误
+	delClientErr := global.ShellDelClient(targetUserID)
+
+	// 断开已连接的客户端
+	killCliSession("del_user", targetUserID)
 
 	err = cliConfig.GetCliConfigByCliID(global.GlobalDB)
 	if err == nil {
@@ -947,6 +944,47 @@ func DelUser(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(responseError)
 			return
 		}
+		deleteCliHeartbeat("del_user", targetUserID, XUserID)
+	}
+
+	// 删除摘要设置
+	digestSchedule := database.DigestSchedule{}
+	digestSchedule.CreateDigestSchedule(global.GlobalDB)
+	digestSchedule.UserID.String = targetUserID
+	if err := digestSchedule.DeleteDigestSchedule(global.GlobalDB); err != nil {
+		global.Log.Errorf("[del_user] 删除摘要设置失败, err:%v", err)
+	}
+
+	// 删除通知订阅、免打扰设置和暂存的通知
+	notifySubscription := database.NotifySubscription{}
+	notifySubscription.CreateNotifySubscription(global.GlobalDB)
+	notifySubscription.UserID.String = targetUserID
+	if err := notifySubscription.DeleteNotifySubscription(global.GlobalDB); err != nil {
+		global.Log.Errorf("[del_user] 删除通知订阅失败, err:%v", err)
+	}
+
+	var revokeErr *global.RevokeError
+	if errors.As(delClientErr, &revokeErr) {
+		global.Log.Errorf("[del_user] 用户已删除, 但证书吊销失败, err:%v", delClientErr)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("用户已删除, 但证书吊销失败, err:%v", delClientErr),
+			Error:   2807,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
+	}
+	if delClientErr != nil {
+		global.Log.Errorf("[del_user] 无法删除客户端, err:%v", delClientErr)
+		responseError := ResponseError{
+			Status:  false,
+			Message: fmt.Sprintf("无法删除客户端, err:%v", delClientErr),
+			Error:   2805,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responseError)
+		return
 	}
 
 	// 返回结果
@@ -1277,44 +1315,6 @@ func CheckMailCode(w http.ResponseWriter, r *http.Request) {
 
 // 获取邮箱验证码
 func GetMailCode(w http.ResponseWriter, r *http.Request) {
-	var sender message.EmailSender
-
-	var emailTable string = "登录验证码"
-	var htmlTemplate string = `<!DOCTYPE html>
-<html>
-<head>
-<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
-<meta name="viewport" content="width=device-width, initial-scale=1.0">
-<style type="text/css">
-	body { margin: 0; padding: 0; font-family: Arial, sans-serif; }
-	.email-container {
-		width: 100%;
-		margin: 0 auto;
-		background: #0e9dbb url('https://rescdn.qqmail.com/zh_CN/htmledition/images/xinzhi/bg/a_09.jpg') repeat-x;
-	}
-	.content {
-		padding: 30px 15px;
-		color: #ffffff;
-		line-height: 1.6;
-		font-size: 16px;
-	}
-</style>
-</head>
-<body>
-<div class="email-container">
-	<div class="content">
-		<div class="message">
-			您本次登录的验证码是：<b>{{mailcode}}</b><br><br>
-			请勿将验证码透露给其他人。<br>
-			如非本人操作，请联系管理员修改密码。<br><br>
-			本邮件由系统自动发送，请勿直接回复！<br>
-			感谢您的访问，祝您使用愉快！
-		</div>
-	</div>
-</div>
-</body>
-</html>`
-
 	addr := r.RemoteAddr
 	ip, port, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -1393,33 +1393,25 @@ func GetMailCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sender = message.EmailSender{
-		Host:     global.GlobalJWireGuardini.EmailHost,
-		Port:     global.GlobalJWireGuardini.EmailPort,
-		Username: global.GlobalJWireGuardini.EmailUser,
-		Password: global.GlobalJWireGuardini.EmailPass,
-		From:     global.GlobalJWireGuardini.FormEmail,
-		Name:     global.GlobalJWireGuardini.FormName,
-	}
-
 	// 获取 6位随机验证码
 	emailCode, err := global.Random6DigitString()
 	if err != nil {
 		global.Log.Errorf("[get_mail_code] 获取邮箱验证码错误：%+v", err)
 	}
 
-	// html 拼接
-	doc, _ := html.Parse(strings.NewReader(htmlTemplate))
-	global.ReplaceText(doc, "{{mailcode}}", emailCode)
-	var b strings.Builder
-	html.Render(&b, doc)
-
-	err = sender.SendMail(
-		[]string{user.UserEmail.String},
-		emailTable,
-		b.String(),
-		true, // 使用 HTML 格式
-	)
+	rendered, err := notifyTemplates().Render(message.TEMPLATEMAILCODE, "", map[string]interface{}{
+		"MailCode":      emailCode,
+		"ExpireMinutes": 5,
+	})
+	if err == nil {
+		sender := notifySender()
+		err = sender.SendAlternative(
+			[]string{user.UserEmail.String},
+			rendered.Subject,
+			rendered.Text,
+			rendered.HTML,
+		)
+	}
 
 	if err != nil {
 		global.Log.Errorf("[get_mail_code] 邮件发送失败：%+v", err)
@@ -2,17 +2,13 @@
 package webservice
 
 import (
-	"bufio"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"jwireguard/database"
 	"jwireguard/global"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -43,6 +39,19 @@ func StartServer(port string, tlsPort string, certfile string, keyfile string) {
 	registerCliRoutes()
 	registerUserRoutes()
 	registerSubnetRoutes()
+	registerCertRoutes()
+	registerSessionRoutes()
+	registerTrafficRoutes()
+	registerIPAMRoutes()
+	registerHeartbeatRoutes()
+	registerCommandRoutes()
+	registerUDPProxyRoutes()
+	registerOfflineRoutes()
+	registerEventRoutes()
+	registerNotifyRoutes()
+	registerProfileMailRoutes()
+	registerDigestRoutes()
+	registerSubscriptionRoutes()
 
 	// 如果提供了 HTTPS 证书，则启动 HTTPS 协程
 	if certfile != "" && keyfile != "" {
@@ -86,82 +95,9 @@ func parseJSONBody(r *http.Request, v interface{}) error {
 	return nil
 }
 
-// ----------------------------------------------------------------------------------------------------------
-//
-//	FindUnusedIP 找到未使用的IP地址，如果全部IP都被占用则返回错误
-//
-// ----------------------------------------------------------------------------------------------------------
-func FindUnusedIP(ccdDir string, ipPrefix string) (string, error) {
-	usedIPs := make(map[string]bool)
-
-	// 遍历CCD目录下的所有文件
-	err := filepath.Walk(ccdDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			// 读取文件内容
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.HasPrefix(line, "ifconfig-push") {
-					fields := strings.Fields(line)
-					// log.Printf("[未判断] 文件中的IP:%s", fields[1])
-					// 调用 splitIP 函数
-					if len(fields) > 1 && fields[1] != "" {
-						newIP, _ := SplitIP(fields[1])
-						if newIP == ipPrefix {
-							// log.Printf("[已判断] 文件中的IP:%s", fields[1])
-							usedIPs[fields[1]] = true
-
-						}
-					}
-				}
-			}
-
-			if err := scanner.Err(); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-
-	if err != nil {
-		return "", err
-	}
-
-	// 找到未使用的IP地址
-	for i := 1; i <= 254; i++ {
-		ip := fmt.Sprintf("%s.%d", ipPrefix, i)
-		useIPStatus := usedIPs[ip]
-		global.Log.Debugf("[判断状态] 当前IP:%s 当前状态:%v", ip, useIPStatus)
-		if !useIPStatus {
-			return ip, nil
-		}
-	}
-
-	// 如果所有IP地址都被占用，返回错误
-	return "", errors.New("all IP addresses in the range are used")
-}
-
-// SplitIP 函数用于将 IP 地址分割成前三部分和最后一部分
+// SplitIP 函数用于将 IP 地址分割成前三部分和最后一部分，IPv6 地址按最后一个冒号分割
 func SplitIP(ip string) (string, string) {
-	// 将 IP 地址按照 "." 分割
-	parts := strings.Split(ip, ".")
-
-	// 组合前三部分
-	newIP := strings.Join(parts[:3], ".")
-
-	// 获取最后一部分
-	lastPart := parts[3]
-
-	return newIP, lastPart
+	return global.SplitIP(ip)
 }
 
 // 创建新 session
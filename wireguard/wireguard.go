@@ -0,0 +1,227 @@
+// wireguard/wireguard.go
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const KEYLEN = 32 // Curve25519 密钥长度
+
+// Key WireGuard 使用的 Curve25519 密钥，文本形式为 base64
+type Key [KEYLEN]byte
+
+// Interface wg-quick 配置中的 [Interface] 段
+type Interface struct {
+	PrivateKey Key
+	Address    []string // CIDR 形式的地址
+	ListenPort int      // 0 表示不监听固定端口
+	DNS        []string
+}
+
+// Peer wg-quick 配置中的 [Peer] 段
+type Peer struct {
+	Name                string // 写入注释，便于识别所属客户端
+	PublicKey           Key
+	Endpoint            string
+	AllowedIPs          []string
+	PersistentKeepalive int
+}
+
+// Config 完整的 wg-quick 配置
+type Config struct {
+	Interface Interface
+	Peers     []Peer
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// GeneratePrivateKey 生成新的私钥，与 wg genkey 一样做 clamp 处理
+// ----------------------------------------------------------------------------------------------------------
+func GeneratePrivateKey() (Key, error) {
+	var key Key
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return Key{}, fmt.Errorf("无法生成私钥: %w", err)
+	}
+	key[0] &= 248
+	key[31] = (key[31] & 127) | 64
+	return key, nil
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ParseKey 解析 base64 格式的密钥
+// ----------------------------------------------------------------------------------------------------------
+func ParseKey(s string) (Key, error) {
+	var key Key
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return Key{}, fmt.Errorf("密钥格式错误: %w", err)
+	}
+	if len(data) != KEYLEN {
+		return Key{}, fmt.Errorf("密钥长度错误: %d", len(data))
+	}
+	copy(key[:], data)
+	return key, nil
+}
+
+// PublicKey 由私钥计算公钥
+func (k Key) PublicKey() (Key, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(k[:])
+	if err != nil {
+		return Key{}, err
+	}
+	var pub Key
+	copy(pub[:], priv.PublicKey().Bytes())
+	return pub, nil
+}
+
+// IsZero 判断密钥是否为空
+func (k Key) IsZero() bool {
+	return k == Key{}
+}
+
+// String 返回 base64 格式的密钥
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// String 生成 [Interface] 段
+func (i Interface) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("[Interface]\n")
+	fmt.Fprintf(&buf, "PrivateKey = %s\n", i.PrivateKey)
+	if len(i.Address) > 0 {
+		fmt.Fprintf(&buf, "Address = %s\n", strings.Join(i.Address, ", "))
+	}
+	if i.ListenPort > 0 {
+		fmt.Fprintf(&buf, "ListenPort = %d\n", i.ListenPort)
+	}
+	if len(i.DNS) > 0 {
+		fmt.Fprintf(&buf, "DNS = %s\n", strings.Join(i.DNS, ", "))
+	}
+	return buf.String()
+}
+
+// String 生成 [Peer] 段
+func (p Peer) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("[Peer]\n")
+	if p.Name != "" {
+		fmt.Fprintf(&buf, "# %s\n", p.Name)
+	}
+	fmt.Fprintf(&buf, "PublicKey = %s\n", p.PublicKey)
+	if p.Endpoint != "" {
+		fmt.Fprintf(&buf, "Endpoint = %s\n", p.Endpoint)
+	}
+	if len(p.AllowedIPs) > 0 {
+		fmt.Fprintf(&buf, "AllowedIPs = %s\n", strings.Join(p.AllowedIPs, ", "))
+	}
+	if p.PersistentKeepalive > 0 {
+		fmt.Fprintf(&buf, "PersistentKeepalive = %d\n", p.PersistentKeepalive)
+	}
+	return buf.String()
+}
+
+// String 生成完整配置，Peer 按名称排序保证输出稳定
+func (c Config) String() string {
+	peers := append([]Peer(nil), c.Peers...)
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(c.Interface.String())
+	for _, peer := range peers {
+		buf.WriteString("\n")
+		buf.WriteString(peer.String())
+	}
+	return buf.String()
+}
+
+// ----------------------------------------------------------------------------------------------------------
+// ParsePeers 解析配置中的 [Peer] 段，其他段落忽略
+// ----------------------------------------------------------------------------------------------------------
+func ParsePeers(data []byte) ([]Peer, error) {
+	var peers []Peer
+	var peer *Peer
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if peer != nil {
+				peers = append(peers, *peer)
+				peer = nil
+			}
+			if strings.EqualFold(line, "[Peer]") {
+				peer = &Peer{}
+			}
+			continue
+		}
+		if peer == nil {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if peer.Name == "" {
+				peer.Name = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			}
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("无法解析配置行: %s", line)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(name) {
+		case "publickey":
+			key, err := ParseKey(value)
+			if err != nil {
+				return nil, err
+			}
+			peer.PublicKey = key
+		case "endpoint":
+			peer.Endpoint = value
+		case "allowedips":
+			for _, ip := range strings.Split(value, ",") {
+				if ip = strings.TrimSpace(ip); ip != "" {
+					peer.AllowedIPs = append(peer.AllowedIPs, ip)
+				}
+			}
+		case "persistentkeepalive":
+			keepalive, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("PersistentKeepalive 格式错误: %s", value)
+			}
+			peer.PersistentKeepalive = keepalive
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if peer != nil {
+		peers = append(peers, *peer)
+	}
+
+	for _, p := range peers {
+		if p.PublicKey.IsZero() {
+			return nil, errors.New("Peer 缺少 PublicKey")
+		}
+	}
+	return peers, nil
+}